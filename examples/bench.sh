@@ -0,0 +1,46 @@
+#!/bin/bash
+# Compare the wakeup latency of the example schedulers while the system is
+# busy building something.
+#
+# Usage: sudo ./examples/bench.sh [duration in seconds]
+#
+# The load is generated by $BUILD_CMD (default: building this repository
+# in a loop) and the latency is measured with schbench, which must be in
+# $PATH. Run `make examples` first.
+
+set -u
+
+DURATION=${1:-30}
+SCHEDULERS=${SCHEDULERS:-"fifo vruntime"}
+BUILD_CMD=${BUILD_CMD:-"while true; do make -s -B build >/dev/null 2>&1; done"}
+NR_CPUS=$(nproc)
+
+if ! command -v schbench >/dev/null; then
+	echo "schbench not found in \$PATH" >&2
+	exit 1
+fi
+
+for sched in $SCHEDULERS; do
+	if [ ! -x "./$sched" ]; then
+		echo "./$sched not found, run 'make examples' first" >&2
+		exit 1
+	fi
+
+	"./$sched" >/dev/null 2>&1 &
+	sched_pid=$!
+	# Give the scheduler the time to attach.
+	sleep 2
+
+	# Run the load in its own session, so that its process group can be
+	# killed with all the children of the build.
+	setsid bash -c "$BUILD_CMD" &
+	load_pgid=$!
+
+	echo "=== $sched ==="
+	schbench -m 2 -t "$NR_CPUS" -r "$DURATION" 2>&1 | grep -A 4 "Wakeup Latencies"
+
+	kill -- "-$load_pgid" 2>/dev/null
+	wait "$load_pgid" 2>/dev/null
+	kill -INT "$sched_pid"
+	wait "$sched_pid"
+done
@@ -0,0 +1,131 @@
+// fifo is a minimal user-space scheduler built on scx_goland_core: tasks are
+// dispatched in the same order they are received from the BPF component, on
+// an idle CPU if one is available, or on the first CPU that becomes
+// available otherwise.
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"os"
+	"time"
+
+	core "github.com/Gthulhu/scx_goland_core/goland_core"
+	"github.com/Gthulhu/scx_goland_core/util"
+)
+
+var (
+	sliceUs = flag.Uint64("slice-us", 5000, "time slice assigned to each task (us)")
+	verbose = flag.Bool("verbose", false, "log every scheduling decision and periodic statistics")
+)
+
+// schedule dispatches the queued tasks in FIFO order until ctx is done.
+func schedule(ctx context.Context, s *core.Sched, sliceNs uint64) {
+	var t core.QueuedTask
+	// The shared DSQ is ordered by vtime: use an increasing sequence
+	// number to preserve the FIFO order (vtime 0 is reserved to the
+	// priority tasks by the BPF component).
+	var seq uint64
+
+	for ctx.Err() == nil {
+		s.DequeueTask(&t)
+		if t.Pid == -1 {
+			// Nothing else to do: let the BPF component know that we
+			// are not holding any task and wait for new ones.
+			if err := s.NotifyComplete(0); err != nil {
+				log.Printf("NotifyComplete failed: %v", err)
+			}
+			s.BlockTilReadyForDequeue(ctx)
+			continue
+		}
+
+		task := core.NewDispatchedTask(&t)
+		cpu, err := s.SelectCPU(&t)
+		if err != nil {
+			cpu = core.RL_CPU_ANY
+		}
+		seq++
+		task.Cpu = cpu
+		task.SliceNs = sliceNs
+		task.Vtime = seq
+
+		if err := s.DispatchTask(task); err != nil {
+			log.Printf("DispatchTask failed: %v", err)
+			continue
+		}
+		if *verbose {
+			log.Printf("dispatch: pid=%d cpu=%d slice=%d", task.Pid, task.Cpu, task.SliceNs)
+		}
+	}
+}
+
+// handleExits logs the tasks that exited: a real scheduler releases its
+// per-task state here.
+func handleExits(ctx context.Context, s *core.Sched) {
+	for {
+		select {
+		case e := <-s.TaskExits():
+			if *verbose {
+				log.Printf("exit: pid=%d tgid=%d", e.Pid, e.Tgid)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// reportStats periodically logs the statistics of the BPF component.
+func reportStats(ctx context.Context, s *core.Sched) {
+	timer := time.NewTicker(1 * time.Second)
+	defer timer.Stop()
+	for {
+		select {
+		case <-timer.C:
+			bss, err := s.GetBssData()
+			if err != nil {
+				log.Printf("GetBssData failed: %v", err)
+				continue
+			}
+			log.Printf("stats: %v", bss)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func main() {
+	flag.Parse()
+
+	s := core.LoadSched("")
+	defer s.Close()
+	if err := s.AssignUserSchedPid(os.Getpid()); err != nil {
+		log.Printf("AssignUserSchedPid failed: %v", err)
+	}
+	s.SetBuiltinIdle(true)
+	s.SetDefaultSlice(*sliceUs * 1000)
+	if _, err := s.Start(); err != nil {
+		log.Panicf("Start failed: %v", err)
+	}
+
+	if err := util.InitCacheDomains(s); err != nil {
+		log.Panicf("InitCacheDomains failed: %v", err)
+	}
+	if err := s.Attach(); err != nil {
+		log.Panicf("Attach failed: %v", err)
+	}
+	log.Printf("fifo scheduler attached (pid %d)", os.Getpid())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go schedule(ctx, s, *sliceUs*1000)
+	go handleExits(ctx, s)
+	if *verbose {
+		go reportStats(ctx, s)
+	}
+
+	if _, err := s.RunUntilSignal(); err != nil {
+		log.Printf("Detach failed: %v", err)
+	}
+	log.Println("fifo scheduler exit")
+}
@@ -0,0 +1,295 @@
+// vruntime is a user-space scheduler built on scx_goland_core that orders
+// tasks by weighted virtual runtime:
+//
+//   - each task is charged the CPU time it used, scaled by its weight;
+//   - tasks that wake up often (voluntary context switches) are considered
+//     interactive and get their deadline anticipated;
+//   - the time slice shrinks when more tasks are waiting;
+//   - tasks woken up synchronously that can't find an idle CPU are
+//     dispatched on the CPU of their waker;
+//   - interactive tasks that can't find an idle CPU are dispatched on their
+//     previous CPU, or on a CPU of the most capable tier on hybrid systems,
+//     preempting the running task.
+//
+// The per-task state is dropped when a task leaves the scheduler (e.g. it
+// switches to SCHED_FIFO) or exits.
+package main
+
+import (
+	"container/heap"
+	"context"
+	"flag"
+	"log"
+	"os"
+	"time"
+
+	core "github.com/Gthulhu/scx_goland_core/goland_core"
+	"github.com/Gthulhu/scx_goland_core/util"
+)
+
+const (
+	SCX_ENQ_WAKEUP = 1
+	NSEC_PER_SEC   = 1000000000
+	// How long the tasks that left the scheduler are remembered, to
+	// ignore their records queued before they left.
+	GONE_TIMEOUT_NS = NSEC_PER_SEC
+)
+
+var (
+	sliceUs    = flag.Uint64("slice-us", 5000, "maximum time slice assigned to each task (us)")
+	sliceMinUs = flag.Uint64("slice-min-us", 500, "minimum time slice assigned to each task (us)")
+	wakeupFreq = flag.Uint64("interactive-freq", 100, "wakeups per second above which a task is interactive")
+	verbose    = flag.Bool("verbose", false, "log every scheduling decision")
+)
+
+// taskInfo is the per-task state of the scheduler.
+type taskInfo struct {
+	vruntime   uint64 // weighted virtual runtime
+	lastWakeup uint64 // timestamp of the last wakeup
+	wakeupFreq uint64 // EWMA of the wakeups per second
+}
+
+// Task is a queued task waiting to be dispatched.
+type Task struct {
+	core.QueuedTask
+	deadline    uint64
+	interactive bool
+}
+
+// taskHeap orders the tasks by deadline.
+type taskHeap []*Task
+
+func (h taskHeap) Len() int { return len(h) }
+func (h taskHeap) Less(i, j int) bool {
+	if h[i].deadline != h[j].deadline {
+		return h[i].deadline < h[j].deadline
+	}
+	return h[i].Pid < h[j].Pid
+}
+func (h taskHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+func (h *taskHeap) Push(x any)   { *h = append(*h, x.(*Task)) }
+func (h *taskHeap) Pop() any {
+	old := *h
+	t := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return t
+}
+
+type scheduler struct {
+	s      *core.Sched
+	events <-chan core.TaskEvent
+	tasks  map[int32]*taskInfo
+	// gone are the tasks that left the scheduler or exited, with the
+	// time of the event.
+	gone        map[int32]uint64
+	queue       taskHeap
+	minVruntime uint64
+	topo        *core.Topology // nil if the topology is not available
+}
+
+func now() uint64 {
+	return uint64(time.Now().UnixNano())
+}
+
+// enqueue computes the deadline of a task received from the BPF component
+// and adds it to the queue.
+func (sc *scheduler) enqueue(t *core.QueuedTask) {
+	sliceNs := *sliceUs * 1000
+	info, ok := sc.tasks[t.Pid]
+	if !ok {
+		info = &taskInfo{vruntime: sc.minVruntime}
+		// A task queued before it left the scheduler is still
+		// dispatched, but it doesn't get a new state.
+		if ts, gone := sc.gone[t.Pid]; !gone || t.EnqueueTs > ts {
+			sc.tasks[t.Pid] = info
+		}
+	}
+
+	// Track the wakeup frequency: a task that voluntarily releases the
+	// CPU often is likely to be interactive.
+	if t.Flags&SCX_ENQ_WAKEUP != 0 {
+		ts := now()
+		if info.lastWakeup != 0 && ts > info.lastWakeup {
+			freq := NSEC_PER_SEC / (ts - info.lastWakeup)
+			info.wakeupFreq = (info.wakeupFreq*3 + freq) / 4
+		}
+		info.lastWakeup = ts
+	}
+
+	// Charge the CPU time used since the last run, scaled by weight, and
+	// don't let a task that slept for long accumulate too much credit.
+	info.vruntime += core.ScaleByWeight(t.RuntimeNs, t.Weight)
+	if floor := sc.minVruntime - min(sc.minVruntime, sliceNs); info.vruntime < floor {
+		info.vruntime = floor
+	}
+
+	task := &Task{QueuedTask: *t, deadline: info.vruntime}
+	if info.wakeupFreq >= *wakeupFreq {
+		task.interactive = true
+		task.deadline -= min(task.deadline, sliceNs)
+	}
+	heap.Push(&sc.queue, task)
+}
+
+// dispatch sends the task with the earliest deadline to the BPF component.
+func (sc *scheduler) dispatch() {
+	t := heap.Pop(&sc.queue).(*Task)
+	if t.deadline > sc.minVruntime {
+		sc.minVruntime = t.deadline
+	}
+
+	// Shrink the time slice when more tasks are waiting, and give higher
+	// weight tasks a proportionally longer slice.
+	nrWaiting := core.GetNrQueued() + uint64(sc.queue.Len()) + 1
+	sliceNs := max(*sliceUs*1000/nrWaiting, *sliceMinUs*1000)
+	sliceNs = min(core.ScaleSliceByWeight(sliceNs, t.Weight), *sliceUs*1000)
+
+	task := core.NewDispatchedTask(&t.QueuedTask)
+	cpu, err := sc.s.SelectCPU(&t.QueuedTask)
+	if err != nil {
+		cpu = core.RL_CPU_ANY
+	}
+	if cpu == core.RL_CPU_ANY && t.WakerCpu >= 0 {
+		// No idle CPU: SelectCPU already claimed any idle CPU it
+		// found, so only the waker's CPU of a sync wakeup is left.
+		cpu = core.SuggestWakeCPU(&t.QueuedTask, sc.topo, core.CpuMask{})
+	}
+	preempt := false
+	if cpu == core.RL_CPU_ANY && t.interactive {
+		// No idle CPU: run the interactive task on its previous CPU
+		// as soon as possible, unless it is a little core.
+		cpu = t.Cpu
+		if sc.topo != nil && sc.topo.Tier(cpu) > 0 {
+			// Prefer a big core that became idle in the meantime.
+			if idle, err := sc.s.IdleCpus(); err == nil {
+				big := sc.topo.CPUsInTier(0)
+				for i := range big {
+					big[i] &= idle[i]
+				}
+				if c := sc.topo.PreferTier(&t.QueuedTask, big, 0); c >= 0 {
+					cpu = c
+				}
+			}
+		}
+		if t.CanRunOn(int(cpu)) {
+			preempt = true
+		} else {
+			cpu = core.RL_CPU_ANY
+		}
+	}
+	task.Cpu = cpu
+	task.SliceNs = sliceNs
+	// Vtime 0 is reserved to the priority tasks by the BPF component.
+	task.Vtime = max(t.deadline, 1)
+
+	if err := sc.s.DispatchTask(task); err != nil {
+		log.Printf("DispatchTask failed: %v", err)
+		return
+	}
+	if preempt {
+		if err := sc.s.PreemptCpu(cpu); err != nil {
+			log.Printf("PreemptCpu failed: %v", err)
+		}
+	}
+	if *verbose {
+		log.Printf("dispatch: pid=%d cpu=%d slice=%d vtime=%d interactive=%v",
+			task.Pid, task.Cpu, task.SliceNs, task.Vtime, t.interactive)
+	}
+}
+
+// handleTaskEvents drops the state of the tasks that left the scheduler or
+// exited: a task that comes back starts from a fresh state.
+func (sc *scheduler) handleTaskEvents() {
+	for {
+		select {
+		case e := <-sc.events:
+			switch e.Kind {
+			case core.TaskEnabled:
+				delete(sc.gone, e.Pid)
+			case core.TaskDisabled, core.TaskExited:
+				delete(sc.tasks, e.Pid)
+				sc.gone[e.Pid] = e.Ts
+			}
+			for pid, ts := range sc.gone {
+				if e.Ts > ts+GONE_TIMEOUT_NS {
+					delete(sc.gone, pid)
+				}
+			}
+		default:
+			return
+		}
+	}
+}
+
+func (sc *scheduler) run(ctx context.Context) {
+	var t core.QueuedTask
+	for ctx.Err() == nil {
+		sc.handleTaskEvents()
+		// Drain all the queued tasks, then dispatch the most urgent one.
+		for {
+			sc.s.DequeueTask(&t)
+			if t.Pid == -1 {
+				break
+			}
+			sc.enqueue(&t)
+		}
+		if sc.queue.Len() == 0 {
+			if err := sc.s.NotifyComplete(0); err != nil {
+				log.Printf("NotifyComplete failed: %v", err)
+			}
+			sc.s.BlockTilReadyForDequeue(ctx)
+			continue
+		}
+		sc.dispatch()
+		if err := sc.s.NotifyComplete(uint64(sc.queue.Len())); err != nil {
+			log.Printf("NotifyComplete failed: %v", err)
+		}
+	}
+}
+
+func main() {
+	flag.Parse()
+
+	s, err := core.LoadSchedWithOpts("", core.LoadSchedOpts{TaskEvents: true})
+	if err != nil {
+		log.Panicf("LoadSchedWithOpts failed: %v", err)
+	}
+	defer s.Close()
+	if err := s.AssignUserSchedPid(os.Getpid()); err != nil {
+		log.Printf("AssignUserSchedPid failed: %v", err)
+	}
+	s.SetBuiltinIdle(true)
+	s.SetDefaultSlice(*sliceUs * 1000)
+	if _, err := s.Start(); err != nil {
+		log.Panicf("Start failed: %v", err)
+	}
+
+	if err := util.InitCacheDomains(s); err != nil {
+		log.Panicf("InitCacheDomains failed: %v", err)
+	}
+	if err := s.Attach(); err != nil {
+		log.Panicf("Attach failed: %v", err)
+	}
+	log.Printf("vruntime scheduler attached (pid %d)", os.Getpid())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	topo, err := core.ReadTopology()
+	if err != nil {
+		log.Printf("ReadTopology failed: %v", err)
+	}
+	sc := &scheduler{
+		s:      s,
+		events: s.TaskEvents(),
+		tasks:  make(map[int32]*taskInfo),
+		gone:   make(map[int32]uint64),
+		topo:   topo,
+	}
+	go sc.run(ctx)
+
+	if _, err := s.RunUntilSignal(); err != nil {
+		log.Printf("Detach failed: %v", err)
+	}
+	log.Println("vruntime scheduler exit")
+}
@@ -0,0 +1,71 @@
+package core
+
+/*
+#include "wrapper.h"
+*/
+import "C"
+
+import "fmt"
+
+// ABIVersion is the version of the protocol spoken with the BPF component
+// that this package implements (see intf.h::GOLAND_ABI_VERSION).
+const ABIVersion = 19
+
+// ABI describes the records exchanged between the BPF component and the
+// user-space scheduler.
+type ABI struct {
+	Version            uint32
+	QueuedTaskSize     uint32
+	DispatchedTaskSize uint32
+	TaskExitSize       uint32
+	CPUEventSize       uint32
+	BounceEventSize    uint32
+}
+
+func (a ABI) String() string {
+	return fmt.Sprintf("v%d (queued_task_ctx %d, dispatched_task_ctx %d, task_exit_ctx %d, cpu_event_ctx %d, bounce_event_ctx %d bytes)",
+		a.Version, a.QueuedTaskSize, a.DispatchedTaskSize, a.TaskExitSize, a.CPUEventSize, a.BounceEventSize)
+}
+
+// ExpectedABI returns the ABI implemented by this package.
+func ExpectedABI() ABI {
+	return ABI{
+		Version:            ABIVersion,
+		QueuedTaskSize:     queuedTaskSize,
+		DispatchedTaskSize: dispatchedTaskSize,
+		TaskExitSize:       taskExitSize,
+		CPUEventSize:       cpuEventSize,
+		BounceEventSize:    bounceEventSize,
+	}
+}
+
+// objectABI returns the ABI embedded in the opened BPF object.
+func objectABI() ABI {
+	return ABI{
+		Version:            uint32(C.get_abi_version()),
+		QueuedTaskSize:     uint32(C.get_abi_queued_task_size()),
+		DispatchedTaskSize: uint32(C.get_abi_dispatched_task_size()),
+		TaskExitSize:       uint32(C.get_abi_task_exit_size()),
+		CPUEventSize:       uint32(C.get_abi_cpu_event_size()),
+		BounceEventSize:    uint32(C.get_abi_bounce_event_size()),
+	}
+}
+
+// ErrABIMismatch is returned by LoadSchedWithOpts when the BPF object and
+// this package don't agree on the layout of the records they exchange.
+type ErrABIMismatch struct {
+	Object   ABI // ABI embedded in the BPF object
+	Expected ABI // ABI implemented by this package
+}
+
+func (e *ErrABIMismatch) Error() string {
+	return fmt.Sprintf("BPF object ABI %v doesn't match goland_core ABI %v", e.Object, e.Expected)
+}
+
+func checkABI() error {
+	obj, expected := objectABI(), ExpectedABI()
+	if obj != expected {
+		return &ErrABIMismatch{Object: obj, Expected: expected}
+	}
+	return nil
+}
@@ -0,0 +1,140 @@
+package core
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// AuditRecord is a dispatch decision recorded by the audit log (see
+// LoadSchedOpts.AuditSize). It is encoded in JSON with the same snake_case
+// field names as QueuedTask.
+type AuditRecord struct {
+	Ts      uint64 `json:"ts"`       // Dispatch time (CLOCK_MONOTONIC ns, see TraceHook)
+	Pid     int32  `json:"pid"`      // Dispatched task
+	Cpu     int32  `json:"cpu"`      // Target CPU (RL_CPU_ANY = first CPU available)
+	SliceNs uint64 `json:"slice_ns"` // Time slice (0 = default)
+	Vtime   uint64 `json:"vtime"`    // Vtime or deadline of the task
+	// QueueLatencyNs is the time from when the BPF component queued the
+	// task to user space to its dispatch.
+	QueueLatencyNs uint64 `json:"queue_latency_ns"`
+}
+
+// auditLog is a fixed-size ring of the sampled dispatches. The tasks are
+// sampled when they are dequeued, so that the queue latency can be measured
+// at dispatch.
+type auditLog struct {
+	every  atomic.Uint32 // sample one dequeue every `every` (0 = paused)
+	seq    atomic.Uint32
+	nvalid atomic.Int32 // entries in enqTs, checked without the lock
+
+	mu      sync.Mutex
+	enqTs   map[int32]uint64 // enqueue time of the sampled tasks not dispatched yet
+	records []AuditRecord
+	next    int  // where the next record is written
+	full    bool // records wrapped around
+}
+
+func newAuditLog(size int, every uint32) *auditLog {
+	a := &auditLog{
+		enqTs:   make(map[int32]uint64),
+		records: make([]AuditRecord, size),
+	}
+	a.every.Store(every)
+	return a
+}
+
+// dequeued samples t.
+func (a *auditLog) dequeued(t *QueuedTask) {
+	every := a.every.Load()
+	if every == 0 || a.seq.Add(1)%every != 0 {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	// Tasks that are never dispatched are only dropped when they exit:
+	// bound the memory they use meanwhile.
+	if len(a.enqTs) >= len(a.records) {
+		return
+	}
+	if _, ok := a.enqTs[t.Pid]; !ok {
+		a.nvalid.Add(1)
+	}
+	a.enqTs[t.Pid] = t.EnqueueTs
+}
+
+// dispatched records the dispatch of pid if it has been sampled.
+func (a *auditLog) dispatched(pid, cpu int32, sliceNs, vtime uint64) {
+	if a.nvalid.Load() == 0 {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	enqTs, ok := a.enqTs[pid]
+	if !ok {
+		return
+	}
+	delete(a.enqTs, pid)
+	a.nvalid.Add(-1)
+
+	r := AuditRecord{
+		Ts:      ktimeNow(),
+		Pid:     pid,
+		Cpu:     cpu,
+		SliceNs: sliceNs,
+		Vtime:   vtime,
+	}
+	if enqTs != 0 && r.Ts > enqTs {
+		r.QueueLatencyNs = r.Ts - enqTs
+	}
+	a.records[a.next] = r
+	a.next++
+	if a.next == len(a.records) {
+		a.next = 0
+		a.full = true
+	}
+}
+
+// exited forgets pid, that exited.
+func (a *auditLog) exited(pid int32) {
+	if a.nvalid.Load() == 0 {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if _, ok := a.enqTs[pid]; ok {
+		delete(a.enqTs, pid)
+		a.nvalid.Add(-1)
+	}
+}
+
+// snapshot returns a copy of the records, oldest first.
+func (a *auditLog) snapshot() []AuditRecord {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if !a.full {
+		return append([]AuditRecord(nil), a.records[:a.next]...)
+	}
+	out := make([]AuditRecord, 0, len(a.records))
+	out = append(out, a.records[a.next:]...)
+	return append(out, a.records[:a.next]...)
+}
+
+// SnapshotAudit returns the dispatches recorded by the audit log, oldest
+// first: the last LoadSchedOpts.AuditSize sampled dispatches at most. It
+// returns nil if the audit log is disabled.
+func (s *Sched) SnapshotAudit() []AuditRecord {
+	if s.audit == nil {
+		return nil
+	}
+	return s.audit.snapshot()
+}
+
+// SetAuditSampling makes the audit log record one task out of every `every`
+// dequeued (1 = all of them, 0 = pause the recording). It can be changed at
+// any time; it is a no-op if the audit log is disabled (see
+// LoadSchedOpts.AuditSize).
+func (s *Sched) SetAuditSampling(every uint32) {
+	if s.audit != nil {
+		s.audit.every.Store(every)
+	}
+}
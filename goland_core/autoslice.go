@@ -0,0 +1,137 @@
+package core
+
+/*
+#include "wrapper.h"
+*/
+import "C"
+
+import (
+	"fmt"
+	"time"
+)
+
+// autoSliceInterval is how often the auto-tuner updates the time slice.
+const autoSliceInterval = 100 * time.Millisecond
+
+// SliceCurve computes the time slice, between min and max, from the number of
+// tasks waiting to run and the number of online CPUs.
+type SliceCurve func(min, max, waiting, cpus uint64) uint64
+
+// DefaultSliceCurve divides max by the number of tasks waiting for each CPU
+// (plus one), clamped to min: each CPU gets the max slice when no task is
+// waiting, half of it with one waiting task per CPU, and so on. Long slices
+// favor throughput under light load, short slices favor latency under
+// contention.
+func DefaultSliceCurve(min, max, waiting, cpus uint64) uint64 {
+	if cpus == 0 {
+		cpus = 1
+	}
+	slice := max * cpus / (waiting + cpus)
+	if slice < min {
+		return min
+	}
+	return slice
+}
+
+// EnableAutoSlice periodically adjusts the default time slice between min and
+// max according to DefaultSliceCurve, using the number of tasks queued to and
+// scheduled by the user-space scheduler as the number of waiting tasks.
+//
+// The default time slice is used by the tasks dispatched by the BPF
+// component and, while the tuner runs, by the tasks dispatched with
+// DispatchedTask.SliceNs set to 0. The tuner runs until DisableAutoSlice or
+// Close is called.
+func (s *Sched) EnableAutoSlice(min, max uint64) error {
+	return s.EnableAutoSliceWith(min, max, DefaultSliceCurve)
+}
+
+// EnableAutoSliceWith is like EnableAutoSlice, with a custom scaling curve.
+// It replaces the tuner started by a previous call.
+func (s *Sched) EnableAutoSliceWith(min, max uint64, curve SliceCurve) error {
+	if min == 0 || min > max {
+		return fmt.Errorf("invalid auto slice range: [%d, %d]", min, max)
+	}
+	if curve == nil {
+		return fmt.Errorf("nil slice curve")
+	}
+	if err := s.acquire(); err != nil {
+		return err
+	}
+	defer s.release()
+	s.autoSliceMu.Lock()
+	defer s.autoSliceMu.Unlock()
+	if s.autoSliceStop != nil {
+		close(s.autoSliceStop)
+	}
+	stop := make(chan struct{})
+	s.autoSliceStop = stop
+	go s.autoSlice(min, max, curve, stop)
+	return nil
+}
+
+// DisableAutoSlice stops the tuner started by EnableAutoSlice and restores
+// the default time slice.
+func (s *Sched) DisableAutoSlice() error {
+	if err := s.acquire(); err != nil {
+		return err
+	}
+	defer s.release()
+	s.autoSliceMu.Lock()
+	defer s.autoSliceMu.Unlock()
+	if s.autoSliceStop != nil {
+		close(s.autoSliceStop)
+		s.autoSliceStop = nil
+	}
+	C.set_auto_slice_ns(0)
+	return nil
+}
+
+// AutoSlice returns the time slice set by the tuner (0 = not set).
+func (s *Sched) AutoSlice() (uint64, error) {
+	if err := s.acquire(); err != nil {
+		return 0, err
+	}
+	defer s.release()
+	return uint64(C.get_auto_slice_ns()), nil
+}
+
+func (s *Sched) autoSlice(min, max uint64, curve SliceCurve, stop chan struct{}) {
+	ticker := time.NewTicker(autoSliceInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-stop:
+			return
+		case <-s.done:
+			return
+		}
+		if err := s.updateAutoSlice(min, max, curve, stop); err != nil {
+			return
+		}
+	}
+}
+
+func (s *Sched) updateAutoSlice(min, max uint64, curve SliceCurve, stop chan struct{}) error {
+	bss, err := s.GetBssData()
+	if err != nil {
+		return err
+	}
+	slice := curve(min, max, bss.Nr_queued+bss.Nr_scheduled, bss.Nr_online_cpus)
+	if err := s.acquire(); err != nil {
+		return err
+	}
+	defer s.release()
+	s.autoSliceMu.Lock()
+	defer s.autoSliceMu.Unlock()
+	// Don't override the slice after the tuner has been stopped or
+	// replaced.
+	if s.autoSliceStop == stop {
+		C.set_auto_slice_ns(C.u64(clamp(slice, min, max)))
+	}
+	return nil
+}
+
+func clamp(v, lo, hi uint64) uint64 {
+	return min(max(v, lo), hi)
+}
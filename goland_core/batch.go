@@ -0,0 +1,330 @@
+package core
+
+/*
+#include "wrapper.h"
+*/
+import "C"
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"syscall"
+	"unsafe"
+
+	bpf "github.com/aquasecurity/libbpfgo"
+	"golang.org/x/sys/unix"
+)
+
+// BatchPath tells how a batch of map entries has been read or written.
+type BatchPath int
+
+const (
+	// BatchSyscall: a single BPF_MAP_*_BATCH command per batch.
+	BatchSyscall BatchPath = iota
+	// BatchPerElement: one command per entry, because the kernel or the
+	// map doesn't support batch operations.
+	BatchPerElement
+)
+
+func (p BatchPath) String() string {
+	switch p {
+	case BatchSyscall:
+		return "batch"
+	case BatchPerElement:
+		return "per-element"
+	}
+	return fmt.Sprintf("BatchPath(%d)", int(p))
+}
+
+// lookupBatchSize is the number of entries read by each BPF_MAP_LOOKUP_BATCH.
+const lookupBatchSize = 256
+
+// errnoENOTSUPP is the kernel-internal ENOTSUPP, returned by the maps that
+// don't implement batch operations.
+const errnoENOTSUPP = syscall.Errno(524)
+
+// batchUnsupported returns true if err means that batch operations are not
+// available, either in the kernel or for the map.
+func batchUnsupported(err error) bool {
+	return errors.Is(err, unix.EINVAL) || errors.Is(err, unix.EOPNOTSUPP) ||
+		errors.Is(err, errnoENOTSUPP)
+}
+
+// UpdatePidMapBatch sets the values of the pids in entries in m, a map keyed
+// by pid (e.g. task_prio or task_tags), with a single BPF_MAP_UPDATE_BATCH
+// command. On kernels without batch support the entries are updated one at a
+// time. Each value must be as large as the values of m.
+func UpdatePidMapBatch(m *bpf.BPFMap, entries map[int32][]byte) error {
+	_, err := updatePidMapBatch(m, entries)
+	return err
+}
+
+func updatePidMapBatch(m *bpf.BPFMap, entries map[int32][]byte) (BatchPath, error) {
+	if m.KeySize() != 4 {
+		return BatchSyscall, fmt.Errorf("%s key size %d doesn't match a pid", m.Name(), m.KeySize())
+	}
+	valSize := m.ValueSize()
+	keys := make([]byte, 0, len(entries)*4)
+	vals := make([]byte, 0, len(entries)*valSize)
+	for pid, val := range entries {
+		if len(val) != valSize {
+			return BatchSyscall, fmt.Errorf("%s value size %d doesn't match %d (pid %d)", m.Name(), len(val), valSize, pid)
+		}
+		keys = binary.NativeEndian.AppendUint32(keys, uint32(pid))
+		vals = append(vals, val...)
+	}
+	return updateMapBatch(m, keys, vals)
+}
+
+// updateMapBatch sets the values vals of the keys keys in m, both packed
+// one after the other.
+func updateMapBatch(m *bpf.BPFMap, keys, vals []byte) (BatchPath, error) {
+	keySize, valSize := m.KeySize(), m.ValueSize()
+	n := len(keys) / keySize
+	if n == 0 {
+		return BatchSyscall, nil
+	}
+	err := m.UpdateBatch(unsafe.Pointer(&keys[0]), unsafe.Pointer(&vals[0]), uint32(n))
+	if err == nil || !batchUnsupported(err) {
+		return BatchSyscall, err
+	}
+	for i := 0; i < n; i++ {
+		key := unsafe.Pointer(&keys[i*keySize])
+		if err := m.Update(key, unsafe.Pointer(&vals[i*valSize])); err != nil {
+			return BatchPerElement, fmt.Errorf("update %s: %w", m.Name(), err)
+		}
+	}
+	return BatchPerElement, nil
+}
+
+// deleteMapBatch deletes the keys keys, packed one after the other, from m.
+// Missing keys are ignored.
+func deleteMapBatch(m *bpf.BPFMap, keys []byte) (BatchPath, error) {
+	keySize := m.KeySize()
+	n := len(keys) / keySize
+	if n == 0 {
+		return BatchSyscall, nil
+	}
+	err := m.DeleteKeyBatch(unsafe.Pointer(&keys[0]), uint32(n))
+	if err == nil {
+		return BatchSyscall, nil
+	}
+	// The batch stops at the first missing key: delete the others one at
+	// a time.
+	if !batchUnsupported(err) && !errors.Is(err, unix.ENOENT) {
+		return BatchSyscall, err
+	}
+	for i := 0; i < n; i++ {
+		err := m.DeleteKey(unsafe.Pointer(&keys[i*keySize]))
+		if err != nil && !errors.Is(err, unix.ENOENT) {
+			return BatchPerElement, fmt.Errorf("delete %s: %w", m.Name(), err)
+		}
+	}
+	return BatchPerElement, nil
+}
+
+// LookupPidMapBatch returns all the entries of m, a map keyed by pid, read
+// with BPF_MAP_LOOKUP_BATCH, or by iterating over the map on kernels without
+// batch support.
+func LookupPidMapBatch(m *bpf.BPFMap) (map[int32][]byte, error) {
+	entries, _, err := lookupPidMapBatch(m)
+	return entries, err
+}
+
+func lookupPidMapBatch(m *bpf.BPFMap) (map[int32][]byte, BatchPath, error) {
+	if m.KeySize() != 4 {
+		return nil, BatchSyscall, fmt.Errorf("%s key size %d doesn't match a pid", m.Name(), m.KeySize())
+	}
+	entries := make(map[int32][]byte)
+	keys := make([]uint32, lookupBatchSize)
+	var next uint32
+	var start unsafe.Pointer
+	for {
+		vals, err := m.GetValueBatch(unsafe.Pointer(&keys[0]), start, unsafe.Pointer(&next), lookupBatchSize)
+		if err != nil {
+			if batchUnsupported(err) && len(entries) == 0 {
+				entries, err := lookupMapPerElement(m)
+				return entries, BatchPerElement, err
+			}
+			return nil, BatchSyscall, fmt.Errorf("lookup %s: %w", m.Name(), err)
+		}
+		for i, val := range vals {
+			entries[int32(keys[i])] = val
+		}
+		if len(vals) < lookupBatchSize {
+			return entries, BatchSyscall, nil
+		}
+		// The next batch starts from the position returned in next
+		// (opaque, a bucket for hash maps): the kernel reads the start
+		// position before writing the next one, so they can share the
+		// same buffer.
+		start = unsafe.Pointer(&next)
+	}
+}
+
+// lookupMapPerElement returns all the entries of m, a map keyed by pid,
+// iterating over its keys.
+func lookupMapPerElement(m *bpf.BPFMap) (map[int32][]byte, error) {
+	entries := make(map[int32][]byte)
+	iter := m.Iterator()
+	for iter.Next() {
+		key := iter.Key()
+		val, err := m.GetValue(unsafe.Pointer(&key[0]))
+		if errors.Is(err, unix.ENOENT) {
+			// Deleted in the meantime.
+			continue
+		} else if err != nil {
+			return nil, fmt.Errorf("lookup %s: %w", m.Name(), err)
+		}
+		entries[int32(binary.NativeEndian.Uint32(key))] = val
+	}
+	if err := iter.Err(); err != nil {
+		return nil, fmt.Errorf("iterate %s: %w", m.Name(), err)
+	}
+	return entries, nil
+}
+
+// SetTaskPriorities is like SetTaskPriority for many pids at once: the
+// priorities are written with a batch command, falling back to one update per
+// pid if it's not supported. The returned BatchPath tells which path was
+// used. The priorities are validated before any is written.
+func (s *Sched) SetTaskPriorities(prios map[int32]TaskPriority) (BatchPath, error) {
+	for pid, prio := range prios {
+		if prio > TaskPriorityBackground {
+			return BatchSyscall, fmt.Errorf("invalid task priority for pid %d: %v", pid, prio)
+		}
+	}
+	if err := s.acquire(); err != nil {
+		return BatchSyscall, err
+	}
+	defer s.release()
+	if s.taskPrio == nil {
+		return BatchSyscall, mapNotFound("task_prio")
+	}
+	var keys, dels, vals []byte
+	for pid, prio := range prios {
+		if prio == TaskPriorityNormal {
+			dels = binary.NativeEndian.AppendUint32(dels, uint32(pid))
+			continue
+		}
+		keys = binary.NativeEndian.AppendUint32(keys, uint32(pid))
+		vals = binary.NativeEndian.AppendUint32(vals, uint32(prio))
+	}
+	path, err := updateMapBatch(s.taskPrio, keys, vals)
+	if err != nil {
+		return path, err
+	}
+	delPath, err := deleteMapBatch(s.taskPrio, dels)
+	return max(path, delPath), err
+}
+
+// TaskPriorities returns the priorities assigned to all the pids, read with a
+// batch command if it's supported.
+func (s *Sched) TaskPriorities() (map[int32]TaskPriority, error) {
+	if err := s.acquire(); err != nil {
+		return nil, err
+	}
+	defer s.release()
+	if s.taskPrio == nil {
+		return nil, mapNotFound("task_prio")
+	}
+	entries, _, err := lookupPidMapBatch(s.taskPrio)
+	if err != nil {
+		return nil, err
+	}
+	prios := make(map[int32]TaskPriority, len(entries))
+	for pid, b := range entries {
+		prios[pid] = TaskPriority(binary.NativeEndian.Uint32(b))
+	}
+	return prios, nil
+}
+
+// SetTaskTags is like SetTaskTag for many pids at once: the tags are written
+// with a batch command, falling back to one update per pid if it's not
+// supported. The returned BatchPath tells which path was used. The tags are
+// validated before any is written.
+func (s *Sched) SetTaskTags(tags map[int32]TaskTag) (BatchPath, error) {
+	for _, tag := range tags {
+		if err := checkTaskTag(tag); err != nil {
+			return BatchSyscall, err
+		}
+	}
+	if err := s.acquire(); err != nil {
+		return BatchSyscall, err
+	}
+	defer s.release()
+	if s.taskTags == nil {
+		return BatchSyscall, mapNotFound("task_tags")
+	}
+	keys := make([]byte, 0, len(tags)*4)
+	vals := make([]byte, 0, len(tags)*8)
+	for pid, tag := range tags {
+		keys = binary.NativeEndian.AppendUint32(keys, uint32(pid))
+		vals = binary.NativeEndian.AppendUint64(vals, uint64(tag))
+	}
+	return updateMapBatch(s.taskTags, keys, vals)
+}
+
+// TaskTags returns the tags assigned to all the pids, read with a batch
+// command if it's supported.
+func (s *Sched) TaskTags() (map[int32]TaskTag, error) {
+	if err := s.acquire(); err != nil {
+		return nil, err
+	}
+	defer s.release()
+	if s.taskTags == nil {
+		return nil, mapNotFound("task_tags")
+	}
+	entries, _, err := lookupPidMapBatch(s.taskTags)
+	if err != nil {
+		return nil, err
+	}
+	tags := make(map[int32]TaskTag, len(entries))
+	for pid, b := range entries {
+		tags[pid] = TaskTag(binary.NativeEndian.Uint64(b))
+	}
+	return tags, nil
+}
+
+// AddManagedCgroups is like AddManagedCgroup for many cgroups at once: the
+// cgroups are added with a batch command, falling back to one update per
+// cgroup if it's not supported. The returned BatchPath tells which path was
+// used. All the paths are resolved before any cgroup is added.
+func (s *Sched) AddManagedCgroups(paths []string) (BatchPath, error) {
+	if err := s.acquire(); err != nil {
+		return BatchSyscall, err
+	}
+	defer s.release()
+	s.cgroupsMu.Lock()
+	defer s.cgroupsMu.Unlock()
+	if s.cgroups == nil {
+		return BatchSyscall, mapNotFound("managed_cgroups")
+	}
+	var keys, vals []byte
+	added := make(map[uint64]bool)
+	for _, path := range paths {
+		cgid, err := CgroupID(path)
+		if err != nil {
+			return BatchSyscall, err
+		}
+		if added[cgid] {
+			continue
+		}
+		added[cgid] = true
+		keys = binary.NativeEndian.AppendUint64(keys, cgid)
+		vals = append(vals, 1)
+	}
+	// Count the cgroups that are not managed yet.
+	for cgid := range added {
+		if _, err := s.cgroups.GetValue(unsafe.Pointer(&cgid)); err == nil {
+			delete(added, cgid)
+		}
+	}
+	path, err := updateMapBatch(s.cgroups, keys, vals)
+	if err != nil {
+		return path, fmt.Errorf("add managed cgroups: %w", err)
+	}
+	C.set_nr_managed_cgroups(C.get_nr_managed_cgroups() + C.u64(len(added)))
+	return path, nil
+}
@@ -0,0 +1,52 @@
+package core
+
+import "testing"
+
+func TestMeasureDispatch(t *testing.T) {
+	records := GenerateQueuedTasks(64)
+	m := NewMockBackend()
+	if err := m.EnqueueRecords(records); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.EnqueueRecords([][]byte{make([]byte, 8)}); err == nil {
+		t.Fatalf("short record enqueued")
+	}
+	run, err := MeasureDispatch(m, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if run.Tasks != len(records) || run.TasksPerSec() <= 0 {
+		t.Fatalf("run %+v", run)
+	}
+	for i, task := range m.Dispatched() {
+		if want := int32(1000 + i); task.Pid != want {
+			t.Fatalf("task %d dispatched with pid %d, want %d", i, task.Pid, want)
+		}
+	}
+}
+
+// BenchmarkDispatchBaseline measures the dispatch path of the package, from
+// the decoding of the queued records to DispatchTask, with the default
+// decision. It reports the throughput in tasks/s, to track regressions.
+func BenchmarkDispatchBaseline(b *testing.B) {
+	const batch = 1024
+	records := GenerateQueuedTasks(batch)
+	var total DispatchRun
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		m := NewMockBackend()
+		if err := m.EnqueueRecords(records); err != nil {
+			b.Fatal(err)
+		}
+		b.StartTimer()
+		run, err := MeasureDispatch(m, nil)
+		if err != nil {
+			b.Fatal(err)
+		}
+		total.Tasks += run.Tasks
+		total.Elapsed += run.Elapsed
+	}
+	b.ReportMetric(total.TasksPerSec(), "tasks/s")
+}
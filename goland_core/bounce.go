@@ -0,0 +1,117 @@
+package core
+
+/*
+#include "wrapper.h"
+*/
+import "C"
+
+import (
+	"encoding/binary"
+	"fmt"
+	"unsafe"
+)
+
+// Size of the channel returned by BounceEvents.
+const bounceEventChanSize = 256
+
+// BounceReason tells why the BPF component couldn't honor a dispatch of the
+// user-space scheduler (see intf.h::bounce_reason).
+type BounceReason uint32
+
+const (
+	// BounceExited: the task exited before it could be dispatched, the
+	// dispatch has been dropped.
+	BounceExited BounceReason = iota
+	// BounceNotAllowed: the task can't run on the target CPU, it has been
+	// dispatched to the shared DSQ instead.
+	BounceNotAllowed
+	// BounceCancelled: the affinity of the task changed while it was
+	// dispatched, the kernel re-enqueues it.
+	BounceCancelled
+	// BounceOffline: the target CPU is offline, the task has been
+	// dispatched to the shared DSQ instead.
+	BounceOffline
+	// BounceNoLLCDsq: the LLC queue of the dispatch has not been set up
+	// (see SetupPerLLCQueues) or none of its CPUs can run the task, it has
+	// been dispatched to the shared DSQ instead.
+	BounceNoLLCDsq
+	// NrBounceReasons is the number of bounce reasons.
+	NrBounceReasons
+)
+
+func (r BounceReason) String() string {
+	switch r {
+	case BounceExited:
+		return "exited"
+	case BounceNotAllowed:
+		return "not-allowed"
+	case BounceCancelled:
+		return "cancelled"
+	case BounceOffline:
+		return "offline"
+	case BounceNoLLCDsq:
+		return "no-llc-dsq"
+	}
+	return fmt.Sprintf("BounceReason(%d)", uint32(r))
+}
+
+// BounceEvent is a dispatch that the BPF component couldn't honor (see
+// bpf_intf::bounce_event_ctx).
+type BounceEvent struct {
+	Pid    int32        // pid of the dispatched task
+	Cpu    int32        // CPU requested by the scheduler
+	Reason BounceReason // why the dispatch bounced
+}
+
+// BounceEvents returns the channel where the bounced dispatches are
+// delivered if LoadSchedOpts.BounceEvents is set, nil otherwise. Events are
+// dropped if the channel is full.
+func (s *Sched) BounceEvents() <-chan BounceEvent {
+	return s.bounces
+}
+
+// enableBounceEvents makes the BPF component send the bounced dispatches to
+// bounce_rb.
+func enableBounceEvents() {
+	C.set_bounce_events(1)
+}
+
+func (s *Sched) consumeBounceEvents() {
+	for {
+		select {
+		case b := <-s.bounceRb:
+			event, err := decodeBounceEvent(b)
+			if err != nil {
+				s.opts.Logger.Printf("decodeBounceEvent err: %v", err)
+				continue
+			}
+			select {
+			case s.bounces <- event:
+			default:
+			}
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// bounceStats reads the number of bounced dispatches by reason.
+func (s *Sched) bounceStats() (map[BounceReason]uint64, error) {
+	if err := s.acquire(); err != nil {
+		return nil, err
+	}
+	defer s.release()
+	if s.bounceMap == nil {
+		return nil, mapNotFound("bounce_stats")
+	}
+	stats := make(map[BounceReason]uint64, NrBounceReasons)
+	for r := BounceReason(0); r < NrBounceReasons; r++ {
+		key := uint32(r)
+		b, err := s.bounceMap.GetValue(unsafe.Pointer(&key))
+		if err != nil {
+			return nil, fmt.Errorf("read bounce_stats: %w", err)
+		}
+		stats[r] = binary.NativeEndian.Uint64(b)
+	}
+	return stats, nil
+}
@@ -3,29 +3,42 @@ package core
 import (
 	"bytes"
 	"encoding/binary"
+	"errors"
 	"fmt"
+	"sync/atomic"
 	"unsafe"
 
 	bpf "github.com/aquasecurity/libbpfgo"
 )
 
 /*
+#include <stdlib.h>
 #include "wrapper.h"
 */
 import "C"
 
 type BssData struct {
-	Usersched_last_run_at uint64 `json:"usersched_last_run_at"` // The PID of the userspace scheduler
-	Nr_queued             uint64 `json:"nr_queued"`             // Number of tasks queued in the userspace scheduler
-	Nr_scheduled          uint64 `json:"nr_scheduled"`          // Number of tasks scheduled by the userspace scheduler
-	Nr_running            uint64 `json:"nr_running"`            // Number of tasks currently running in the userspace scheduler
-	Nr_online_cpus        uint64 `json:"nr_online_cpus"`        // Number of online CPUs in the system
-	Nr_user_dispatches    uint64 `json:"nr_user_dispatches"`    // Number of user-space dispatches
-	Nr_kernel_dispatches  uint64 `json:"nr_kernel_dispatches"`  // Number of kernel-space dispatches
-	Nr_cancel_dispatches  uint64 `json:"nr_cancel_dispatches"`  // Number of cancelled dispatches
-	Nr_bounce_dispatches  uint64 `json:"nr_bounce_dispatches"`  // Number of bounce dispatches
-	Nr_failed_dispatches  uint64 `json:"nr_failed_dispatches"`  // Number of failed dispatches
-	Nr_sched_congested    uint64 `json:"nr_sched_congested"`    // Number of times the scheduler was congested
+	Usersched_last_run_at   uint64 `json:"usersched_last_run_at"`   // The PID of the userspace scheduler
+	Nr_queued               uint64 `json:"nr_queued"`               // Number of tasks queued in the userspace scheduler
+	Nr_scheduled            uint64 `json:"nr_scheduled"`            // Number of tasks scheduled by the userspace scheduler
+	Nr_running              uint64 `json:"nr_running"`              // Number of tasks currently running in the userspace scheduler
+	Nr_online_cpus          uint64 `json:"nr_online_cpus"`          // Number of online CPUs in the system
+	Nr_user_dispatches      uint64 `json:"nr_user_dispatches"`      // Number of user-space dispatches
+	Nr_kernel_dispatches    uint64 `json:"nr_kernel_dispatches"`    // Number of kernel-space dispatches
+	Nr_cancel_dispatches    uint64 `json:"nr_cancel_dispatches"`    // Number of cancelled dispatches
+	Nr_bounce_dispatches    uint64 `json:"nr_bounce_dispatches"`    // Number of bounce dispatches
+	Nr_failed_dispatches    uint64 `json:"nr_failed_dispatches"`    // Number of failed dispatches
+	Nr_sched_congested      uint64 `json:"nr_sched_congested"`      // Number of times the scheduler was congested
+	Nr_prio_dispatches      uint64 `json:"nr_prio_dispatches"`      // Number of dispatches fast-pathed by the task priority
+	Nr_sched_saturated      uint64 `json:"nr_sched_saturated"`      // Number of tasks dispatched by BPF because too many tasks were queued
+	Nr_dispatch_received    uint64 `json:"nr_dispatch_received"`    // Number of dispatched tasks received by the BPF component
+	Nr_dispatch_dropped     uint64 `json:"nr_dispatch_dropped"`     // Number of dispatched tasks dropped because the task exited
+	Nr_protected_dispatches uint64 `json:"nr_protected_dispatches"` // Number of tasks of the protected processes dispatched directly
+	Vtime_now               uint64 `json:"vtime_now"`               // Highest vtime of the tasks that started running (see Sched.MinVtime)
+	Nr_select_fast          uint64 `json:"nr_select_fast"`          // Number of CPUs selected in ops.select_cpu() (see Sched.SelectPathStats)
+	Nr_select_user          uint64 `json:"nr_select_user"`          // Number of CPUs selected by the user-space scheduler through rs_select_cpu
+	Nr_exit_dropped         uint64 `json:"nr_exit_dropped"`         // Number of task exits lost because exit_rb was full (see Sched.DroppedExits and TaskEvent)
+	Nr_bypass_dispatches    uint64 `json:"nr_bypass_dispatches"`    // Number of tasks dispatched directly because of Bypass
 }
 
 func (data BssData) String() string {
@@ -33,52 +46,329 @@ func (data BssData) String() string {
 		fmt.Sprintf("Nr_scheduled: %v, Nr_running: %v ", data.Nr_scheduled, data.Nr_running) +
 		fmt.Sprintf("Nr_online_cpus: %v, Nr_user_dispatches: %v ", data.Nr_online_cpus, data.Nr_user_dispatches) +
 		fmt.Sprintf("Nr_kernel_dispatches: %v, Nr_cancel_dispatches: %v ", data.Nr_kernel_dispatches, data.Nr_cancel_dispatches) +
-		fmt.Sprintf("Nr_bounce_dispatches: %v, Nr_failed_dispatches: %v", data.Nr_bounce_dispatches, data.Nr_failed_dispatches) +
-		fmt.Sprintf("Nr_sched_congested: %v", data.Nr_sched_congested)
+		fmt.Sprintf("Nr_bounce_dispatches: %v, Nr_failed_dispatches: %v ", data.Nr_bounce_dispatches, data.Nr_failed_dispatches) +
+		fmt.Sprintf("Nr_sched_congested: %v, Nr_prio_dispatches: %v ", data.Nr_sched_congested, data.Nr_prio_dispatches) +
+		fmt.Sprintf("Nr_sched_saturated: %v, Nr_dispatch_received: %v ", data.Nr_sched_saturated, data.Nr_dispatch_received) +
+		fmt.Sprintf("Nr_dispatch_dropped: %v, Nr_protected_dispatches: %v", data.Nr_dispatch_dropped, data.Nr_protected_dispatches)
+}
+
+// Tunables mirrors the .data.tunables section of the BPF component: the
+// settings changed by the user-space scheduler, kept apart from the counters
+// of .bss so that they can be written together (see BssMap.WriteStruct).
+type Tunables struct {
+	Nr_managed_cgroups  uint64 `json:"nr_managed_cgroups"`  // Number of cgroups managed by the userspace scheduler (0 = all)
+	Max_queued          uint64 `json:"max_queued"`          // Queued tasks threshold of the kernel fallback (0 = default)
+	Nr_queued_shards    uint64 `json:"nr_queued_shards"`    // Number of queued ring buffers (0 = 1)
+	Auto_slice_ns       uint64 `json:"auto_slice_ns"`       // Time slice set by the auto-tuner (0 = default slice)
+	Bounce_events       uint64 `json:"bounce_events"`       // Bounced dispatches are sent to bounce_rb (see LoadSchedOpts.BounceEvents)
+	Bypass              uint64 `json:"bypass"`              // The user-space scheduler is bypassed (see Sched.SetBypass)
+	Task_events         uint64 `json:"task_events"`         // The enable/disable and affinity events of the tasks are sent to exit_rb (see LoadSchedOpts.TaskEvents)
+	Cpumask_events      uint64 `json:"cpumask_events"`      // The affinity events of the tasks are sent to exit_rb, for the SelectCPU cache (see LoadSchedOpts.SelectCPUCacheSize)
+	Queued_shard_by_cpu uint64 `json:"queued_shard_by_cpu"` // The queued tasks are sharded by CPU (see LoadSchedOpts.PinQueuedConsumers)
 }
 
 func LoadSkel() unsafe.Pointer {
 	return C.open_skel()
 }
 
+// loadSkelFromBytes opens the skeleton from a copy of the BPF object obj. The
+// copy is returned in buf and must be released with freeSkelBuf once the
+// object is closed.
+func loadSkelFromBytes(obj []byte) (skel, buf unsafe.Pointer, err error) {
+	buf = C.CBytes(obj)
+	skel, err = C.open_skel_from(buf, C.size_t(len(obj)))
+	if skel == nil {
+		C.free(buf)
+		return nil, nil, fmt.Errorf("failed to open BPF object: %w", err)
+	}
+	return skel, buf, nil
+}
+
+// reloadSkelFromBytes is like loadSkelFromBytes, but the new skeleton gets
+// the settings (rodata and bss tunables) of the current one, that is
+// returned in prev.
+func reloadSkelFromBytes(obj []byte) (skel, buf, prev unsafe.Pointer, err error) {
+	buf = C.CBytes(obj)
+	skel, err = C.reopen_skel_from(buf, C.size_t(len(obj)), &prev)
+	if skel == nil {
+		C.free(buf)
+		return nil, nil, nil, fmt.Errorf("failed to open BPF object: %w", err)
+	}
+	return skel, buf, prev, nil
+}
+
+// restoreSkel makes prev the current skeleton again, after a failed
+// reloadSkelFromBytes. objClosed tells if the BPF object of the new
+// skeleton has already been closed by its module.
+func restoreSkel(prev unsafe.Pointer, objClosed bool) {
+	C.restore_skel(prev, C.bool(objClosed))
+}
+
+// releaseSkel releases a skeleton replaced by reloadSkelFromBytes, once its
+// module has been closed.
+func releaseSkel(skel unsafe.Pointer) {
+	C.release_skel(skel, true)
+}
+
+// closeSkel releases the current skeleton, after a failed load or when the
+// scheduler is closed. objClosed tells if its BPF object has already been
+// closed by its module.
+func closeSkel(objClosed bool) {
+	C.close_skel(C.bool(objClosed))
+}
+
+func freeSkelBuf(buf unsafe.Pointer) {
+	if buf != nil {
+		C.free(buf)
+	}
+}
+
 func GetUserSchedPid() int {
 	return int(C.get_usersched_pid())
 }
 
+// GetNrQueued returns the number of tasks sent by the BPF component that the
+// user-space scheduler didn't dequeue yet (see Sched.NotifyComplete), or 0 if
+// no scheduler is loaded.
 func GetNrQueued() uint64 {
 	return uint64(C.get_nr_queued())
 }
+
+// GetNrScheduled returns the number of pending tasks last reported with
+// NotifyComplete, or 0 if no scheduler is loaded.
 func GetNrScheduled() uint64 {
 	return uint64(C.get_nr_scheduled())
 }
 
+// NotifyComplete is Sched.NotifyComplete for the scheduler that is loaded. It
+// fails with ErrClosed if no scheduler is loaded.
 func NotifyComplete(nr_pending uint64) error {
+	if C.get_bss() == nil {
+		return ErrClosed
+	}
 	C.notify_complete(C.u64(nr_pending))
 	return nil
 }
 
+// NotifyComplete reports to the BPF component the number of tasks that the
+// user-space scheduler holds: received with DequeueTask, but not dispatched
+// yet. It must be called at the end of every scheduling round, with 0 when
+// the scheduler has nothing left to dispatch.
+//
+// The BPF component relies on two counters to know if the user-space
+// scheduler has work to do:
+//
+//   - nr_queued (GetNrQueued) is incremented by the BPF component for each
+//     task sent to user space and decremented by DequeueTask;
+//   - nr_scheduled (GetNrScheduled) is the value set by NotifyComplete.
+//
+// While any of them is not 0, or the queued ring buffers are not empty, the
+// BPF component keeps the user-space scheduler running when a CPU goes idle.
+// Otherwise it lets it sleep until a new task is queued. A scheduler that
+// stops running while holding tasks is detected by the BPF component, which
+// wakes it up periodically and dispatches new tasks directly once nr_queued
+// reaches the threshold of SetMaxQueued: reporting a stale value here keeps
+// the scheduler busy for nothing, or leaves tasks waiting.
+func (s *Sched) NotifyComplete(nrPending uint64) error {
+	if err := s.acquire(); err != nil {
+		return err
+	}
+	defer s.release()
+	C.notify_complete(C.u64(nrPending))
+	return nil
+}
+
+// SetMaxQueued sets the maximum amount of tasks that can wait to be consumed
+// by the user-space scheduler: beyond this threshold the BPF component
+// dispatches new tasks directly on the shared DSQ, so that a stalled
+// user-space scheduler doesn't stall the whole system. Setting it to 0
+// restores the default threshold. It can be changed at any time.
+func (s *Sched) SetMaxQueued(nr uint64) error {
+	if err := s.acquire(); err != nil {
+		return err
+	}
+	defer s.release()
+	C.set_max_queued(C.u64(nr))
+	return nil
+}
+
+// SetBypass enables or disables the bypass of the user-space scheduler: while
+// it is enabled, the scheduler stays attached but the BPF component
+// dispatches every new task directly on the shared DSQ, as it does when the
+// user-space scheduler is saturated, instead of queuing it to user space. It
+// is meant as a safety switch, e.g. to rule out a bug of the scheduling
+// policy without detaching.
+//
+// It can be changed at any time: the tasks already queued to user space when
+// the bypass is enabled are still dispatched as decided by the user-space
+// scheduler. The current mode and the tasks dispatched in bypass mode are
+// reported by Health.
+func (s *Sched) SetBypass(on bool) error {
+	if err := s.acquire(); err != nil {
+		return err
+	}
+	defer s.release()
+	enabled := uint64(0)
+	if on {
+		enabled = 1
+	}
+	C.set_bypass(C.u64(enabled))
+	return nil
+}
+
+// GetTunables returns the current tunables of the BPF component.
+func (s *Sched) GetTunables() (Tunables, error) {
+	if err := s.acquire(); err != nil {
+		return Tunables{}, err
+	}
+	defer s.release()
+	if s.tunables == nil {
+		return Tunables{}, mapNotFound(".data.tunables")
+	}
+	var t Tunables
+	if err := s.tunables.ReadAll(&t); err != nil {
+		return Tunables{}, err
+	}
+	return t, nil
+}
+
+// SetTunables changes all the tunables of the BPF component at once, with a
+// single map update (see BssMap.WriteStruct): start from GetTunables and
+// change the fields of interest. Nr_queued_shards and Queued_shard_by_cpu are
+// fixed when the BPF object is loaded, and Nr_managed_cgroups is maintained
+// by the cgroup filter (see AddManagedCgroup): they can't be changed here.
+func (s *Sched) SetTunables(t Tunables) error {
+	if err := s.acquire(); err != nil {
+		return err
+	}
+	defer s.release()
+	if s.tunables == nil {
+		return mapNotFound(".data.tunables")
+	}
+	var cur Tunables
+	if err := s.tunables.ReadAll(&cur); err != nil {
+		return err
+	}
+	if t.Nr_queued_shards != cur.Nr_queued_shards || t.Queued_shard_by_cpu != cur.Queued_shard_by_cpu {
+		return fmt.Errorf("tunables: the queued shards can't be changed after load")
+	}
+	if t.Nr_managed_cgroups != cur.Nr_managed_cgroups {
+		return fmt.Errorf("tunables: Nr_managed_cgroups is maintained by the cgroup filter")
+	}
+	return s.tunables.WriteStruct(&t)
+}
+
+// SubNrQueued decrements nr_queued (see NotifyComplete) for a task consumed
+// by the user-space scheduler. DequeueTask calls it for every record it
+// receives, even the ones it can't decode.
 func (s *Sched) SubNrQueued() error {
-	C.sub_nr_queued()
+	return s.subNrQueued(1)
+}
+
+func (s *Sched) subNrQueued(n uint64) error {
+	if err := s.acquire(); err != nil {
+		return err
+	}
+	defer s.release()
+	C.sub_nr_queued(C.u64(n))
 	return nil
 }
 
+// dropQueued accounts n queued records dropped before they could be
+// decoded: the BPF component counted them in nr_queued.
+func (s *Sched) dropQueued(n uint64) {
+	s.queuedDropped.Add(n)
+	if err := s.subNrQueued(n); err != nil && !errors.Is(err, ErrClosed) {
+		s.opts.Logger.Printf("SubNrQueued err: %v", err)
+	}
+}
+
 type BssMap struct {
 	*bpf.BPFMap
 }
 
-func (s *Sched) GetBssData() (BssData, error) {
-	if s.bss == nil {
-		return BssData{}, fmt.Errorf("BssMap is nil")
+// ReadAll reads the whole .bss section with a single map lookup and decodes
+// it into dst, which must be a pointer to a fixed-size struct mirroring the
+// layout of the .bss section (see BssData). dst may describe only the first
+// part of the section, but it can't be larger than the section itself.
+func (m *BssMap) ReadAll(dst interface{}) error {
+	size := binary.Size(dst)
+	if size < 0 {
+		return fmt.Errorf("bss: invalid destination type %T", dst)
+	}
+	if valueSize := m.BPFMap.ValueSize(); size > valueSize {
+		return fmt.Errorf("bss: %T is %d bytes, larger than the bss value (%d bytes)", dst, size, valueSize)
 	}
 	i := 0
-	b, err := s.bss.BPFMap.GetValue(unsafe.Pointer(&i))
+	b, err := m.BPFMap.GetValue(unsafe.Pointer(&i))
 	if err != nil {
+		return err
+	}
+	return binary.Read(bytes.NewReader(b), binary.NativeEndian, dst)
+}
+
+// WriteStruct encodes src, a fixed-size struct mirroring the layout of the
+// section (e.g. Tunables), and writes it with a single map update, so that
+// the BPF component never observes a partially updated set of tunables. src
+// must describe the whole section: it is meant for the sections holding only
+// settings, since the whole value is overwritten.
+func (m *BssMap) WriteStruct(src interface{}) error {
+	size := binary.Size(src)
+	if size < 0 {
+		return fmt.Errorf("bss: invalid source type %T", src)
+	}
+	if valueSize := m.BPFMap.ValueSize(); size != valueSize {
+		return fmt.Errorf("bss: %T is %d bytes, the section value is %d bytes", src, size, valueSize)
+	}
+	var buf bytes.Buffer
+	buf.Grow(size)
+	if err := binary.Write(&buf, binary.NativeEndian, src); err != nil {
+		return err
+	}
+	i := 0
+	return m.BPFMap.Update(unsafe.Pointer(&i), unsafe.Pointer(&buf.Bytes()[0]))
+}
+
+// tunablesSize returns the size of the .data.tunables section of the
+// skeleton, that Tunables must match.
+func tunablesSize() uintptr {
+	return unsafe.Sizeof(C.struct_main_bpf__data_tunables{})
+}
+
+// Number of u64 words of BssData: all its fields are u64, so it can be read
+// word by word from the memory mapping of the .bss section.
+const bssDataWords = int(unsafe.Sizeof(BssData{}) / 8)
+
+// loadBssData copies the memory mapping src of the .bss section to bss, with
+// an atomic load of each word, as the BPF component updates it concurrently.
+func loadBssData(src *[bssDataWords]uint64, bss *BssData) {
+	dst := (*[bssDataWords]uint64)(unsafe.Pointer(bss))
+	for i := range src {
+		dst[i] = atomic.LoadUint64(&src[i])
+	}
+}
+
+// GetBssData returns the content of the .bss section. It reads the memory
+// mapping of the section when available, without any syscall, and falls back
+// to a map lookup otherwise. It fails if the section of the BPF object is
+// smaller than BssData (e.g. an older object loaded with SkipABICheck).
+func (s *Sched) GetBssData() (BssData, error) {
+	if err := s.acquire(); err != nil {
 		return BssData{}, err
 	}
+	defer s.release()
+	if s.bss == nil {
+		return BssData{}, fmt.Errorf("BssMap is nil")
+	}
 	var bss BssData
-	buff := bytes.NewBuffer(b)
-	err = binary.Read(buff, binary.LittleEndian, &bss)
-	if err != nil {
+	if size, valueSize := int(unsafe.Sizeof(bss)), s.bss.ValueSize(); size > valueSize {
+		return BssData{}, fmt.Errorf("bss: %T is %d bytes, larger than the bss value (%d bytes)", bss, size, valueSize)
+	}
+	if p := C.get_bss(); p != nil {
+		loadBssData((*[bssDataWords]uint64)(p), &bss)
+		return bss, nil
+	}
+	if err := s.bss.ReadAll(&bss); err != nil {
 		return BssData{}, err
 	}
 	return bss, nil
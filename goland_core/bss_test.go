@@ -0,0 +1,82 @@
+package core
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"testing"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestLoadBssData(t *testing.T) {
+	var words [bssDataWords]uint64
+	for i := range words {
+		words[i] = uint64(i + 1)
+	}
+	var bss BssData
+	loadBssData(&words, &bss)
+	if bss.Usersched_last_run_at != 1 {
+		t.Fatalf("first field = %d, want 1", bss.Usersched_last_run_at)
+	}
+	if got := (*[bssDataWords]uint64)(unsafe.Pointer(&bss)); *got != words {
+		t.Fatalf("got %v, want %v", *got, words)
+	}
+}
+
+// TestTunablesSize checks Tunables against the .data.tunables section of the
+// skeleton, that BssMap.WriteStruct overwrites as a whole.
+func TestTunablesSize(t *testing.T) {
+	if got, want := binary.Size(Tunables{}), int(tunablesSize()); got != want {
+		t.Fatalf("Tunables is %d bytes, .data.tunables is %d bytes", got, want)
+	}
+}
+
+func TestSchedTunablesWithoutMap(t *testing.T) {
+	s := &Sched{done: make(chan struct{})}
+	if _, err := s.GetTunables(); !errors.Is(err, ErrMapNotFound) {
+		t.Fatalf("GetTunables: %v, want ErrMapNotFound", err)
+	}
+	if err := s.SetTunables(Tunables{Bypass: 1}); !errors.Is(err, ErrMapNotFound) {
+		t.Fatalf("SetTunables: %v, want ErrMapNotFound", err)
+	}
+}
+
+// BenchmarkBssMmapRead reads the stats from the memory mapping of the .bss
+// section, as GetBssData does when libbpf mapped it.
+func BenchmarkBssMmapRead(b *testing.B) {
+	var words [bssDataWords]uint64
+	var bss BssData
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		loadBssData(&words, &bss)
+	}
+}
+
+// BenchmarkBssLookupRead reads the stats like the map lookup fallback of
+// GetBssData (see BssMap.ReadAll): a syscall copying the section to a buffer,
+// here a pread of a memfd of the same size instead of BPF_MAP_LOOKUP_ELEM,
+// and its decoding.
+func BenchmarkBssLookupRead(b *testing.B) {
+	fd, err := unix.MemfdCreate("bss", unix.MFD_CLOEXEC)
+	if err != nil {
+		b.Skip(err)
+	}
+	defer unix.Close(fd)
+	size := int(unsafe.Sizeof(BssData{}))
+	if err := unix.Ftruncate(fd, int64(size)); err != nil {
+		b.Fatal(err)
+	}
+	var bss BssData
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		buf := make([]byte, size)
+		if _, err := unix.Pread(fd, buf, 0); err != nil {
+			b.Fatal(err)
+		}
+		if err := binary.Read(bytes.NewReader(buf), binary.NativeEndian, &bss); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
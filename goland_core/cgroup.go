@@ -0,0 +1,92 @@
+package core
+
+/*
+#include "wrapper.h"
+*/
+import "C"
+
+import (
+	"fmt"
+	"path/filepath"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// CgroupRoot is the mount point of the cgroup v2 hierarchy, used to resolve
+// relative cgroup paths.
+const CgroupRoot = "/sys/fs/cgroup"
+
+// CgroupID returns the id of the cgroup v2 at path (the inode number of the
+// cgroup directory). Relative paths are resolved against CgroupRoot.
+func CgroupID(path string) (uint64, error) {
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(CgroupRoot, path)
+	}
+	var st unix.Stat_t
+	if err := unix.Stat(path, &st); err != nil {
+		return 0, fmt.Errorf("stat cgroup %s: %w", path, err)
+	}
+	return st.Ino, nil
+}
+
+// AddManagedCgroup restricts the user-space scheduler to the tasks of the
+// cgroup at path (in addition to the cgroups already added). Tasks outside of
+// the managed cgroups are dispatched directly by the BPF component.
+//
+// When no cgroup is managed (the default), all the tasks are sent to the
+// user-space scheduler.
+func (s *Sched) AddManagedCgroup(path string) error {
+	if err := s.acquire(); err != nil {
+		return err
+	}
+	defer s.release()
+	s.cgroupsMu.Lock()
+	defer s.cgroupsMu.Unlock()
+	if s.cgroups == nil {
+		return mapNotFound("managed_cgroups")
+	}
+	cgid, err := CgroupID(path)
+	if err != nil {
+		return err
+	}
+	_, err = s.cgroups.GetValue(unsafe.Pointer(&cgid))
+	exists := err == nil
+	val := uint8(1)
+	if err := s.cgroups.Update(unsafe.Pointer(&cgid), unsafe.Pointer(&val)); err != nil {
+		return fmt.Errorf("add managed cgroup %s: %w", path, err)
+	}
+	if !exists {
+		C.set_nr_managed_cgroups(C.get_nr_managed_cgroups() + 1)
+	}
+	return nil
+}
+
+// RemoveManagedCgroup removes the cgroup at path from the managed cgroups.
+// Removing the last managed cgroup makes the user-space scheduler manage all
+// the tasks again.
+func (s *Sched) RemoveManagedCgroup(path string) error {
+	if err := s.acquire(); err != nil {
+		return err
+	}
+	defer s.release()
+	s.cgroupsMu.Lock()
+	defer s.cgroupsMu.Unlock()
+	if s.cgroups == nil {
+		return mapNotFound("managed_cgroups")
+	}
+	cgid, err := CgroupID(path)
+	if err != nil {
+		return err
+	}
+	if _, err := s.cgroups.GetValue(unsafe.Pointer(&cgid)); err != nil {
+		return nil
+	}
+	if err := s.cgroups.DeleteKey(unsafe.Pointer(&cgid)); err != nil {
+		return fmt.Errorf("remove managed cgroup %s: %w", path, err)
+	}
+	if nr := C.get_nr_managed_cgroups(); nr > 0 {
+		C.set_nr_managed_cgroups(nr - 1)
+	}
+	return nil
+}
@@ -0,0 +1,134 @@
+package core
+
+import (
+	"errors"
+	"os"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+// newAnonRingBuf returns a queued ring buffer consumer backed by anonymous
+// memory instead of a ring buffer map, that never receives any record.
+func newAnonRingBuf(t *testing.T) *epollRingBuf {
+	t.Helper()
+	pageSize := os.Getpagesize()
+	rb := &epollRingBuf{
+		epfd:   -1,
+		evfd:   -1,
+		mask:   uint64(pageSize - 1),
+		ch:     make(chan []byte),
+		stop:   make(chan struct{}),
+		logger: nopLogger{},
+	}
+	fail := func(err error) {
+		rb.release()
+		t.Fatal(err)
+	}
+	var err error
+	anon := unix.MAP_ANONYMOUS | unix.MAP_PRIVATE
+	if rb.cons, err = unix.Mmap(-1, 0, pageSize, unix.PROT_READ|unix.PROT_WRITE, anon); err != nil {
+		fail(err)
+	}
+	if rb.prod, err = unix.Mmap(-1, 0, 3*pageSize, unix.PROT_READ, anon); err != nil {
+		fail(err)
+	}
+	rb.data = rb.prod[pageSize:]
+	if rb.epfd, err = unix.EpollCreate1(unix.EPOLL_CLOEXEC); err != nil {
+		fail(err)
+	}
+	if rb.evfd, err = unix.Eventfd(0, unix.EFD_CLOEXEC|unix.EFD_NONBLOCK); err != nil {
+		fail(err)
+	}
+	event := unix.EpollEvent{Events: unix.EPOLLIN, Fd: int32(rb.evfd)}
+	if err := unix.EpollCtl(rb.epfd, unix.EPOLL_CTL_ADD, rb.evfd, &event); err != nil {
+		fail(err)
+	}
+	return rb
+}
+
+func TestCloseTwice(t *testing.T) {
+	s := &Sched{done: make(chan struct{})}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatalf("second Close: %v", err)
+	}
+	if _, err := s.GetBssData(); !errors.Is(err, ErrClosed) {
+		t.Fatalf("GetBssData after Close: %v, want ErrClosed", err)
+	}
+}
+
+func TestCloseNil(t *testing.T) {
+	var s *Sched
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCloseBeforeAttach(t *testing.T) {
+	// A Sched loaded and started, with its consumers running, but never
+	// attached.
+	qrb := newAnonRingBuf(t)
+	qrb.Start()
+	s := &Sched{
+		done: make(chan struct{}),
+		qrbs: []*epollRingBuf{qrb},
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if !s.AttachedAt().IsZero() {
+		t.Fatalf("closed Sched reported as attached")
+	}
+	select {
+	case <-qrb.stop:
+	default:
+		t.Fatalf("queued consumer not stopped")
+	}
+}
+
+func TestClosePartialLoad(t *testing.T) {
+	// A load that failed before creating the done channel and the BPF
+	// module: only some of the optional components are set, and the
+	// consumer was never started.
+	s := &Sched{qrbs: []*epollRingBuf{newAnonRingBuf(t)}}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatalf("second Close: %v", err)
+	}
+}
+
+func TestCloseRingBuffersUnset(t *testing.T) {
+	// No exit_rb, cpu_rb, bounce_rb nor dispatched ring buffer: only the
+	// queued consumers, that fail to release their mappings.
+	broken := func() *epollRingBuf {
+		rb := newAnonRingBuf(t)
+		if err := unix.Munmap(rb.cons); err != nil {
+			t.Fatal(err)
+		}
+		rb.cons = make([]byte, 8)
+		return rb
+	}
+	s := &Sched{
+		done: make(chan struct{}),
+		qrbs: []*epollRingBuf{broken(), broken()},
+	}
+	err := s.Close()
+	if err == nil {
+		t.Fatalf("Close succeeded")
+	}
+	var joined interface{ Unwrap() []error }
+	if !errors.As(err, &joined) || len(joined.Unwrap()) != 2 {
+		t.Fatalf("got %v, want the errors of both ring buffers", err)
+	}
+	if !errors.Is(err, unix.EINVAL) {
+		t.Fatalf("got %v, want EINVAL", err)
+	}
+	if err2 := s.Close(); err2 != err {
+		t.Fatalf("second Close returned %v, want %v", err2, err)
+	}
+}
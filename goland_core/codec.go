@@ -0,0 +1,278 @@
+package core
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"strings"
+)
+
+// Sizes of the records exchanged with the BPF component (see intf.h).
+//
+// Records and prog arguments are plain C structs shared with the kernel, so
+// they are encoded with the host byte order and the padding of the C layout
+// is written explicitly (as zeroes).
+//
+// The decoders reject records of any other size: a mismatch means that the
+// layout of the BPF structs changed without updating this file. The BPF side
+// checks the same sizes and offsets at build time in goland_init().
+const (
+	queuedTaskSize     = 312          // sizeof(struct queued_task_ctx)
+	taskExitSize       = 152          // sizeof(struct task_exit_ctx)
+	cpuEventSize       = 16           // sizeof(struct cpu_event_ctx)
+	bounceEventSize    = 12           // sizeof(struct bounce_event_ctx)
+	dispatchedTaskSize = 56           // sizeof(struct dispatched_task_ctx)
+	taskCpuArgSize     = 16           // sizeof(struct task_cpu_arg)
+	domainArgSize      = 12           // sizeof(struct domain_arg)
+	preemptArgSize     = 4            // sizeof(struct preempt_cpu_arg)
+	llcDsqArgSize      = 8            // sizeof(struct llc_dsq_arg)
+	cpuPerfArgSize     = 8            // sizeof(struct cpu_perf_arg)
+	dsqDumpArgSize     = 8            // sizeof(struct dsq_dump_arg)
+	dsqDumpSize        = 88           // sizeof(struct dsq_dump)
+	idleCpumaskSize    = MAX_CPUS / 8 // sizeof(struct idle_cpumask)
+)
+
+// nrRecentCpus is the number of CPUs reported in queued_task_ctx.recent_cpus
+// (see intf.h::NR_RECENT_CPUS).
+const nrRecentCpus = 4
+
+// Flags of queued_task_ctx.task_flags (see intf.h::queued_task_flags).
+const (
+	queuedTaskKthread     = 1 << 0
+	queuedTaskCpuSelected = 1 << 1
+)
+
+// decodeQueuedTask decodes a record received from the queued ring buffer into
+// a newly allocated QueuedTask.
+func decodeQueuedTask(data []byte) (*QueuedTask, error) {
+	task := &QueuedTask{}
+	if err := fastDecode(data, task); err != nil {
+		return nil, err
+	}
+	return task, nil
+}
+
+func fastDecode(data []byte, task *QueuedTask) error {
+	if len(data) != queuedTaskSize {
+		return fmt.Errorf("data length %d doesn't match queued_task_ctx size %d", len(data), queuedTaskSize)
+	}
+	task.Pid = int32(binary.NativeEndian.Uint32(data[0:4]))
+	task.Cpu = int32(binary.NativeEndian.Uint32(data[4:8]))
+	task.NrCpusAllowed = binary.NativeEndian.Uint64(data[8:16])
+	task.Flags = binary.NativeEndian.Uint64(data[16:24])
+	task.StartTs = binary.NativeEndian.Uint64(data[24:32])
+	task.StopTs = binary.NativeEndian.Uint64(data[32:40])
+	task.SumExecRuntime = binary.NativeEndian.Uint64(data[40:48])
+	task.Weight = binary.NativeEndian.Uint64(data[48:56])
+	task.Vtime = binary.NativeEndian.Uint64(data[56:64])
+	task.Tgid = int32(binary.NativeEndian.Uint32(data[64:68]))
+	task.Nice = int32(binary.NativeEndian.Uint32(data[68:72]))
+	task.RuntimeNs = binary.NativeEndian.Uint64(data[72:80])
+	for i := range task.AllowedCpus {
+		off := 80 + i*8
+		task.AllowedCpus[i] = binary.NativeEndian.Uint64(data[off : off+8])
+	}
+	task.PrevCpu = int32(binary.NativeEndian.Uint32(data[208:212]))
+	task.Comm = decodeComm(data[212:228])
+	task.WakerCpu = int32(binary.NativeEndian.Uint32(data[228:232]))
+	task.WakeFlags = binary.NativeEndian.Uint64(data[232:240])
+	task.MigrationCount = binary.NativeEndian.Uint64(data[240:248])
+	task.RecentCpus = CpuMask{}
+	for i := 0; i < nrRecentCpus; i++ {
+		off := 248 + i*4
+		if cpu := int32(binary.NativeEndian.Uint32(data[off : off+4])); cpu >= 0 && cpu < MAX_CPUS {
+			task.RecentCpus.Set(int(cpu))
+		}
+	}
+	task.CgroupId = binary.NativeEndian.Uint64(data[264:272])
+	task.EnqueueTs = binary.NativeEndian.Uint64(data[272:280])
+	task.Nvcsw = binary.NativeEndian.Uint64(data[280:288])
+	task.Nivcsw = binary.NativeEndian.Uint64(data[288:296])
+	taskFlags := binary.NativeEndian.Uint64(data[296:304])
+	task.IsKthread = taskFlags&queuedTaskKthread != 0
+	task.CpuSelected = taskFlags&queuedTaskCpuSelected != 0
+	task.RunningTs = binary.NativeEndian.Uint64(data[304:312])
+
+	return nil
+}
+
+// encodeQueuedTask serializes t as a record of the queued ring buffer (see
+// intf.h::queued_task_ctx), the inverse of fastDecode.
+func encodeQueuedTask(t *QueuedTask) []byte {
+	data := make([]byte, queuedTaskSize)
+
+	binary.NativeEndian.PutUint32(data[0:4], uint32(t.Pid))
+	binary.NativeEndian.PutUint32(data[4:8], uint32(t.Cpu))
+	binary.NativeEndian.PutUint64(data[8:16], t.NrCpusAllowed)
+	binary.NativeEndian.PutUint64(data[16:24], t.Flags)
+	binary.NativeEndian.PutUint64(data[24:32], t.StartTs)
+	binary.NativeEndian.PutUint64(data[32:40], t.StopTs)
+	binary.NativeEndian.PutUint64(data[40:48], t.SumExecRuntime)
+	binary.NativeEndian.PutUint64(data[48:56], t.Weight)
+	binary.NativeEndian.PutUint64(data[56:64], t.Vtime)
+	binary.NativeEndian.PutUint32(data[64:68], uint32(t.Tgid))
+	binary.NativeEndian.PutUint32(data[68:72], uint32(t.Nice))
+	binary.NativeEndian.PutUint64(data[72:80], t.RuntimeNs)
+	for i, w := range t.AllowedCpus {
+		off := 80 + i*8
+		binary.NativeEndian.PutUint64(data[off:off+8], w)
+	}
+	binary.NativeEndian.PutUint32(data[208:212], uint32(t.PrevCpu))
+	copy(data[212:227], t.Comm) // NUL-terminated
+	binary.NativeEndian.PutUint32(data[228:232], uint32(t.WakerCpu))
+	binary.NativeEndian.PutUint64(data[232:240], t.WakeFlags)
+	binary.NativeEndian.PutUint64(data[240:248], t.MigrationCount)
+	cpus := t.RecentCpus.Cpus()
+	for i := 0; i < nrRecentCpus; i++ {
+		cpu := int32(-1)
+		if i < len(cpus) {
+			cpu = int32(cpus[i])
+		}
+		off := 248 + i*4
+		binary.NativeEndian.PutUint32(data[off:off+4], uint32(cpu))
+	}
+	binary.NativeEndian.PutUint64(data[264:272], t.CgroupId)
+	binary.NativeEndian.PutUint64(data[272:280], t.EnqueueTs)
+	binary.NativeEndian.PutUint64(data[280:288], t.Nvcsw)
+	binary.NativeEndian.PutUint64(data[288:296], t.Nivcsw)
+	var taskFlags uint64
+	if t.IsKthread {
+		taskFlags |= queuedTaskKthread
+	}
+	if t.CpuSelected {
+		taskFlags |= queuedTaskCpuSelected
+	}
+	binary.NativeEndian.PutUint64(data[296:304], taskFlags)
+	binary.NativeEndian.PutUint64(data[304:312], t.RunningTs)
+
+	return data
+}
+
+// decodeComm decodes a NUL-terminated task command name, replacing the bytes
+// that are not valid UTF-8.
+func decodeComm(b []byte) string {
+	if i := bytes.IndexByte(b, 0); i >= 0 {
+		b = b[:i]
+	}
+	return strings.ToValidUTF8(string(b), "\uFFFD")
+}
+
+// decodeTaskEvent decodes a record received from the exit_rb ring buffer.
+func decodeTaskEvent(data []byte) (TaskEvent, error) {
+	if len(data) != taskExitSize {
+		return TaskEvent{}, fmt.Errorf("data length %d doesn't match task_exit_ctx size %d", len(data), taskExitSize)
+	}
+	event := TaskEvent{
+		Pid:  int32(binary.NativeEndian.Uint32(data[0:4])),
+		Tgid: int32(binary.NativeEndian.Uint32(data[4:8])),
+		Kind: TaskEventKind(binary.NativeEndian.Uint32(data[8:12])),
+		Ts:   binary.NativeEndian.Uint64(data[16:24]),
+	}
+	for i := range event.AllowedCpus {
+		off := 24 + i*8
+		event.AllowedCpus[i] = binary.NativeEndian.Uint64(data[off : off+8])
+	}
+	return event, nil
+}
+
+// decodeCPUEvent decodes a record received from the cpu_rb ring buffer.
+func decodeCPUEvent(data []byte) (CPUEvent, error) {
+	if len(data) != cpuEventSize {
+		return CPUEvent{}, fmt.Errorf("data length %d doesn't match cpu_event_ctx size %d", len(data), cpuEventSize)
+	}
+	return CPUEvent{
+		Cpu:  int32(binary.NativeEndian.Uint32(data[0:4])),
+		Kind: CPUEventKind(binary.NativeEndian.Uint32(data[4:8])),
+		Ts:   binary.NativeEndian.Uint64(data[8:16]),
+	}, nil
+}
+
+// decodeBounceEvent decodes a record received from the bounce_rb ring buffer.
+func decodeBounceEvent(data []byte) (BounceEvent, error) {
+	if len(data) != bounceEventSize {
+		return BounceEvent{}, fmt.Errorf("data length %d doesn't match bounce_event_ctx size %d", len(data), bounceEventSize)
+	}
+	return BounceEvent{
+		Pid:    int32(binary.NativeEndian.Uint32(data[0:4])),
+		Cpu:    int32(binary.NativeEndian.Uint32(data[4:8])),
+		Reason: BounceReason(binary.NativeEndian.Uint32(data[8:12])),
+	}, nil
+}
+
+// encodeTaskCpuArg serializes the input of the rs_select_cpu prog (see
+// intf.h::task_cpu_arg).
+func encodeTaskCpuArg(pid, cpu int32, flags uint64) []byte {
+	data := make([]byte, taskCpuArgSize)
+
+	binary.NativeEndian.PutUint32(data[0:4], uint32(pid)) // pid_t pid
+	binary.NativeEndian.PutUint32(data[4:8], uint32(cpu)) // s32 cpu
+	binary.NativeEndian.PutUint64(data[8:16], flags)      // u64 flags
+
+	return data
+}
+
+// encodeDomainArg serializes the input of the enable_sibling_cpu prog (see
+// intf.h::domain_arg).
+func encodeDomainArg(lvlId, cpuId, siblingCpuId int32) []byte {
+	data := make([]byte, domainArgSize)
+
+	binary.NativeEndian.PutUint32(data[0:4], uint32(lvlId))
+	binary.NativeEndian.PutUint32(data[4:8], uint32(cpuId))
+	binary.NativeEndian.PutUint32(data[8:12], uint32(siblingCpuId))
+
+	return data
+}
+
+// encodePreemptArg serializes the input of the do_preempt prog (see
+// intf.h::preempt_cpu_arg).
+func encodePreemptArg(cpuId int32) []byte {
+	data := make([]byte, preemptArgSize)
+
+	binary.NativeEndian.PutUint32(data[0:4], uint32(cpuId))
+
+	return data
+}
+
+// encodeLlcDsqArg serializes the input of the setup_llc_dsq prog (see
+// intf.h::llc_dsq_arg).
+func encodeLlcDsqArg(cpuId, llcId int32) []byte {
+	data := make([]byte, llcDsqArgSize)
+
+	binary.NativeEndian.PutUint32(data[0:4], uint32(cpuId))
+	binary.NativeEndian.PutUint32(data[4:8], uint32(llcId))
+
+	return data
+}
+
+// encodeDsqDumpArg serializes the input of the dump_dsq prog (see
+// intf.h::dsq_dump_arg).
+func encodeDsqDumpArg(dsq uint64) []byte {
+	data := make([]byte, dsqDumpArgSize)
+
+	binary.NativeEndian.PutUint64(data[0:8], dsq)
+
+	return data
+}
+
+// encodeCpuPerfArg serializes the input of the set_cpu_perf and
+// get_cpu_perf_cap progs (see intf.h::cpu_perf_arg).
+func encodeCpuPerfArg(cpuId int32, perf uint32) []byte {
+	data := make([]byte, cpuPerfArgSize)
+
+	binary.NativeEndian.PutUint32(data[0:4], uint32(cpuId))
+	binary.NativeEndian.PutUint32(data[4:8], perf)
+
+	return data
+}
+
+// decodeIdleCpumask decodes the value of the idle_cpumask map.
+func decodeIdleCpumask(data []byte) (CpuMask, error) {
+	if len(data) != idleCpumaskSize {
+		return CpuMask{}, fmt.Errorf("data length %d doesn't match idle_cpumask size %d", len(data), idleCpumaskSize)
+	}
+	var mask CpuMask
+	for i := range mask {
+		mask[i] = binary.NativeEndian.Uint64(data[i*8 : i*8+8])
+	}
+	return mask, nil
+}
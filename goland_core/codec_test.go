@@ -0,0 +1,294 @@
+package core
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"reflect"
+	"testing"
+	"unicode/utf8"
+)
+
+// goldenQueuedTask is a queued_task_ctx record laid out field by field at the
+// offsets computed by the C compiler from intf.h (see cStructs),
+// independently of encodeQueuedTask: it fails if the Go and the C layouts
+// drift apart.
+func goldenQueuedTask() []byte {
+	layout := cStructs()["queued_task_ctx"]
+	off := func(field string) int { return int(layout.offsets[field]) }
+	b := make([]byte, layout.size)
+	put32 := func(at int, v int32) { binary.NativeEndian.PutUint32(b[at:], uint32(v)) }
+	put64 := func(at int, v uint64) { binary.NativeEndian.PutUint64(b[at:], v) }
+	put32(off("pid"), 1234)
+	put32(off("cpu"), 3)
+	put64(off("nr_cpus_allowed"), 2)
+	put64(off("flags"), 0x41)
+	put64(off("start_ts"), 1000)
+	put64(off("stop_ts"), 2000)
+	put64(off("exec_runtime"), 3000)
+	put64(off("weight"), 100)
+	put64(off("vtime"), 4000)
+	put32(off("tgid"), 1200)
+	put32(off("nice"), -5)
+	put64(off("runtime_ns"), 5000)
+	put64(off("cpumask"), 1<<3|1<<5) // cpumask[0]
+	put64(off("cpumask")+8, 1)       // cpumask[1]
+	put32(off("prev_cpu"), 5)
+	copy(b[off("comm"):], "worker")
+	put32(off("waker_cpu"), 7)
+	put64(off("wake_flags"), 0x10)
+	put64(off("nr_migrations"), 9)
+	put32(off("recent_cpus"), 5)     // recent_cpus[0]
+	put32(off("recent_cpus")+4, 3)   // recent_cpus[1]
+	put32(off("recent_cpus")+8, -1)  // recent_cpus[2]
+	put32(off("recent_cpus")+12, -1) // recent_cpus[3]
+	put64(off("cgroup_id"), 42)
+	put64(off("enq_ts"), 6000)
+	put64(off("nvcsw"), 11)
+	put64(off("nivcsw"), 12)
+	put64(off("task_flags"), 0x3)
+	put64(off("running_ts"), 7000)
+	return b
+}
+
+func TestQueuedTaskGolden(t *testing.T) {
+	golden := goldenQueuedTask()
+	if len(golden) != queuedTaskSize {
+		t.Fatalf("queuedTaskSize %d, want %d", queuedTaskSize, len(golden))
+	}
+	task, err := decodeQueuedTask(golden)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &QueuedTask{
+		Pid:            1234,
+		Cpu:            3,
+		NrCpusAllowed:  2,
+		Flags:          0x41,
+		StartTs:        1000,
+		StopTs:         2000,
+		SumExecRuntime: 3000,
+		Weight:         100,
+		Vtime:          4000,
+		Tgid:           1200,
+		Nice:           -5,
+		RuntimeNs:      5000,
+		PrevCpu:        5,
+		Comm:           "worker",
+		WakerCpu:       7,
+		WakeFlags:      0x10,
+		MigrationCount: 9,
+		CgroupId:       42,
+		EnqueueTs:      6000,
+		Nvcsw:          11,
+		Nivcsw:         12,
+		IsKthread:      true,
+		CpuSelected:    true,
+		RunningTs:      7000,
+	}
+	want.AllowedCpus[0] = 1<<3 | 1<<5
+	want.AllowedCpus[1] = 1
+	want.RecentCpus.Set(3)
+	want.RecentCpus.Set(5)
+	if !reflect.DeepEqual(task, want) {
+		t.Fatalf("got %+v\nwant %+v", task, want)
+	}
+	// recent_cpus is encoded in CPU order, not most recent first.
+	recent := cStructs()["queued_task_ctx"].offsets["recent_cpus"]
+	binary.NativeEndian.PutUint32(golden[recent:], 3)
+	binary.NativeEndian.PutUint32(golden[recent+4:], 5)
+	if enc := encodeQueuedTask(task); !bytes.Equal(enc, golden) {
+		t.Fatalf("encodeQueuedTask doesn't match the golden record:\n%x\n%x", enc, golden)
+	}
+}
+
+func TestTaskEventGolden(t *testing.T) {
+	b := make([]byte, 152)
+	binary.NativeEndian.PutUint32(b[0:], 1234)                       // pid
+	binary.NativeEndian.PutUint32(b[4:], 1200)                       // tgid
+	binary.NativeEndian.PutUint32(b[8:], uint32(TaskCpumaskChanged)) // kind
+	binary.NativeEndian.PutUint64(b[16:], 5000)                      // ts
+	binary.NativeEndian.PutUint64(b[24:], 0x6)                       // cpumask[0]
+	binary.NativeEndian.PutUint64(b[144:], 1<<63)                    // cpumask[15]
+	if len(b) != taskExitSize {
+		t.Fatalf("taskExitSize %d, want %d", taskExitSize, len(b))
+	}
+	event, err := decodeTaskEvent(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := TaskEvent{Pid: 1234, Tgid: 1200, Kind: TaskCpumaskChanged, Ts: 5000}
+	want.AllowedCpus[0] = 0x6
+	want.AllowedCpus[15] = 1 << 63
+	if event != want {
+		t.Fatalf("got %+v, want %+v", event, want)
+	}
+}
+
+func FuzzDecodeQueuedTask(f *testing.F) {
+	golden := goldenQueuedTask()
+	f.Add(golden)
+	f.Add(golden[:queuedTaskSize-1])
+	f.Add(append(golden, 0))
+	f.Add([]byte{})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		task, err := decodeQueuedTask(data)
+		if len(data) != queuedTaskSize {
+			if err == nil {
+				t.Fatalf("record of %d bytes accepted", len(data))
+			}
+			return
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !utf8.ValidString(task.Comm) {
+			t.Fatalf("invalid comm %q", task.Comm)
+		}
+		if n := task.RecentCpus.Count(); n > nrRecentCpus {
+			t.Fatalf("%d recent CPUs", n)
+		}
+		// Decoding is stable: the comm may be truncated when it is
+		// encoded again, if invalid bytes were replaced.
+		again, err := decodeQueuedTask(encodeQueuedTask(task))
+		if err != nil {
+			t.Fatal(err)
+		}
+		task.Comm, again.Comm = "", ""
+		if !reflect.DeepEqual(task, again) {
+			t.Fatalf("decode(encode(t)) != t:\n%+v\n%+v", again, task)
+		}
+	})
+}
+
+func FuzzDecodeTaskEvent(f *testing.F) {
+	f.Add(make([]byte, taskExitSize))
+	f.Add(make([]byte, taskExitSize-1))
+	f.Add([]byte{})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		event, err := decodeTaskEvent(data)
+		if len(data) != taskExitSize {
+			if err == nil {
+				t.Fatalf("record of %d bytes accepted", len(data))
+			}
+			return
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if event.Pid != int32(binary.NativeEndian.Uint32(data[0:4])) {
+			t.Fatalf("pid %d", event.Pid)
+		}
+	})
+}
+
+func TestQueuedTaskRoundTrip(t *testing.T) {
+	tests := []QueuedTask{
+		{},
+		{Pid: 1, Cpu: RL_CPU_ANY, PrevCpu: -1, WakerCpu: -1, Comm: "swapper/0"},
+		{Pid: -1, Tgid: 1<<31 - 1, Nice: -20, Weight: 1 << 63, Vtime: 1<<64 - 1, IsKthread: true},
+		{Pid: 42, Comm: "0123456789abcde", CpuSelected: true, RunningTs: 1 << 40, EnqueueTs: 1 << 41},
+	}
+	tests[3].AllowedCpus.Set(0)
+	tests[3].AllowedCpus.Set(MAX_CPUS - 1)
+	tests[3].RecentCpus.Set(7)
+	for i, want := range tests {
+		got, err := decodeQueuedTask(encodeQueuedTask(&want))
+		if err != nil {
+			t.Fatalf("%d: %v", i, err)
+		}
+		if !reflect.DeepEqual(*got, want) {
+			t.Errorf("%d: got %+v\nwant %+v", i, *got, want)
+		}
+	}
+}
+
+func TestDecodeShortRecords(t *testing.T) {
+	decoders := map[string]struct {
+		size   int
+		decode func([]byte) error
+	}{
+		"queued_task_ctx":  {queuedTaskSize, func(b []byte) error { _, err := decodeQueuedTask(b); return err }},
+		"task_exit_ctx":    {taskExitSize, func(b []byte) error { _, err := decodeTaskEvent(b); return err }},
+		"cpu_event_ctx":    {cpuEventSize, func(b []byte) error { _, err := decodeCPUEvent(b); return err }},
+		"bounce_event_ctx": {bounceEventSize, func(b []byte) error { _, err := decodeBounceEvent(b); return err }},
+		"idle_cpumask":     {idleCpumaskSize, func(b []byte) error { _, err := decodeIdleCpumask(b); return err }},
+	}
+	for name, d := range decoders {
+		for _, n := range []int{0, 1, d.size - 1, d.size + 1} {
+			if err := d.decode(make([]byte, n)); err == nil {
+				t.Errorf("%s: record of %d bytes accepted", name, n)
+			}
+		}
+		if err := d.decode(make([]byte, d.size)); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+}
+
+func TestDecodeEvents(t *testing.T) {
+	cpu := make([]byte, cpuEventSize)
+	binary.NativeEndian.PutUint32(cpu[0:], 7)
+	binary.NativeEndian.PutUint32(cpu[4:], uint32(CPUOffline))
+	binary.NativeEndian.PutUint64(cpu[8:], 1234)
+	if got, _ := decodeCPUEvent(cpu); got != (CPUEvent{Cpu: 7, Kind: CPUOffline, Ts: 1234}) {
+		t.Errorf("decodeCPUEvent: got %+v", got)
+	}
+
+	bounce := make([]byte, bounceEventSize)
+	binary.NativeEndian.PutUint32(bounce[0:], 10)
+	binary.NativeEndian.PutUint32(bounce[4:], ^uint32(0)) // -1
+	binary.NativeEndian.PutUint32(bounce[8:], uint32(BounceOffline))
+	if got, _ := decodeBounceEvent(bounce); got != (BounceEvent{Pid: 10, Cpu: -1, Reason: BounceOffline}) {
+		t.Errorf("decodeBounceEvent: got %+v", got)
+	}
+}
+
+// nativeBytes returns the fields of a prog argument laid out with the host
+// byte order, as the C compiler does.
+func nativeBytes(fields ...any) []byte {
+	var buf bytes.Buffer
+	for _, f := range fields {
+		if err := binary.Write(&buf, binary.NativeEndian, f); err != nil {
+			panic(err)
+		}
+	}
+	return buf.Bytes()
+}
+
+func TestEncodeProgArgs(t *testing.T) {
+	tests := []struct {
+		name string
+		got  []byte
+		want []byte
+	}{
+		{"task_cpu_arg", encodeTaskCpuArg(0x01020304, -1, 0x1122334455667788),
+			nativeBytes(int32(0x01020304), int32(-1), uint64(0x1122334455667788))},
+		{"domain_arg", encodeDomainArg(2, 3, 4),
+			nativeBytes(int32(2), int32(3), int32(4))},
+		{"preempt_cpu_arg", encodePreemptArg(5),
+			nativeBytes(int32(5))},
+		{"llc_dsq_arg", encodeLlcDsqArg(6, 1),
+			nativeBytes(int32(6), int32(1))},
+		{"dsq_dump_arg", encodeDsqDumpArg(1 << 63),
+			nativeBytes(uint64(1 << 63))},
+		{"cpu_perf_arg", encodeCpuPerfArg(7, 1024),
+			nativeBytes(int32(7), uint32(1024))},
+	}
+	for _, tt := range tests {
+		if !bytes.Equal(tt.got, tt.want) {
+			t.Errorf("%s: got %x, want %x", tt.name, tt.got, tt.want)
+		}
+	}
+}
+
+func TestEncodeTaskCpuArgByteOrder(t *testing.T) {
+	b := encodeTaskCpuArg(0x01020304, 0, 0)
+	want := "04030201"
+	if binary.NativeEndian.Uint16([]byte{1, 0}) != 1 {
+		want = "01020304" // big endian, e.g. s390x
+	}
+	if got := fmt.Sprintf("%x", b[:4]); got != want {
+		t.Fatalf("pid encoded as %s, want %s", got, want)
+	}
+}
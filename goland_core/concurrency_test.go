@@ -0,0 +1,220 @@
+package core
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestSchedConcurrentClose calls the API of a Sched from several goroutines
+// while it is closed, also concurrently: run it with -race.
+func TestSchedConcurrentClose(t *testing.T) {
+	// No BPF object: SelectCPU falls back to RL_CPU_ANY, and neither the
+	// stats nor the dispatched ring buffer are available. Records are
+	// left in the queue after Close.
+	s := &Sched{
+		done:  make(chan struct{}),
+		opts:  LoadSchedOpts{SelectCPUFallback: true, Logger: nopLogger{}},
+		queue: make(chan []byte, 1024),
+	}
+	for _, r := range GenerateQueuedTasks(cap(s.queue)) {
+		s.queue <- r
+	}
+
+	const workers = 8
+	const ops = 6
+	var wg sync.WaitGroup
+	errc := make(chan error, ops*workers)
+	started := make(chan struct{}, ops*workers)
+	loop := func(op func() error) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			started <- struct{}{}
+			for {
+				if err := op(); errors.Is(err, ErrClosed) {
+					return
+				} else if err != nil {
+					errc <- err
+					return
+				}
+			}
+		}()
+	}
+	for i := 0; i < workers; i++ {
+		pid := int32(i + 1)
+		loop(func() error {
+			task := &QueuedTask{Pid: pid, Cpu: 1}
+			cpu, err := s.SelectCPU(task)
+			if err == nil && cpu != RL_CPU_ANY {
+				return errors.New("SelectCPU returned a CPU without rs_select_cpu")
+			}
+			return err
+		})
+		loop(func() error {
+			// Picked in BPF: answered even after Close.
+			task := &QueuedTask{Pid: pid, Cpu: 2, CpuSelected: true}
+			if cpu, err := s.SelectCPU(task); err != nil || cpu != 2 {
+				return errors.New("SelectCPU ignored the CPU selected in BPF")
+			}
+			if !s.AttachedAt().IsZero() {
+				return errors.New("Sched reported as attached")
+			}
+			select {
+			case <-s.done:
+				return ErrClosed
+			default:
+				return nil
+			}
+		})
+		loop(func() error {
+			_, err := s.GetBssData()
+			if err == nil {
+				return errors.New("GetBssData succeeded without bss")
+			}
+			if errors.Is(err, ErrClosed) {
+				return err
+			}
+			return nil
+		})
+		loop(func() error {
+			err := s.DispatchTask(&DispatchedTask{Pid: pid, Cpu: RL_CPU_ANY})
+			if errors.Is(err, ErrMapNotFound) {
+				return nil
+			}
+			return err
+		})
+		loop(func() error {
+			var task QueuedTask
+			s.DequeueTask(&task)
+			select {
+			case <-s.done:
+				return ErrClosed
+			default:
+				return nil
+			}
+		})
+		loop(func() error {
+			return s.SetMaxQueued(uint64(pid))
+		})
+	}
+	for i := 0; i < ops*workers; i++ {
+		<-started
+	}
+	time.Sleep(10 * time.Millisecond)
+
+	var closers sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		closers.Add(1)
+		go func() {
+			defer closers.Done()
+			if err := s.Close(); err != nil {
+				errc <- err
+			}
+		}()
+	}
+	closers.Wait()
+
+	// The records left in the queue are not consumed anymore.
+	s.queue <- GenerateQueuedTasks(1)[0]
+	var task QueuedTask
+	s.DequeueTask(&task)
+	if task.Pid != -1 {
+		t.Errorf("task %d dequeued after Close", task.Pid)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("operations still running after Close")
+	}
+	close(errc)
+	for err := range errc {
+		t.Error(err)
+	}
+}
+
+// TestMockBackendConcurrent runs a policy loop per goroutine over a shared
+// MockBackend, with concurrent producers and stats readers: run it with
+// -race.
+func TestMockBackendConcurrent(t *testing.T) {
+	const producers, consumers, perProducer = 4, 4, 500
+	m := NewMockBackend()
+	m.SelectCpuFn = func(task *QueuedTask) (int32, error) { return task.Cpu, nil }
+
+	var wg sync.WaitGroup
+	for p := 0; p < producers; p++ {
+		wg.Add(1)
+		go func(p int) {
+			defer wg.Done()
+			for i := 0; i < perProducer; i++ {
+				m.Enqueue(QueuedTask{Pid: int32(p*perProducer + i + 1), Cpu: int32(i % 4)})
+			}
+		}(p)
+	}
+
+	var dispatched sync.WaitGroup
+	dispatched.Add(producers * perProducer)
+	stop := make(chan struct{})
+	for c := 0; c < consumers; c++ {
+		go func() {
+			var task QueuedTask
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				m.DequeueTask(&task)
+				if task.Pid < 0 {
+					continue
+				}
+				cpu, err := m.SelectCPU(&task)
+				if err != nil {
+					t.Error(err)
+				}
+				if err := m.DispatchTask(&DispatchedTask{Pid: task.Pid, Cpu: cpu}); err != nil {
+					t.Error(err)
+				}
+				dispatched.Done()
+			}
+		}()
+	}
+	go func() {
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			if _, err := m.GetBssData(); err != nil {
+				t.Error(err)
+			}
+		}
+	}()
+
+	wg.Wait()
+	dispatched.Wait()
+	close(stop)
+
+	bss, _ := m.GetBssData()
+	if bss.Nr_queued != 0 || bss.Nr_user_dispatches != producers*perProducer {
+		t.Fatalf("stats %d queued, %d dispatched", bss.Nr_queued, bss.Nr_user_dispatches)
+	}
+	seen := make(map[int32]bool)
+	for _, task := range m.Dispatched() {
+		if seen[task.Pid] {
+			t.Fatalf("pid %d dispatched twice", task.Pid)
+		}
+		seen[task.Pid] = true
+	}
+	if len(seen) != producers*perProducer {
+		t.Fatalf("%d tasks dispatched, want %d", len(seen), producers*perProducer)
+	}
+}
@@ -0,0 +1,74 @@
+package core
+
+/*
+#include "wrapper.h"
+*/
+import "C"
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// SchedConfigVersion is the version of the format of SchedConfig. It changes
+// only when a field changes meaning: new fields are added without bumping it,
+// and they keep their current value when an older dump is loaded.
+const SchedConfigVersion = 1
+
+// SchedConfig is the JSON document written by DumpConfig and read by
+// LoadConfig: the tunables of the scheduler that an operator can change.
+type SchedConfig struct {
+	Version   int    `json:"version"`
+	SliceNs   uint64 `json:"slice_ns"`   // default time slice (see SetDefaultSlice)
+	OpsFlags  uint64 `json:"ops_flags"`  // struct_ops flags (see SetOpsFlags)
+	TimeoutMs uint32 `json:"timeout_ms"` // watchdog timeout (see SetOpsTimeout)
+}
+
+// DumpConfig returns the current tunables of the scheduler, as a JSON
+// SchedConfig, so that they can be restored with LoadConfig, e.g. after the
+// daemon restarts.
+func (s *Sched) DumpConfig() ([]byte, error) {
+	if err := s.acquire(); err != nil {
+		return nil, err
+	}
+	defer s.release()
+	return json.Marshal(s.config())
+}
+
+// config returns the current tunables. The caller must hold s.mu.
+func (s *Sched) config() SchedConfig {
+	return SchedConfig{
+		Version:   SchedConfigVersion,
+		SliceNs:   uint64(C.get_default_slice()),
+		OpsFlags:  uint64(C.get_ops_flags()),
+		TimeoutMs: uint32(C.get_ops_timeout()),
+	}
+}
+
+// LoadConfig restores the tunables dumped by DumpConfig. The fields missing
+// from data keep their current value. Like the setters of the tunables, it
+// must be called before Start; nothing is changed if it fails.
+func (s *Sched) LoadConfig(data []byte) error {
+	if err := s.acquire(); err != nil {
+		return err
+	}
+	cfg := s.config()
+	s.release()
+	cfg.Version = 0
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return fmt.Errorf("invalid scheduler config: %w", err)
+	}
+	if cfg.Version <= 0 || cfg.Version > SchedConfigVersion {
+		return fmt.Errorf("unsupported scheduler config version %d (supported: 1..%d)", cfg.Version, SchedConfigVersion)
+	}
+	if cfg.TimeoutMs > maxOpsTimeoutMs {
+		return fmt.Errorf("ops timeout %dms exceeds the maximum of %dms", cfg.TimeoutMs, maxOpsTimeoutMs)
+	}
+	if err := s.checkNotLoaded(); err != nil {
+		return err
+	}
+	s.SetDefaultSlice(cfg.SliceNs)
+	C.set_ops_flags(C.u64(cfg.OpsFlags))
+	C.set_ops_timeout(C.u32(cfg.TimeoutMs))
+	return nil
+}
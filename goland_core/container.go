@@ -0,0 +1,240 @@
+package core
+
+import (
+	"errors"
+	"io/fs"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// containerIDPatterns match the name of the cgroup of a container, with the
+// container id as the first submatch.
+var containerIDPatterns = []*regexp.Regexp{
+	// systemd cgroup driver (docker, containerd, CRI-O, podman):
+	// .../docker-<id>.scope, .../cri-containerd-<id>.scope, ...
+	regexp.MustCompile(`^(?:docker|cri-containerd|crio|libpod)-([0-9a-f]{64})\.scope$`),
+	// cgroupfs driver: .../docker/<id>, .../kubepods/burstable/pod<uid>/<id>
+	regexp.MustCompile(`^([0-9a-f]{64})$`),
+}
+
+// ContainerID extracts the id of the container from the path of a cgroup,
+// following the systemd, containerd and CRI-O conventions. Nested cgroups
+// (e.g. the sub-cgroups created inside the container) resolve to the nearest
+// container ancestor.
+func ContainerID(path string) (string, bool) {
+	elems := strings.Split(filepath.Clean(path), "/")
+	for i := len(elems) - 1; i >= 0; i-- {
+		for _, re := range containerIDPatterns {
+			if m := re.FindStringSubmatch(elems[i]); m != nil {
+				return m[1], true
+			}
+		}
+	}
+	return "", false
+}
+
+// inotifyPollMs is how often the inotify goroutine of a ContainerResolver
+// checks if it has been closed.
+const inotifyPollMs = 500
+
+// cgroupWatch is a cgroup directory watched by a ContainerResolver.
+type cgroupWatch struct {
+	path string
+	cgid uint64
+}
+
+// ContainerResolver maps the cgroup ids reported with the tasks
+// (QueuedTask.CgroupId) to the ids of their containers, so that a policy can
+// act on containers.
+//
+// The mapping is built by walking the cgroup hierarchy once, then it is kept
+// up to date with inotify: the cgroups created later are added when the
+// event is received, so a task of a container that just started may not be
+// resolved for a short time. The hierarchy is walked again if inotify drops
+// events.
+type ContainerResolver struct {
+	root    string
+	fd      int
+	mu      sync.RWMutex
+	ids     map[uint64]string // cgroup id -> container id ("" = not a container)
+	watches map[int32]cgroupWatch
+	done    chan struct{}
+	wg      sync.WaitGroup
+}
+
+// NewContainerResolver walks the cgroup hierarchy at root (CgroupRoot if
+// empty) and starts watching it. It must be closed with Close.
+func NewContainerResolver(root string) (*ContainerResolver, error) {
+	if root == "" {
+		root = CgroupRoot
+	}
+	fd, err := unix.InotifyInit1(unix.IN_CLOEXEC | unix.IN_NONBLOCK)
+	if err != nil {
+		return nil, err
+	}
+	r := &ContainerResolver{
+		root:    root,
+		fd:      fd,
+		ids:     make(map[uint64]string),
+		watches: make(map[int32]cgroupWatch),
+		done:    make(chan struct{}),
+	}
+	if err := r.addTree(root, nil); err != nil {
+		unix.Close(fd)
+		return nil, err
+	}
+	r.wg.Add(1)
+	go r.watch()
+	return r, nil
+}
+
+// ResolveContainer returns the id of the container of the cgroup cgid, and
+// false if the cgroup doesn't belong to a container or it is not known.
+func (r *ContainerResolver) ResolveContainer(cgid uint64) (string, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	id := r.ids[cgid]
+	return id, id != ""
+}
+
+// Close stops watching the cgroup hierarchy.
+func (r *ContainerResolver) Close() error {
+	close(r.done)
+	r.wg.Wait()
+	return unix.Close(r.fd)
+}
+
+// addTree watches the cgroup at path and all its descendants. The ids of the
+// cgroups found are added to seen, if not nil.
+func (r *ContainerResolver) addTree(path string, seen map[uint64]bool) error {
+	return filepath.WalkDir(path, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			// Removed while walking.
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if !d.IsDir() {
+			return nil
+		}
+		if cgid, ok := r.add(p); ok && seen != nil {
+			seen[cgid] = true
+		}
+		return nil
+	})
+}
+
+// inotifyAddWatch is unix.InotifyAddWatch, replaced by the tests.
+var inotifyAddWatch = unix.InotifyAddWatch
+
+// add records the cgroup at path and watches it. The watch is best effort
+// (e.g. the inotify watch limit may be hit): the children created later in a
+// cgroup not watched are only found by the next rescan.
+func (r *ContainerResolver) add(path string) (uint64, bool) {
+	cgid, err := CgroupID(path)
+	if err != nil {
+		return 0, false
+	}
+	id, _ := ContainerID(strings.TrimPrefix(path, r.root))
+	r.mu.Lock()
+	r.ids[cgid] = id
+	r.mu.Unlock()
+
+	wd, err := inotifyAddWatch(r.fd, path, unix.IN_CREATE|unix.IN_ONLYDIR)
+	if err != nil {
+		return cgid, true
+	}
+	r.mu.Lock()
+	r.watches[int32(wd)] = cgroupWatch{path: path, cgid: cgid}
+	r.mu.Unlock()
+	return cgid, true
+}
+
+// rescan walks the whole hierarchy again, when inotify events have been
+// lost, and forgets the cgroups that don't exist anymore.
+func (r *ContainerResolver) rescan() {
+	seen := make(map[uint64]bool)
+	if err := r.addTree(r.root, seen); err != nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for cgid := range r.ids {
+		if !seen[cgid] {
+			delete(r.ids, cgid)
+		}
+	}
+	for wd, w := range r.watches {
+		if !seen[w.cgid] {
+			delete(r.watches, wd)
+		}
+	}
+}
+
+// remove forgets the cgroup watched by wd, removed by the kernel.
+func (r *ContainerResolver) remove(wd int32) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if w, ok := r.watches[wd]; ok {
+		delete(r.ids, w.cgid)
+		delete(r.watches, wd)
+	}
+}
+
+// watch handles the inotify events until the resolver is closed.
+func (r *ContainerResolver) watch() {
+	defer r.wg.Done()
+	buf := make([]byte, 64*1024)
+	fds := []unix.PollFd{{Fd: int32(r.fd), Events: unix.POLLIN}}
+	for {
+		select {
+		case <-r.done:
+			return
+		default:
+		}
+		n, err := unix.Poll(fds, inotifyPollMs)
+		if err != nil || n == 0 {
+			continue
+		}
+		n, err = unix.Read(r.fd, buf)
+		if err != nil {
+			continue
+		}
+		r.handleEvents(buf[:n])
+	}
+}
+
+// handleEvents handles a buffer of inotify events.
+func (r *ContainerResolver) handleEvents(buf []byte) {
+	for off := 0; off+unix.SizeofInotifyEvent <= len(buf); {
+		ev := (*unix.InotifyEvent)(unsafe.Pointer(&buf[off]))
+		name := buf[off+unix.SizeofInotifyEvent : off+unix.SizeofInotifyEvent+int(ev.Len)]
+		off += unix.SizeofInotifyEvent + int(ev.Len)
+
+		switch {
+		case ev.Mask&unix.IN_Q_OVERFLOW != 0:
+			// Events have been dropped: the cgroups created or
+			// removed in the meantime are unknown.
+			r.rescan()
+		case ev.Mask&unix.IN_IGNORED != 0:
+			// The cgroup has been removed.
+			r.remove(ev.Wd)
+		case ev.Mask&unix.IN_CREATE != 0 && ev.Mask&unix.IN_ISDIR != 0:
+			r.mu.RLock()
+			parent, ok := r.watches[ev.Wd]
+			r.mu.RUnlock()
+			if !ok {
+				continue
+			}
+			n := strings.TrimRight(string(name), "\x00")
+			// The new cgroup can have children already.
+			r.addTree(filepath.Join(parent.path, n), nil)
+		}
+	}
+}
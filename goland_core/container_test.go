@@ -0,0 +1,126 @@
+package core
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+var testContainerID = strings.Repeat("ab", 32)
+
+func TestContainerID(t *testing.T) {
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"/system.slice/docker-" + testContainerID + ".scope", testContainerID},
+		{"/kubepods.slice/cri-containerd-" + testContainerID + ".scope/init", testContainerID},
+		{"/kubepods/burstable/pod1234/" + testContainerID, testContainerID},
+		{"/system.slice/sshd.service", ""},
+		{"/docker-short.scope", ""},
+	}
+	for _, tt := range tests {
+		id, ok := ContainerID(tt.path)
+		if id != tt.want || ok != (tt.want != "") {
+			t.Errorf("ContainerID(%q) = %q, %v; want %q", tt.path, id, ok, tt.want)
+		}
+	}
+}
+
+// failWatches makes the inotify watches fail for the duration of the test.
+func failWatches(t *testing.T) {
+	t.Helper()
+	orig := inotifyAddWatch
+	inotifyAddWatch = func(int, string, uint32) (int, error) { return -1, unix.ENOSPC }
+	t.Cleanup(func() { inotifyAddWatch = orig })
+}
+
+// mkCgroup creates the directory of a fake cgroup under root and returns its
+// id.
+func mkCgroup(t *testing.T, root, path string) uint64 {
+	t.Helper()
+	p := filepath.Join(root, path)
+	if err := os.MkdirAll(p, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	cgid, err := CgroupID(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cgid
+}
+
+func newTestResolver(t *testing.T, root string) *ContainerResolver {
+	t.Helper()
+	r, err := NewContainerResolver(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { r.Close() })
+	return r
+}
+
+func TestContainerResolverWatchFailure(t *testing.T) {
+	failWatches(t)
+	root := t.TempDir()
+	cgid := mkCgroup(t, root, "system.slice/docker-"+testContainerID+".scope")
+
+	r := newTestResolver(t, root)
+	if id, ok := r.ResolveContainer(cgid); !ok || id != testContainerID {
+		t.Fatalf("got %q, %v; want %q", id, ok, testContainerID)
+	}
+}
+
+func TestContainerResolverCreate(t *testing.T) {
+	root := t.TempDir()
+	mkCgroup(t, root, "system.slice")
+	r := newTestResolver(t, root)
+
+	cgid := mkCgroup(t, root, "system.slice/docker-"+testContainerID+".scope/init")
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if id, ok := r.ResolveContainer(cgid); ok && id == testContainerID {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("cgroup created after the walk not resolved")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// inotifyEvent encodes an inotify event without a name.
+func inotifyEvent(wd int32, mask uint32) []byte {
+	buf := make([]byte, unix.SizeofInotifyEvent)
+	binary.NativeEndian.PutUint32(buf[0:], uint32(wd))
+	binary.NativeEndian.PutUint32(buf[4:], mask)
+	return buf
+}
+
+func TestContainerResolverOverflow(t *testing.T) {
+	// No watch, so that only the overflow updates the mapping.
+	failWatches(t)
+	root := t.TempDir()
+	old := mkCgroup(t, root, "docker-"+strings.Repeat("cd", 32)+".scope")
+	r := newTestResolver(t, root)
+
+	if err := os.Remove(filepath.Join(root, "docker-"+strings.Repeat("cd", 32)+".scope")); err != nil {
+		t.Fatal(err)
+	}
+	cgid := mkCgroup(t, root, "docker-"+testContainerID+".scope")
+	r.handleEvents(inotifyEvent(-1, unix.IN_Q_OVERFLOW))
+
+	if id, ok := r.ResolveContainer(cgid); !ok || id != testContainerID {
+		t.Fatalf("new cgroup not found by the rescan: %q, %v", id, ok)
+	}
+	if cgid != old {
+		if _, ok := r.ResolveContainer(old); ok {
+			t.Fatalf("removed cgroup still resolved after the rescan")
+		}
+	}
+}
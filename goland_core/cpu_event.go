@@ -0,0 +1,122 @@
+package core
+
+// Size of the channel returned by CPUEvents.
+const cpuEventChanSize = 256
+
+// CPUEventKind is the kind of a CPUEvent (see intf.h::cpu_event_kind).
+type CPUEventKind uint32
+
+const (
+	// CPUReleased means that the CPU has been taken away from the
+	// scheduler by a higher priority sched_class (RT, DL, ...).
+	CPUReleased CPUEventKind = iota
+	// CPUAcquired means that the CPU has been given back to the scheduler.
+	CPUAcquired
+	// CPUOnline means that the CPU has been brought online.
+	CPUOnline
+	// CPUOffline means that the CPU has been taken offline.
+	CPUOffline
+	// CPUFallbackOn means that a PSIMonitor switched all the CPUs to the
+	// kernel fallback, under memory pressure. It is not sent by the BPF
+	// component: Cpu is RL_CPU_ANY and Ts is CLOCK_MONOTONIC.
+	CPUFallbackOn
+	// CPUFallbackOff means that a PSIMonitor switched back from the kernel
+	// fallback, like CPUFallbackOn.
+	CPUFallbackOff
+)
+
+func (k CPUEventKind) String() string {
+	switch k {
+	case CPUReleased:
+		return "released"
+	case CPUAcquired:
+		return "acquired"
+	case CPUOnline:
+		return "online"
+	case CPUOffline:
+		return "offline"
+	case CPUFallbackOn:
+		return "fallback-on"
+	case CPUFallbackOff:
+		return "fallback-off"
+	}
+	return "unknown"
+}
+
+// CPUEvent notifies that a CPU has been released to or acquired from a
+// higher priority sched_class, or that it went online or offline (see
+// bpf_intf::cpu_event_ctx), or that the CPUs switched to the kernel fallback
+// or back (see PSIMonitor).
+type CPUEvent struct {
+	Cpu  int32        // CPU id (RL_CPU_ANY = all the CPUs)
+	Kind CPUEventKind // CPUReleased, CPUAcquired, CPUOnline, CPUOffline, ...
+	Ts   uint64       // Timestamp of the event (scx_bpf_now())
+}
+
+// CPUEvents returns the channel where the CPU events are delivered. Events
+// are dropped if the channel is full, but the state reported by CPUAvailable
+// and IdleCpus is always kept up to date.
+func (s *Sched) CPUEvents() <-chan CPUEvent {
+	return s.cpuEvents
+}
+
+// CPUAvailable returns false if cpu has been taken away by a higher priority
+// sched_class and not acquired back yet, true otherwise.
+func (s *Sched) CPUAvailable(cpu int32) bool {
+	if cpu < 0 || cpu >= MAX_CPUS {
+		return false
+	}
+	return !s.cpuReleased[cpu].Load()
+}
+
+func (s *Sched) consumeCPUEvents() {
+	for {
+		select {
+		case b := <-s.cpuRb:
+			event, err := decodeCPUEvent(b)
+			if err != nil {
+				s.opts.Logger.Printf("decodeCPUEvent err: %v", err)
+				continue
+			}
+			s.updateCPUState(event)
+			s.sendCPUEvent(event)
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// sendCPUEvent delivers event on the CPUEvents channel, if any, dropping it
+// if the channel is full.
+func (s *Sched) sendCPUEvent(event CPUEvent) {
+	select {
+	case s.cpuEvents <- event:
+	default:
+	}
+}
+
+// updateCPUState applies event to the state of its CPU.
+func (s *Sched) updateCPUState(event CPUEvent) {
+	if event.Cpu < 0 || event.Cpu >= MAX_CPUS {
+		return
+	}
+	switch event.Kind {
+	case CPUReleased, CPUAcquired:
+		s.cpuReleased[event.Cpu].Store(event.Kind == CPUReleased)
+	case CPUOnline, CPUOffline:
+		s.cpuOnline[event.Cpu].Store(event.Kind == CPUOnline)
+	}
+}
+
+// initOnlineCpus reads the online CPUs, then kept up to date by the CPU
+// events.
+func (s *Sched) initOnlineCpus() error {
+	online, err := readCpuList("online")
+	if err != nil {
+		return err
+	}
+	for cpu := range s.cpuOnline {
+		s.cpuOnline[cpu].Store(online.Test(cpu))
+	}
+	return nil
+}
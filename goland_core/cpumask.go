@@ -0,0 +1,107 @@
+package core
+
+import (
+	"fmt"
+	"math/bits"
+	"strconv"
+	"strings"
+)
+
+// CpuMask is a bitmap of CPUs, with the same layout as the cpumasks sent by
+// the BPF component (MAX_CPUS bits, CPU n is bit n%64 of word n/64).
+type CpuMask [MAX_CPUS / 64]uint64
+
+// Test returns true if cpu is set in the mask.
+func (m *CpuMask) Test(cpu int) bool {
+	if cpu < 0 || cpu >= MAX_CPUS {
+		return false
+	}
+	return m[cpu/64]&(1<<(cpu%64)) != 0
+}
+
+// Set adds cpu to the mask.
+func (m *CpuMask) Set(cpu int) {
+	if cpu < 0 || cpu >= MAX_CPUS {
+		return
+	}
+	m[cpu/64] |= 1 << (cpu % 64)
+}
+
+// Clear removes cpu from the mask.
+func (m *CpuMask) Clear(cpu int) {
+	if cpu < 0 || cpu >= MAX_CPUS {
+		return
+	}
+	m[cpu/64] &^= 1 << (cpu % 64)
+}
+
+// Empty returns true if no CPU is set in the mask.
+func (m *CpuMask) Empty() bool {
+	for _, w := range m {
+		if w != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Count returns the number of CPUs set in the mask.
+func (m *CpuMask) Count() int {
+	n := 0
+	for _, w := range m {
+		n += bits.OnesCount64(w)
+	}
+	return n
+}
+
+// Cpus returns the CPUs set in the mask, in ascending order.
+func (m *CpuMask) Cpus() []int {
+	cpus := make([]int, 0, m.Count())
+	for i, w := range m {
+		for w != 0 {
+			b := bits.TrailingZeros64(w)
+			cpus = append(cpus, i*64+b)
+			w &^= 1 << b
+		}
+	}
+	return cpus
+}
+
+func (m CpuMask) String() string {
+	cpus := m.Cpus()
+	s := make([]string, len(cpus))
+	for i, cpu := range cpus {
+		s[i] = strconv.Itoa(cpu)
+	}
+	return strings.Join(s, ",")
+}
+
+// ParseCpuList parses a CPU list in the format used by sysfs (e.g.
+// "0-3,8,10-11").
+func ParseCpuList(list string) (CpuMask, error) {
+	var m CpuMask
+	list = strings.TrimSpace(list)
+	if list == "" {
+		return m, nil
+	}
+	for _, r := range strings.Split(list, ",") {
+		lo, hi, isRange := strings.Cut(r, "-")
+		first, err := strconv.Atoi(lo)
+		if err != nil {
+			return m, fmt.Errorf("invalid cpu list %q", list)
+		}
+		last := first
+		if isRange {
+			if last, err = strconv.Atoi(hi); err != nil {
+				return m, fmt.Errorf("invalid cpu list %q", list)
+			}
+		}
+		if first < 0 || last < first || last >= MAX_CPUS {
+			return m, fmt.Errorf("invalid cpu range %q", r)
+		}
+		for cpu := first; cpu <= last; cpu++ {
+			m.Set(cpu)
+		}
+	}
+	return m, nil
+}
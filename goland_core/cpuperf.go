@@ -0,0 +1,99 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	bpf "github.com/aquasecurity/libbpfgo"
+	"golang.org/x/sys/unix"
+)
+
+// CPUPerfMax is the maximum performance target and capacity of a CPU
+// (SCX_CPUPERF_ONE).
+const CPUPerfMax = 1024
+
+// SetCPUPerf sets the performance target of cpu, from 0 to CPUPerfMax, e.g.
+// to lower the frequency of the CPUs running background tasks. The target is
+// a hint for the schedutil governor: ErrCPUPerfUnsupported is returned if cpu
+// uses another cpufreq governor, or no cpufreq driver at all.
+func (s *Sched) SetCPUPerf(cpu int32, perf uint32) error {
+	if err := checkCPU(cpu); err != nil {
+		return err
+	}
+	if perf > CPUPerfMax {
+		return fmt.Errorf("cpu perf %d out of range [0, %d]", perf, CPUPerfMax)
+	}
+	if err := checkSchedutil(cpu); err != nil {
+		return err
+	}
+	if err := s.acquire(); err != nil {
+		return err
+	}
+	defer s.release()
+	if s.cpuPerf == nil {
+		return progNotFound("set_cpu_perf")
+	}
+	retVal, err := runCpuPerfProg(s.cpuPerf, cpu, perf)
+	if err != nil {
+		return fmt.Errorf("run set_cpu_perf: %w", err)
+	}
+	if retVal != 0 {
+		return fmt.Errorf("set perf of cpu %d: %w", cpu, unix.Errno(-retVal))
+	}
+	return nil
+}
+
+// GetCPUPerfCap returns the current capacity of cpu, from 0 to CPUPerfMax,
+// relative to the most capable CPU of the system.
+func (s *Sched) GetCPUPerfCap(cpu int32) (uint32, error) {
+	if err := checkCPU(cpu); err != nil {
+		return 0, err
+	}
+	if err := s.acquire(); err != nil {
+		return 0, err
+	}
+	defer s.release()
+	if s.cpuPerfCap == nil {
+		return 0, progNotFound("get_cpu_perf_cap")
+	}
+	retVal, err := runCpuPerfProg(s.cpuPerfCap, cpu, 0)
+	if err != nil {
+		return 0, fmt.Errorf("run get_cpu_perf_cap: %w", err)
+	}
+	if retVal < 0 {
+		return 0, fmt.Errorf("get capacity of cpu %d: %w", cpu, unix.Errno(-retVal))
+	}
+	return uint32(retVal), nil
+}
+
+func runCpuPerfProg(prog *bpf.BPFProg, cpu int32, perf uint32) (int32, error) {
+	data := encodeCpuPerfArg(cpu, perf)
+	opt := bpf.RunOpts{
+		CtxIn:     data,
+		CtxSizeIn: uint32(len(data)),
+	}
+	if err := prog.Run(&opt); err != nil {
+		return 0, err
+	}
+	return int32(opt.RetVal), nil
+}
+
+// checkSchedutil fails with ErrCPUPerfUnsupported if the frequency of cpu is
+// not driven by the schedutil governor.
+func checkSchedutil(cpu int32) error {
+	path := filepath.Join(sysCpuPath, fmt.Sprintf("cpu%d", cpu), "cpufreq", "scaling_governor")
+	b, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("%w: no cpufreq for cpu %d", ErrCPUPerfUnsupported, cpu)
+	} else if err != nil {
+		return err
+	}
+	if gov := strings.TrimSpace(string(b)); gov != "schedutil" {
+		return fmt.Errorf("%w: cpu %d uses the %s governor", ErrCPUPerfUnsupported, cpu, gov)
+	}
+	return nil
+}
@@ -0,0 +1,105 @@
+package core
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"unsafe"
+)
+
+// cpuStatsSize is sizeof(struct cpu_stats) (see intf.h).
+const cpuStatsSize = 32
+
+// CPUStats are the statistics of a CPU collected by the BPF component (see
+// intf.h::cpu_stats).
+type CPUStats struct {
+	Cpu         int
+	Online      bool   // Offline CPUs report the counters collected while they were online
+	Dispatches  uint64 // Tasks dispatched to the CPU by the user-space scheduler
+	Bounces     uint64 // Tasks sent to the CPU that were not allowed to run there, or while it was offline
+	Preemptions uint64 // Preemptions requested with PreemptCpu
+	Queued      uint64 // Tasks in the CPU's DSQ the last time it looked for work
+}
+
+// GetPerCPUStats returns the statistics of every possible CPU, read with a
+// single map lookup.
+func (s *Sched) GetPerCPUStats() ([]CPUStats, error) {
+	possible, err := readCpuList("possible")
+	if err != nil {
+		return nil, err
+	}
+	online, err := readCpuList("online")
+	if err != nil {
+		return nil, err
+	}
+	if err := s.acquire(); err != nil {
+		return nil, err
+	}
+	defer s.release()
+	if s.cpuStats == nil {
+		return nil, mapNotFound("cpu_stats")
+	}
+	key := uint32(0)
+	b, err := s.cpuStats.GetValue(unsafe.Pointer(&key))
+	if err != nil {
+		return nil, fmt.Errorf("read cpu_stats: %w", err)
+	}
+	if len(b) != MAX_CPUS*cpuStatsSize {
+		return nil, fmt.Errorf("cpu_stats value size %d doesn't match %d", len(b), MAX_CPUS*cpuStatsSize)
+	}
+	cpus := possible.Cpus()
+	stats := make([]CPUStats, len(cpus))
+	for i, cpu := range cpus {
+		rec := b[cpu*cpuStatsSize : (cpu+1)*cpuStatsSize]
+		stats[i] = CPUStats{
+			Cpu:         cpu,
+			Online:      online.Test(cpu),
+			Dispatches:  binary.NativeEndian.Uint64(rec[0:8]),
+			Bounces:     binary.NativeEndian.Uint64(rec[8:16]),
+			Preemptions: binary.NativeEndian.Uint64(rec[16:24]),
+			Queued:      binary.NativeEndian.Uint64(rec[24:32]),
+		}
+	}
+	return stats, nil
+}
+
+// Imbalance returns how unevenly the dispatches are spread across the online
+// CPUs in stats, as the coefficient of variation of the dispatch counts
+// (standard deviation divided by the mean): 0 means perfectly balanced. It
+// returns 0 if no task has been dispatched.
+//
+// To measure the imbalance of a time window, pass the difference between two
+// samples of GetPerCPUStats.
+func Imbalance(stats []CPUStats) float64 {
+	var n, sum float64
+	for _, st := range stats {
+		if st.Online {
+			n++
+			sum += float64(st.Dispatches)
+		}
+	}
+	if n == 0 || sum == 0 {
+		return 0
+	}
+	mean := sum / n
+	var variance float64
+	for _, st := range stats {
+		if st.Online {
+			d := float64(st.Dispatches) - mean
+			variance += d * d
+		}
+	}
+	return math.Sqrt(variance/n) / mean
+}
+
+// readCpuList reads the CPU list of sysfs called name (e.g. "online").
+func readCpuList(name string) (CpuMask, error) {
+	b, err := os.ReadFile(filepath.Join(sysCpuPath, name))
+	if err != nil {
+		return CpuMask{}, err
+	}
+	return ParseCpuList(strings.TrimSpace(string(b)))
+}
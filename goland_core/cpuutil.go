@@ -0,0 +1,101 @@
+package core
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// procStatPath is the file the CPU times are read from.
+var procStatPath = "/proc/stat"
+
+// cpuTimes are the busy and total times of a CPU, in USER_HZ.
+type cpuTimes struct {
+	busy, total uint64
+}
+
+// cpuUtilState is the sample of the CPU times taken by the last
+// CpuUtilization call.
+type cpuUtilState struct {
+	mu   sync.Mutex
+	prev []cpuTimes
+}
+
+// CpuUtilization returns the fraction of time (0..1) each CPU has been busy
+// since the previous call, indexed by CPU id. The first call returns zeros,
+// since there is no previous sample. Offline CPUs report 0.
+func (s *Sched) CpuUtilization() ([]float64, error) {
+	if err := s.acquire(); err != nil {
+		return nil, err
+	}
+	defer s.release()
+	cur, err := readCPUTimes()
+	if err != nil {
+		return nil, err
+	}
+	u := &s.cpuUtil
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	util := make([]float64, len(cur))
+	for cpu, c := range cur {
+		if cpu >= len(u.prev) {
+			continue
+		}
+		p := u.prev[cpu]
+		if c.total > p.total && c.busy >= p.busy {
+			util[cpu] = min(float64(c.busy-p.busy)/float64(c.total-p.total), 1)
+		}
+	}
+	u.prev = cur
+	return util, nil
+}
+
+// readCPUTimes parses the per-CPU lines of /proc/stat.
+func readCPUTimes() ([]cpuTimes, error) {
+	data, err := os.ReadFile(procStatPath)
+	if err != nil {
+		return nil, err
+	}
+	var times []cpuTimes
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	// The intr line lists a counter per interrupt: it's longer than the
+	// default max token size (64 KiB) on the large machines.
+	sc.Buffer(nil, len(data)+1)
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		// Skip the aggregated "cpu" line.
+		if len(fields) < 5 || !strings.HasPrefix(fields[0], "cpu") || fields[0] == "cpu" {
+			continue
+		}
+		cpu, err := strconv.Atoi(fields[0][len("cpu"):])
+		if err != nil || cpu < 0 || cpu >= MAX_CPUS {
+			return nil, fmt.Errorf("%s: invalid cpu %q", procStatPath, fields[0])
+		}
+		var t cpuTimes
+		for i, f := range fields[1:] {
+			v, err := strconv.ParseUint(f, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("%s: invalid %s time %q", procStatPath, fields[0], f)
+			}
+			// guest and guest_nice are already accounted in user
+			// and nice.
+			if i >= 8 {
+				break
+			}
+			t.total += v
+			// idle and iowait
+			if i != 3 && i != 4 {
+				t.busy += v
+			}
+		}
+		for len(times) <= cpu {
+			times = append(times, cpuTimes{})
+		}
+		times[cpu] = t
+	}
+	return times, sc.Err()
+}
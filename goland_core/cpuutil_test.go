@@ -0,0 +1,56 @@
+package core
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// fakeProcStat makes readCPUTimes read stat for the duration of the test.
+func fakeProcStat(t *testing.T, stat string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "stat")
+	if err := os.WriteFile(path, []byte(stat), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	orig := procStatPath
+	procStatPath = path
+	t.Cleanup(func() { procStatPath = orig })
+}
+
+func TestReadCPUTimes(t *testing.T) {
+	fakeProcStat(t, `cpu  30 0 30 300 10 0 0 0 0 0
+cpu0 10 0 20 100 5 0 0 0 7 0
+cpu1 20 0 10 200 5 0 0 0 0 0
+intr 1 2 3
+ctxt 100
+`)
+	times, err := readCPUTimes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []cpuTimes{{total: 135, busy: 30}, {total: 235, busy: 30}}
+	if len(times) != len(want) {
+		t.Fatalf("got %d CPUs, want %d", len(times), len(want))
+	}
+	for cpu := range want {
+		if times[cpu] != want[cpu] {
+			t.Errorf("cpu%d: got %+v, want %+v", cpu, times[cpu], want[cpu])
+		}
+	}
+}
+
+func TestReadCPUTimesLongIntrLine(t *testing.T) {
+	// An intr line longer than the default bufio.Scanner max (64 KiB),
+	// before the per-CPU lines of the CPUs that follow it.
+	intr := "intr" + strings.Repeat(" 12345678", 16*1024)
+	fakeProcStat(t, "cpu0 1 0 1 1 0 0 0 0 0 0\n"+intr+"\ncpu1 2 0 2 2 0 0 0 0 0 0\n")
+	times, err := readCPUTimes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(times) != 2 || times[1].total != 6 {
+		t.Fatalf("got %+v", times)
+	}
+}
@@ -0,0 +1,75 @@
+package core
+
+import (
+	"context"
+	"errors"
+)
+
+// Decider decides how a task dequeued by Run is dispatched. The QueuedTask is
+// reused by Run, it must not be retained after the call.
+//
+// Returning a nil DispatchedTask and no error means that the decider took
+// care of the task itself (e.g. it dispatched it, or it keeps it to dispatch
+// it later). If it fails, the error is logged and the task is dispatched with
+// the default decision, so that it is not left behind.
+type Decider func(t *QueuedTask) (*DispatchedTask, error)
+
+// SetDecider sets the function used by Run to dispatch the tasks; nil
+// restores the default decision, that dispatches each task with
+// NewDispatchedTask. It can be called at any time, also while Run is
+// running: the decider is read atomically for every task, so that the
+// scheduling policy can be swapped live, e.g. to compare two policies on the
+// same attached scheduler.
+func (s *Sched) SetDecider(fn func(*QueuedTask) (*DispatchedTask, error)) {
+	if fn == nil {
+		s.decider.Store(nil)
+		return
+	}
+	d := Decider(fn)
+	s.decider.Store(&d)
+}
+
+// Run is a basic scheduling loop: it dequeues the tasks and dispatches them
+// as decided by the decider set with SetDecider, until ctx is done or the
+// scheduler is closed. It returns ctx.Err() or ErrClosed.
+func (s *Sched) Run(ctx context.Context) error {
+	var t QueuedTask
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		s.DequeueTask(&t)
+		if t.Pid == -1 {
+			// All the queued tasks have been dispatched.
+			if err := s.NotifyComplete(0); err != nil {
+				return err
+			}
+			s.BlockTilReadyForDequeue(ctx)
+			continue
+		}
+		task := s.decide(&t)
+		if task == nil {
+			continue
+		}
+		if err := s.DispatchTask(task); errors.Is(err, ErrClosed) {
+			return err
+		} else if err != nil {
+			s.opts.Logger.Printf("dispatch pid %d: %v", t.Pid, err)
+		}
+	}
+}
+
+// decide returns the task to dispatch for t, nil if the decider dispatched
+// it.
+func (s *Sched) decide(t *QueuedTask) *DispatchedTask {
+	d := s.decider.Load()
+	if d == nil {
+		return NewDispatchedTask(t)
+	}
+	task, err := (*d)(t)
+	if err != nil {
+		s.opts.Logger.Printf("decider failed for pid %d: %v", t.Pid, err)
+		return NewDispatchedTask(t)
+	}
+	return task
+}
@@ -0,0 +1,274 @@
+package core
+
+/*
+#include "wrapper.h"
+*/
+import "C"
+
+import (
+	"errors"
+	"fmt"
+	"time"
+	"unsafe"
+
+	bpf "github.com/aquasecurity/libbpfgo"
+	"golang.org/x/sys/unix"
+)
+
+// DispatchStats reports what happened to the tasks submitted with
+// DispatchTask and TryDispatchTask, to reconcile the scheduling decisions
+// with the dispatches actually performed by the BPF component.
+type DispatchStats struct {
+	Submitted uint64 // Tasks sent to the dispatched ring buffer
+	Received  uint64 // Tasks received by the BPF component
+	Accepted  uint64 // Tasks inserted into a DSQ
+	Dropped   uint64 // Tasks that exited before they could be dispatched
+	Cancelled uint64 // Dispatches cancelled because the target CPU became invalid
+	// Bounces counts the dispatches that couldn't be honored as
+	// requested, by reason (see also LoadSchedOpts.BounceEvents).
+	Bounces map[BounceReason]uint64
+}
+
+// Pending returns the number of submitted tasks that the BPF component
+// didn't receive yet.
+func (d DispatchStats) Pending() uint64 {
+	if d.Received > d.Submitted {
+		return 0
+	}
+	return d.Submitted - d.Received
+}
+
+// DispatchStats returns the dispatch counters. A cancelled dispatch is not
+// lost: the kernel re-enqueues the task, that is sent again to the user-space
+// scheduler.
+func (s *Sched) DispatchStats() (DispatchStats, error) {
+	bss, err := s.GetBssData()
+	if err != nil {
+		return DispatchStats{}, err
+	}
+	d := DispatchStats{
+		Submitted: s.submitted.Load(),
+		Received:  bss.Nr_dispatch_received,
+		Dropped:   bss.Nr_dispatch_dropped,
+		Cancelled: bss.Nr_cancel_dispatches,
+	}
+	if done := d.Dropped + d.Cancelled; d.Received > done {
+		d.Accepted = d.Received - done
+	}
+	d.Bounces, err = s.bounceStats()
+	if err != nil {
+		return DispatchStats{}, err
+	}
+	return d, nil
+}
+
+// flushTimeout is how long Flush waits for the BPF component to receive the
+// submitted dispatches.
+const flushTimeout = time.Second
+
+// Flush waits until the BPF component has received all the dispatches
+// committed before the call (see DispatchStats.Pending), so that they reach
+// the kernel before the scheduler is detached. It returns immediately if the
+// scheduler is not attached, as nothing consumes the dispatched ring buffer
+// then.
+//
+// The dispatches committed concurrently with Flush may or may not be waited
+// for, and the slots reserved but not committed are not: the records are
+// received in the order they were reserved, so a pending slot holds back all
+// the records after it. Flush fails if the dispatches are not received
+// within 1s, e.g. because the BPF component exited.
+func (s *Sched) Flush() error {
+	if s.AttachedAt().IsZero() {
+		return nil
+	}
+	target := s.submitted.Load()
+	deadline := time.Now().Add(flushTimeout)
+	ticker := time.NewTicker(dispatchRetryInterval)
+	defer ticker.Stop()
+	for {
+		bss, err := s.GetBssData()
+		if err != nil {
+			return err
+		}
+		if bss.Nr_dispatch_received >= target {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("flush: %d dispatches not received after %v",
+				target-bss.Nr_dispatch_received, flushTimeout)
+		}
+		select {
+		case <-ticker.C:
+		case <-s.done:
+			return ErrClosed
+		}
+	}
+}
+
+// DispatchRecord mirrors intf.h::dispatched_task_ctx, the record sent to the
+// BPF component for each dispatched task (see DispatchedTask for the meaning
+// of the fields).
+type DispatchRecord struct {
+	Pid        int32
+	Cpu        int32
+	Flags      uint64
+	SliceNs    uint64
+	Vtime      uint64
+	CpuMaskCnt uint64
+	Sticky     uint32 // 1 = sticky
+	_          uint32
+	Dsq        uint64
+}
+
+// DispatchRecord must have the same layout as dispatched_task_ctx.
+var _ [unsafe.Sizeof(DispatchRecord{}) - dispatchedTaskSize]struct{}
+var _ [dispatchedTaskSize - unsafe.Sizeof(DispatchRecord{})]struct{}
+
+// dispatchRetryInterval is how often a blocked ReserveDispatch retries to
+// reserve a record: the BPF component drains the dispatched ring buffer
+// without notifying user space.
+const dispatchRetryInterval = 100 * time.Microsecond
+
+// DispatchSlot is a record reserved in the dispatched ring buffer. Its fields
+// are written in place, through the embedded DispatchRecord, then the record
+// is sent to the BPF component by Commit, or dropped by Discard.
+//
+// The BPF component consumes the records in order, so a slot must be
+// committed or discarded quickly: the records reserved after it are not
+// dispatched until then. The slot must not be used after Commit or Discard.
+type DispatchSlot struct {
+	*DispatchRecord
+	s  *Sched
+	rb unsafe.Pointer // ring buffer where the record has been reserved
+}
+
+// newDispatchRb creates the producer of the dispatched ring buffer.
+//
+// This producer is the only write path to the dispatched ring buffer: every
+// dispatch (DispatchTask, TryDispatchTask, ReserveDispatch) reserves a record
+// in it, under dispatchMu, and the record is visible to the BPF component as
+// soon as it is committed, without any intermediate queue in user space.
+// Writing to the map through another producer (e.g. a libbpfgo user ring
+// buffer opened on Map("dispatched")) is not supported: libbpf requires a
+// single producer.
+func newDispatchRb(m *bpf.BPFMap) (unsafe.Pointer, error) {
+	rb, err := C.new_dispatch_rb(C.int(m.FileDescriptor()))
+	if rb == nil {
+		return nil, fmt.Errorf("failed to create dispatched ring buffer: %w", err)
+	}
+	return rb, nil
+}
+
+func freeDispatchRb(rb unsafe.Pointer) {
+	if rb != nil {
+		C.free_dispatch_rb(rb)
+	}
+}
+
+// ReserveDispatch reserves a zeroed record in the dispatched ring buffer,
+// blocking while the buffer is full or being replaced by ReloadBPF, so that
+// hot-path schedulers can write the dispatched task in place, without
+// intermediate copies.
+func (s *Sched) ReserveDispatch() (*DispatchSlot, error) {
+	var timer *time.Timer
+	for {
+		slot, err := s.tryReserveDispatch()
+		if !errors.Is(err, ErrDispatchBufferFull) {
+			return slot, err
+		}
+		if timer == nil {
+			timer = time.NewTimer(dispatchRetryInterval)
+			defer timer.Stop()
+		} else {
+			timer.Reset(dispatchRetryInterval)
+		}
+		select {
+		case <-timer.C:
+		case <-s.done:
+			return nil, ErrClosed
+		}
+	}
+}
+
+// tryReserveDispatch is like ReserveDispatch, but returns
+// ErrDispatchBufferFull instead of blocking if the buffer is full.
+func (s *Sched) tryReserveDispatch() (*DispatchSlot, error) {
+	if err := s.acquire(); err != nil {
+		return nil, err
+	}
+	defer s.release()
+	if s.dispatchRb == nil {
+		return nil, mapNotFound("dispatched")
+	}
+	if s.reloading {
+		// The dispatched ring buffer is being replaced by ReloadBPF.
+		return nil, ErrDispatchBufferFull
+	}
+	s.dispatchMu.Lock()
+	p, err := C.reserve_dispatch(s.dispatchRb, C.u32(dispatchedTaskSize))
+	s.dispatchMu.Unlock()
+	if p == nil {
+		if errors.Is(err, unix.ENOSPC) {
+			s.dispatchFull.Store(true)
+			return nil, ErrDispatchBufferFull
+		}
+		return nil, fmt.Errorf("dispatched ring buffer: %w", err)
+	}
+	s.dispatchFull.Store(false)
+	s.slots.Add(1)
+	r := (*DispatchRecord)(p)
+	*r = DispatchRecord{}
+	return &DispatchSlot{DispatchRecord: r, s: s, rb: s.dispatchRb}, nil
+}
+
+// set fills the record with t.
+func (d *DispatchSlot) set(t *DispatchedTask) {
+	*d.DispatchRecord = DispatchRecord{
+		Pid:        t.Pid,
+		Cpu:        t.Cpu,
+		Flags:      t.Flags,
+		SliceNs:    t.SliceNs,
+		Vtime:      t.Vtime,
+		CpuMaskCnt: t.CpuMaskCnt,
+		Dsq:        t.Dsq,
+	}
+	if t.Sticky {
+		d.Sticky = 1
+	}
+	if t.Deadline != 0 {
+		d.Vtime = t.Deadline
+	}
+	if t.Runtime != 0 && t.SliceNs == 0 {
+		d.SliceNs = t.Runtime
+	}
+}
+
+// Commit sends the record to the BPF component.
+func (d *DispatchSlot) Commit() {
+	if d.DispatchRecord == nil {
+		return
+	}
+	pid, cpu := d.Pid, d.Cpu
+	slice, vtime := d.SliceNs, d.Vtime
+	C.submit_dispatch(d.rb, unsafe.Pointer(d.DispatchRecord))
+	d.DispatchRecord = nil
+	d.s.submitted.Add(1)
+	d.s.lastDispatch.Store(time.Now().UnixNano())
+	d.s.slots.Done()
+	if d.s.opts.TraceHook != nil {
+		d.s.traceDispatched(pid, cpu)
+	}
+	if d.s.audit != nil {
+		d.s.audit.dispatched(pid, cpu, slice, vtime)
+	}
+}
+
+// Discard releases the record without sending it.
+func (d *DispatchSlot) Discard() {
+	if d.DispatchRecord == nil {
+		return
+	}
+	C.discard_dispatch(d.rb, unsafe.Pointer(d.DispatchRecord))
+	d.DispatchRecord = nil
+	d.s.slots.Done()
+}
@@ -0,0 +1,121 @@
+package core
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"unsafe"
+
+	bpf "github.com/aquasecurity/libbpfgo"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// SHARED_DSQ is the DSQ shared by all the CPUs, where the tasks
+	// dispatched to RL_CPU_ANY are queued (see main.bpf.c). The DSQ of
+	// each CPU has the id of the CPU.
+	SHARED_DSQ = MAX_CPUS
+	// SCHED_DSQ is the DSQ of the user-space scheduler itself.
+	SCHED_DSQ = MAX_CPUS + 1
+)
+
+// dsqDumpPids is the maximum number of pids reported for each DSQ (see
+// intf.h::DSQ_DUMP_PIDS).
+const dsqDumpPids = 16
+
+// DSQInfo is the content of a DSQ of the BPF component, see DumpDSQs.
+type DSQInfo struct {
+	ID    uint64
+	Cpu   int     // CPU of a per-CPU DSQ, -1 for the other DSQs
+	Depth int     // Tasks in the DSQ
+	Pids  []int32 // First tasks of the DSQ (up to 16), in dispatch order
+}
+
+// DumpDSQs returns the content of the DSQs of the BPF component: the shared
+// DSQ, the DSQ of the user-space scheduler, the DSQ of each possible CPU and
+// the LLC DSQs created by SetupPerLLCQueues.
+//
+// It is a debug facility: it runs a program for each DSQ, and each DSQ is
+// read at a different time, so the result is not a consistent snapshot. The
+// DSQs are only read, scheduling is not affected.
+func (s *Sched) DumpDSQs() ([]DSQInfo, error) {
+	possible, err := readCpuList("possible")
+	if err != nil {
+		return nil, err
+	}
+	if err := s.acquire(); err != nil {
+		return nil, err
+	}
+	defer s.release()
+	if s.dumpDsq == nil {
+		return nil, progNotFound("dump_dsq")
+	}
+	if s.dsqDump == nil {
+		return nil, mapNotFound("dsq_dump")
+	}
+	s.dsqDumpMu.Lock()
+	defer s.dsqDumpMu.Unlock()
+
+	ids := []uint64{SHARED_DSQ, SCHED_DSQ}
+	for _, cpu := range possible.Cpus() {
+		ids = append(ids, uint64(cpu))
+	}
+	llcs := make(map[uint64]bool)
+	for cpu := range s.llcDsq {
+		if dsq := s.llcDsq[cpu].Load(); dsq != 0 && !llcs[dsq] {
+			llcs[dsq] = true
+			ids = append(ids, dsq)
+		}
+	}
+
+	dsqs := make([]DSQInfo, 0, len(ids))
+	for _, id := range ids {
+		info, err := dumpDSQ(s.dumpDsq, s.dsqDump, id)
+		if errors.Is(err, unix.ENOENT) {
+			// Not created, e.g. the DSQ of a CPU that never came online.
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		dsqs = append(dsqs, info)
+	}
+	return dsqs, nil
+}
+
+// dumpDSQ runs the dump_dsq prog for the DSQ id and decodes its output.
+func dumpDSQ(prog *bpf.BPFProg, out *bpf.BPFMap, id uint64) (DSQInfo, error) {
+	data := encodeDsqDumpArg(id)
+	opt := bpf.RunOpts{
+		CtxIn:     data,
+		CtxSizeIn: uint32(len(data)),
+	}
+	if err := prog.Run(&opt); err != nil {
+		return DSQInfo{}, fmt.Errorf("run dump_dsq: %w", err)
+	}
+	if ret := int32(opt.RetVal); ret < 0 {
+		return DSQInfo{}, fmt.Errorf("dump DSQ %d: %w", id, unix.Errno(-ret))
+	}
+	key := uint32(0)
+	b, err := out.GetValue(unsafe.Pointer(&key))
+	if err != nil {
+		return DSQInfo{}, fmt.Errorf("read dsq_dump: %w", err)
+	}
+	if len(b) != dsqDumpSize {
+		return DSQInfo{}, fmt.Errorf("dsq_dump value size %d doesn't match %d", len(b), dsqDumpSize)
+	}
+	info := DSQInfo{
+		ID:    binary.NativeEndian.Uint64(b[0:8]),
+		Cpu:   -1,
+		Depth: int(binary.NativeEndian.Uint64(b[8:16])),
+	}
+	if info.ID < MAX_CPUS {
+		info.Cpu = int(info.ID)
+	}
+	n := min(int(binary.NativeEndian.Uint32(b[16:20])), dsqDumpPids)
+	info.Pids = make([]int32, n)
+	for i := range info.Pids {
+		off := 20 + i*4
+		info.Pids[i] = int32(binary.NativeEndian.Uint32(b[off : off+4]))
+	}
+	return info, nil
+}
@@ -0,0 +1,59 @@
+package core
+
+import "container/heap"
+
+// EDFQueue orders the queued tasks by earliest deadline first
+// (QueuedTask.Deadline), then by pid. Tasks without a deadline (0) are
+// ordered after all the tasks with a deadline. The zero value is an empty
+// queue, it is not safe for concurrent use.
+type EDFQueue struct {
+	tasks edfHeap
+}
+
+// Push adds t to the queue.
+func (q *EDFQueue) Push(t *QueuedTask) {
+	heap.Push(&q.tasks, t)
+}
+
+// Pop removes and returns the task with the earliest deadline, or nil if the
+// queue is empty.
+func (q *EDFQueue) Pop() *QueuedTask {
+	if len(q.tasks) == 0 {
+		return nil
+	}
+	return heap.Pop(&q.tasks).(*QueuedTask)
+}
+
+// Peek returns the task with the earliest deadline without removing it, or
+// nil if the queue is empty.
+func (q *EDFQueue) Peek() *QueuedTask {
+	if len(q.tasks) == 0 {
+		return nil
+	}
+	return q.tasks[0]
+}
+
+// Len returns the number of tasks in the queue.
+func (q *EDFQueue) Len() int {
+	return len(q.tasks)
+}
+
+type edfHeap []*QueuedTask
+
+func (h edfHeap) Len() int { return len(h) }
+func (h edfHeap) Less(i, j int) bool {
+	di, dj := h[i].Deadline-1, h[j].Deadline-1 // 0 (no deadline) wraps to the end
+	if di != dj {
+		return di < dj
+	}
+	return h[i].Pid < h[j].Pid
+}
+func (h edfHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+func (h *edfHeap) Push(x any)   { *h = append(*h, x.(*QueuedTask)) }
+func (h *edfHeap) Pop() any {
+	old := *h
+	t := old[len(old)-1]
+	old[len(old)-1] = nil
+	*h = old[:len(old)-1]
+	return t
+}
@@ -0,0 +1,59 @@
+package core
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestEDFQueueOrder(t *testing.T) {
+	var q EDFQueue
+	if q.Pop() != nil || q.Peek() != nil {
+		t.Fatalf("empty queue returned a task")
+	}
+	// Mixed deadlines: ties, tasks without a deadline, and the latest
+	// possible one.
+	for _, task := range []QueuedTask{
+		{Pid: 1, Deadline: 300},
+		{Pid: 2, Deadline: 0},
+		{Pid: 3, Deadline: 100},
+		{Pid: 4, Deadline: ^uint64(0)},
+		{Pid: 5, Deadline: 100},
+		{Pid: 6, Deadline: 0},
+		{Pid: 7, Deadline: 200},
+		{Pid: 8, Deadline: 1},
+	} {
+		q.Push(&task)
+	}
+	if q.Len() != 8 || q.Peek().Pid != 8 {
+		t.Fatalf("len %d, first %v", q.Len(), q.Peek())
+	}
+
+	var order []int32
+	// A task queued while the others are being dispatched.
+	pushed := false
+	for task := q.Pop(); task != nil; task = q.Pop() {
+		order = append(order, task.Pid)
+		if !pushed && task.Deadline >= 200 {
+			q.Push(&QueuedTask{Pid: 9, Deadline: 250})
+			pushed = true
+		}
+	}
+	if got := fmt.Sprint(order); got != "[8 3 5 7 9 1 4 2 6]" {
+		t.Fatalf("dispatch order %s", got)
+	}
+}
+
+func TestEDFDispatchedTask(t *testing.T) {
+	// The assigned deadline and runtime override the vtime and the slice
+	// of the record sent to the BPF component.
+	var r DispatchRecord
+	slot := DispatchSlot{DispatchRecord: &r}
+	slot.set(&DispatchedTask{Pid: 1, Vtime: 10, Deadline: 500, Runtime: 2000})
+	if r.Vtime != 500 || r.SliceNs != 2000 {
+		t.Fatalf("vtime %d, slice %d", r.Vtime, r.SliceNs)
+	}
+	slot.set(&DispatchedTask{Pid: 1, Vtime: 10, SliceNs: 1000, Runtime: 2000})
+	if r.Vtime != 10 || r.SliceNs != 1000 {
+		t.Fatalf("vtime %d, slice %d without deadline", r.Vtime, r.SliceNs)
+	}
+}
@@ -0,0 +1,132 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+
+	"golang.org/x/sys/unix"
+)
+
+// Errors returned by the scheduler, to be matched with errors.Is. Failures
+// reported by the kernel wrap the original errno, so that e.g.
+// errors.Is(err, unix.EBUSY) works as well.
+var (
+	// ErrProgNotFound is returned when a BPF program required by an
+	// operation is not available, e.g. because Start() was not called or
+	// the object file doesn't provide it.
+	ErrProgNotFound = errors.New("prog not found")
+	// ErrMapNotFound is returned when a BPF map required by an operation
+	// is not available.
+	ErrMapNotFound = errors.New("map not found")
+	// ErrAlreadyAttached is returned by Attach if the scheduler is
+	// already attached.
+	ErrAlreadyAttached = errors.New("scheduler already attached")
+	// ErrAlreadyLoaded is returned by the settings that can only be
+	// changed before the BPF object is loaded by Start.
+	ErrAlreadyLoaded = errors.New("BPF object already loaded")
+	// ErrDispatchBufferFull is returned by TryDispatchTask when the
+	// dispatch buffer can't accept more tasks.
+	ErrDispatchBufferFull = errors.New("dispatch buffer full")
+	// ErrInvalidCPU is returned when a CPU id is out of the range
+	// supported by the BPF component.
+	ErrInvalidCPU = errors.New("invalid cpu")
+	// ErrCPUPerfUnsupported is returned by SetCPUPerf when the CPU
+	// frequency is not driven by the schedutil governor, that is the only
+	// one honoring the performance target.
+	ErrCPUPerfUnsupported = errors.New("cpu performance target not supported")
+	// ErrClosed is returned by the operations attempted after Close.
+	ErrClosed = errors.New("scheduler closed")
+)
+
+func progNotFound(name string) error {
+	return fmt.Errorf("%w: %s", ErrProgNotFound, name)
+}
+
+func mapNotFound(name string) error {
+	return fmt.Errorf("%w: %s", ErrMapNotFound, name)
+}
+
+func checkCPU(cpu int32) error {
+	if cpu < 0 || cpu >= MAX_CPUS {
+		return fmt.Errorf("%w: %d", ErrInvalidCPU, cpu)
+	}
+	return nil
+}
+
+// ErrSiblingCpu is returned by EnableSiblingCpu when the enable_sibling_cpu
+// program rejects the request, returning a negative errno.
+type ErrSiblingCpu struct {
+	Level   int32 // Cache level of the scheduling domain
+	Cpu     int32
+	Sibling int32
+	RetVal  int
+}
+
+func (e *ErrSiblingCpu) Error() string {
+	return fmt.Sprintf("enable sibling cpu %d of cpu %d (level %d) failed: retVal %d (%v)",
+		e.Sibling, e.Cpu, e.Level, e.RetVal, unix.Errno(-e.RetVal))
+}
+
+// Unwrap returns the errno returned by the program.
+func (e *ErrSiblingCpu) Unwrap() error {
+	if e.RetVal >= 0 {
+		return nil
+	}
+	return unix.Errno(-e.RetVal)
+}
+
+// ErrKprobeAttach is returned, or reported by Sched.Warnings, when a kprobe of
+// the BPF component can't be attached, e.g. because the kernel doesn't export
+// the probed symbol.
+type ErrKprobeAttach struct {
+	Prog string
+	Err  error
+}
+
+func (e *ErrKprobeAttach) Error() string {
+	return fmt.Sprintf("attach %s failed: %v", e.Prog, e.Err)
+}
+
+func (e *ErrKprobeAttach) Unwrap() error {
+	return e.Err
+}
+
+// ErrSchedulerBusy is returned by Attach when another sched_ext scheduler is
+// already attached. It matches unix.EBUSY.
+type ErrSchedulerBusy struct {
+	Current string // Name of the attached scheduler ("" = unknown)
+}
+
+func (e *ErrSchedulerBusy) Error() string {
+	if e.Current == "" {
+		return "another sched_ext scheduler is already attached"
+	}
+	return fmt.Sprintf("sched_ext scheduler %q is already attached", e.Current)
+}
+
+func (e *ErrSchedulerBusy) Unwrap() error {
+	return unix.EBUSY
+}
+
+// ErrPreemptCpu is returned by PreemptCpu when the do_preempt program
+// rejects the request.
+type ErrPreemptCpu struct {
+	RetVal int
+}
+
+func (e *ErrPreemptCpu) Error() string {
+	return fmt.Sprintf("preempt cpu failed: retVal %d", e.RetVal)
+}
+
+// ErrCPULimit is returned by Attach when the system has more possible CPUs
+// than the BPF object supports (see Sched.Limits and
+// LoadSchedOpts.IgnoreCPULimit): the CPUs beyond MAX_CPUS can't be tracked by
+// the BPF component.
+type ErrCPULimit struct {
+	Limits Limits
+}
+
+func (e *ErrCPULimit) Error() string {
+	return fmt.Sprintf("the system has %d possible CPUs, the BPF object supports %d",
+		e.Limits.PossibleCPUs, e.Limits.MaxCPUs)
+}
@@ -0,0 +1,248 @@
+package core
+
+/*
+#include "wrapper.h"
+*/
+import "C"
+
+// Size of the channels returned by TaskExits and TaskEvents.
+const (
+	taskExitChanSize  = 256
+	taskEventChanSize = 1024
+)
+
+// maxPendingExits is the maximum number of task exits coalesced while the
+// reader of ExitEventsBatched is busy; the exits beyond it are dropped (see
+// DroppedExits).
+const maxPendingExits = 1 << 16
+
+// TaskExit is the notification of a task that exited (see
+// bpf_intf::task_exit_ctx).
+//
+// Task exits are delivered through the exit_rb ring buffer, which carries
+// only the lifecycle events of the tasks (see TaskEvent): the exit of the
+// scheduler itself is reported through the UEI (see Stopped and GetUeiData).
+type TaskExit struct {
+	Pid  int32 // pid of the task that exited
+	Tgid int32 // thread group id of the task that exited
+}
+
+// TaskEventKind is the kind of a TaskEvent (see intf.h::task_event_kind).
+type TaskEventKind uint32
+
+const (
+	// TaskExited means that the task exited.
+	TaskExited TaskEventKind = iota
+	// TaskEnabled means that the task joined the scheduler: it is
+	// enqueued to the user-space scheduler from now on.
+	TaskEnabled
+	// TaskDisabled means that the task left the scheduler, e.g. because
+	// it switched to SCHED_FIFO or because it is exiting. It is not
+	// enqueued anymore until the next TaskEnabled.
+	TaskDisabled
+	// TaskCpumaskChanged means that the affinity of the task changed
+	// (e.g. with sched_setaffinity): TaskEvent.AllowedCpus is the new set
+	// of CPUs that it can use.
+	TaskCpumaskChanged
+)
+
+func (k TaskEventKind) String() string {
+	switch k {
+	case TaskExited:
+		return "exited"
+	case TaskEnabled:
+		return "enabled"
+	case TaskDisabled:
+		return "disabled"
+	case TaskCpumaskChanged:
+		return "cpumask_changed"
+	}
+	return "unknown"
+}
+
+// TaskEvent is a lifecycle event of a task (see bpf_intf::task_exit_ctx),
+// delivered on the channel returned by TaskEvents.
+type TaskEvent struct {
+	Kind TaskEventKind
+	Pid  int32
+	Tgid int32
+	Ts   uint64 // Timestamp of the event (CLOCK_MONOTONIC ns, as QueuedTask.EnqueueTs)
+	// AllowedCpus is the new affinity of the task for TaskCpumaskChanged,
+	// empty for the other kinds.
+	AllowedCpus CpuMask
+}
+
+// TaskEvents returns the channel where the lifecycle events of the tasks are
+// delivered when LoadSchedOpts.TaskEvents is set, nil otherwise: when a task
+// joins the scheduler (TaskEnabled), leaves it (TaskDisabled), changes
+// affinity (TaskCpumaskChanged) and exits (TaskExited). The task exits are
+// delivered only on this channel then, not on TaskExits.
+//
+// The CPU cached by SelectCPU for a task is dropped when its affinity
+// changes, before the event is delivered.
+//
+// The events of a task are delivered in order, and an exiting task is always
+// disabled before it exits. They are not ordered with the QueuedTask records
+// of the same pid, that are received through other ring buffers: a task can
+// still be dequeued after its TaskDisabled or TaskExited event, if it was
+// queued before it. Such a record has an EnqueueTs older than the Ts of the
+// event, and it must not be used to create a new per-task state.
+//
+// Like TaskExits, the events are not received from exit_rb while the channel
+// is full. When the scheduler is attached, all the tasks of the system are
+// enabled at once: the events that don't fit in exit_rb are counted by
+// DroppedExits.
+func (s *Sched) TaskEvents() <-chan TaskEvent {
+	return s.taskEvents
+}
+
+// enableTaskEvents makes the BPF component send the enable/disable and
+// affinity change events of the tasks to exit_rb.
+func enableTaskEvents() {
+	C.set_task_events(1)
+}
+
+// enableCpumaskEvents makes the BPF component send the affinity change events
+// of the tasks to exit_rb, to invalidate the SelectCPU cache: the consumers
+// that only deliver the exits drop them once handled.
+func enableCpumaskEvents() {
+	C.set_cpumask_events(1)
+}
+
+// TaskExits returns the channel where task exit notifications are delivered,
+// so that schedulers can release their per-task state. The channel is nil if
+// the BPF object doesn't provide the exit_rb ring buffer, or if the exits are
+// delivered in batches or with the other task events (see
+// LoadSchedOpts.BatchedExits and LoadSchedOpts.TaskEvents).
+//
+// The exits are not received from exit_rb while the channel is full: a
+// reader that falls behind makes the BPF component drop the exits once the
+// ring buffer fills up.
+func (s *Sched) TaskExits() <-chan TaskExit {
+	return s.exits
+}
+
+// ExitEventsBatched returns the channel where the task exits are delivered
+// when LoadSchedOpts.BatchedExits is set, nil otherwise. Each batch holds the
+// exits received since the previous one was read, in order, and it is never
+// empty.
+//
+// exit_rb is drained even if the reader is busy, so the exits are not lost
+// during a fork/exit storm: up to 65536 exits are kept until the next read,
+// the following ones are dropped and counted by DroppedExits.
+func (s *Sched) ExitEventsBatched() <-chan []TaskExit {
+	return s.exitBatches
+}
+
+// DroppedExits returns the number of task exits lost since the scheduler was
+// loaded: the exits that didn't fit in exit_rb, and the ones beyond the limit
+// of a batch (see ExitEventsBatched). A scheduler that keeps per-task state
+// can't release the state of these tasks when they exit.
+func (s *Sched) DroppedExits() (uint64, error) {
+	if err := s.acquire(); err != nil {
+		return 0, err
+	}
+	defer s.release()
+	return uint64(C.get_nr_exit_dropped()) + s.exitsDropped.Load(), nil
+}
+
+// receiveTaskEvent decodes a record of exit_rb and releases the state that
+// the package keeps for the task when it exits, or when its affinity changes.
+func (s *Sched) receiveTaskEvent(b []byte) (TaskEvent, bool) {
+	event, err := decodeTaskEvent(b)
+	if err != nil {
+		s.opts.Logger.Printf("decodeTaskEvent err: %v", err)
+		return TaskEvent{}, false
+	}
+	switch event.Kind {
+	case TaskCpumaskChanged:
+		s.InvalidateSelectCPU(event.Pid)
+		return event, true
+	case TaskExited:
+	default:
+		return event, true
+	}
+	s.InvalidateSelectCPU(event.Pid)
+	if s.opts.TraceHook != nil {
+		s.traceExited(event.Pid)
+	}
+	if s.audit != nil {
+		s.audit.exited(event.Pid)
+	}
+	return event, true
+}
+
+// receiveTaskExit is like receiveTaskEvent, for the consumers that only
+// deliver the task exits.
+func (s *Sched) receiveTaskExit(b []byte) (TaskExit, bool) {
+	event, ok := s.receiveTaskEvent(b)
+	if !ok || event.Kind != TaskExited {
+		return TaskExit{}, false
+	}
+	return TaskExit{Pid: event.Pid, Tgid: event.Tgid}, true
+}
+
+func (s *Sched) consumeTaskExits() {
+	for {
+		select {
+		case b := <-s.exitRb:
+			exit, ok := s.receiveTaskExit(b)
+			if !ok {
+				continue
+			}
+			select {
+			case s.exits <- exit:
+			case <-s.done:
+				return
+			}
+		case <-s.done:
+			return
+		}
+	}
+}
+
+func (s *Sched) consumeTaskEvents() {
+	for {
+		select {
+		case b := <-s.exitRb:
+			event, ok := s.receiveTaskEvent(b)
+			if !ok {
+				continue
+			}
+			select {
+			case s.taskEvents <- event:
+			case <-s.done:
+				return
+			}
+		case <-s.done:
+			return
+		}
+	}
+}
+
+func (s *Sched) consumeTaskExitBatches() {
+	var pending []TaskExit
+	for {
+		// The batch is only sent when there is something to deliver.
+		var out chan []TaskExit
+		if len(pending) > 0 {
+			out = s.exitBatches
+		}
+		select {
+		case b := <-s.exitRb:
+			exit, ok := s.receiveTaskExit(b)
+			if !ok {
+				continue
+			}
+			if len(pending) >= maxPendingExits {
+				s.exitsDropped.Add(1)
+				continue
+			}
+			pending = append(pending, exit)
+		case out <- pending:
+			pending = nil
+		case <-s.done:
+			return
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package core
+
+/*
+#include "wrapper.h"
+*/
+import "C"
+
+// MinVtime returns the global minimum vtime: the highest vtime of the tasks
+// that started running, i.e. the point the DSQs ordered by vtime have
+// reached. A task dispatched with a lower vtime runs before all the queued
+// ones.
+func (s *Sched) MinVtime() (uint64, error) {
+	if err := s.acquire(); err != nil {
+		return 0, err
+	}
+	defer s.release()
+	return uint64(C.get_vtime_now()), nil
+}
+
+// FairVtime returns the vtime of a task with the given weight that is
+// dispatched with a time slice of sliceNs, when the global minimum vtime is
+// minVtime: minVtime plus the slice scaled by the weight (see
+// ScaleByWeight), so that the tasks with higher weights are queued ahead.
+//
+// The vtime is computed from the global minimum, not from the vtime of the
+// task: a task that slept for a long time doesn't carry a vtime far behind
+// the others, that would let it monopolize the CPUs until it caught up. The
+// weight is clamped to WeightMin..WeightMax. The result is never 0, as vtime
+// 0 is reserved to the priority tasks by the BPF component.
+func FairVtime(minVtime, weight, sliceNs uint64) uint64 {
+	weight = min(max(weight, WeightMin), WeightMax)
+	return max(minVtime+ScaleByWeight(sliceNs, weight), 1)
+}
+
+// DispatchFair dispatches the task pid to the first CPU available, with a
+// time slice of sliceNs (0 = default) and the vtime computed by FairVtime
+// from the global minimum vtime and weight: the shared DSQ is ordered by
+// vtime, so the CPU time is shared among the tasks in proportion to their
+// weights.
+func (s *Sched) DispatchFair(pid int32, weight uint64, sliceNs uint64) error {
+	vtime, err := s.fairVtime(weight, sliceNs)
+	if err != nil {
+		return err
+	}
+	t := s.NewDispatchedTask()
+	t.Pid = pid
+	t.Cpu = RL_CPU_ANY
+	t.SliceNs = sliceNs
+	t.Vtime = vtime
+	if err := s.DispatchTask(t); err != nil {
+		s.putDispatchedTask(t)
+		return err
+	}
+	return nil
+}
+
+// fairVtime returns the FairVtime of a task dispatched now with the given
+// weight and time slice (0 = default).
+func (s *Sched) fairVtime(weight, sliceNs uint64) (uint64, error) {
+	if err := s.acquire(); err != nil {
+		return 0, err
+	}
+	defer s.release()
+	slice := sliceNs
+	if slice == 0 {
+		// The slice set by the auto-slice tuner, or the default one
+		// as an estimate of the slice left to the task.
+		slice = uint64(C.get_auto_slice_ns())
+		if slice == 0 {
+			slice = uint64(C.get_default_slice())
+		}
+	}
+	return FairVtime(uint64(C.get_vtime_now()), weight, slice), nil
+}
@@ -0,0 +1,26 @@
+package core
+
+import "testing"
+
+func TestFairVtime(t *testing.T) {
+	const slice = 5000000
+	for _, tt := range []struct {
+		name                    string
+		minVtime, weight, slice uint64
+		want                    uint64
+	}{
+		{"default weight", 1000, WeightDefault, slice, 1000 + slice},
+		{"double weight", 1000, 2 * WeightDefault, slice, 1000 + slice/2},
+		{"half weight", 1000, WeightDefault / 2, slice, 1000 + 2*slice},
+		{"weight 0 clamped", 1000, 0, slice, 1000 + slice*WeightDefault/WeightMin},
+		{"weight above max clamped", 1000, WeightMax * 10, slice, 1000 + slice*WeightDefault/WeightMax},
+		{"no slice", 1000, WeightDefault, 0, 1000},
+		{"vtime 0 reserved", 0, WeightDefault, 0, 1},
+		{"large minimum", 1 << 40, WeightDefault, slice, 1<<40 + slice},
+	} {
+		if got := FairVtime(tt.minVtime, tt.weight, tt.slice); got != tt.want {
+			t.Errorf("%s: FairVtime(%d, %d, %d) = %d, want %d",
+				tt.name, tt.minVtime, tt.weight, tt.slice, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package core
+
+/*
+#include "wrapper.h"
+*/
+import "C"
+
+import (
+	"errors"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// SetFastSelect enables or disables the fast path of the CPU selection for
+// pid: when the task wakes up, the BPF component picks an idle CPU in
+// ops.select_cpu() (a fully idle core first, then any idle CPU close to the
+// previous one) and dispatches the task there directly, without going
+// through the user-space scheduler. The tasks that can't be dispatched
+// directly are queued as usual, with QueuedTask.CpuSelected set if an idle
+// CPU was found.
+//
+// It is the per-task version of SetBuiltinIdle, that enables the fast path
+// for all the tasks. The setting is dropped when the task exits.
+func (s *Sched) SetFastSelect(pid int32, enabled bool) error {
+	if err := s.acquire(); err != nil {
+		return err
+	}
+	defer s.release()
+	if s.fastSelect == nil {
+		return mapNotFound("fast_select_tasks")
+	}
+	key := uint32(pid)
+	if !enabled {
+		err := s.fastSelect.DeleteKey(unsafe.Pointer(&key))
+		if errors.Is(err, unix.ENOENT) {
+			return nil
+		}
+		return err
+	}
+	val := uint8(1)
+	return s.fastSelect.Update(unsafe.Pointer(&key), unsafe.Pointer(&val))
+}
+
+// SelectPathStats counts the CPU selections made by the BPF component and by
+// the user-space scheduler, to measure how the tasks are split between the
+// two paths.
+type SelectPathStats struct {
+	// Fast: idle CPUs picked in ops.select_cpu(), for the tasks with the
+	// fast path enabled (see SetFastSelect and SetBuiltinIdle).
+	Fast uint64
+	// User: CPUs selected by the user-space scheduler with the
+	// rs_select_cpu prog (see SelectCPU).
+	User uint64
+}
+
+// SelectPathStats returns the CPU selections made on each path since the
+// scheduler was loaded.
+func (s *Sched) SelectPathStats() (SelectPathStats, error) {
+	if err := s.acquire(); err != nil {
+		return SelectPathStats{}, err
+	}
+	defer s.release()
+	return SelectPathStats{
+		Fast: uint64(C.get_nr_select_fast()),
+		User: uint64(C.get_nr_select_user()),
+	}, nil
+}
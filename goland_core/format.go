@@ -0,0 +1,205 @@
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/bits"
+	"strings"
+)
+
+// EnqFlags are the sched_ext enqueue flags reported in QueuedTask.Flags and
+// passed back in DispatchedTask.Flags (see enum scx_enq_flags).
+type EnqFlags uint64
+
+const (
+	EnqWakeup      EnqFlags = 1 << 0  // SCX_ENQ_WAKEUP
+	EnqHead        EnqFlags = 1 << 4  // SCX_ENQ_HEAD
+	EnqCpuSelected EnqFlags = 1 << 10 // SCX_ENQ_CPU_SELECTED
+	EnqPreempt     EnqFlags = 1 << 32 // SCX_ENQ_PREEMPT
+	EnqReenq       EnqFlags = 1 << 40 // SCX_ENQ_REENQ
+	EnqLast        EnqFlags = 1 << 41 // SCX_ENQ_LAST
+	EnqClearOpss   EnqFlags = 1 << 56 // SCX_ENQ_CLEAR_OPSS
+	EnqDsqPriq     EnqFlags = 1 << 57 // SCX_ENQ_DSQ_PRIQ
+)
+
+var enqFlagNames = map[EnqFlags]string{
+	EnqWakeup:      "WAKEUP",
+	EnqHead:        "HEAD",
+	EnqCpuSelected: "CPU_SELECTED",
+	EnqPreempt:     "PREEMPT",
+	EnqReenq:       "REENQ",
+	EnqLast:        "LAST",
+	EnqClearOpss:   "CLEAR_OPSS",
+	EnqDsqPriq:     "DSQ_PRIQ",
+}
+
+// Names returns the names of the flags set in f, from the lowest bit. Unknown
+// flags are reported in hex.
+func (f EnqFlags) Names() []string {
+	names := []string{}
+	for w := uint64(f); w != 0; w &= w - 1 {
+		flag := EnqFlags(1) << bits.TrailingZeros64(w)
+		if name, ok := enqFlagNames[flag]; ok {
+			names = append(names, name)
+		} else {
+			names = append(names, fmt.Sprintf("%#x", uint64(flag)))
+		}
+	}
+	return names
+}
+
+func (f EnqFlags) String() string {
+	if f == 0 {
+		return "0"
+	}
+	return strings.Join(f.Names(), "|")
+}
+
+func formatCpu(cpu int32) string {
+	if cpu == RL_CPU_ANY {
+		return "any"
+	}
+	return fmt.Sprint(cpu)
+}
+
+func (t QueuedTask) String() string {
+	return fmt.Sprintf("pid=%d (%s) %v cpu=%d prev_cpu=%d flags=%v weight=%d vtime=%d runtime=%d",
+		t.Pid, t.Comm, t.Reason(), t.Cpu, t.PrevCpu, EnqFlags(t.Flags), t.Weight, t.Vtime, t.RuntimeNs)
+}
+
+// MarshalJSON encodes the task with stable snake_case field names, the
+// allowed CPUs as a CPU list and the names of the enqueue flags.
+func (t QueuedTask) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Pid            int32    `json:"pid"`
+		Tgid           int32    `json:"tgid"`
+		Comm           string   `json:"comm"`
+		Cpu            int32    `json:"cpu"`
+		PrevCpu        int32    `json:"prev_cpu"`
+		NrCpusAllowed  uint64   `json:"nr_cpus_allowed"`
+		AllowedCpus    string   `json:"allowed_cpus"`
+		Flags          uint64   `json:"flags"`
+		FlagNames      []string `json:"flag_names"`
+		Reason         string   `json:"reason"`
+		StartTs        uint64   `json:"start_ts"`
+		StopTs         uint64   `json:"stop_ts"`
+		SumExecRuntime uint64   `json:"sum_exec_runtime"`
+		RuntimeNs      uint64   `json:"runtime_ns"`
+		Weight         uint64   `json:"weight"`
+		Nice           int32    `json:"nice"`
+		Vtime          uint64   `json:"vtime"`
+		WakerCpu       int32    `json:"waker_cpu"`
+		WakeFlags      uint64   `json:"wake_flags"`
+		MigrationCount uint64   `json:"migration_count"`
+		RecentCpus     string   `json:"recent_cpus"`
+		CgroupId       uint64   `json:"cgroup_id"`
+		EnqueueTs      uint64   `json:"enqueue_ts"`
+		Nvcsw          uint64   `json:"nvcsw"`
+		Nivcsw         uint64   `json:"nivcsw"`
+		IsKthread      bool     `json:"is_kthread"`
+		CpuSelected    bool     `json:"cpu_selected"`
+		RunningTs      uint64   `json:"running_ts"`
+		Deadline       uint64   `json:"deadline"`
+		Runtime        uint64   `json:"runtime"`
+	}{
+		Pid:            t.Pid,
+		Tgid:           t.Tgid,
+		Comm:           t.Comm,
+		Cpu:            t.Cpu,
+		PrevCpu:        t.PrevCpu,
+		NrCpusAllowed:  t.NrCpusAllowed,
+		AllowedCpus:    t.AllowedCpus.String(),
+		Flags:          t.Flags,
+		FlagNames:      EnqFlags(t.Flags).Names(),
+		Reason:         t.Reason().String(),
+		StartTs:        t.StartTs,
+		StopTs:         t.StopTs,
+		SumExecRuntime: t.SumExecRuntime,
+		RuntimeNs:      t.RuntimeNs,
+		Weight:         t.Weight,
+		Nice:           t.Nice,
+		Vtime:          t.Vtime,
+		WakerCpu:       t.WakerCpu,
+		WakeFlags:      t.WakeFlags,
+		MigrationCount: t.MigrationCount,
+		RecentCpus:     t.RecentCpus.String(),
+		CgroupId:       t.CgroupId,
+		EnqueueTs:      t.EnqueueTs,
+		Nvcsw:          t.Nvcsw,
+		Nivcsw:         t.Nivcsw,
+		IsKthread:      t.IsKthread,
+		CpuSelected:    t.CpuSelected,
+		RunningTs:      t.RunningTs,
+		Deadline:       t.Deadline,
+		Runtime:        t.Runtime,
+	})
+}
+
+func (t DispatchedTask) String() string {
+	s := fmt.Sprintf("pid=%d cpu=%s flags=%v slice=%d vtime=%d",
+		t.Pid, formatCpu(t.Cpu), EnqFlags(t.Flags), t.SliceNs, t.Vtime)
+	if t.Sticky {
+		s += " sticky"
+	}
+	if t.Dsq != 0 {
+		s += fmt.Sprintf(" dsq=%#x", t.Dsq)
+	}
+	return s
+}
+
+// MarshalJSON encodes the task with stable snake_case field names and the
+// names of the enqueue flags.
+func (t DispatchedTask) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Pid        int32    `json:"pid"`
+		Cpu        int32    `json:"cpu"`
+		Flags      uint64   `json:"flags"`
+		FlagNames  []string `json:"flag_names"`
+		SliceNs    uint64   `json:"slice_ns"`
+		Vtime      uint64   `json:"vtime"`
+		CpuMaskCnt uint64   `json:"cpumask_cnt"`
+		Sticky     bool     `json:"sticky"`
+		Dsq        uint64   `json:"dsq"`
+		Deadline   uint64   `json:"deadline"`
+		Runtime    uint64   `json:"runtime"`
+	}{
+		Pid:        t.Pid,
+		Cpu:        t.Cpu,
+		Flags:      t.Flags,
+		FlagNames:  EnqFlags(t.Flags).Names(),
+		SliceNs:    t.SliceNs,
+		Vtime:      t.Vtime,
+		CpuMaskCnt: t.CpuMaskCnt,
+		Sticky:     t.Sticky,
+		Dsq:        t.Dsq,
+		Deadline:   t.Deadline,
+		Runtime:    t.Runtime,
+	})
+}
+
+func (uei UserExitInfo) String() string {
+	s := fmt.Sprintf("kind %d, exit code %d", uei.Kind, uei.ExitCode)
+	if reason := uei.GetReason(); reason != "" {
+		s += ": " + reason
+	}
+	if msg := uei.GetMessage(); msg != "" {
+		s += " (" + msg + ")"
+	}
+	return s
+}
+
+// MarshalJSON encodes the exit info with the reason and the message as
+// strings.
+func (uei UserExitInfo) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Kind     int32  `json:"kind"`
+		ExitCode int64  `json:"exit_code"`
+		Reason   string `json:"reason"`
+		Message  string `json:"message"`
+	}{
+		Kind:     uei.Kind,
+		ExitCode: uei.ExitCode,
+		Reason:   uei.GetReason(),
+		Message:  uei.GetMessage(),
+	})
+}
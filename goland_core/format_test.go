@@ -0,0 +1,74 @@
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestQueuedTaskJSON(t *testing.T) {
+	task, err := decodeQueuedTask(goldenQueuedTask())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ptr, err := json.Marshal(task)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The values, e.g. in a slice, are encoded like the pointers.
+	slice, err := json.Marshal([]QueuedTask{*task})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(slice) != "["+string(ptr)+"]" {
+		t.Fatalf("value encoded as %s, pointer as %s", slice, ptr)
+	}
+
+	var got map[string]any
+	if err := json.Unmarshal(ptr, &got); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]any{
+		"pid":          float64(1234),
+		"comm":         "worker",
+		"allowed_cpus": "3,5,64",
+		"flag_names":   []any{"WAKEUP", "0x40"},
+		"recent_cpus":  "3,5",
+		"running_ts":   float64(7000),
+		"is_kthread":   true,
+	}
+	for k, v := range want {
+		if !reflect.DeepEqual(got[k], v) {
+			t.Errorf("%s = %v, want %v", k, got[k], v)
+		}
+	}
+	if fmt.Sprint(*task) != task.String() {
+		t.Fatalf("value formatted as %v, pointer as %v", *task, task)
+	}
+}
+
+func TestDispatchedTaskJSON(t *testing.T) {
+	task := DispatchedTask{Pid: 10, Cpu: RL_CPU_ANY, Flags: uint64(EnqHead), SliceNs: 5000, Sticky: true}
+	b, err := json.Marshal(task)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got struct {
+		Pid       int32    `json:"pid"`
+		Cpu       int32    `json:"cpu"`
+		FlagNames []string `json:"flag_names"`
+		SliceNs   uint64   `json:"slice_ns"`
+		Sticky    bool     `json:"sticky"`
+	}
+	if err := json.Unmarshal(b, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Pid != 10 || got.Cpu != RL_CPU_ANY || fmt.Sprint(got.FlagNames) != "[HEAD]" ||
+		got.SliceNs != 5000 || !got.Sticky {
+		t.Fatalf("decoded %+v from %s", got, b)
+	}
+	if s := fmt.Sprint(task); s != "pid=10 cpu=any flags=HEAD slice=5000 vtime=0 sticky" {
+		t.Fatalf("formatted as %q", s)
+	}
+}
@@ -0,0 +1,171 @@
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// healthStaleTimeout is how long the user-space scheduler can go without
+// running, or the ring buffers can stay full, before Healthy reports the
+// scheduler as unhealthy. It matches the timeout of the sched_ext watchdog
+// (see main.bpf.c::timeout_ms).
+const healthStaleTimeout = 5 * time.Second
+
+// healthState tracks the progress of the scheduler across Healthy calls.
+type healthState struct {
+	mu        sync.Mutex
+	lastRunAt uint64     // last usersched_last_run_at observed
+	runSeen   time.Time  // when lastRunAt changed
+	fullSince time.Time  // when the ring buffers were first seen full (zero = not full)
+	self      selfSample // process times at the previous Health call
+}
+
+// HealthReport describes the state of the scheduler, see Health.
+type HealthReport struct {
+	Healthy      bool      `json:"healthy"`
+	Reason       string    `json:"reason,omitempty"` // Why the scheduler is not healthy
+	Attached     bool      `json:"attached"`
+	AttachedAt   time.Time `json:"attached_at"`
+	LastDispatch time.Time `json:"last_dispatch"` // Last task committed to the dispatched ring buffer (zero = none)
+	ExitKind     int32     `json:"exit_kind"`     // Exit kind of the BPF component (0 = running)
+	ExitCode     int64     `json:"exit_code"`
+	Queued       uint64    `json:"queued"`           // Tasks waiting to be consumed by the user-space scheduler
+	Scheduled    uint64    `json:"scheduled"`        // Tasks waiting to be dispatched by the user-space scheduler
+	Pending      uint64    `json:"pending_dispatch"` // Tasks submitted but not received by the BPF component yet
+	// SelfCPU is the CPU used by the process of the user-space scheduler
+	// since the previous Health call, as a fraction of one CPU (see
+	// SelfUsage).
+	SelfCPU float64 `json:"self_cpu"`
+	// Bypass is set while the user-space scheduler is bypassed (see
+	// SetBypass).
+	Bypass bool `json:"bypass"`
+
+	// Error counters, increasing since the scheduler was loaded.
+	FailedDispatches  uint64 `json:"failed_dispatches"`
+	BounceDispatches  uint64 `json:"bounce_dispatches"`
+	CancelDispatches  uint64 `json:"cancel_dispatches"`
+	DroppedDispatches uint64 `json:"dropped_dispatches"`
+	DroppedExits      uint64 `json:"dropped_exits"`
+	DroppedQueued     uint64 `json:"dropped_queued"` // Queued records that couldn't be decoded
+	Congested         uint64 `json:"congested"`
+	Saturated         uint64 `json:"saturated"`
+	BypassDispatches  uint64 `json:"bypass_dispatches"`
+}
+
+// Health returns a report of the state of the scheduler. It checks the same
+// conditions as Healthy, and it reads the BPF counters from the memory
+// mapping of the .bss section, so it never blocks the dispatch of the tasks.
+func (s *Sched) Health() HealthReport {
+	r := HealthReport{AttachedAt: s.AttachedAt()}
+	r.Attached = !r.AttachedAt.IsZero()
+	if ns := s.lastDispatch.Load(); ns != 0 {
+		r.LastDispatch = time.Unix(0, ns)
+	}
+	r.DroppedQueued = s.queuedDropped.Load()
+	r.Healthy, r.Reason = s.checkHealth(&r)
+	return r
+}
+
+// Healthy reports whether the scheduler is working, and the reason why it is
+// not otherwise. It checks that the struct_ops is still attached, that the
+// BPF component didn't exit, that the user-space scheduler keeps being
+// scheduled and that the ring buffers don't stay full.
+//
+// It is cheap and safe to call periodically (e.g. from a liveness probe); the
+// staleness checks need at least two calls to detect a stall.
+func (s *Sched) Healthy() (bool, string) {
+	r := s.Health()
+	return r.Healthy, r.Reason
+}
+
+// checkHealth fills r with the state of the BPF component and checks it.
+func (s *Sched) checkHealth(r *HealthReport) (bool, string) {
+	if !r.Attached {
+		return false, "struct_ops not attached"
+	}
+
+	uei, err := s.GetUeiData()
+	if err != nil {
+		return false, fmt.Sprintf("read uei: %v", err)
+	}
+	r.ExitKind, r.ExitCode = uei.Kind, uei.ExitCode
+	if uei.Kind != 0 || uei.ExitCode != 0 {
+		return false, fmt.Sprintf("BPF component exited: kind %d, exit code %d", uei.Kind, uei.ExitCode)
+	}
+
+	bss, err := s.GetBssData()
+	if err != nil {
+		return false, fmt.Sprintf("read bss: %v", err)
+	}
+	r.Queued = bss.Nr_queued
+	r.Scheduled = bss.Nr_scheduled
+	if submitted := s.submitted.Load(); submitted > bss.Nr_dispatch_received {
+		r.Pending = submitted - bss.Nr_dispatch_received
+	}
+	r.FailedDispatches = bss.Nr_failed_dispatches
+	r.BounceDispatches = bss.Nr_bounce_dispatches
+	r.CancelDispatches = bss.Nr_cancel_dispatches
+	r.DroppedDispatches = bss.Nr_dispatch_dropped
+	r.DroppedExits = bss.Nr_exit_dropped + s.exitsDropped.Load()
+	r.Congested = bss.Nr_sched_congested
+	r.Saturated = bss.Nr_sched_saturated
+	tunables, err := s.GetTunables()
+	if err != nil {
+		return false, fmt.Sprintf("read tunables: %v", err)
+	}
+	r.Bypass = tunables.Bypass != 0
+	r.BypassDispatches = bss.Nr_bypass_dispatches
+
+	h := &s.health
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	now := time.Now()
+
+	if self, err := s.takeSelfSample(); err == nil {
+		if !h.self.at.IsZero() {
+			r.SelfCPU = usageSince(h.self, self).CPU
+		}
+		h.self = self
+	}
+
+	if bss.Usersched_last_run_at != h.lastRunAt || h.runSeen.IsZero() {
+		h.lastRunAt = bss.Usersched_last_run_at
+		h.runSeen = now
+	} else if stale := now.Sub(h.runSeen); stale > healthStaleTimeout {
+		return false, fmt.Sprintf("user-space scheduler didn't run for %v", stale.Truncate(time.Millisecond))
+	}
+
+	if s.ringBufsFull() {
+		if h.fullSince.IsZero() {
+			h.fullSince = now
+		} else if full := now.Sub(h.fullSince); full > healthStaleTimeout {
+			return false, fmt.Sprintf("ring buffers full for %v", full.Truncate(time.Millisecond))
+		}
+	} else {
+		h.fullSince = time.Time{}
+	}
+
+	return true, ""
+}
+
+// ringBufsFull returns true if the queued or the dispatched buffer is full.
+func (s *Sched) ringBufsFull() bool {
+	return (s.queue != nil && len(s.queue) == cap(s.queue)) || s.dispatchFull.Load()
+}
+
+// HealthHandler returns an HTTP handler that serves the HealthReport as JSON,
+// with status 200 if the scheduler is healthy and 503 otherwise, to be used
+// as a liveness probe.
+func (s *Sched) HealthHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		r := s.Health()
+		w.Header().Set("Content-Type", "application/json")
+		if !r.Healthy {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		json.NewEncoder(w).Encode(r)
+	})
+}
@@ -0,0 +1,128 @@
+package core
+
+/*
+#include "intf.h"
+*/
+import "C"
+
+import "unsafe"
+
+// cStruct is the layout of a C struct of intf.h, as laid out by the C
+// compiler for the target: its size and the offsets of its fields.
+type cStruct struct {
+	size    uintptr
+	offsets map[string]uintptr
+}
+
+// cStructs returns the layout of the records and prog arguments exchanged
+// with the BPF component, to check the hand-written codecs of this package
+// against intf.h on every architecture.
+func cStructs() map[string]cStruct {
+	var qt C.struct_queued_task_ctx
+	var te C.struct_task_exit_ctx
+	var ce C.struct_cpu_event_ctx
+	var be C.struct_bounce_event_ctx
+	var dt C.struct_dispatched_task_ctx
+	var tc C.struct_task_cpu_arg
+	var da C.struct_domain_arg
+	var ld C.struct_llc_dsq_arg
+	var cp C.struct_cpu_perf_arg
+	var dd C.struct_dsq_dump
+
+	return map[string]cStruct{
+		"queued_task_ctx": {unsafe.Sizeof(qt), map[string]uintptr{
+			"pid":             unsafe.Offsetof(qt.pid),
+			"cpu":             unsafe.Offsetof(qt.cpu),
+			"nr_cpus_allowed": unsafe.Offsetof(qt.nr_cpus_allowed),
+			"flags":           unsafe.Offsetof(qt.flags),
+			"start_ts":        unsafe.Offsetof(qt.start_ts),
+			"stop_ts":         unsafe.Offsetof(qt.stop_ts),
+			"exec_runtime":    unsafe.Offsetof(qt.exec_runtime),
+			"weight":          unsafe.Offsetof(qt.weight),
+			"vtime":           unsafe.Offsetof(qt.vtime),
+			"tgid":            unsafe.Offsetof(qt.tgid),
+			"nice":            unsafe.Offsetof(qt.nice),
+			"runtime_ns":      unsafe.Offsetof(qt.runtime_ns),
+			"cpumask":         unsafe.Offsetof(qt.cpumask),
+			"prev_cpu":        unsafe.Offsetof(qt.prev_cpu),
+			"comm":            unsafe.Offsetof(qt.comm),
+			"waker_cpu":       unsafe.Offsetof(qt.waker_cpu),
+			"wake_flags":      unsafe.Offsetof(qt.wake_flags),
+			"nr_migrations":   unsafe.Offsetof(qt.nr_migrations),
+			"recent_cpus":     unsafe.Offsetof(qt.recent_cpus),
+			"cgroup_id":       unsafe.Offsetof(qt.cgroup_id),
+			"enq_ts":          unsafe.Offsetof(qt.enq_ts),
+			"nvcsw":           unsafe.Offsetof(qt.nvcsw),
+			"nivcsw":          unsafe.Offsetof(qt.nivcsw),
+			"task_flags":      unsafe.Offsetof(qt.task_flags),
+			"running_ts":      unsafe.Offsetof(qt.running_ts),
+		}},
+		"task_exit_ctx": {unsafe.Sizeof(te), map[string]uintptr{
+			"pid":     unsafe.Offsetof(te.pid),
+			"tgid":    unsafe.Offsetof(te.tgid),
+			"kind":    unsafe.Offsetof(te.kind),
+			"ts":      unsafe.Offsetof(te.ts),
+			"cpumask": unsafe.Offsetof(te.cpumask),
+		}},
+		"cpu_event_ctx": {unsafe.Sizeof(ce), map[string]uintptr{
+			"cpu":  unsafe.Offsetof(ce.cpu),
+			"kind": unsafe.Offsetof(ce.kind),
+			"ts":   unsafe.Offsetof(ce.ts),
+		}},
+		"bounce_event_ctx": {unsafe.Sizeof(be), map[string]uintptr{
+			"pid":    unsafe.Offsetof(be.pid),
+			"cpu":    unsafe.Offsetof(be.cpu),
+			"reason": unsafe.Offsetof(be.reason),
+		}},
+		"dispatched_task_ctx": {unsafe.Sizeof(dt), map[string]uintptr{
+			"pid":         unsafe.Offsetof(dt.pid),
+			"cpu":         unsafe.Offsetof(dt.cpu),
+			"flags":       unsafe.Offsetof(dt.flags),
+			"slice_ns":    unsafe.Offsetof(dt.slice_ns),
+			"vtime":       unsafe.Offsetof(dt.vtime),
+			"cpumask_cnt": unsafe.Offsetof(dt.cpumask_cnt),
+			"sticky":      unsafe.Offsetof(dt.sticky),
+			"dsq_id":      unsafe.Offsetof(dt.dsq_id),
+		}},
+		"task_cpu_arg": {unsafe.Sizeof(tc), map[string]uintptr{
+			"pid":   unsafe.Offsetof(tc.pid),
+			"cpu":   unsafe.Offsetof(tc.cpu),
+			"flags": unsafe.Offsetof(tc.flags),
+		}},
+		"domain_arg": {unsafe.Sizeof(da), map[string]uintptr{
+			"lvl_id":         unsafe.Offsetof(da.lvl_id),
+			"cpu_id":         unsafe.Offsetof(da.cpu_id),
+			"sibling_cpu_id": unsafe.Offsetof(da.sibling_cpu_id),
+		}},
+		"preempt_cpu_arg": {C.sizeof_struct_preempt_cpu_arg, nil},
+		"llc_dsq_arg": {unsafe.Sizeof(ld), map[string]uintptr{
+			"cpu_id": unsafe.Offsetof(ld.cpu_id),
+			"llc_id": unsafe.Offsetof(ld.llc_id),
+		}},
+		"cpu_perf_arg": {unsafe.Sizeof(cp), map[string]uintptr{
+			"cpu_id": unsafe.Offsetof(cp.cpu_id),
+			"perf":   unsafe.Offsetof(cp.perf),
+		}},
+		"dsq_dump_arg": {C.sizeof_struct_dsq_dump_arg, nil},
+		"dsq_dump": {unsafe.Sizeof(dd), map[string]uintptr{
+			"dsq_id":    unsafe.Offsetof(dd.dsq_id),
+			"nr_queued": unsafe.Offsetof(dd.nr_queued),
+			"nr_pids":   unsafe.Offsetof(dd.nr_pids),
+			"pids":      unsafe.Offsetof(dd.pids),
+		}},
+		"idle_cpumask": {C.sizeof_struct_idle_cpumask, nil},
+	}
+}
+
+// cDefines returns the constants of intf.h that size the records or version
+// the protocol, and the number of bounce reasons.
+func cDefines() map[string]int {
+	return map[string]int{
+		"GOLAND_ABI_VERSION": C.GOLAND_ABI_VERSION,
+		"MAX_CPUS":           C.MAX_CPUS,
+		"NR_RECENT_CPUS":     C.NR_RECENT_CPUS,
+		"DSQ_DUMP_PIDS":      C.DSQ_DUMP_PIDS,
+		"TASK_COMM_LEN":      C.TASK_COMM_LEN,
+		"NR_BOUNCE_REASONS":  C.NR_BOUNCE_REASONS,
+	}
+}
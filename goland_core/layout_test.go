@@ -0,0 +1,97 @@
+package core
+
+import (
+	"testing"
+	"unsafe"
+)
+
+func TestLayoutDefines(t *testing.T) {
+	want := map[string]int{
+		"GOLAND_ABI_VERSION": ABIVersion,
+		"MAX_CPUS":           MAX_CPUS,
+		"NR_RECENT_CPUS":     nrRecentCpus,
+		"DSQ_DUMP_PIDS":      dsqDumpPids,
+		"TASK_COMM_LEN":      228 - 212, // queued_task_ctx.comm in fastDecode
+		"NR_BOUNCE_REASONS":  int(NrBounceReasons),
+	}
+	for name, c := range cDefines() {
+		if c != want[name] {
+			t.Errorf("%s = %d in intf.h, %d in Go", name, c, want[name])
+		}
+	}
+}
+
+func TestLayoutSizes(t *testing.T) {
+	want := map[string]uintptr{
+		"queued_task_ctx":     queuedTaskSize,
+		"task_exit_ctx":       taskExitSize,
+		"cpu_event_ctx":       cpuEventSize,
+		"bounce_event_ctx":    bounceEventSize,
+		"dispatched_task_ctx": dispatchedTaskSize,
+		"task_cpu_arg":        taskCpuArgSize,
+		"domain_arg":          domainArgSize,
+		"preempt_cpu_arg":     preemptArgSize,
+		"llc_dsq_arg":         llcDsqArgSize,
+		"cpu_perf_arg":        cpuPerfArgSize,
+		"dsq_dump_arg":        dsqDumpArgSize,
+		"dsq_dump":            dsqDumpSize,
+		"idle_cpumask":        idleCpumaskSize,
+	}
+	structs := cStructs()
+	for name, size := range want {
+		if c, ok := structs[name]; !ok {
+			t.Errorf("struct %s missing", name)
+		} else if c.size != size {
+			t.Errorf("sizeof(struct %s) = %d in intf.h, %d in Go", name, c.size, size)
+		}
+	}
+}
+
+// TestLayoutOffsets checks the offsets used by the codecs of this package,
+// and the fields of DispatchRecord, against the offsets of the C fields.
+func TestLayoutOffsets(t *testing.T) {
+	var r DispatchRecord
+	want := map[string]map[string]uintptr{
+		"queued_task_ctx": {
+			"pid": 0, "cpu": 4, "nr_cpus_allowed": 8, "flags": 16,
+			"start_ts": 24, "stop_ts": 32, "exec_runtime": 40, "weight": 48,
+			"vtime": 56, "tgid": 64, "nice": 68, "runtime_ns": 72,
+			"cpumask": 80, "prev_cpu": 208, "comm": 212, "waker_cpu": 228,
+			"wake_flags": 232, "nr_migrations": 240, "recent_cpus": 248,
+			"cgroup_id": 264, "enq_ts": 272, "nvcsw": 280, "nivcsw": 288,
+			"task_flags": 296, "running_ts": 304,
+		},
+		"task_exit_ctx":    {"pid": 0, "tgid": 4, "kind": 8, "ts": 16, "cpumask": 24},
+		"cpu_event_ctx":    {"cpu": 0, "kind": 4, "ts": 8},
+		"bounce_event_ctx": {"pid": 0, "cpu": 4, "reason": 8},
+		"dispatched_task_ctx": {
+			"pid":         unsafe.Offsetof(r.Pid),
+			"cpu":         unsafe.Offsetof(r.Cpu),
+			"flags":       unsafe.Offsetof(r.Flags),
+			"slice_ns":    unsafe.Offsetof(r.SliceNs),
+			"vtime":       unsafe.Offsetof(r.Vtime),
+			"cpumask_cnt": unsafe.Offsetof(r.CpuMaskCnt),
+			"sticky":      unsafe.Offsetof(r.Sticky),
+			"dsq_id":      unsafe.Offsetof(r.Dsq),
+		},
+		"task_cpu_arg": {"pid": 0, "cpu": 4, "flags": 8},
+		"domain_arg":   {"lvl_id": 0, "cpu_id": 4, "sibling_cpu_id": 8},
+		"llc_dsq_arg":  {"cpu_id": 0, "llc_id": 4},
+		"cpu_perf_arg": {"cpu_id": 0, "perf": 4},
+		"dsq_dump":     {"dsq_id": 0, "nr_queued": 8, "nr_pids": 16, "pids": 20},
+	}
+	structs := cStructs()
+	for name, fields := range want {
+		c := structs[name]
+		if len(c.offsets) != len(fields) {
+			t.Errorf("struct %s has %d fields in intf.h, %d in Go", name, len(c.offsets), len(fields))
+		}
+		for field, off := range fields {
+			if cOff, ok := c.offsets[field]; !ok {
+				t.Errorf("%s.%s missing", name, field)
+			} else if cOff != off {
+				t.Errorf("offsetof(%s, %s) = %d in intf.h, %d in Go", name, field, cOff, off)
+			}
+		}
+	}
+}
@@ -0,0 +1,161 @@
+package core
+
+/*
+#include "wrapper.h"
+*/
+import "C"
+
+import (
+	"slices"
+	"strings"
+	"sync"
+
+	bpf "github.com/aquasecurity/libbpfgo"
+)
+
+// LibbpfLogLevel is the most verbose level of the libbpf messages that are
+// routed to the Logger (see LoadSchedOpts.LibbpfLogLevel).
+type LibbpfLogLevel int
+
+const (
+	// LibbpfLogStderr leaves the messages of libbpf to its default
+	// output, the standard error.
+	LibbpfLogStderr LibbpfLogLevel = iota
+	// LibbpfLogWarn routes the warnings of libbpf, e.g. the verifier log
+	// of a program that failed to load.
+	LibbpfLogWarn
+	// LibbpfLogInfo also routes the informational messages.
+	LibbpfLogInfo
+	// LibbpfLogDebug routes all the messages of libbpf.
+	LibbpfLogDebug
+)
+
+func (l LibbpfLogLevel) String() string {
+	switch l {
+	case LibbpfLogStderr:
+		return "stderr"
+	case LibbpfLogWarn:
+		return "warn"
+	case LibbpfLogInfo:
+		return "info"
+	case LibbpfLogDebug:
+		return "debug"
+	}
+	return "unknown"
+}
+
+// libbpfNoise are the messages of libbpf that are expected when loading the
+// scheduler and are never routed to the Logger.
+var libbpfNoise = []string{
+	// The sections of the object that libbpf doesn't load, e.g.
+	// .rodata.abi on old libbpf versions.
+	"skipping unrecognized data section",
+	// The struct_ops callbacks that the running kernel doesn't know
+	// (e.g. cpu_acquire before 6.12) and that are left unset.
+	"skipping it as it's set to zero",
+}
+
+// libbpfLogger routes the messages of libbpf to a Logger. The print function
+// of libbpf is global to the process: the last loaded Sched that routes them
+// owns it until it is closed, then the previous one gets it back.
+type libbpfLogger struct {
+	logger Logger
+	level  LibbpfLogLevel
+}
+
+var libbpfLog struct {
+	mu     sync.Mutex
+	owners []*libbpfLogger // the last one receives the messages
+}
+
+// setLoggerCbs installs the print callbacks of libbpf, replaced by the
+// tests.
+var setLoggerCbs = bpf.SetLoggerCbs
+
+// registerLibbpfLog routes the messages of libbpf up to level to logger,
+// and returns the handle to pass to unregisterLibbpfLog. It returns nil if
+// level is LibbpfLogStderr.
+func registerLibbpfLog(logger Logger, level LibbpfLogLevel) *libbpfLogger {
+	if level == LibbpfLogStderr {
+		return nil
+	}
+	l := &libbpfLogger{logger: logger, level: level}
+	libbpfLog.mu.Lock()
+	defer libbpfLog.mu.Unlock()
+	libbpfLog.owners = append(libbpfLog.owners, l)
+	l.install()
+	return l
+}
+
+// unregisterLibbpfLog stops routing the messages of libbpf to l. If l owned
+// the output, it goes back to the previous registered logger, or to the
+// default output of libbpf if there is none.
+func unregisterLibbpfLog(l *libbpfLogger) {
+	if l == nil {
+		return
+	}
+	libbpfLog.mu.Lock()
+	defer libbpfLog.mu.Unlock()
+	owners := libbpfLog.owners
+	i := slices.Index(owners, l)
+	if i < 0 {
+		return
+	}
+	libbpfLog.owners = slices.Delete(owners, i, i+1)
+	if i != len(owners)-1 {
+		// Another logger owns the output.
+		return
+	}
+	if n := len(libbpfLog.owners); n > 0 {
+		libbpfLog.owners[n-1].install()
+		return
+	}
+	setLoggerCbs(bpf.Callbacks{})
+}
+
+// install makes l the output of libbpf.
+func (l *libbpfLogger) install() {
+	setLoggerCbs(bpf.Callbacks{
+		Log:        l.print,
+		LogFilters: []func(int, string) bool{l.filter},
+	})
+}
+
+// libbpfLevel maps a libbpf print level to a LibbpfLogLevel.
+func libbpfLevel(libLevel int) LibbpfLogLevel {
+	switch libLevel {
+	case bpf.LibbpfWarnLevel:
+		return LibbpfLogWarn
+	case bpf.LibbpfInfoLevel:
+		return LibbpfLogInfo
+	}
+	return LibbpfLogDebug
+}
+
+// filter returns true for the messages that must not be routed: the ones
+// above the level of l and the known noise.
+func (l *libbpfLogger) filter(libLevel int, msg string) bool {
+	if libbpfLevel(libLevel) > l.level {
+		return true
+	}
+	for _, noise := range libbpfNoise {
+		if strings.Contains(msg, noise) {
+			return true
+		}
+	}
+	return false
+}
+
+func (l *libbpfLogger) print(libLevel int, msg string) {
+	msg = strings.TrimSuffix(msg, "\n")
+	msg = strings.TrimPrefix(msg, "libbpf: ")
+	l.logger.Printf("libbpf %v: %s", libbpfLevel(libLevel), msg)
+}
+
+// setKernelLogLevel sets the log level of the verifier for the programs of
+// the opened skeleton (see LoadSchedOpts.KernelLogLevel). The skeleton is
+// opened by the wrapper and swapped into the module, so the level passed to
+// bpf.NewModuleFromFileArgs never reaches the programs.
+func setKernelLogLevel(level uint32) {
+	C.set_prog_log_level(C.u32(level))
+}
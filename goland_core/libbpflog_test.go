@@ -0,0 +1,115 @@
+package core
+
+import (
+	"fmt"
+	"testing"
+
+	bpf "github.com/aquasecurity/libbpfgo"
+)
+
+// recordLogger keeps the messages it receives.
+type recordLogger struct {
+	msgs []string
+}
+
+func (l *recordLogger) Printf(format string, v ...any) {
+	l.msgs = append(l.msgs, fmt.Sprintf(format, v...))
+}
+
+// fakeLoggerCbs replaces setLoggerCbs for the duration of the test, and
+// returns the callbacks installed last.
+func fakeLoggerCbs(t *testing.T) *bpf.Callbacks {
+	t.Helper()
+	var cur bpf.Callbacks
+	orig := setLoggerCbs
+	setLoggerCbs = func(cbs bpf.Callbacks) { cur = cbs }
+	t.Cleanup(func() { setLoggerCbs = orig })
+	return &cur
+}
+
+// emit delivers msg through the installed callbacks, as libbpfgo does.
+func emit(cbs *bpf.Callbacks, level int, msg string) {
+	for _, filter := range cbs.LogFilters {
+		if filter(level, msg) {
+			return
+		}
+	}
+	if cbs.Log != nil {
+		cbs.Log(level, msg)
+	}
+}
+
+func TestLibbpfLogOwners(t *testing.T) {
+	cbs := fakeLoggerCbs(t)
+	var a, b recordLogger
+
+	la := registerLibbpfLog(&a, LibbpfLogWarn)
+	lb := registerLibbpfLog(&b, LibbpfLogWarn)
+	emit(cbs, bpf.LibbpfWarnLevel, "libbpf: one\n")
+	if len(a.msgs) != 0 || len(b.msgs) != 1 {
+		t.Fatalf("last registered logger must own the output: a %q, b %q", a.msgs, b.msgs)
+	}
+
+	// Closing the owner gives the output back to the previous logger.
+	unregisterLibbpfLog(lb)
+	emit(cbs, bpf.LibbpfWarnLevel, "libbpf: two\n")
+	if len(a.msgs) != 1 || len(b.msgs) != 1 {
+		t.Fatalf("output not restored to the previous logger: a %q, b %q", a.msgs, b.msgs)
+	}
+
+	unregisterLibbpfLog(la)
+	if cbs.Log != nil || len(cbs.LogFilters) != 0 {
+		t.Fatalf("default output not restored")
+	}
+	if len(libbpfLog.owners) != 0 {
+		t.Fatalf("owners left: %d", len(libbpfLog.owners))
+	}
+}
+
+func TestLibbpfLogUnregisterNotOwner(t *testing.T) {
+	cbs := fakeLoggerCbs(t)
+	var a, b recordLogger
+
+	la := registerLibbpfLog(&a, LibbpfLogWarn)
+	lb := registerLibbpfLog(&b, LibbpfLogWarn)
+	// Closing a logger that doesn't own the output leaves it alone.
+	unregisterLibbpfLog(la)
+	emit(cbs, bpf.LibbpfWarnLevel, "libbpf: one\n")
+	if len(a.msgs) != 0 || len(b.msgs) != 1 {
+		t.Fatalf("a %q, b %q", a.msgs, b.msgs)
+	}
+	unregisterLibbpfLog(lb)
+	if cbs.Log != nil {
+		t.Fatalf("default output not restored")
+	}
+	// Unregistering twice, or a nil logger, is a no-op.
+	unregisterLibbpfLog(lb)
+	unregisterLibbpfLog(nil)
+}
+
+func TestLibbpfLogStderr(t *testing.T) {
+	fakeLoggerCbs(t)
+	if l := registerLibbpfLog(&recordLogger{}, LibbpfLogStderr); l != nil {
+		t.Fatalf("LibbpfLogStderr must not register a logger")
+	}
+}
+
+func TestLibbpfLogFilter(t *testing.T) {
+	cbs := fakeLoggerCbs(t)
+	var a recordLogger
+	l := registerLibbpfLog(&a, LibbpfLogInfo)
+	defer unregisterLibbpfLog(l)
+
+	emit(cbs, bpf.LibbpfWarnLevel, "libbpf: prog 'goland_enqueue': failed to load\n")
+	emit(cbs, bpf.LibbpfInfoLevel, "libbpf: info\n")
+	emit(cbs, bpf.LibbpfDebugLevel, "libbpf: debug\n")
+	emit(cbs, bpf.LibbpfInfoLevel, "libbpf: elf: skipping unrecognized data section(7) .comment\n")
+
+	want := []string{
+		"libbpf warn: prog 'goland_enqueue': failed to load",
+		"libbpf info: info",
+	}
+	if fmt.Sprint(a.msgs) != fmt.Sprint(want) {
+		t.Fatalf("got %q, want %q", a.msgs, want)
+	}
+}
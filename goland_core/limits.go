@@ -0,0 +1,83 @@
+package core
+
+/*
+#include "wrapper.h"
+*/
+import "C"
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Limits compares the number of CPUs supported by the BPF object with the
+// CPUs of the system, see Sched.Limits.
+type Limits struct {
+	// MaxCPUs is the number of CPUs supported by the BPF object, the
+	// MAX_CPUS it was compiled with (see intf.h).
+	MaxCPUs int
+	// PossibleCPUs is the number of possible CPUs of the system
+	// (nr_cpu_ids): the highest CPU id that can ever come online, plus 1.
+	PossibleCPUs int
+}
+
+// Limits returns the number of CPUs supported by the BPF object and the
+// number of possible CPUs of the system, as read when the object was loaded.
+func (s *Sched) Limits() Limits {
+	return s.limits
+}
+
+// readLimits reads the MAX_CPUS of the opened BPF object and the possible
+// CPUs of the system.
+func readLimits() (Limits, error) {
+	possible, err := nrPossibleCpus()
+	if err != nil {
+		return Limits{}, err
+	}
+	return Limits{
+		MaxCPUs:      int(C.get_abi_max_cpus()),
+		PossibleCPUs: possible,
+	}, nil
+}
+
+// nrPossibleCpus returns nr_cpu_ids, from the list of the possible CPUs. The
+// list is not parsed as a CpuMask, that can't represent the CPUs beyond
+// MAX_CPUS.
+func nrPossibleCpus() (int, error) {
+	b, err := os.ReadFile(filepath.Join(sysCpuPath, "possible"))
+	if err != nil {
+		return 0, err
+	}
+	list := strings.TrimSpace(string(b))
+	nr := 0
+	for _, r := range strings.Split(list, ",") {
+		_, hi, isRange := strings.Cut(r, "-")
+		if !isRange {
+			hi = r
+		}
+		last, err := strconv.Atoi(hi)
+		if err != nil || last < 0 {
+			return 0, fmt.Errorf("invalid cpu list %q", list)
+		}
+		nr = max(nr, last+1)
+	}
+	return nr, nil
+}
+
+// checkLimits fails with ErrCPULimit if the system has more possible CPUs
+// than the BPF object supports, unless LoadSchedOpts.IgnoreCPULimit is set.
+func (s *Sched) checkLimits() error {
+	l := s.limits
+	if l.PossibleCPUs <= l.MaxCPUs {
+		return nil
+	}
+	err := &ErrCPULimit{Limits: l}
+	if !s.opts.IgnoreCPULimit {
+		return err
+	}
+	s.opts.Logger.Printf("%v: the CPUs from %d are not supported", err, l.MaxCPUs)
+	return nil
+}
@@ -0,0 +1,148 @@
+package core
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	bpf "github.com/aquasecurity/libbpfgo"
+	"golang.org/x/sys/unix"
+)
+
+// LLC_DSQ_BASE is the id of the DSQ of the LLC 0: the DSQ of the LLC with id
+// N is LLC_DSQ_BASE + N (see intf.h).
+const LLC_DSQ_BASE = MAX_CPUS * 2
+
+const sysCpuPath = "/sys/devices/system/cpu"
+
+// SetupPerLLCQueues creates a DSQ for each last level cache of the system and
+// makes every CPU consume the DSQ of its LLC, right after its own DSQ. It
+// returns the DSQ of each LLC, indexed by LLC id.
+//
+// Tasks are dispatched to an LLC queue by setting DispatchedTask.Dsq, they
+// then run on the first CPU of that LLC that becomes available. If the cache
+// topology is not available, all the CPUs are assigned to a single LLC.
+//
+// The queues don't survive ReloadBPF: until they are set up again, tasks
+// dispatched to them go to the shared DSQ, as the tasks that can't run on
+// any CPU of their LLC, and are reported as BounceNoLLCDsq.
+func (s *Sched) SetupPerLLCQueues() (map[int]uint64, error) {
+	if err := s.acquire(); err != nil {
+		return nil, err
+	}
+	defer s.release()
+	if s.llcDsqProg == nil {
+		return nil, progNotFound("setup_llc_dsq")
+	}
+	llcs, err := cpuLLCs()
+	if err != nil {
+		return nil, err
+	}
+
+	queues := make(map[int]uint64)
+	for cpu, llc := range llcs {
+		if err := checkCPU(int32(cpu)); err != nil {
+			return nil, err
+		}
+		if llc < 0 || llc >= MAX_CPUS {
+			return nil, fmt.Errorf("invalid LLC id %d for cpu %d", llc, cpu)
+		}
+		data := encodeLlcDsqArg(int32(cpu), int32(llc))
+		opt := bpf.RunOpts{
+			CtxIn:     data,
+			CtxSizeIn: uint32(len(data)),
+		}
+		if err := s.llcDsqProg.Run(&opt); err != nil {
+			return nil, fmt.Errorf("run setup_llc_dsq: %w", err)
+		}
+		if ret := int32(opt.RetVal); ret < 0 {
+			return nil, fmt.Errorf("setup DSQ of LLC %d for cpu %d: %w", llc, cpu, unix.Errno(-ret))
+		}
+		dsq := uint64(LLC_DSQ_BASE + llc)
+		s.llcDsq[cpu].Store(dsq)
+		queues[llc] = dsq
+	}
+	return queues, nil
+}
+
+// LLCQueue returns the DSQ of the LLC of cpu, or 0 if SetupPerLLCQueues
+// didn't set it up.
+func (s *Sched) LLCQueue(cpu int32) uint64 {
+	if cpu < 0 || cpu >= MAX_CPUS {
+		return 0
+	}
+	return s.llcDsq[cpu].Load()
+}
+
+// cpuLLCs returns the id of the last level cache of each CPU. CPUs without
+// cache information (e.g. offline) are skipped, unless no CPU has it: then
+// all the CPUs are assigned to the LLC 0.
+func cpuLLCs() (map[int]int, error) {
+	dirs, err := filepath.Glob(filepath.Join(sysCpuPath, "cpu[0-9]*"))
+	if err != nil {
+		return nil, err
+	}
+	llcs := make(map[int]int)
+	var all []int
+	for _, dir := range dirs {
+		cpu, err := strconv.Atoi(strings.TrimPrefix(filepath.Base(dir), "cpu"))
+		if err != nil {
+			continue
+		}
+		all = append(all, cpu)
+		if llc, ok := cpuLLC(dir); ok {
+			llcs[cpu] = llc
+		}
+	}
+	if len(all) == 0 {
+		return nil, fmt.Errorf("no CPU found in %s", sysCpuPath)
+	}
+	if len(llcs) == 0 {
+		for _, cpu := range all {
+			llcs[cpu] = 0
+		}
+	}
+	return llcs, nil
+}
+
+// cpuLLC returns the id of the cache with the highest level of the CPU at
+// dir. The id is read from the cache id, or from the first CPU sharing the
+// cache if the id is not available.
+func cpuLLC(dir string) (int, bool) {
+	indexes, _ := filepath.Glob(filepath.Join(dir, "cache", "index[0-9]*"))
+	llcIndex, llcLevel := "", -1
+	for _, index := range indexes {
+		level, err := readInt(filepath.Join(index, "level"))
+		if err == nil && level > llcLevel {
+			llcIndex, llcLevel = index, level
+		}
+	}
+	if llcIndex == "" {
+		return 0, false
+	}
+	if id, err := readInt(filepath.Join(llcIndex, "id")); err == nil {
+		return id, true
+	}
+	b, err := os.ReadFile(filepath.Join(llcIndex, "shared_cpu_list"))
+	if err != nil {
+		return 0, false
+	}
+	first := strings.FieldsFunc(string(b), func(r rune) bool {
+		return r < '0' || r > '9'
+	})
+	if len(first) == 0 {
+		return 0, false
+	}
+	id, err := strconv.Atoi(first[0])
+	return id, err == nil
+}
+
+func readInt(path string) (int, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(strings.TrimSpace(string(b)))
+}
@@ -0,0 +1,12 @@
+package core
+
+// Logger receives the diagnostic messages of the scheduler. *log.Logger
+// satisfies it, so log.Default() restores the output of the standard log
+// package.
+type Logger interface {
+	Printf(format string, v ...any)
+}
+
+type nopLogger struct{}
+
+func (nopLogger) Printf(string, ...any) {}
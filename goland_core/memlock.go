@@ -0,0 +1,64 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+
+	"golang.org/x/sys/unix"
+)
+
+// RaiseMemlockRlimit raises RLIMIT_MEMLOCK to infinity. On kernels older than
+// 5.11 the memory of the BPF maps is charged to this limit, and a low limit
+// makes the load fail with "operation not permitted".
+//
+// It is called by LoadSched and the other loaders, unless
+// LoadSchedOpts.SkipMemlockRlimit or LoadSchedOpts.SkipMemoryLock is set, that
+// only log a warning if it fails. It is a no-op if the limit is already
+// infinite. Raising the hard limit requires CAP_SYS_RESOURCE: without it, the
+// soft limit is raised to the hard limit, and an error is still returned
+// since the limit stays finite.
+func RaiseMemlockRlimit() error {
+	var cur unix.Rlimit
+	if err := unix.Getrlimit(unix.RLIMIT_MEMLOCK, &cur); err != nil {
+		return fmt.Errorf("get RLIMIT_MEMLOCK: %w", err)
+	}
+	if cur.Cur == unix.RLIM_INFINITY && cur.Max == unix.RLIM_INFINITY {
+		return nil
+	}
+	rlim := unix.Rlimit{Cur: unix.RLIM_INFINITY, Max: unix.RLIM_INFINITY}
+	err := unix.Setrlimit(unix.RLIMIT_MEMLOCK, &rlim)
+	if errors.Is(err, unix.EPERM) {
+		const hint = "CAP_SYS_RESOURCE is required, or raise the limit before starting the process, e.g. ulimit -l unlimited"
+		if cur.Cur < cur.Max {
+			rlim = unix.Rlimit{Cur: cur.Max, Max: cur.Max}
+			if unix.Setrlimit(unix.RLIMIT_MEMLOCK, &rlim) == nil {
+				return fmt.Errorf("raise RLIMIT_MEMLOCK: %w (raised to the hard limit, %d bytes; %s)", err, cur.Max, hint)
+			}
+		}
+		return fmt.Errorf("raise RLIMIT_MEMLOCK: %w (%s)", err, hint)
+	} else if err != nil {
+		return fmt.Errorf("raise RLIMIT_MEMLOCK: %w", err)
+	}
+	return nil
+}
+
+// LockMemory raises RLIMIT_MEMLOCK to infinity and locks all the current and
+// future pages of the process in memory, so that the scheduler never waits
+// for a page fault to be served while tasks are waiting to be dispatched.
+//
+// The memory is locked by LoadSched and the other loaders, unless
+// LoadSchedOpts.SkipMemoryLock is set. It fails if the process is not allowed
+// to raise the limit, e.g. in a container with a low memlock limit.
+func LockMemory() error {
+	if err := RaiseMemlockRlimit(); err != nil {
+		return err
+	}
+	return mlockAll()
+}
+
+func mlockAll() error {
+	if err := unix.Mlockall(unix.MCL_CURRENT | unix.MCL_FUTURE); err != nil {
+		return fmt.Errorf("mlockall: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,152 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// MockBackend is an in-memory SchedBackend: it feeds scripted tasks to the
+// policy and records every dispatch, so that a scheduling policy can be
+// tested without loading any BPF program.
+type MockBackend struct {
+	mu         sync.Mutex
+	queue      []mockQueued
+	dispatched []DispatchedTask
+	notify     chan struct{}
+	stats      BssData
+
+	// SelectCpuFn, if set, is used to answer SelectCPU; otherwise SelectCPU
+	// returns RL_CPU_ANY.
+	SelectCpuFn func(t *QueuedTask) (int32, error)
+	// DispatchErr, if set, is returned by DispatchTask and the task is not
+	// recorded.
+	DispatchErr error
+}
+
+// mockQueued is a task queued in a MockBackend.
+type mockQueued struct {
+	task   QueuedTask
+	record []byte // Record of the queued ring buffer, decoded by DequeueTask (nil = task)
+}
+
+// NewMockBackend creates a MockBackend with the given tasks already queued.
+func NewMockBackend(tasks ...QueuedTask) *MockBackend {
+	m := &MockBackend{
+		notify: make(chan struct{}, 1),
+	}
+	m.Enqueue(tasks...)
+	return m
+}
+
+// Enqueue appends tasks to the queue, as if they had been sent by the BPF
+// component.
+func (m *MockBackend) Enqueue(tasks ...QueuedTask) {
+	if len(tasks) == 0 {
+		return
+	}
+	queued := make([]mockQueued, len(tasks))
+	for i := range tasks {
+		queued[i].task = tasks[i]
+	}
+	m.enqueue(queued)
+}
+
+// EnqueueRecords enqueues records of the queued ring buffer (e.g. made by
+// GenerateQueuedTasks). They are decoded by DequeueTask, like the records
+// received from the BPF component, so that MeasureDispatch accounts for the
+// decoding. It fails if a record doesn't have the size of queued_task_ctx.
+func (m *MockBackend) EnqueueRecords(records [][]byte) error {
+	queued := make([]mockQueued, len(records))
+	for i, r := range records {
+		if len(r) != queuedTaskSize {
+			return fmt.Errorf("data length %d doesn't match queued_task_ctx size %d", len(r), queuedTaskSize)
+		}
+		queued[i].record = r
+	}
+	m.enqueue(queued)
+	return nil
+}
+
+func (m *MockBackend) enqueue(queued []mockQueued) {
+	if len(queued) == 0 {
+		return
+	}
+	m.mu.Lock()
+	m.queue = append(m.queue, queued...)
+	m.stats.Nr_queued += uint64(len(queued))
+	m.mu.Unlock()
+	select {
+	case m.notify <- struct{}{}:
+	default:
+	}
+}
+
+func (m *MockBackend) BlockTilReadyForDequeue(ctx context.Context) {
+	for !m.ReadyForDequeue() {
+		select {
+		case <-m.notify:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (m *MockBackend) ReadyForDequeue() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.queue) > 0
+}
+
+// DequeueTask pops the first queued task, or sets task.Pid to -1 if the queue
+// is empty (same contract as Sched.DequeueTask).
+func (m *MockBackend) DequeueTask(task *QueuedTask) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(m.queue) == 0 {
+		task.Pid = -1
+		return
+	}
+	if q := &m.queue[0]; q.record != nil {
+		// The size has been checked by EnqueueRecords.
+		_ = fastDecode(q.record, task)
+	} else {
+		*task = q.task
+	}
+	m.queue[0] = mockQueued{}
+	m.queue = m.queue[1:]
+	if m.stats.Nr_queued > 0 {
+		m.stats.Nr_queued--
+	}
+}
+
+func (m *MockBackend) DispatchTask(t *DispatchedTask) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.DispatchErr != nil {
+		return m.DispatchErr
+	}
+	m.dispatched = append(m.dispatched, *t)
+	m.stats.Nr_user_dispatches++
+	return nil
+}
+
+func (m *MockBackend) SelectCPU(t *QueuedTask) (int32, error) {
+	if m.SelectCpuFn != nil {
+		return m.SelectCpuFn(t)
+	}
+	return RL_CPU_ANY, nil
+}
+
+func (m *MockBackend) GetBssData() (BssData, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.stats, nil
+}
+
+// Dispatched returns a copy of all the tasks dispatched so far, in order.
+func (m *MockBackend) Dispatched() []DispatchedTask {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]DispatchedTask(nil), m.dispatched...)
+}
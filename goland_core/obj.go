@@ -2,111 +2,493 @@ package core
 
 import (
 	"bytes"
-	"encoding/binary"
+	"debug/elf"
+	"errors"
 	"fmt"
-	"log"
-	"syscall"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unsafe"
 
 	bpf "github.com/aquasecurity/libbpfgo"
-	"golang.org/x/sys/unix"
 )
 
 const (
 	RL_CPU_ANY = 1 << 20
+	MAX_CPUS   = 1024 // Maximum amount of CPUs supported by the BPF component
 )
 
+// Sched is a sched_ext scheduler backed by the BPF component.
+//
+// LoadSched, Start and the rodata setters (SetDebug, SetDefaultSlice, ...)
+// must be called from a single goroutine before the scheduler is attached.
+// After Start, all the other methods can be called concurrently from multiple
+// goroutines. Close waits for the operations in flight to complete and for
+// the reserved dispatch slots to be committed or discarded, wakes up the
+// goroutines blocked in DispatchTask, and makes every later operation fail
+// with ErrClosed.
 type Sched struct {
 	mod        *bpf.Module
 	bss        *BssMap
+	tunables   *BssMap
 	uei        *UeiMap
 	rodata     *RodataMap
 	structOps  *bpf.BPFMap
-	queue      chan []byte // The map containing tasks that are queued to user space from the kernel.
-	dispatch   chan []byte
-	selectCpu  *bpf.BPFProg
-	preemptCpu *bpf.BPFProg
-	siblingCpu *bpf.BPFProg
-	urb        *bpf.UserRingBuffer
+	link       *bpf.BPFLink
+	attachedAt time.Time
+	queue      chan []byte    // The map containing tasks that are queued to user space from the kernel.
+	queueHigh  atomic.Int64   // highest depth of queue seen by DequeueTask
+	dispatchRb unsafe.Pointer // producer of the dispatched ring buffer (struct user_ring_buffer)
+	// dispatchMu serializes the reservations in the dispatched ring
+	// buffer, that support a single producer at a time.
+	dispatchMu    sync.Mutex
+	dispatchFull  atomic.Bool    // the last reservation failed because the buffer was full
+	slots         sync.WaitGroup // dispatch slots reserved but not committed yet
+	submitted     atomic.Uint64  // tasks sent to the dispatched ring buffer
+	lastDispatch  atomic.Int64   // time of the last dispatch (ns since the epoch)
+	taskPool      sync.Pool      // DispatchedTask objects returned by DispatchTask
+	autoSliceMu   sync.Mutex
+	autoSliceStop chan struct{} // stops the auto-slice goroutine (nil = not running)
+	selectCpu     *bpf.BPFProg
+	selectCpuWarn sync.Once       // warns once about the SelectCPU fallback
+	selectCache   *selectCPUCache // nil = disabled
+	audit         *auditLog       // nil = disabled
+	limits        Limits
+	preemptCpu    *bpf.BPFProg
+	llcDsqProg    *bpf.BPFProg
+	cpuPerf       *bpf.BPFProg
+	cpuPerfCap    *bpf.BPFProg
+	dumpDsq       *bpf.BPFProg
+	dsqDump       *bpf.BPFMap
+	dsqDumpMu     sync.Mutex              // serializes the users of dsqDump
+	llcDsq        [MAX_CPUS]atomic.Uint64 // LLC DSQ of each CPU (0 = not set up)
+	siblingCpu    *bpf.BPFProg
+	qrbs          []*epollRingBuf   // queued ring buffers consumed in EpollMode
+	queuedRbs     []*bpf.RingBuffer // queued ring buffers consumed in PollMode
+	erb           *bpf.RingBuffer
+	exitRb        chan []byte
+	exits         chan TaskExit
+	exitBatches   chan []TaskExit
+	taskEvents    chan TaskEvent
+	exitsDropped  atomic.Uint64 // task exits dropped by consumeTaskExitBatches
+	queuedDropped atomic.Uint64 // queued records dropped by DequeueTask or a queued ring buffer (see dropQueued)
+	crb           *bpf.RingBuffer
+	cpuRb         chan []byte
+	cpuEvents     chan CPUEvent
+	brb           *bpf.RingBuffer
+	bounceRb      chan []byte
+	bounces       chan BounceEvent
+	bounceMap     *bpf.BPFMap
+	cpuReleased   [MAX_CPUS]atomic.Bool // CPUs taken by a higher priority sched_class
+	cpuOnline     [MAX_CPUS]atomic.Bool
+	idleMaskProg  *bpf.BPFProg
+	idleMask      *bpf.BPFMap
+	idleMaskMu    sync.Mutex // serializes the users of idleMask
+	done          chan struct{}
+	cgroups       *bpf.BPFMap
+	taskPrio      *bpf.BPFMap
+	taskTags      *bpf.BPFMap
+	tagStats      *bpf.BPFMap
+	protected     *bpf.BPFMap // processes dispatched directly by the BPF component
+	cpuStats      *bpf.BPFMap
+	cpuSliceEnd   *bpf.BPFMap
+	runningTask   *bpf.BPFMap
+	switchStats   *bpf.BPFMap
+	fastSelect    *bpf.BPFMap
+	opts          LoadSchedOpts
+	objBuf        unsafe.Pointer // BPF object loaded by LoadSchedFromBytes
+	health        healthState
+	phases        phaseState              // cost of the scheduling loop (see SelfUsage)
+	trace         traceState              // tasks sampled by LoadSchedOpts.TraceHook
+	decider       atomic.Pointer[Decider] // decision function of Run (nil = default)
+	libbpfLog     *libbpfLogger           // routes the libbpf messages to the Logger (nil = stderr)
+	rrCursor      atomic.Int32            // next CPU tried by DispatchRoundRobinIdle
+	selfUsage     selfUsageState
+	warnings      []error // non-fatal errors of Start (see Warnings)
+	cpuUtil       cpuUtilState
+	// mu is held for reading by the operations that use the BPF
+	// resources, and for writing by Attach, Detach, ReloadBPF and Close.
+	mu        sync.RWMutex
+	closed    bool
+	reloading bool       // no dispatch slot can be reserved (see ReloadBPF)
+	reloadMu  sync.Mutex // serializes ReloadBPF
+	closeOnce sync.Once
+	closeErr  error      // result of the first Close
+	cgroupsMu sync.Mutex // serializes the updates of the managed cgroups
 }
 
-func init() {
-	unix.Mlockall(syscall.MCL_CURRENT | syscall.MCL_FUTURE)
+// acquire prevents Close from releasing the BPF resources until release is
+// called. It fails with ErrClosed if the scheduler has been closed.
+func (s *Sched) acquire() error {
+	s.mu.RLock()
+	if s.closed {
+		s.mu.RUnlock()
+		return ErrClosed
+	}
+	return nil
+}
+
+func (s *Sched) release() {
+	s.mu.RUnlock()
 }
 
 func LoadSched(objPath string) *Sched {
-	obj := LoadSkel()
+	s, err := LoadSchedWithOpts(objPath, LoadSchedOpts{})
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
+
+// LoadSchedWithOpts opens the BPF object and returns the scheduler configured
+// by opts. The scheduler is loaded into the kernel by Start.
+func LoadSchedWithOpts(objPath string, opts LoadSchedOpts) (*Sched, error) {
+	if err := opts.setDefaults(); err != nil {
+		return nil, err
+	}
+	return loadSched(LoadSkel(), opts)
+}
+
+// LoadSchedFromBytes loads the scheduler from the BPF object obj, instead of
+// the object embedded in the skeleton, e.g. to run a patched BPF component.
+// The object must be built from the same interface (intf.h) and define the
+// same global variables as main.bpf.c.
+func LoadSchedFromBytes(obj []byte) (*Sched, error) {
+	return LoadSchedFromBytesWithOpts(obj, LoadSchedOpts{})
+}
+
+// LoadSchedFromBytesWithOpts is like LoadSchedFromBytes, with the options
+// opts.
+func LoadSchedFromBytesWithOpts(obj []byte, opts LoadSchedOpts) (*Sched, error) {
+	if err := opts.setDefaults(); err != nil {
+		return nil, err
+	}
+	if err := checkBPFObject(obj); err != nil {
+		return nil, err
+	}
+	skel, buf, err := loadSkelFromBytes(obj)
+	if err != nil {
+		return nil, err
+	}
+	s, err := loadSched(skel, opts)
+	if err != nil {
+		// loadSched released the skeleton, so its object no longer
+		// references buf.
+		freeSkelBuf(buf)
+		return nil, err
+	}
+	s.objBuf = buf
+	return s, nil
+}
+
+// checkBPFObject returns an error if obj is not a BPF ELF object.
+func checkBPFObject(obj []byte) error {
+	f, err := elf.NewFile(bytes.NewReader(obj))
+	if err != nil {
+		return fmt.Errorf("invalid BPF object: %w", err)
+	}
+	defer f.Close()
+	if f.Machine != elf.EM_BPF || f.Class != elf.ELFCLASS64 {
+		return fmt.Errorf("invalid BPF object: machine %v, class %v", f.Machine, f.Class)
+	}
+	return nil
+}
+
+func loadSched(obj unsafe.Pointer, opts LoadSchedOpts) (s *Sched, err error) {
+	// On error, release the skeleton of obj: its BPF object is closed with
+	// the module once it replaced the object of the module.
+	objClosed := false
+	defer func() {
+		if err != nil {
+			closeSkel(objClosed)
+		}
+	}()
+	raised := false
+	if !opts.SkipMemlockRlimit && !opts.SkipMemoryLock {
+		// Since 5.11 the BPF maps are charged to the memory cgroup, not
+		// to RLIMIT_MEMLOCK: go on with the current limit, the load or
+		// the memory lock fail later if it is actually too low.
+		if err := RaiseMemlockRlimit(); err != nil {
+			opts.Logger.Printf("warning: %v", err)
+		} else {
+			raised = true
+		}
+	}
+	if !opts.SkipMemoryLock {
+		if err := mlockAll(); err != nil {
+			if !raised {
+				opts.Logger.Printf("can't lock the memory of the scheduler: %v; "+
+					"raise RLIMIT_MEMLOCK (e.g. ulimit -l unlimited, or --ulimit memlock=-1 "+
+					"for a container) or set LoadSchedOpts.SkipMemoryLock", err)
+			} else {
+				opts.Logger.Printf("can't lock the memory of the scheduler: %v; "+
+					"check the memory available to the process (e.g. the memory limit "+
+					"of its cgroup) or set LoadSchedOpts.SkipMemoryLock", err)
+			}
+			return nil, err
+		}
+	}
+	if !opts.SkipABICheck {
+		if err := checkABI(); err != nil {
+			return nil, err
+		}
+	}
+	limits, err := readLimits()
+	if err != nil {
+		return nil, err
+	}
+	libbpfLog := registerLibbpfLog(opts.Logger, opts.LibbpfLogLevel)
 	bpfModule, err := bpf.NewModuleFromFileArgs(bpf.NewModuleArgs{
-		BPFObjPath:     "",
-		KernelLogLevel: 0,
+		// The object of the module is replaced by the one of the
+		// skeleton: its verifier log level is set by
+		// setKernelLogLevel.
+		BPFObjPath: "",
 	})
 	if err != nil {
-		panic(err)
+		unregisterLibbpfLog(libbpfLog)
+		return nil, err
 	}
 	if err := bpfModule.BPFReplaceExistedObject(obj); err != nil {
-		panic(err)
+		bpfModule.Close()
+		unregisterLibbpfLog(libbpfLog)
+		return nil, err
+	}
+	objClosed = true
+	setKernelLogLevel(opts.KernelLogLevel)
+	if err := setupQueuedShards(bpfModule, opts.QueuedShards, opts.PinQueuedConsumers); err != nil {
+		bpfModule.Close()
+		unregisterLibbpfLog(libbpfLog)
+		return nil, err
 	}
 
-	s := &Sched{
-		mod: bpfModule,
+	s = &Sched{
+		mod:       bpfModule,
+		opts:      opts,
+		done:      make(chan struct{}),
+		limits:    limits,
+		libbpfLog: libbpfLog,
+	}
+	if err := s.initOnlineCpus(); err != nil {
+		bpfModule.Close()
+		unregisterLibbpfLog(libbpfLog)
+		return nil, err
+	}
+	s.selfUsage.prev, _ = s.takeSelfSample()
+	if opts.SelectCPUCacheSize > 0 {
+		s.selectCache = newSelectCPUCache(opts.SelectCPUCacheSize, opts.SelectCPUCacheTTL)
+	}
+	if opts.AuditSize > 0 {
+		s.audit = newAuditLog(opts.AuditSize, uint32(opts.AuditSampleEvery))
 	}
 
-	return s
+	return s, nil
+}
+
+// Start loads the BPF object into the kernel, attaches its kprobes and starts
+// consuming its ring buffers. It returns the non-fatal errors, as Warnings:
+// e.g. the fault tracking kprobes that couldn't be attached, unless
+// LoadSchedOpts.RequireFaultTracking makes them fatal.
+func (s *Sched) Start() (warnings []error, err error) {
+	if err := s.mod.BPFLoadObject(); err != nil {
+		return nil, fmt.Errorf("load BPF object: %w", err)
+	}
+	if err := s.bind(); err != nil {
+		return nil, err
+	}
+
+	if s.opts.SelfProtection {
+		if err := s.ProtectSelf(); err != nil {
+			return s.Warnings(), err
+		}
+	}
+	return s.Warnings(), nil
+}
+
+// Warnings returns the non-fatal errors of Start (or of the last ReloadBPF),
+// e.g. the kprobes that couldn't be attached (see ErrKprobeAttach).
+func (s *Sched) Warnings() []error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return append([]error(nil), s.warnings...)
 }
 
-func (s *Sched) Start() {
+// bind attaches the kprobes of the loaded BPF module, starts consuming its
+// ring buffers and looks up the maps and progs used by s. The channels fed by
+// the ring buffers and their consumer goroutines are created only once, so
+// that ReloadBPF can bind a new module to the existing ones.
+func (s *Sched) bind() error {
 	var err error
 	bpfModule := s.mod
-	bpfModule.BPFLoadObject()
+	var kprobeErrs []error
 	iters := bpfModule.Iterator()
 	for {
 		prog := iters.NextProgram()
 		if prog == nil {
 			break
 		}
-		if prog.Name() == "kprobe_handle_mm_fault" {
-			log.Println("attach kprobe_handle_mm_fault")
-			_, err := prog.AttachGeneric()
-			if err != nil {
-				log.Panicf("attach kprobe_handle_mm_fault failed: %v", err)
+		if prog.Name() == "kprobe_handle_mm_fault" || prog.Name() == "kretprobe_handle_mm_fault" {
+			s.opts.Logger.Printf("attach %s", prog.Name())
+			if _, err := prog.AttachGeneric(); err != nil {
+				kprobeErrs = append(kprobeErrs, &ErrKprobeAttach{Prog: prog.Name(), Err: err})
 			}
-			continue
 		}
-		if prog.Name() == "kretprobe_handle_mm_fault" {
-			log.Println("attach kretprobe_handle_mm_fault")
-			_, err := prog.AttachGeneric()
-			if err != nil {
-				log.Panicf("attach kretprobe_handle_mm_fault failed: %v", err)
-			}
-			continue
+	}
+	if len(kprobeErrs) > 0 {
+		if s.opts.RequireFaultTracking {
+			return errors.Join(kprobeErrs...)
+		}
+		for _, err := range kprobeErrs {
+			s.opts.Logger.Printf("%v: fault tracking disabled", err)
 		}
 	}
+	s.warnings = kprobeErrs
 	iters = bpfModule.Iterator()
 	for {
 		m := iters.NextMap()
 		if m == nil {
 			break
 		}
-		fmt.Printf("map: %s, type: %s, fd: %d\n", m.Name(), m.Type().String(), m.FileDescriptor())
+		s.opts.Logger.Printf("map: %s, type: %s, fd: %d", m.Name(), m.Type().String(), m.FileDescriptor())
 		if m.Name() == "main_bpf.bss" {
 			s.bss = &BssMap{m}
+		} else if m.Name() == ".data.tunables" {
+			s.tunables = &BssMap{m}
 		} else if m.Name() == "main_bpf.data" {
 			s.uei = &UeiMap{m}
 		} else if m.Name() == "main_bpf.rodata" {
 			s.rodata = &RodataMap{m}
-		} else if m.Name() == "queued" {
-			s.queue = make(chan []byte, 4096)
-			rb, err := s.mod.InitRingBuf("queued", s.queue)
+		} else if shard, ok := queuedShard(m.Name()); ok {
+			if shard >= s.opts.QueuedShards {
+				continue
+			}
+			if s.queue == nil {
+				s.queue = make(chan []byte, 4096)
+			}
+			if s.opts.QueuedMode == EpollMode {
+				qrb, err := newEpollRingBuf(m, s.queue)
+				if err != nil {
+					return err
+				}
+				qrb.shard = shard
+				qrb.logger = s.opts.Logger
+				qrb.recordSize = queuedTaskSize
+				qrb.dropped = s.dropQueued
+				if s.opts.PinQueuedConsumers {
+					nr, err := nrCpuIds()
+					if err != nil {
+						qrb.release()
+						return err
+					}
+					// No CPU writes into the shard if there are
+					// more shards than CPUs.
+					if cpus := queuedShardCpus(shard, s.opts.QueuedShards, nr); !cpus.Empty() {
+						qrb.cpus = &cpus
+					}
+				}
+				qrb.Start()
+				s.qrbs = append(s.qrbs, qrb)
+				continue
+			}
+			rb, err := s.mod.InitRingBuf(m.Name(), s.queue)
+			if err != nil {
+				return err
+			}
+			rb.Poll(s.opts.QueuedPollMs)
+			s.queuedRbs = append(s.queuedRbs, rb)
+		} else if m.Name() == "managed_cgroups" {
+			s.cgroups = m
+		} else if m.Name() == "task_prio" {
+			s.taskPrio = m
+		} else if m.Name() == "task_tags" {
+			s.taskTags = m
+		} else if m.Name() == "tag_stats" {
+			s.tagStats = m
+		} else if m.Name() == "protected_tgids" {
+			s.protected = m
+		} else if m.Name() == "cpu_stats" {
+			s.cpuStats = m
+		} else if m.Name() == "cpu_slice_end" {
+			s.cpuSliceEnd = m
+		} else if m.Name() == "running_task" {
+			s.runningTask = m
+		} else if m.Name() == "task_switch_stats" {
+			s.switchStats = m
+		} else if m.Name() == "fast_select_tasks" {
+			s.fastSelect = m
+		} else if m.Name() == "dsq_dump" {
+			s.dsqDump = m
+		} else if m.Name() == "idle_cpumask" {
+			s.idleMask = m
+		} else if m.Name() == "bounce_stats" {
+			s.bounceMap = m
+		} else if m.Name() == "bounce_rb" && s.opts.BounceEvents {
+			newConsumer := s.bounceRb == nil
+			if newConsumer {
+				s.bounceRb = make(chan []byte, 4096)
+				s.bounces = make(chan BounceEvent, bounceEventChanSize)
+			}
+			s.brb, err = s.mod.InitRingBuf("bounce_rb", s.bounceRb)
 			if err != nil {
-				panic(err)
+				return err
+			}
+			s.brb.Poll(s.opts.CPUPollMs)
+			enableBounceEvents()
+			if newConsumer {
+				go s.consumeBounceEvents()
 			}
-			rb.Poll(50)
 		} else if m.Name() == "dispatched" {
-			s.dispatch = make(chan []byte, 4096)
-			s.urb, err = s.mod.InitUserRingBuf("dispatched", s.dispatch)
+			s.dispatchRb, err = newDispatchRb(m)
+			if err != nil {
+				return err
+			}
+		} else if m.Name() == "exit_rb" {
+			newConsumer := s.exitRb == nil
+			if newConsumer {
+				s.exitRb = make(chan []byte, 4096)
+				switch {
+				case s.opts.TaskEvents:
+					s.taskEvents = make(chan TaskEvent, taskEventChanSize)
+				case s.opts.BatchedExits:
+					s.exitBatches = make(chan []TaskExit, 1)
+				default:
+					s.exits = make(chan TaskExit, taskExitChanSize)
+				}
+			}
+			s.erb, err = s.mod.InitRingBuf("exit_rb", s.exitRb)
+			if err != nil {
+				return err
+			}
+			s.erb.Poll(s.opts.ExitPollMs)
+			if s.opts.TaskEvents {
+				enableTaskEvents()
+			}
+			if s.selectCache != nil {
+				enableCpumaskEvents()
+			}
+			if newConsumer {
+				switch {
+				case s.opts.TaskEvents:
+					go s.consumeTaskEvents()
+				case s.opts.BatchedExits:
+					go s.consumeTaskExitBatches()
+				default:
+					go s.consumeTaskExits()
+				}
+			}
+		} else if m.Name() == "cpu_rb" {
+			newConsumer := s.cpuRb == nil
+			if newConsumer {
+				s.cpuRb = make(chan []byte, 4096)
+				s.cpuEvents = make(chan CPUEvent, cpuEventChanSize)
+			}
+			s.crb, err = s.mod.InitRingBuf("cpu_rb", s.cpuRb)
 			if err != nil {
-				panic(err)
+				return err
+			}
+			s.crb.Poll(s.opts.CPUPollMs)
+			if newConsumer {
+				go s.consumeCPUEvents()
 			}
-			s.urb.Start()
 		}
 		if m.Type().String() == "BPF_MAP_TYPE_STRUCT_OPS" {
 			s.structOps = m
@@ -131,106 +513,396 @@ func (s *Sched) Start() {
 		if prog.Name() == "do_preempt" {
 			s.preemptCpu = prog
 		}
+
+		if prog.Name() == "setup_llc_dsq" {
+			s.llcDsqProg = prog
+		}
+
+		if prog.Name() == "set_cpu_perf" {
+			s.cpuPerf = prog
+		}
+
+		if prog.Name() == "get_cpu_perf_cap" {
+			s.cpuPerfCap = prog
+		}
+
+		if prog.Name() == "dump_dsq" {
+			s.dumpDsq = prog
+		}
+
+		if prog.Name() == "get_idle_cpumask" {
+			s.idleMaskProg = prog
+		}
 	}
+	return nil
 }
 
-type task_cpu_arg struct {
-	pid   int32
-	cpu   int32
-	flags uint64
+// SelectCPU asks the BPF component for an idle CPU where t can run. It
+// returns RL_CPU_ANY if no idle CPU is available. If the CPU has already
+// been picked in BPF when the task woke up (t.CpuSelected), it is returned
+// without running the prog.
+//
+// If the BPF object doesn't provide the rs_select_cpu program, SelectCPU
+// fails with ErrProgNotFound, unless LoadSchedOpts.SelectCPUFallback is set:
+// in that case it logs a warning the first time, and always returns
+// RL_CPU_ANY.
+func (s *Sched) SelectCPU(t *QueuedTask) (cpu int32, err error) {
+	if start := s.phases.begin(PhaseSelect); !start.IsZero() {
+		defer s.phases.end(PhaseSelect, start)
+	}
+	if s.opts.TraceHook != nil {
+		defer func() {
+			if err == nil {
+				s.traceSelected(t.Pid, cpu)
+			}
+		}()
+	}
+	if t.CpuSelected && t.CanRunOn(int(t.Cpu)) {
+		return t.Cpu, nil
+	}
+	if err := s.acquire(); err != nil {
+		return 0, err
+	}
+	defer s.release()
+	var now time.Time
+	if s.selectCache != nil && s.selectCpu != nil {
+		now = time.Now()
+		if cpu, ok := s.selectCache.get(s, t, now); ok {
+			return cpu, nil
+		}
+	}
+	cpu, err = s.selectCPU(t.Pid, t.Cpu, t.Flags)
+	if err != nil || cpu == RL_CPU_ANY {
+		return cpu, err
+	}
+	// Never suggest a CPU that the task is not allowed to use.
+	if !t.CanRunOn(int(cpu)) {
+		return RL_CPU_ANY, nil
+	}
+	if s.selectCache != nil {
+		s.selectCache.put(t.Pid, cpu, now)
+	}
+	return cpu, nil
 }
 
-var selectFailed error = fmt.Errorf("prog (selectCpu) not found")
+// SelectCPUWith is like SelectCPU for the task pid, as if it was woken up on
+// prevCpu with the enqueue flags flags, without a QueuedTask: e.g. to ask
+// where a task would be placed before it is queued. The result doesn't go
+// through the SelectCPU cache, and it's not checked against the affinity
+// reported with the task, only against the current one of pid.
+func (s *Sched) SelectCPUWith(pid, prevCpu int32, flags uint64) (int32, error) {
+	if err := s.acquire(); err != nil {
+		return 0, err
+	}
+	defer s.release()
+	return s.selectCPU(pid, prevCpu, flags)
+}
 
-func (s *Sched) SelectCPU(t *QueuedTask) (error, int32) {
+// selectCPU runs the rs_select_cpu prog. The caller must hold s.mu.
+func (s *Sched) selectCPU(pid, prevCpu int32, flags uint64) (int32, error) {
 	if s.selectCpu != nil {
-		arg := &task_cpu_arg{
-			pid:   t.Pid,
-			cpu:   t.Cpu,
-			flags: t.Flags,
-		}
-		var data bytes.Buffer
-		binary.Write(&data, binary.LittleEndian, arg)
+		data := encodeTaskCpuArg(pid, prevCpu, flags)
 		opt := bpf.RunOpts{
-			CtxIn:     data.Bytes(),
-			CtxSizeIn: uint32(data.Len()),
+			CtxIn:     data,
+			CtxSizeIn: uint32(len(data)),
 		}
 		err := s.selectCpu.Run(&opt)
 		if err != nil {
-			return err, 0
+			return 0, fmt.Errorf("run rs_select_cpu: %w", err)
 		}
 		if opt.RetVal > 2147483647 {
-			return nil, RL_CPU_ANY
+			return RL_CPU_ANY, nil
 		}
-		return nil, int32(opt.RetVal)
+		return int32(opt.RetVal), nil
 	}
-	return selectFailed, 0
-}
-
-type preempt_arg struct {
-	cpuId int32
-}
-
-type domain_arg struct {
-	lvlId        int32
-	cpuId        int32
-	siblingCpuId int32
+	if s.opts.SelectCPUFallback {
+		s.selectCpuWarn.Do(func() {
+			s.opts.Logger.Printf("rs_select_cpu program not found, SelectCPU always returns RL_CPU_ANY")
+		})
+		return RL_CPU_ANY, nil
+	}
+	return 0, progNotFound("rs_select_cpu")
 }
 
 func (s *Sched) PreemptCpu(cpuId int32) error {
+	if err := checkCPU(cpuId); err != nil {
+		return err
+	}
+	if err := s.acquire(); err != nil {
+		return err
+	}
+	defer s.release()
 	if s.preemptCpu != nil {
-		arg := &preempt_arg{
-			cpuId: cpuId,
-		}
-		var data bytes.Buffer
-		binary.Write(&data, binary.LittleEndian, arg)
+		data := encodePreemptArg(cpuId)
 		opt := bpf.RunOpts{
-			CtxIn:     data.Bytes(),
-			CtxSizeIn: uint32(data.Len()),
+			CtxIn:     data,
+			CtxSizeIn: uint32(len(data)),
 		}
 		err := s.preemptCpu.Run(&opt)
 		if err != nil {
-			return err
+			return fmt.Errorf("run do_preempt: %w", err)
 		}
 		if opt.RetVal != 0 {
-			return fmt.Errorf("retVal: %v", opt.RetVal)
+			return &ErrPreemptCpu{RetVal: int(opt.RetVal)}
 		}
 		return nil
 	}
-	return fmt.Errorf("prog (selectCpu) not found")
+	return progNotFound("do_preempt")
 }
 
+// EnableSiblingCpu adds siblingCpuId to the scheduling domain of cpuId at
+// cache level lvlId (2 or 3). Both CPUs must be possible CPUs of the system.
+// Adding the same sibling twice has no effect.
 func (s *Sched) EnableSiblingCpu(lvlId, cpuId, siblingCpuId int32) error {
+	if err := checkSiblingPair(SiblingPair{Level: lvlId, Cpu: cpuId, Sibling: siblingCpuId}); err != nil {
+		return err
+	}
+	if err := s.acquire(); err != nil {
+		return err
+	}
+	defer s.release()
 	if s.siblingCpu != nil {
-		arg := &domain_arg{
-			lvlId:        lvlId,
-			cpuId:        cpuId,
-			siblingCpuId: siblingCpuId,
-		}
-		var data bytes.Buffer
-		binary.Write(&data, binary.LittleEndian, arg)
+		data := encodeDomainArg(lvlId, cpuId, siblingCpuId)
 		opt := bpf.RunOpts{
-			CtxIn:     data.Bytes(),
-			CtxSizeIn: uint32(data.Len()),
+			CtxIn:     data,
+			CtxSizeIn: uint32(len(data)),
 		}
 		err := s.siblingCpu.Run(&opt)
 		if err != nil {
-			return err
+			return fmt.Errorf("run enable_sibling_cpu: %w", err)
 		}
-		if opt.RetVal != 0 {
-			return fmt.Errorf("retVal: %v", opt.RetVal)
+		return siblingCpuResult(lvlId, cpuId, siblingCpuId, opt.RetVal)
+	}
+	return progNotFound("enable_sibling_cpu")
+}
+
+// siblingCpuResult decodes the value returned by the enable_sibling_cpu
+// program: a negative errno on failure; positive values are informational.
+func siblingCpuResult(lvlId, cpuId, siblingCpuId int32, retVal uint32) error {
+	if ret := int32(retVal); ret < 0 {
+		return &ErrSiblingCpu{
+			Level:   lvlId,
+			Cpu:     cpuId,
+			Sibling: siblingCpuId,
+			RetVal:  int(ret),
 		}
-		return nil
 	}
-	return fmt.Errorf("prog (siblingCpu) not found")
+	return nil
 }
 
+// Attach attaches the struct_ops to the kernel, so that the scheduler starts
+// scheduling tasks. It returns ErrAlreadyAttached if it is already attached,
+// and ErrSchedulerBusy if another sched_ext scheduler is attached (after
+// waiting for LoadSchedOpts.TakeoverTimeout).
 func (s *Sched) Attach() error {
-	_, err := s.structOps.AttachStructOps()
+	if s.AttachedAt().IsZero() {
+		// Fail early with a meaningful error if another scheduler is
+		// attached, or wait for it to exit.
+		if err := s.waitSchedulerInactive(s.opts.TakeoverTimeout); err != nil {
+			return err
+		}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return ErrClosed
+	}
+	if s.link != nil {
+		return ErrAlreadyAttached
+	}
+	if s.structOps == nil {
+		return mapNotFound("struct_ops")
+	}
+	if err := s.checkLimits(); err != nil {
+		return err
+	}
+	link, err := s.structOps.AttachStructOps()
+	if err != nil {
+		if active, name, _ := SchedulerActive(); active {
+			return &ErrSchedulerBusy{Current: name}
+		}
+		return fmt.Errorf("attach struct_ops: %w", err)
+	}
+	s.link = link
+	s.attachedAt = time.Now()
+	return nil
+}
+
+// Detach detaches the struct_ops from the kernel, so that all the tasks are
+// moved back to the default scheduler. It is a no-op if the scheduler is not
+// attached.
+//
+// The dispatches committed before the call are flushed first (see Flush), so
+// that the last decisions of the user-space scheduler are applied; the
+// scheduler is detached even if the flush fails.
+func (s *Sched) Detach() error {
+	if err := s.Flush(); err != nil && !errors.Is(err, ErrClosed) {
+		s.opts.Logger.Printf("detach: %v", err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.link == nil {
+		return nil
+	}
+	err := s.link.Destroy()
+	s.link = nil
+	s.attachedAt = time.Time{}
 	return err
 }
 
-func (s *Sched) Close() {
-	s.urb.Close()
-	s.mod.Close()
+// AttachedAt returns when the scheduler has been attached, or the zero time
+// if it is not attached.
+func (s *Sched) AttachedAt() time.Time {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.attachedAt
+}
+
+// Map returns the map called name of the BPF object, e.g. to access the maps
+// added to the BPF component by a downstream fork.
+//
+// Mutating the maps used by this package through this escape hatch is at
+// your own risk: it can break the assumptions of the BPF component and of
+// Sched.
+func (s *Sched) Map(name string) (*bpf.BPFMap, error) {
+	if err := s.acquire(); err != nil {
+		return nil, err
+	}
+	defer s.release()
+	m, err := s.mod.GetMap(name)
+	if err != nil || m == nil {
+		return nil, mapNotFound(name)
+	}
+	return m, nil
+}
+
+// Prog returns the program called name of the BPF object, with the same
+// caveats as Map.
+func (s *Sched) Prog(name string) (*bpf.BPFProg, error) {
+	if err := s.acquire(); err != nil {
+		return nil, err
+	}
+	defer s.release()
+	prog, err := s.mod.GetProgram(name)
+	if err != nil || prog == nil {
+		return nil, progNotFound(name)
+	}
+	return prog, nil
+}
+
+// Maps returns the names of the maps of the BPF object.
+func (s *Sched) Maps() ([]string, error) {
+	if err := s.acquire(); err != nil {
+		return nil, err
+	}
+	defer s.release()
+	var names []string
+	iters := s.mod.Iterator()
+	for m := iters.NextMap(); m != nil; m = iters.NextMap() {
+		names = append(names, m.Name())
+	}
+	return names, nil
+}
+
+// Progs returns the names of the programs of the BPF object.
+func (s *Sched) Progs() ([]string, error) {
+	if err := s.acquire(); err != nil {
+		return nil, err
+	}
+	defer s.release()
+	var names []string
+	iters := s.mod.Iterator()
+	for prog := iters.NextProgram(); prog != nil; prog = iters.NextProgram() {
+		names = append(names, prog.Name())
+	}
+	return names, nil
+}
+
+// Uptime returns for how long the scheduler has been attached, or 0 if it is
+// not attached.
+func (s *Sched) Uptime() time.Duration {
+	attachedAt := s.AttachedAt()
+	if attachedAt.IsZero() {
+		return 0
+	}
+	return time.Since(attachedAt)
+}
+
+// Close releases the BPF resources, after waiting for the operations in
+// flight to complete. It can be called on a partially initialized Sched
+// (e.g. if Start was not called), and it returns the errors of all the
+// resources that failed to be released.
+//
+// The resources are released in order: the consumers of the ring buffers are
+// stopped first, then the struct_ops is detached (without flushing the
+// pending dispatches, see Detach) and finally the BPF module is closed, so
+// that no callback runs on released state. Close is idempotent: the later
+// calls wait for the first one to complete and return its result.
+func (s *Sched) Close() error {
+	if s == nil {
+		return nil
+	}
+	s.closeOnce.Do(func() {
+		s.closeErr = s.close()
+	})
+	return s.closeErr
+}
+
+func (s *Sched) close() error {
+	// Wake up the goroutines blocked in DispatchTask before waiting for
+	// them.
+	if s.done != nil {
+		close(s.done)
+	}
+	s.mu.Lock()
+	s.closed = true
+	s.mu.Unlock()
+	// The reserved dispatch slots point into the dispatched ring buffer:
+	// wait for them to be committed or discarded before releasing it. No
+	// slot can be reserved anymore.
+	s.slots.Wait()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// libbpf can log while the module is released.
+	defer unregisterLibbpfLog(s.libbpfLog)
+	return s.closeBPF()
+}
+
+// closeBPF stops the ring buffers, detaches the struct_ops and releases the
+// BPF module of s.
+func (s *Sched) closeBPF() error {
+	var errs []error
+	for _, qrb := range s.qrbs {
+		if err := qrb.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("close queued ring buffer: %w", err))
+		}
+	}
+	for _, rb := range s.queuedRbs {
+		rb.Close()
+	}
+	if s.crb != nil {
+		s.crb.Close()
+	}
+	if s.brb != nil {
+		s.brb.Close()
+	}
+	if s.erb != nil {
+		s.erb.Close()
+	}
+	if s.link != nil {
+		if err := s.link.Destroy(); err != nil {
+			errs = append(errs, fmt.Errorf("detach struct_ops: %w", err))
+		}
+		s.link = nil
+		s.attachedAt = time.Time{}
+	}
+	freeDispatchRb(s.dispatchRb)
+	if s.mod != nil {
+		s.mod.Close()
+		// The skeleton points into the closed object: release it, so
+		// that the package-level accessors see no scheduler.
+		closeSkel(true)
+	}
+	freeSkelBuf(s.objBuf)
+	return errors.Join(errs...)
 }
@@ -0,0 +1,228 @@
+package core
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+const (
+	defaultQueuedPollMs = 50
+	defaultExitPollMs   = 300
+	defaultCPUPollMs    = 300
+)
+
+// QueuedMode selects how the queued ring buffer is consumed.
+type QueuedMode int
+
+const (
+	// PollMode consumes the queued ring buffer through libbpf, polling it
+	// with a timeout of LoadSchedOpts.QueuedPollMs.
+	PollMode QueuedMode = iota
+	// EpollMode consumes the queued ring buffer directly from its memory
+	// mapping and blocks on the map fd without any timeout: the consumer
+	// is woken up only when the kernel submits new tasks, so it doesn't
+	// spin while the system is idle.
+	EpollMode
+)
+
+// LoadSchedOpts configures the scheduler loaded by LoadSchedWithOpts. The
+// zero value selects the default of every option.
+type LoadSchedOpts struct {
+	// QueuedPollMs is the timeout (in ms) of each poll of the queued ring
+	// buffer (0 = 50ms). The consumer is woken up as soon as records are
+	// available, the timeout bounds how long it sleeps when the ring buffer
+	// stays empty.
+	QueuedPollMs int
+	// QueuedMode selects how the queued ring buffer is consumed (default
+	// PollMode).
+	QueuedMode QueuedMode
+	// QueuedShards is the number of ring buffers used to receive the
+	// queued tasks, 1..MaxQueuedShards (0 = 1). Tasks are sharded by pid,
+	// each ring buffer is drained by its own consumer and all of them feed
+	// DequeueTask: the tasks of different shards can be interleaved, but
+	// the events of a given task are always received in order (unless
+	// PinQueuedConsumers is set).
+	QueuedShards int
+	// PinQueuedConsumers shards the queued tasks by the CPU that queues
+	// them instead of by pid, splitting the CPUs in QueuedShards contiguous
+	// ranges, and pins the consumer of each queued ring buffer to an OS
+	// thread that only runs on the CPUs of its range, so that the ring
+	// buffers stay cache-hot. The events of a task are not received in
+	// order anymore if it migrates while it is queued. Only supported in
+	// EpollMode: the PollMode consumers are run by libbpf. If the affinity
+	// can't be set, e.g. because the CPUs are outside the cpuset of a
+	// container, the failure is reported to the Logger and the consumer
+	// runs unpinned. By default the consumers are not pinned (see
+	// Sched.QueuedConsumerStats).
+	PinQueuedConsumers bool
+	// ExitPollMs is the timeout (in ms) of each poll of the exit_rb ring
+	// buffer (0 = 300ms).
+	ExitPollMs int
+	// BatchedExits delivers the task exits in batches, on the channel
+	// returned by Sched.ExitEventsBatched, instead of one by one on the
+	// channel returned by Sched.TaskExits: the exits received while the
+	// reader is busy are coalesced in the next batch, so that exit_rb keeps
+	// being drained during a fork/exit storm.
+	BatchedExits bool
+	// TaskEvents delivers the lifecycle events of the tasks (enabled,
+	// disabled, affinity changed and exited) on the channel returned by
+	// Sched.TaskEvents, instead of the task exits on Sched.TaskExits. It
+	// can't be combined with BatchedExits.
+	TaskEvents bool
+	// CPUPollMs is the timeout (in ms) of each poll of the cpu_rb ring
+	// buffer (0 = 300ms).
+	CPUPollMs int
+	// BounceEvents delivers every dispatch that the BPF component couldn't
+	// honor on the channel returned by Sched.BounceEvents (bounce_rb is
+	// polled every CPUPollMs). The bounces are always counted in
+	// DispatchStats.Bounces.
+	BounceEvents bool
+	// Logger receives the diagnostic messages of the scheduler (default:
+	// discard them).
+	Logger Logger
+	// LibbpfLogLevel routes the messages of libbpf up to this level to the
+	// Logger, prefixed with their severity, instead of printing them on
+	// the standard error (the default, LibbpfLogStderr). The messages that
+	// are expected when loading the scheduler are dropped. libbpf has a
+	// single output per process: the last loaded scheduler that sets it
+	// receives the messages until it is closed, then the previous one
+	// gets them back.
+	LibbpfLogLevel LibbpfLogLevel
+	// KernelLogLevel is the log level of the verifier for the programs of
+	// the BPF object, a combination of BPF_LOG_LEVEL1 (1), BPF_LOG_LEVEL2
+	// (2) and BPF_LOG_STATS (4). With 0 (the default), the verifier log is
+	// only printed by libbpf when a program fails to load.
+	KernelLogLevel uint32
+	// SkipABICheck loads the BPF object even if the layout of its records
+	// doesn't match the one expected by this package (see ErrABIMismatch).
+	// Only useful to run a BPF object that is known to be compatible.
+	SkipABICheck bool
+	// IgnoreCPULimit attaches the scheduler even if the system has more
+	// possible CPUs than the BPF object supports (see Sched.Limits); the
+	// mismatch is only reported to the Logger. By default Attach fails
+	// with ErrCPULimit.
+	IgnoreCPULimit bool
+	// SelfProtection makes Start call ProtectSelf, so that the threads of
+	// this process are never scheduled by the user-space scheduler.
+	SelfProtection bool
+	// SelectCPUFallback makes SelectCPU return RL_CPU_ANY, instead of
+	// failing with ErrProgNotFound, if the BPF object doesn't provide the
+	// rs_select_cpu program.
+	SelectCPUFallback bool
+	// TakeoverTimeout is how long Attach waits for another sched_ext
+	// scheduler to exit before attaching (0 = fail immediately with
+	// ErrSchedulerBusy).
+	TakeoverTimeout time.Duration
+	// SkipMemoryLock doesn't raise RLIMIT_MEMLOCK and doesn't lock the
+	// memory of the process when the scheduler is loaded. The memory is
+	// locked by default, so that the scheduler is never stalled by page
+	// faults.
+	SkipMemoryLock bool
+	// SkipMemlockRlimit doesn't raise RLIMIT_MEMLOCK when the scheduler is
+	// loaded (see RaiseMemlockRlimit), e.g. if the limit is managed by the
+	// service manager, but still locks the memory unless SkipMemoryLock is
+	// set. Locking the memory may fail then.
+	SkipMemlockRlimit bool
+	// RequireFaultTracking makes Start fail if the kprobes tracking the
+	// page faults (handle_mm_fault) can't be attached. By default the
+	// scheduler runs without fault tracking, and the failures are
+	// reported by Sched.Warnings.
+	RequireFaultTracking bool
+	// SelectCPUCacheSize, if not 0, makes SelectCPU cache the CPU picked
+	// for up to SelectCPUCacheSize pids, and answer from the cache instead
+	// of running the rs_select_cpu prog again. A cached CPU is dropped when
+	// it's older than SelectCPUCacheTTL, when the task can't run there
+	// anymore, when the CPU is taken by a higher priority sched_class and
+	// when the task exits.
+	//
+	// It trades accuracy for speed: a cached CPU was idle when it was
+	// picked, but it may be busy by the time it is returned again. Only
+	// enable it for tasks that wake up often and keep landing on the same
+	// CPU (see Sched.SelectCPUCacheStats).
+	SelectCPUCacheSize int
+	// SelectCPUCacheTTL is the max age of a CPU cached by SelectCPU (0 =
+	// 10ms).
+	SelectCPUCacheTTL time.Duration
+	// TraceHook, if set, receives the stages of the scheduling of the
+	// tasks it samples, from their enqueue to when they run (see
+	// TraceHook). Unsampled tasks only cost a call to Sample.
+	TraceHook TraceHook
+	// AuditSize, if not 0, enables the audit log: a ring of the last
+	// AuditSize dispatches of the sampled tasks, with the CPU, the slice,
+	// the vtime and the queue latency (see Sched.SnapshotAudit). It is
+	// meant to diagnose the fairness of a policy; it is disabled by
+	// default and costs nothing then.
+	AuditSize int
+	// AuditSampleEvery records one task out of every AuditSampleEvery
+	// dequeued in the audit log (0 = 1, all of them). It can be changed at
+	// runtime with Sched.SetAuditSampling.
+	AuditSampleEvery int
+}
+
+func (opts *LoadSchedOpts) setDefaults() error {
+	if opts.QueuedPollMs < 0 {
+		return fmt.Errorf("invalid QueuedPollMs: %d", opts.QueuedPollMs)
+	}
+	if opts.ExitPollMs < 0 {
+		return fmt.Errorf("invalid ExitPollMs: %d", opts.ExitPollMs)
+	}
+	if opts.QueuedMode != PollMode && opts.QueuedMode != EpollMode {
+		return fmt.Errorf("invalid QueuedMode: %d", opts.QueuedMode)
+	}
+	if opts.QueuedShards < 0 || opts.QueuedShards > MaxQueuedShards {
+		return fmt.Errorf("invalid QueuedShards: %d", opts.QueuedShards)
+	}
+	if opts.TaskEvents && opts.BatchedExits {
+		return fmt.Errorf("TaskEvents can't be combined with BatchedExits")
+	}
+	if opts.PinQueuedConsumers && opts.QueuedMode != EpollMode {
+		return fmt.Errorf("PinQueuedConsumers requires EpollMode")
+	}
+	if opts.TakeoverTimeout < 0 {
+		return fmt.Errorf("invalid TakeoverTimeout: %v", opts.TakeoverTimeout)
+	}
+	if opts.CPUPollMs < 0 {
+		return fmt.Errorf("invalid CPUPollMs: %d", opts.CPUPollMs)
+	}
+	if opts.SelectCPUCacheSize < 0 {
+		return fmt.Errorf("invalid SelectCPUCacheSize: %d", opts.SelectCPUCacheSize)
+	}
+	if opts.SelectCPUCacheTTL < 0 {
+		return fmt.Errorf("invalid SelectCPUCacheTTL: %v", opts.SelectCPUCacheTTL)
+	}
+	if opts.AuditSize < 0 {
+		return fmt.Errorf("invalid AuditSize: %d", opts.AuditSize)
+	}
+	if opts.AuditSampleEvery < 0 || uint64(opts.AuditSampleEvery) > math.MaxUint32 {
+		return fmt.Errorf("invalid AuditSampleEvery: %d", opts.AuditSampleEvery)
+	}
+	if opts.LibbpfLogLevel < LibbpfLogStderr || opts.LibbpfLogLevel > LibbpfLogDebug {
+		return fmt.Errorf("invalid LibbpfLogLevel: %d", opts.LibbpfLogLevel)
+	}
+	if opts.KernelLogLevel&^7 != 0 {
+		return fmt.Errorf("invalid KernelLogLevel: %d", opts.KernelLogLevel)
+	}
+	if opts.QueuedPollMs == 0 {
+		opts.QueuedPollMs = defaultQueuedPollMs
+	}
+	if opts.QueuedShards == 0 {
+		opts.QueuedShards = 1
+	}
+	if opts.ExitPollMs == 0 {
+		opts.ExitPollMs = defaultExitPollMs
+	}
+	if opts.CPUPollMs == 0 {
+		opts.CPUPollMs = defaultCPUPollMs
+	}
+	if opts.SelectCPUCacheTTL == 0 {
+		opts.SelectCPUCacheTTL = defaultSelectCPUCacheTTL
+	}
+	if opts.AuditSampleEvery == 0 {
+		opts.AuditSampleEvery = 1
+	}
+	if opts.Logger == nil {
+		opts.Logger = nopLogger{}
+	}
+	return nil
+}
@@ -0,0 +1,380 @@
+// Package otlptrace exports the scheduling of the tasks traced through
+// core.TraceHook to an OpenTelemetry collector.
+package otlptrace
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	core "github.com/Gthulhu/scx_goland_core/goland_core"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	defaultOTLPSampleRate  = 0.001
+	defaultOTLPServiceName = "scx_goland"
+	defaultOTLPInterval    = 5 * time.Second
+	defaultOTLPMaxSpans    = 4096
+	// otlpPendingTimeout is how long a span waits for the task to run
+	// before it's dropped, e.g. because the task exited.
+	otlpPendingTimeout = 10 * time.Second
+)
+
+// TracerOpts configures a Tracer. The zero value selects the default
+// of every option, except Endpoint.
+type TracerOpts struct {
+	// Endpoint is the URL of the OTLP/HTTP traces endpoint of the
+	// collector, e.g. "http://localhost:4318/v1/traces".
+	Endpoint string
+	// SampleRate is the fraction of the scheduling decisions traced, in
+	// (0, 1] (0 = 0.001).
+	SampleRate float64
+	// ServiceName is the service.name of the spans (default "scx_goland").
+	ServiceName string
+	// Interval is how often the spans are exported by Run (0 = 5s).
+	Interval time.Duration
+	// MaxSpans is the max number of spans buffered, finished or not (0 =
+	// 4096). The tasks sampled beyond it are not traced.
+	MaxSpans int
+	// Client sends the spans (default: http.DefaultClient).
+	Client *http.Client
+	// Logger receives the diagnostic messages of the tracer (default:
+	// discard them).
+	Logger core.Logger
+}
+
+// schedEvent is a stage of a traced task.
+type schedEvent struct {
+	name string
+	ts   uint64 // CLOCK_MONOTONIC ns
+	cpu  int32
+}
+
+// schedSpan is the scheduling of a task, from its enqueue to when it runs.
+type schedSpan struct {
+	traceID [16]byte
+	spanID  [8]byte
+	pid     int32
+	tgid    int32
+	comm    string
+	start   uint64
+	end     uint64
+	created time.Time
+	events  []schedEvent
+}
+
+// Tracer is a core.TraceHook that exports a span per sampled scheduling
+// decision to an OpenTelemetry collector, using OTLP/HTTP with the JSON
+// encoding. Each span goes from the enqueue of the task to when it starts
+// running, with an event for each stage in between.
+//
+// Spans are buffered and exported by Run (or Flush): the scheduling loop
+// never waits for the collector.
+type Tracer struct {
+	opts      TracerOpts
+	threshold uint64 // a task is sampled if a random uint64 is below it
+	offset    int64  // offset from CLOCK_MONOTONIC to the Unix time (ns)
+	mu        sync.Mutex
+	pending   map[int32]*schedSpan // sampled tasks that didn't run yet
+	done      []*schedSpan         // finished spans, not exported yet
+	dropped   atomic.Uint64
+}
+
+// NewTracer returns a Tracer configured by opts, to be set as
+// core.LoadSchedOpts.TraceHook.
+func NewTracer(opts TracerOpts) (*Tracer, error) {
+	if opts.Endpoint == "" {
+		return nil, fmt.Errorf("invalid TracerOpts: missing Endpoint")
+	}
+	if opts.SampleRate < 0 || opts.SampleRate > 1 || opts.Interval < 0 || opts.MaxSpans < 0 {
+		return nil, fmt.Errorf("invalid TracerOpts: %+v", opts)
+	}
+	if opts.SampleRate == 0 {
+		opts.SampleRate = defaultOTLPSampleRate
+	}
+	if opts.ServiceName == "" {
+		opts.ServiceName = defaultOTLPServiceName
+	}
+	if opts.Interval == 0 {
+		opts.Interval = defaultOTLPInterval
+	}
+	if opts.MaxSpans == 0 {
+		opts.MaxSpans = defaultOTLPMaxSpans
+	}
+	if opts.Client == nil {
+		opts.Client = http.DefaultClient
+	}
+	if opts.Logger == nil {
+		opts.Logger = nopLogger{}
+	}
+	threshold := uint64(math.MaxUint64)
+	if opts.SampleRate < 1 {
+		threshold = uint64(opts.SampleRate * math.MaxUint64)
+	}
+	return &Tracer{
+		opts:      opts,
+		threshold: threshold,
+		offset:    time.Now().UnixNano() - int64(monotonicNow()),
+		pending:   make(map[int32]*schedSpan),
+	}, nil
+}
+
+// Sample implements core.TraceHook.
+func (o *Tracer) Sample(t *core.QueuedTask) bool {
+	if rand.Uint64() >= o.threshold {
+		return false
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if len(o.pending)+len(o.done) >= o.opts.MaxSpans {
+		o.dropped.Add(1)
+		return false
+	}
+	span := &schedSpan{pid: t.Pid, tgid: t.Tgid, comm: t.Comm, created: time.Now()}
+	binary.NativeEndian.PutUint64(span.traceID[:8], rand.Uint64())
+	binary.NativeEndian.PutUint64(span.traceID[8:], rand.Uint64()|1)
+	binary.NativeEndian.PutUint64(span.spanID[:], rand.Uint64()|1)
+	o.pending[t.Pid] = span
+	return true
+}
+
+var _ core.TraceHook = (*Tracer)(nil)
+
+// event adds a stage to the span of pid.
+func (o *Tracer) event(pid int32, name string, cpu int32, ts uint64) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if span, ok := o.pending[pid]; ok {
+		span.events = append(span.events, schedEvent{name: name, ts: ts, cpu: cpu})
+	}
+}
+
+// Queued implements core.TraceHook.
+func (o *Tracer) Queued(pid int32, ts uint64) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if span, ok := o.pending[pid]; ok {
+		span.start = ts
+	}
+}
+
+// Dequeued implements core.TraceHook.
+func (o *Tracer) Dequeued(pid int32, ts uint64) {
+	o.event(pid, "dequeued", -1, ts)
+}
+
+// Selected implements core.TraceHook.
+func (o *Tracer) Selected(pid int32, cpu int32, ts uint64) {
+	o.event(pid, "selected", cpu, ts)
+}
+
+// Dispatched implements core.TraceHook.
+func (o *Tracer) Dispatched(pid int32, cpu int32, ts uint64) {
+	o.event(pid, "dispatched", cpu, ts)
+}
+
+// Running implements core.TraceHook. The span ends when the task runs.
+func (o *Tracer) Running(pid int32, cpu int32, ts uint64) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	span, ok := o.pending[pid]
+	if !ok {
+		return
+	}
+	delete(o.pending, pid)
+	span.end = span.start
+	if n := len(span.events); n > 0 {
+		span.end = span.events[n-1].ts
+	}
+	// The task may have been queued again without running (e.g.
+	// re-enqueued by the kernel): RunningTs is then an older run.
+	if ts >= span.end {
+		span.events = append(span.events, schedEvent{name: "running", ts: ts, cpu: cpu})
+		span.end = ts
+	}
+	o.done = append(o.done, span)
+}
+
+// Dropped returns the number of sampled tasks not traced because MaxSpans
+// spans were buffered.
+func (o *Tracer) Dropped() uint64 {
+	return o.dropped.Load()
+}
+
+// Run exports the spans every Interval until ctx is done, then exports the
+// remaining ones.
+func (o *Tracer) Run(ctx context.Context) error {
+	ticker := time.NewTicker(o.opts.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := o.Flush(ctx); err != nil {
+				o.opts.Logger.Printf("OTLP tracer: %v", err)
+			}
+		case <-ctx.Done():
+			flushCtx, cancel := context.WithTimeout(context.Background(), o.opts.Interval)
+			defer cancel()
+			if err := o.Flush(flushCtx); err != nil {
+				o.opts.Logger.Printf("OTLP tracer: %v", err)
+			}
+			return ctx.Err()
+		}
+	}
+}
+
+// Flush exports the finished spans. The spans of the tasks that didn't run
+// for 10s are dropped. The spans are lost if the export fails.
+func (o *Tracer) Flush(ctx context.Context) error {
+	o.mu.Lock()
+	spans := o.done
+	o.done = nil
+	now := time.Now()
+	for pid, span := range o.pending {
+		if now.Sub(span.created) > otlpPendingTimeout {
+			delete(o.pending, pid)
+		}
+	}
+	o.mu.Unlock()
+	if len(spans) == 0 {
+		return nil
+	}
+	body, err := json.Marshal(o.encode(spans))
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, o.opts.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := o.opts.Client.Do(req)
+	if err != nil {
+		return fmt.Errorf("export %d spans: %w", len(spans), err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("export %d spans: %s", len(spans), resp.Status)
+	}
+	return nil
+}
+
+// The OTLP/JSON encoding of the spans (see
+// opentelemetry-proto/opentelemetry/proto/trace/v1/trace.proto): 64-bit
+// integers are strings, ids are hex strings.
+type (
+	otlpValue struct {
+		StringValue *string `json:"stringValue,omitempty"`
+		IntValue    *string `json:"intValue,omitempty"`
+	}
+	otlpAttr struct {
+		Key   string    `json:"key"`
+		Value otlpValue `json:"value"`
+	}
+	otlpEvent struct {
+		TimeUnixNano string     `json:"timeUnixNano"`
+		Name         string     `json:"name"`
+		Attributes   []otlpAttr `json:"attributes,omitempty"`
+	}
+	otlpSpan struct {
+		TraceID           string      `json:"traceId"`
+		SpanID            string      `json:"spanId"`
+		Name              string      `json:"name"`
+		Kind              int         `json:"kind"`
+		StartTimeUnixNano string      `json:"startTimeUnixNano"`
+		EndTimeUnixNano   string      `json:"endTimeUnixNano"`
+		Attributes        []otlpAttr  `json:"attributes"`
+		Events            []otlpEvent `json:"events"`
+	}
+	otlpScopeSpans struct {
+		Scope struct {
+			Name string `json:"name"`
+		} `json:"scope"`
+		Spans []otlpSpan `json:"spans"`
+	}
+	otlpResourceSpans struct {
+		Resource struct {
+			Attributes []otlpAttr `json:"attributes"`
+		} `json:"resource"`
+		ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+	}
+	otlpTraces struct {
+		ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+	}
+)
+
+const otlpSpanKindInternal = 1
+
+func otlpString(key, v string) otlpAttr {
+	return otlpAttr{Key: key, Value: otlpValue{StringValue: &v}}
+}
+
+func otlpInt(key string, v int64) otlpAttr {
+	s := strconv.FormatInt(v, 10)
+	return otlpAttr{Key: key, Value: otlpValue{IntValue: &s}}
+}
+
+// unixNano converts a CLOCK_MONOTONIC timestamp to a Unix time.
+func (o *Tracer) unixNano(ts uint64) string {
+	return strconv.FormatInt(int64(ts)+o.offset, 10)
+}
+
+func (o *Tracer) encode(spans []*schedSpan) otlpTraces {
+	scope := otlpScopeSpans{Spans: make([]otlpSpan, 0, len(spans))}
+	scope.Scope.Name = "github.com/Gthulhu/scx_goland_core"
+	for _, span := range spans {
+		s := otlpSpan{
+			TraceID:           hex.EncodeToString(span.traceID[:]),
+			SpanID:            hex.EncodeToString(span.spanID[:]),
+			Name:              "sched",
+			Kind:              otlpSpanKindInternal,
+			StartTimeUnixNano: o.unixNano(span.start),
+			EndTimeUnixNano:   o.unixNano(span.end),
+			Attributes: []otlpAttr{
+				otlpInt("pid", int64(span.pid)),
+				otlpInt("tgid", int64(span.tgid)),
+				otlpString("comm", span.comm),
+			},
+			Events: make([]otlpEvent, 0, len(span.events)),
+		}
+		for _, ev := range span.events {
+			e := otlpEvent{TimeUnixNano: o.unixNano(ev.ts), Name: ev.name}
+			// No attribute for RL_CPU_ANY.
+			if ev.cpu >= 0 && ev.cpu < core.MAX_CPUS {
+				e.Attributes = []otlpAttr{otlpInt("cpu", int64(ev.cpu))}
+			}
+			s.Events = append(s.Events, e)
+		}
+		scope.Spans = append(scope.Spans, s)
+	}
+	rs := otlpResourceSpans{ScopeSpans: []otlpScopeSpans{scope}}
+	rs.Resource.Attributes = []otlpAttr{otlpString("service.name", o.opts.ServiceName)}
+	return otlpTraces{ResourceSpans: []otlpResourceSpans{rs}}
+}
+
+// monotonicNow returns the current CLOCK_MONOTONIC time, the clock of the
+// timestamps of core.TraceHook.
+func monotonicNow() uint64 {
+	var ts unix.Timespec
+	if err := unix.ClockGettime(unix.CLOCK_MONOTONIC, &ts); err != nil {
+		return 0
+	}
+	return uint64(ts.Nano())
+}
+
+type nopLogger struct{}
+
+func (nopLogger) Printf(string, ...any) {}
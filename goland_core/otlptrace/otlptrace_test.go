@@ -0,0 +1,100 @@
+package otlptrace
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	core "github.com/Gthulhu/scx_goland_core/goland_core"
+)
+
+func TestTracerSpan(t *testing.T) {
+	var body []byte
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ = io.ReadAll(r.Body)
+	}))
+	defer srv.Close()
+	o, err := NewTracer(TracerOpts{Endpoint: srv.URL, SampleRate: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	o.offset = 0
+
+	if !o.Sample(&core.QueuedTask{Pid: 10, Tgid: 9, Comm: "worker"}) {
+		t.Fatalf("task not sampled with SampleRate 1")
+	}
+	o.Queued(10, 100)
+	o.Dequeued(10, 110)
+	o.Selected(10, core.RL_CPU_ANY, 120)
+	o.Dispatched(10, 3, 130)
+	o.Running(10, 3, 150)
+	if err := o.Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	var traces otlpTraces
+	if err := json.Unmarshal(body, &traces); err != nil {
+		t.Fatal(err)
+	}
+	spans := traces.ResourceSpans[0].ScopeSpans[0].Spans
+	if len(spans) != 1 {
+		t.Fatalf("got %d spans, want 1", len(spans))
+	}
+	span := spans[0]
+	if span.StartTimeUnixNano != "100" || span.EndTimeUnixNano != "150" {
+		t.Fatalf("span from %s to %s, want 100 to 150", span.StartTimeUnixNano, span.EndTimeUnixNano)
+	}
+	var events []string
+	for _, ev := range span.Events {
+		cpu := "-"
+		if len(ev.Attributes) > 0 {
+			cpu = *ev.Attributes[0].Value.IntValue
+		}
+		events = append(events, fmt.Sprintf("%s@%s cpu=%s", ev.Name, ev.TimeUnixNano, cpu))
+	}
+	want := []string{
+		"dequeued@110 cpu=-",
+		"selected@120 cpu=-",
+		"dispatched@130 cpu=3",
+		"running@150 cpu=3",
+	}
+	if fmt.Sprint(events) != fmt.Sprint(want) {
+		t.Fatalf("got events %q, want %q", events, want)
+	}
+}
+
+func TestTracerStaleRunning(t *testing.T) {
+	o, err := NewTracer(TracerOpts{Endpoint: "http://localhost", SampleRate: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	o.Sample(&core.QueuedTask{Pid: 10})
+	o.Queued(10, 100)
+	o.Dispatched(10, 1, 130)
+	// A run older than the span: the task didn't run after the dispatch.
+	o.Running(10, 1, 50)
+	if len(o.done) != 1 {
+		t.Fatalf("got %d finished spans, want 1", len(o.done))
+	}
+	span := o.done[0]
+	if span.end != 130 || span.events[len(span.events)-1].name != "dispatched" {
+		t.Fatalf("stale run recorded: end %d, events %+v", span.end, span.events)
+	}
+}
+
+func TestTracerMaxSpans(t *testing.T) {
+	o, err := NewTracer(TracerOpts{Endpoint: "http://localhost", SampleRate: 1, MaxSpans: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for pid := int32(1); pid <= 3; pid++ {
+		o.Sample(&core.QueuedTask{Pid: pid})
+	}
+	if len(o.pending) != 2 || o.Dropped() != 1 {
+		t.Fatalf("pending %d, dropped %d; want 2, 1", len(o.pending), o.Dropped())
+	}
+}
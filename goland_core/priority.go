@@ -0,0 +1,76 @@
+package core
+
+import (
+	"encoding/binary"
+	"fmt"
+	"unsafe"
+)
+
+// TaskPriority is the priority assigned to a PID (see intf.h::task_priority).
+type TaskPriority uint32
+
+const (
+	// TaskPriorityNormal tasks are scheduled by the user-space scheduler.
+	TaskPriorityNormal TaskPriority = iota
+	// TaskPriorityCritical tasks are dispatched immediately by the BPF
+	// component on a local DSQ, preempting the running task if needed.
+	TaskPriorityCritical
+	// TaskPriorityBackground tasks are dispatched directly by the BPF
+	// component on the shared DSQ, without going through user-space.
+	TaskPriorityBackground
+)
+
+func (p TaskPriority) String() string {
+	switch p {
+	case TaskPriorityNormal:
+		return "normal"
+	case TaskPriorityCritical:
+		return "critical"
+	case TaskPriorityBackground:
+		return "background"
+	}
+	return fmt.Sprintf("TaskPriority(%d)", uint32(p))
+}
+
+// SetTaskPriority assigns a priority to pid. Tasks with a priority other than
+// TaskPriorityNormal are dispatched by the BPF component directly, even when
+// the user-space queue is backed up. The priority is dropped when the task
+// exits.
+func (s *Sched) SetTaskPriority(pid int32, prio TaskPriority) error {
+	if err := s.acquire(); err != nil {
+		return err
+	}
+	defer s.release()
+	if s.taskPrio == nil {
+		return mapNotFound("task_prio")
+	}
+	key := uint32(pid)
+	switch prio {
+	case TaskPriorityNormal:
+		if _, err := s.taskPrio.GetValue(unsafe.Pointer(&key)); err != nil {
+			return nil
+		}
+		return s.taskPrio.DeleteKey(unsafe.Pointer(&key))
+	case TaskPriorityCritical, TaskPriorityBackground:
+		val := uint32(prio)
+		return s.taskPrio.Update(unsafe.Pointer(&key), unsafe.Pointer(&val))
+	}
+	return fmt.Errorf("invalid task priority: %v", prio)
+}
+
+// GetTaskPriority returns the priority assigned to pid.
+func (s *Sched) GetTaskPriority(pid int32) (TaskPriority, error) {
+	if err := s.acquire(); err != nil {
+		return TaskPriorityNormal, err
+	}
+	defer s.release()
+	if s.taskPrio == nil {
+		return TaskPriorityNormal, mapNotFound("task_prio")
+	}
+	key := uint32(pid)
+	b, err := s.taskPrio.GetValue(unsafe.Pointer(&key))
+	if err != nil || len(b) < 4 {
+		return TaskPriorityNormal, nil
+	}
+	return TaskPriority(binary.NativeEndian.Uint32(b)), nil
+}
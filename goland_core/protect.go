@@ -0,0 +1,50 @@
+package core
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// ProtectSelf makes the BPF component dispatch the threads of the current
+// process directly on the local DSQ of their CPU, without going through the
+// user-space scheduler: a policy that starves the scheduler itself would
+// otherwise stall the whole system, until the sched_ext watchdog kicks in.
+// BssData.Nr_protected_dispatches counts how often the fast path triggers.
+func (s *Sched) ProtectSelf() error {
+	if err := s.acquire(); err != nil {
+		return err
+	}
+	defer s.release()
+	if s.protected == nil {
+		return mapNotFound("protected_tgids")
+	}
+	tgid := uint32(os.Getpid())
+	val := uint8(1)
+	if err := s.protected.Update(unsafe.Pointer(&tgid), unsafe.Pointer(&val)); err != nil {
+		return fmt.Errorf("protect tgid %d: %w", tgid, err)
+	}
+	return nil
+}
+
+// PinThread locks the calling goroutine to its OS thread and restricts that
+// thread to cpu. It is meant to be called by the goroutine that dispatches
+// the tasks, to run it on a CPU reserved to the scheduler.
+//
+// On failure the goroutine is unlocked from its thread.
+func PinThread(cpu int) error {
+	if err := checkCPU(int32(cpu)); err != nil {
+		return err
+	}
+	runtime.LockOSThread()
+	var set unix.CPUSet
+	set.Set(cpu)
+	if err := unix.SchedSetaffinity(0, &set); err != nil {
+		runtime.UnlockOSThread()
+		return fmt.Errorf("pin thread to cpu %d: %w", cpu, err)
+	}
+	return nil
+}
@@ -0,0 +1,256 @@
+package core
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// psiDir is the directory of the pressure stall information files.
+var psiDir = "/proc/pressure"
+
+// PSILine is a line of a pressure file: the share of time (in percent) that
+// some or all the tasks were stalled on a resource, averaged over 10, 60 and
+// 300 seconds, and the total stall time.
+type PSILine struct {
+	Avg10  float64
+	Avg60  float64
+	Avg300 float64
+	Total  time.Duration
+}
+
+// Pressure is the pressure stall information of a resource (see
+// Documentation/accounting/psi.rst): Some is the time at least one task was
+// stalled, Full the time all the non-idle tasks were stalled at once (always
+// zero for the CPU at the system level).
+type Pressure struct {
+	Some PSILine
+	Full PSILine
+}
+
+// ReadPressure reads the pressure of resource, "cpu", "memory" or "io".
+func ReadPressure(resource string) (Pressure, error) {
+	path := psiDir + "/" + resource
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Pressure{}, err
+	}
+	var p Pressure
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		var line *PSILine
+		switch fields[0] {
+		case "some":
+			line = &p.Some
+		case "full":
+			line = &p.Full
+		default:
+			continue
+		}
+		for _, f := range fields[1:] {
+			key, val, ok := strings.Cut(f, "=")
+			if !ok {
+				return Pressure{}, fmt.Errorf("%s: invalid field %q", path, f)
+			}
+			if key == "total" {
+				us, err := strconv.ParseUint(val, 10, 64)
+				if err != nil {
+					return Pressure{}, fmt.Errorf("%s: invalid total %q", path, val)
+				}
+				line.Total = time.Duration(us) * time.Microsecond
+				continue
+			}
+			v, err := strconv.ParseFloat(val, 64)
+			if err != nil {
+				return Pressure{}, fmt.Errorf("%s: invalid %s %q", path, key, val)
+			}
+			switch key {
+			case "avg10":
+				line.Avg10 = v
+			case "avg60":
+				line.Avg60 = v
+			case "avg300":
+				line.Avg300 = v
+			}
+		}
+	}
+	return p, sc.Err()
+}
+
+const (
+	defaultPSIInterval          = time.Second
+	defaultPSIHigh              = 20
+	defaultPSILow               = 5
+	defaultPSIFallbackMaxQueued = 1
+)
+
+// PSIMonitorOpts configures a PSIMonitor. The zero value selects the default
+// of every option.
+type PSIMonitorOpts struct {
+	// Interval is how often the pressure is read (0 = 1s).
+	Interval time.Duration
+	// High is the memory pressure (the "some" avg10, in percent) above
+	// which the monitor switches to the kernel fallback (0 = 20).
+	High float64
+	// Low is the memory pressure below which the monitor switches back
+	// (0 = 5). It must be lower than High, the gap avoids flapping.
+	Low float64
+	// FallbackMaxQueued is the threshold of queued tasks set with
+	// SetMaxQueued in the kernel fallback (0 = 1): beyond it the BPF
+	// component dispatches the tasks without going through user space.
+	FallbackMaxQueued uint64
+	// MaxQueued is the threshold set with SetMaxQueued when the monitor
+	// switches back (0 = the default threshold).
+	MaxQueued uint64
+	// OnChange, if set, is called when the monitor switched to the kernel
+	// fallback (true) or back (false), e.g. to relax the policy too.
+	OnChange func(fallback bool)
+	// Logger receives the diagnostic messages of the monitor (default:
+	// discard them).
+	Logger Logger
+}
+
+// PSIMonitor watches the memory and CPU pressure of the system, so that the
+// scheduler can back off when the machine is thrashing: the user-space
+// scheduler gets slow under memory pressure too, and the scheduling latency
+// explodes.
+//
+// When the memory pressure goes above High the monitor switches to the
+// kernel fallback, lowering the threshold of SetMaxQueued to
+// FallbackMaxQueued, and it restores MaxQueued when the pressure drops below
+// Low. The switches are delivered on the CPUEvents channel of the scheduler,
+// as CPUFallbackOn and CPUFallbackOff events.
+type PSIMonitor struct {
+	opts         PSIMonitorOpts
+	s            *Sched
+	setMaxQueued func(nr uint64) error
+	mu           sync.Mutex
+	memory       Pressure
+	cpu          Pressure
+	fallback     bool
+}
+
+// NewPSIMonitor returns a PSIMonitor of s configured by opts, started by Run.
+func NewPSIMonitor(s *Sched, opts PSIMonitorOpts) (*PSIMonitor, error) {
+	if s == nil {
+		return nil, fmt.Errorf("NewPSIMonitor: nil scheduler")
+	}
+	if opts.Interval < 0 || opts.High < 0 || opts.Low < 0 {
+		return nil, fmt.Errorf("invalid PSIMonitorOpts: %+v", opts)
+	}
+	if opts.Interval == 0 {
+		opts.Interval = defaultPSIInterval
+	}
+	if opts.High == 0 {
+		opts.High = defaultPSIHigh
+	}
+	if opts.Low == 0 {
+		opts.Low = defaultPSILow
+	}
+	if opts.Low >= opts.High {
+		return nil, fmt.Errorf("invalid PSIMonitorOpts: Low %v must be lower than High %v", opts.Low, opts.High)
+	}
+	if opts.FallbackMaxQueued == 0 {
+		opts.FallbackMaxQueued = defaultPSIFallbackMaxQueued
+	}
+	if opts.Logger == nil {
+		opts.Logger = nopLogger{}
+	}
+	return &PSIMonitor{
+		opts:         opts,
+		s:            s,
+		setMaxQueued: s.SetMaxQueued,
+	}, nil
+}
+
+// Run reads the pressure every Interval until ctx is done. It fails if the
+// kernel doesn't provide the pressure stall information (CONFIG_PSI), and
+// returns ErrClosed once the scheduler is closed. The threshold of
+// SetMaxQueued is left as it is when Run returns.
+func (m *PSIMonitor) Run(ctx context.Context) error {
+	if err := m.update(); err != nil {
+		return err
+	}
+	ticker := time.NewTicker(m.opts.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := m.update(); errors.Is(err, ErrClosed) {
+				return err
+			} else if err != nil {
+				m.opts.Logger.Printf("PSI monitor: %v", err)
+			}
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// update reads the pressure and switches the mode if needed.
+func (m *PSIMonitor) update() error {
+	memory, err := ReadPressure("memory")
+	if err != nil {
+		return err
+	}
+	cpu, err := ReadPressure("cpu")
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	m.memory, m.cpu = memory, cpu
+	fallback := m.fallback
+	m.mu.Unlock()
+
+	switch {
+	case !fallback && memory.Some.Avg10 >= m.opts.High:
+		fallback = true
+		err = m.setMaxQueued(m.opts.FallbackMaxQueued)
+	case fallback && memory.Some.Avg10 < m.opts.Low:
+		fallback = false
+		err = m.setMaxQueued(m.opts.MaxQueued)
+	default:
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	m.fallback = fallback
+	m.mu.Unlock()
+	m.opts.Logger.Printf("PSI monitor: memory pressure %.2f%%, kernel fallback %v", memory.Some.Avg10, fallback)
+	kind := CPUFallbackOff
+	if fallback {
+		kind = CPUFallbackOn
+	}
+	m.s.sendCPUEvent(CPUEvent{Cpu: RL_CPU_ANY, Kind: kind, Ts: ktimeNow()})
+	if m.opts.OnChange != nil {
+		m.opts.OnChange(fallback)
+	}
+	return nil
+}
+
+// Pressure returns the memory and CPU pressure of the last reading.
+func (m *PSIMonitor) Pressure() (memory, cpu Pressure) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.memory, m.cpu
+}
+
+// Fallback returns true if the monitor switched to the kernel fallback.
+func (m *PSIMonitor) Fallback() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.fallback
+}
@@ -0,0 +1,87 @@
+package core
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// fakePressure makes ReadPressure read the memory pressure avg10 and an idle
+// CPU from a temporary directory, for the duration of the test.
+func fakePressure(t *testing.T) (set func(avg10 float64)) {
+	t.Helper()
+	dir := t.TempDir()
+	orig := psiDir
+	psiDir = dir
+	t.Cleanup(func() { psiDir = orig })
+	write := func(name, data string) {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("cpu", "some avg10=0.00 avg60=0.00 avg300=0.00 total=0\n")
+	return func(avg10 float64) {
+		write("memory", fmt.Sprintf("some avg10=%.2f avg60=0.00 avg300=0.00 total=1500\n"+
+			"full avg10=0.00 avg60=0.00 avg300=0.00 total=0\n", avg10))
+	}
+}
+
+func TestReadPressure(t *testing.T) {
+	set := fakePressure(t)
+	set(12.5)
+	p, err := ReadPressure("memory")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Some.Avg10 != 12.5 || p.Some.Total.Microseconds() != 1500 {
+		t.Fatalf("got %+v", p)
+	}
+}
+
+func TestPSIMonitorHysteresis(t *testing.T) {
+	set := fakePressure(t)
+	s := &Sched{cpuEvents: make(chan CPUEvent, 8)}
+	m, err := NewPSIMonitor(s, PSIMonitorOpts{High: 20, Low: 5, MaxQueued: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var thresholds []uint64
+	m.setMaxQueued = func(nr uint64) error {
+		thresholds = append(thresholds, nr)
+		return nil
+	}
+
+	for _, avg10 := range []float64{1, 25, 10, 30, 4, 10} {
+		set(avg10)
+		if err := m.update(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if fmt.Sprint(thresholds) != "[1 100]" {
+		t.Fatalf("SetMaxQueued called with %v, want [1 100]", thresholds)
+	}
+	if m.Fallback() {
+		t.Fatalf("monitor still in the kernel fallback")
+	}
+	var kinds []CPUEventKind
+	for len(s.cpuEvents) > 0 {
+		event := <-s.cpuEvents
+		if event.Cpu != RL_CPU_ANY {
+			t.Fatalf("event of CPU %d", event.Cpu)
+		}
+		kinds = append(kinds, event.Kind)
+	}
+	if fmt.Sprint(kinds) != "[fallback-on fallback-off]" {
+		t.Fatalf("got events %v", kinds)
+	}
+}
+
+func TestNewPSIMonitorInvalid(t *testing.T) {
+	if _, err := NewPSIMonitor(nil, PSIMonitorOpts{}); err == nil {
+		t.Fatalf("nil scheduler accepted")
+	}
+	if _, err := NewPSIMonitor(&Sched{}, PSIMonitorOpts{High: 5, Low: 10}); err == nil {
+		t.Fatalf("Low above High accepted")
+	}
+}
@@ -0,0 +1,152 @@
+package core
+
+/*
+#include "wrapper.h"
+*/
+import "C"
+
+import (
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	bpf "github.com/aquasecurity/libbpfgo"
+)
+
+// MaxQueuedShards is the maximum number of queued ring buffers (see
+// intf.h::MAX_QUEUED_SHARDS).
+const MaxQueuedShards = 8
+
+// queuedShardName returns the name of the map of the queued ring buffer with
+// index shard: "queued" for the first one, "queued_N" for the others.
+func queuedShardName(shard int) string {
+	if shard == 0 {
+		return "queued"
+	}
+	return "queued_" + strconv.Itoa(shard)
+}
+
+// queuedShard returns the index of the queued ring buffer with map name
+// name, or false if name is not a queued ring buffer.
+func queuedShard(name string) (int, bool) {
+	if name == "queued" {
+		return 0, true
+	}
+	suffix, ok := strings.CutPrefix(name, "queued_")
+	if !ok {
+		return 0, false
+	}
+	shard, err := strconv.Atoi(suffix)
+	if err != nil || shard <= 0 || shard >= MaxQueuedShards {
+		return 0, false
+	}
+	return shard, true
+}
+
+// setupQueuedShards tells the BPF component how many queued ring buffers to
+// use, and whether to shard the tasks by CPU, and shrinks the ones that are
+// not used to a single page. It must be called before loading the program.
+func setupQueuedShards(mod *bpf.Module, shards int, byCPU bool) error {
+	C.set_nr_queued_shards(C.u64(shards))
+	if byCPU {
+		C.set_queued_shard_by_cpu(1)
+	}
+	for i := shards; i < MaxQueuedShards; i++ {
+		m, err := mod.GetMap(queuedShardName(i))
+		if err != nil {
+			// Not provided by this object.
+			continue
+		}
+		if err := m.SetMaxEntries(uint32(os.Getpagesize())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// queuedShardCpus returns the CPUs that queue the tasks into shard, out of
+// shards, when the tasks are sharded by CPU: the nrCpus possible CPUs are
+// split in contiguous ranges, as done by queued_shard() in main.bpf.c.
+func queuedShardCpus(shard, shards, nrCpus int) CpuMask {
+	var mask CpuMask
+	for cpu := 0; cpu < nrCpus && cpu < MAX_CPUS; cpu++ {
+		if cpu*shards/nrCpus == shard {
+			mask.Set(cpu)
+		}
+	}
+	return mask
+}
+
+// QueuedConsumerStats describes the consumer of a queued ring buffer, see
+// Sched.QueuedConsumerStats.
+type QueuedConsumerStats struct {
+	Shard int
+	// Pinned is set if the consumer runs on the CPUs of its shard (see
+	// LoadSchedOpts.PinQueuedConsumers).
+	Pinned bool
+	// Records is the number of tasks received from the ring buffer, since
+	// the BPF object was loaded: sample it periodically to compute the
+	// throughput of the consumer.
+	Records uint64
+}
+
+// QueuedConsumerStats returns the counters of the consumers of the queued
+// ring buffers, by shard. The consumers are only tracked in EpollMode, it
+// returns nil in PollMode.
+func (s *Sched) QueuedConsumerStats() ([]QueuedConsumerStats, error) {
+	if err := s.acquire(); err != nil {
+		return nil, err
+	}
+	defer s.release()
+	if len(s.qrbs) == 0 {
+		return nil, nil
+	}
+	stats := make([]QueuedConsumerStats, 0, len(s.qrbs))
+	for _, qrb := range s.qrbs {
+		stats = append(stats, QueuedConsumerStats{
+			Shard:   qrb.shard,
+			Pinned:  qrb.pinned.Load(),
+			Records: qrb.records.Load(),
+		})
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Shard < stats[j].Shard })
+	return stats, nil
+}
+
+// QueueStats describes the channel where the tasks received from the queued
+// ring buffers wait for DequeueTask.
+type QueueStats struct {
+	Depth    int // Tasks buffered now
+	Capacity int // Size of the channel
+	// QueueHighWater is the highest number of tasks buffered at once
+	// since the scheduler was loaded, or since the last
+	// ResetQueueHighWater. When it approaches Capacity, the consumers of
+	// the ring buffers block and the tasks pile up in the ring buffers.
+	QueueHighWater int
+}
+
+// QueueStats returns the depth of the queue of the tasks waiting for
+// DequeueTask and its high-water mark, for capacity planning.
+func (s *Sched) QueueStats() QueueStats {
+	return QueueStats{
+		Depth:          len(s.queue),
+		Capacity:       cap(s.queue),
+		QueueHighWater: int(s.queueHigh.Load()),
+	}
+}
+
+// ResetQueueHighWater resets the high-water mark reported by QueueStats.
+func (s *Sched) ResetQueueHighWater() {
+	s.queueHigh.Store(0)
+}
+
+// noteQueueDepth raises the high-water mark of the queue to n.
+func (s *Sched) noteQueueDepth(n int) {
+	for {
+		high := s.queueHigh.Load()
+		if int64(n) <= high || s.queueHigh.CompareAndSwap(high, int64(n)) {
+			return
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package core
+
+import "testing"
+
+func TestQueuedShardCpus(t *testing.T) {
+	tests := []struct {
+		shards, nrCpus int
+		want           []string
+	}{
+		{1, 4, []string{"0,1,2,3"}},
+		{2, 8, []string{"0,1,2,3", "4,5,6,7"}},
+		{3, 8, []string{"0,1,2", "3,4,5", "6,7"}},
+		{4, 2, []string{"0", "", "1", ""}},
+	}
+	for _, tt := range tests {
+		var all CpuMask
+		for shard := 0; shard < tt.shards; shard++ {
+			mask := queuedShardCpus(shard, tt.shards, tt.nrCpus)
+			if got := mask.String(); got != tt.want[shard] {
+				t.Errorf("queuedShardCpus(%d, %d, %d) = %q, want %q",
+					shard, tt.shards, tt.nrCpus, got, tt.want[shard])
+			}
+			for _, cpu := range mask.Cpus() {
+				if all.Test(cpu) {
+					t.Errorf("CPU %d in several shards", cpu)
+				}
+				all.Set(cpu)
+			}
+		}
+		if n := all.Count(); n != tt.nrCpus {
+			t.Errorf("%d shards of %d CPUs cover %d CPUs", tt.shards, tt.nrCpus, n)
+		}
+	}
+}
+
+func TestQueuedShard(t *testing.T) {
+	for _, tt := range []struct {
+		name  string
+		shard int
+		ok    bool
+	}{
+		{"queued", 0, true},
+		{"queued_1", 1, true},
+		{"queued_7", 7, true},
+		{"queued_8", 0, false},
+		{"queued_x", 0, false},
+	} {
+		shard, ok := queuedShard(tt.name)
+		if shard != tt.shard || ok != tt.ok {
+			t.Errorf("queuedShard(%q) = %d, %v; want %d, %v", tt.name, shard, ok, tt.shard, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,251 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"time"
+	"unsafe"
+
+	bpf "github.com/aquasecurity/libbpfgo"
+)
+
+// ReloadBPF replaces the running BPF component with the BPF object obj, e.g.
+// to roll out a fixed build without restarting the user-space scheduler.
+//
+// The object must be a build of the same program: the same interface
+// (intf.h), maps, progs and global variables as main.bpf.c. The settings of
+// the current object (the rodata and the tunables) are copied to the new
+// one, as well as the priorities, the tags, the managed cgroups and the
+// protected processes. The queued and the exit ring buffers of the new object
+// feed the same channels, so the consumers of the scheduler are not affected.
+//
+// The new object is loaded while the current one is still attached. Then the
+// current one is detached and the new one is attached in its place: only one
+// sched_ext scheduler can be attached at a time, so between the two steps the
+// tasks are run by the default scheduler. The tasks queued to user space or
+// dispatched but not consumed by the old object are not lost, the kernel
+// moves them back to the default scheduler on detach, and they are enqueued
+// to the new object as soon as it is attached. If the scheduler is not
+// attached, the new object just replaces the current one.
+//
+// The state set up through progs is not carried over: SetupPerLLCQueues and
+// EnableSiblingCpu must be called again after ReloadBPF. If the new object
+// can't be loaded, the current one keeps running. If the new object can't be
+// attached, the current one is attached again.
+func (s *Sched) ReloadBPF(obj []byte) error {
+	if err := checkBPFObject(obj); err != nil {
+		return err
+	}
+	s.reloadMu.Lock()
+	defer s.reloadMu.Unlock()
+	// The reserved dispatch slots point into the current dispatched ring
+	// buffer, that is released below: block the new reservations and wait
+	// for the reserved slots to be committed or discarded. The lock is not
+	// held meanwhile, as the holder of a slot may need it before Commit
+	// (e.g. to call SelectCPU).
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return ErrClosed
+	}
+	s.reloading = true
+	s.mu.Unlock()
+	s.slots.Wait()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	defer func() { s.reloading = false }()
+	if s.closed {
+		return ErrClosed
+	}
+	if s.structOps == nil {
+		return mapNotFound("struct_ops")
+	}
+
+	skel, buf, prev, err := reloadSkelFromBytes(obj)
+	if err != nil {
+		return err
+	}
+	if !s.opts.SkipABICheck {
+		if err := checkABI(); err != nil {
+			restoreSkel(prev, false)
+			freeSkelBuf(buf)
+			return err
+		}
+	}
+	mod, err := bpf.NewModuleFromFileArgs(bpf.NewModuleArgs{
+		// The object of the module is replaced by the one of the
+		// skeleton: its verifier log level is set by
+		// setKernelLogLevel.
+		BPFObjPath: "",
+	})
+	if err != nil {
+		restoreSkel(prev, false)
+		freeSkelBuf(buf)
+		return err
+	}
+	// ns binds the new module to the channels of s, so that no consumer
+	// goroutine is started.
+	ns := &Sched{
+		mod:         mod,
+		objBuf:      buf,
+		opts:        s.opts,
+		queue:       s.queue,
+		exitRb:      s.exitRb,
+		exits:       s.exits,
+		exitBatches: s.exitBatches,
+		taskEvents:  s.taskEvents,
+		cpuRb:       s.cpuRb,
+		cpuEvents:   s.cpuEvents,
+		bounceRb:    s.bounceRb,
+		bounces:     s.bounces,
+	}
+	if err := ns.loadReplacement(skel); err != nil {
+		ns.closeBPF()
+		restoreSkel(prev, true)
+		return err
+	}
+	if err := s.copyConfigMaps(ns); err != nil {
+		ns.closeBPF()
+		restoreSkel(prev, true)
+		return err
+	}
+
+	attached := s.link != nil
+	if attached {
+		if err := s.link.Destroy(); err != nil {
+			ns.closeBPF()
+			restoreSkel(prev, true)
+			return fmt.Errorf("detach struct_ops: %w", err)
+		}
+		s.link = nil
+	}
+	s.swapBPF(ns)
+	if attached {
+		link, err := s.structOps.AttachStructOps()
+		if err != nil {
+			err = fmt.Errorf("attach struct_ops: %w", err)
+			// Attach the old object again.
+			s.swapBPF(ns)
+			ns.closeBPF()
+			restoreSkel(prev, true)
+			link, rerr := s.structOps.AttachStructOps()
+			if rerr != nil {
+				return errors.Join(err, fmt.Errorf("reattach struct_ops: %w", rerr))
+			}
+			s.link = link
+			s.attachedAt = time.Now()
+			return err
+		}
+		s.link = link
+		s.attachedAt = time.Now()
+	}
+	for cpu := range s.llcDsq {
+		s.llcDsq[cpu].Store(0)
+	}
+	// ns holds the old object now.
+	err = ns.closeBPF()
+	releaseSkel(prev)
+	return err
+}
+
+// loadReplacement loads the object opened in skel into the kernel and binds
+// it to s.
+func (s *Sched) loadReplacement(skel unsafe.Pointer) error {
+	if err := s.mod.BPFReplaceExistedObject(skel); err != nil {
+		return err
+	}
+	setKernelLogLevel(s.opts.KernelLogLevel)
+	if err := setupQueuedShards(s.mod, s.opts.QueuedShards, s.opts.PinQueuedConsumers); err != nil {
+		return err
+	}
+	if err := s.mod.BPFLoadObject(); err != nil {
+		return fmt.Errorf("load BPF object: %w", err)
+	}
+	return s.bind()
+}
+
+// copyConfigMaps copies the maps configured by the user-space scheduler from
+// s to ns.
+func (s *Sched) copyConfigMaps(ns *Sched) error {
+	for _, m := range []struct {
+		name     string
+		src, dst *bpf.BPFMap
+	}{
+		{"task_prio", s.taskPrio, ns.taskPrio},
+		{"task_tags", s.taskTags, ns.taskTags},
+		{"fast_select_tasks", s.fastSelect, ns.fastSelect},
+		{"managed_cgroups", s.cgroups, ns.cgroups},
+		{"protected_tgids", s.protected, ns.protected},
+	} {
+		if m.src == nil {
+			continue
+		}
+		if m.dst == nil {
+			return mapNotFound(m.name)
+		}
+		if err := copyMap(m.dst, m.src); err != nil {
+			return fmt.Errorf("copy %s: %w", m.name, err)
+		}
+	}
+	return nil
+}
+
+// copyMap copies all the entries of src to dst.
+func copyMap(dst, src *bpf.BPFMap) error {
+	iter := src.Iterator()
+	for iter.Next() {
+		key := iter.Key()
+		val, err := src.GetValue(unsafe.Pointer(&key[0]))
+		if err != nil {
+			// Deleted in the meantime.
+			continue
+		}
+		if err := dst.Update(unsafe.Pointer(&key[0]), unsafe.Pointer(&val[0])); err != nil {
+			return err
+		}
+	}
+	return iter.Err()
+}
+
+// swapBPF exchanges the BPF module of s, and all the resources bound to it by
+// bind, with the ones of ns.
+func (s *Sched) swapBPF(ns *Sched) {
+	s.mod, ns.mod = ns.mod, s.mod
+	s.objBuf, ns.objBuf = ns.objBuf, s.objBuf
+	s.bss, ns.bss = ns.bss, s.bss
+	s.tunables, ns.tunables = ns.tunables, s.tunables
+	s.uei, ns.uei = ns.uei, s.uei
+	s.rodata, ns.rodata = ns.rodata, s.rodata
+	s.structOps, ns.structOps = ns.structOps, s.structOps
+	s.dispatchRb, ns.dispatchRb = ns.dispatchRb, s.dispatchRb
+	s.qrbs, ns.qrbs = ns.qrbs, s.qrbs
+	s.queuedRbs, ns.queuedRbs = ns.queuedRbs, s.queuedRbs
+	s.erb, ns.erb = ns.erb, s.erb
+	s.crb, ns.crb = ns.crb, s.crb
+	s.brb, ns.brb = ns.brb, s.brb
+	s.bounceMap, ns.bounceMap = ns.bounceMap, s.bounceMap
+	s.cgroups, ns.cgroups = ns.cgroups, s.cgroups
+	s.taskPrio, ns.taskPrio = ns.taskPrio, s.taskPrio
+	s.taskTags, ns.taskTags = ns.taskTags, s.taskTags
+	s.tagStats, ns.tagStats = ns.tagStats, s.tagStats
+	s.protected, ns.protected = ns.protected, s.protected
+	s.cpuStats, ns.cpuStats = ns.cpuStats, s.cpuStats
+	s.cpuSliceEnd, ns.cpuSliceEnd = ns.cpuSliceEnd, s.cpuSliceEnd
+	s.runningTask, ns.runningTask = ns.runningTask, s.runningTask
+	s.switchStats, ns.switchStats = ns.switchStats, s.switchStats
+	s.fastSelect, ns.fastSelect = ns.fastSelect, s.fastSelect
+	s.selectCpu, ns.selectCpu = ns.selectCpu, s.selectCpu
+	s.siblingCpu, ns.siblingCpu = ns.siblingCpu, s.siblingCpu
+	s.preemptCpu, ns.preemptCpu = ns.preemptCpu, s.preemptCpu
+	s.llcDsqProg, ns.llcDsqProg = ns.llcDsqProg, s.llcDsqProg
+	s.cpuPerf, ns.cpuPerf = ns.cpuPerf, s.cpuPerf
+	s.cpuPerfCap, ns.cpuPerfCap = ns.cpuPerfCap, s.cpuPerfCap
+	s.dumpDsq, ns.dumpDsq = ns.dumpDsq, s.dumpDsq
+	s.dsqDump, ns.dsqDump = ns.dsqDump, s.dsqDump
+	s.idleMaskProg, ns.idleMaskProg = ns.idleMaskProg, s.idleMaskProg
+	s.idleMask, ns.idleMask = ns.idleMask, s.idleMask
+	s.warnings, ns.warnings = ns.warnings, s.warnings
+	// The dispatches of the new object are counted from 0, as the ones it
+	// receives (see DispatchStats and Flush).
+	s.submitted.Store(0)
+}
@@ -0,0 +1,219 @@
+package core
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"unsafe"
+
+	bpf "github.com/aquasecurity/libbpfgo"
+	"golang.org/x/sys/unix"
+)
+
+// Ring buffer record header (see include/uapi/linux/bpf.h).
+const (
+	ringbufBusyBit    = 1 << 31
+	ringbufDiscardBit = 1 << 30
+	ringbufHdrSize    = 8
+)
+
+// epollRingBuf consumes a BPF_MAP_TYPE_RINGBUF map directly through its memory
+// mapping and blocks on the map fd with epoll, so that the consumer sleeps
+// until the kernel submits new records, without any periodic wakeup.
+type epollRingBuf struct {
+	epfd int
+	evfd int
+	cons []byte // consumer position page (read-write)
+	prod []byte // producer position page + data pages (read-only)
+	data []byte
+	mask uint64
+	ch   chan []byte
+	stop chan struct{}
+	wg   sync.WaitGroup
+
+	shard   int
+	cpus    *CpuMask // CPUs where the consumer runs (nil = not pinned)
+	logger  Logger   // never nil
+	pinned  atomic.Bool
+	records atomic.Uint64 // records delivered to ch
+
+	// recordSize, if not 0, is the size of every record of the ring
+	// buffer: dropped is then called with the number of records skipped
+	// past a corrupted header.
+	recordSize uint64
+	dropped    func(records uint64)
+}
+
+func newEpollRingBuf(m *bpf.BPFMap, ch chan []byte) (*epollRingBuf, error) {
+	pageSize := os.Getpagesize()
+	maxEntries := int(m.MaxEntries())
+	fd := m.FileDescriptor()
+
+	rb := &epollRingBuf{
+		epfd: -1,
+		evfd: -1,
+		mask: uint64(maxEntries - 1),
+		ch:   ch,
+		stop: make(chan struct{}),
+	}
+	var err error
+	rb.cons, err = unix.Mmap(fd, 0, pageSize, unix.PROT_READ|unix.PROT_WRITE, unix.MAP_SHARED)
+	if err != nil {
+		return nil, fmt.Errorf("mmap %s consumer page: %w", m.Name(), err)
+	}
+	// Data pages are mapped twice by the kernel, so records wrapping
+	// around the end of the ring buffer can be read contiguously.
+	rb.prod, err = unix.Mmap(fd, int64(pageSize), pageSize+2*maxEntries, unix.PROT_READ, unix.MAP_SHARED)
+	if err != nil {
+		rb.release()
+		return nil, fmt.Errorf("mmap %s producer pages: %w", m.Name(), err)
+	}
+	rb.data = rb.prod[pageSize:]
+
+	if rb.epfd, err = unix.EpollCreate1(unix.EPOLL_CLOEXEC); err != nil {
+		rb.release()
+		return nil, err
+	}
+	if rb.evfd, err = unix.Eventfd(0, unix.EFD_CLOEXEC|unix.EFD_NONBLOCK); err != nil {
+		rb.release()
+		return nil, err
+	}
+	for _, f := range []int{fd, rb.evfd} {
+		event := unix.EpollEvent{Events: unix.EPOLLIN, Fd: int32(f)}
+		if err := unix.EpollCtl(rb.epfd, unix.EPOLL_CTL_ADD, f, &event); err != nil {
+			rb.release()
+			return nil, err
+		}
+	}
+	return rb, nil
+}
+
+// Start starts consuming the ring buffer in a new goroutine.
+func (rb *epollRingBuf) Start() {
+	rb.wg.Add(1)
+	go rb.run()
+}
+
+// pin binds the consumer goroutine to its OS thread and restricts the thread
+// to rb.cpus. The thread is never unlocked once its affinity is changed: it
+// is terminated when the consumer exits, instead of going back to the pool of
+// the Go runtime with a restricted affinity.
+func (rb *epollRingBuf) pin() {
+	runtime.LockOSThread()
+	var set unix.CPUSet
+	for _, cpu := range rb.cpus.Cpus() {
+		set.Set(cpu)
+	}
+	if err := unix.SchedSetaffinity(0, &set); err != nil {
+		rb.logger.Printf("pin queued consumer %d to CPUs %v: %v, running unpinned", rb.shard, rb.cpus, err)
+		runtime.UnlockOSThread()
+		return
+	}
+	rb.pinned.Store(true)
+}
+
+// Close stops the consumer and releases all the resources.
+func (rb *epollRingBuf) Close() error {
+	close(rb.stop)
+	var one [8]byte
+	binary.NativeEndian.PutUint64(one[:], 1)
+	unix.Write(rb.evfd, one[:])
+	rb.wg.Wait()
+	return rb.release()
+}
+
+func (rb *epollRingBuf) release() error {
+	var errs []error
+	if rb.cons != nil {
+		errs = append(errs, unix.Munmap(rb.cons))
+	}
+	if rb.prod != nil {
+		errs = append(errs, unix.Munmap(rb.prod))
+	}
+	if rb.epfd >= 0 {
+		errs = append(errs, unix.Close(rb.epfd))
+	}
+	if rb.evfd >= 0 {
+		errs = append(errs, unix.Close(rb.evfd))
+	}
+	return errors.Join(errs...)
+}
+
+func (rb *epollRingBuf) run() {
+	defer rb.wg.Done()
+	if rb.cpus != nil {
+		rb.pin()
+	}
+	events := make([]unix.EpollEvent, 2)
+	for {
+		if !rb.consume() {
+			return
+		}
+		n, err := unix.EpollWait(rb.epfd, events, -1)
+		if err == unix.EINTR {
+			continue
+		}
+		if err != nil {
+			return
+		}
+		for i := 0; i < n; i++ {
+			if events[i].Fd == int32(rb.evfd) {
+				return
+			}
+		}
+	}
+}
+
+// consume delivers all the records available in the ring buffer, it returns
+// false if the ring buffer has been closed.
+func (rb *epollRingBuf) consume() bool {
+	consPos := (*uint64)(unsafe.Pointer(&rb.cons[0]))
+	prodPos := (*uint64)(unsafe.Pointer(&rb.prod[0]))
+
+	cons := atomic.LoadUint64(consPos)
+	for {
+		prod := atomic.LoadUint64(prodPos)
+		if cons >= prod {
+			return true
+		}
+		for cons < prod {
+			off := cons & rb.mask
+			hdr := atomic.LoadUint32((*uint32)(unsafe.Pointer(&rb.data[off])))
+			if hdr&ringbufBusyBit != 0 {
+				// Record not committed yet: epoll will notify us.
+				return true
+			}
+			n := uint64(hdr &^ (ringbufBusyBit | ringbufDiscardBit))
+			if n > rb.mask+1-ringbufHdrSize {
+				// Corrupted header: the length of the record
+				// can't be trusted, so drop all the records
+				// submitted so far and keep going.
+				rb.logger.Printf("queued ring buffer %d: corrupted record header %#x, dropping %d bytes",
+					rb.shard, hdr, prod-cons)
+				if rb.recordSize != 0 && rb.dropped != nil {
+					stride := (rb.recordSize + ringbufHdrSize + 7) &^ 7
+					rb.dropped((prod - cons + stride - 1) / stride)
+				}
+				cons = prod
+				atomic.StoreUint64(consPos, cons)
+				continue
+			}
+			if hdr&ringbufDiscardBit == 0 {
+				sample := make([]byte, n)
+				copy(sample, rb.data[off+ringbufHdrSize:off+ringbufHdrSize+n])
+				select {
+				case rb.ch <- sample:
+					rb.records.Add(1)
+				case <-rb.stop:
+					return false
+				}
+			}
+			cons += (n + ringbufHdrSize + 7) &^ 7
+			atomic.StoreUint64(consPos, cons)
+		}
+	}
+}
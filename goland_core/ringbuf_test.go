@@ -0,0 +1,148 @@
+package core
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+// testRingBuf emulates the memory mapping of a ring buffer of size bytes.
+type testRingBuf struct {
+	rb   *epollRingBuf
+	prod uint64
+	log  recordLogger
+}
+
+func newTestRingBuf(size int) *testRingBuf {
+	trb := &testRingBuf{}
+	trb.rb = &epollRingBuf{
+		cons: make([]byte, 8),
+		prod: make([]byte, 8),
+		// The kernel maps the data pages twice.
+		data:   make([]byte, 2*size),
+		mask:   uint64(size - 1),
+		ch:     make(chan []byte, 16),
+		stop:   make(chan struct{}),
+		logger: &trb.log,
+	}
+	return trb
+}
+
+// submit writes a record with the header hdrFlags|len(payload), as the
+// kernel does.
+func (trb *testRingBuf) submit(payload []byte, hdrFlags uint32) {
+	trb.submitHdr(hdrFlags|uint32(len(payload)), payload)
+}
+
+// submitHdr writes a record with a raw header, advancing the producer by
+// the size of payload.
+func (trb *testRingBuf) submitHdr(hdr uint32, payload []byte) {
+	rb := trb.rb
+	off := trb.prod & rb.mask
+	rec := make([]byte, ringbufHdrSize+len(payload))
+	binary.NativeEndian.PutUint32(rec, hdr)
+	copy(rec[ringbufHdrSize:], payload)
+	copy(rb.data[off:], rec)
+	copy(rb.data[off+rb.mask+1:], rec)
+	trb.prod += (uint64(len(rec)) + 7) &^ 7
+	binary.NativeEndian.PutUint64(rb.prod, trb.prod)
+}
+
+func (trb *testRingBuf) consumerPos() uint64 {
+	return binary.NativeEndian.Uint64(trb.rb.cons)
+}
+
+// received returns the records delivered so far.
+func (trb *testRingBuf) received() []string {
+	var recs []string
+	for {
+		select {
+		case b := <-trb.rb.ch:
+			recs = append(recs, string(b))
+		default:
+			return recs
+		}
+	}
+}
+
+func TestEpollRingBufConsume(t *testing.T) {
+	trb := newTestRingBuf(64)
+	trb.submit([]byte("one"), 0)
+	trb.submit([]byte("discarded"), ringbufDiscardBit)
+	trb.submit([]byte("two"), 0)
+	if !trb.rb.consume() {
+		t.Fatalf("consumer stopped")
+	}
+	if got := trb.received(); len(got) != 2 || got[0] != "one" || got[1] != "two" {
+		t.Fatalf("got %q", got)
+	}
+	if trb.consumerPos() != trb.prod {
+		t.Fatalf("consumer at %d, producer at %d", trb.consumerPos(), trb.prod)
+	}
+
+	// Records wrapping around the end of the data pages.
+	trb.submit([]byte("wrapped record"), 0)
+	trb.submit([]byte("three"), 0)
+	trb.rb.consume()
+	if got := trb.received(); len(got) != 2 || got[0] != "wrapped record" || got[1] != "three" {
+		t.Fatalf("got %q", got)
+	}
+}
+
+func TestEpollRingBufBusy(t *testing.T) {
+	trb := newTestRingBuf(64)
+	trb.submit([]byte("one"), 0)
+	trb.submit([]byte("busy"), ringbufBusyBit)
+	if !trb.rb.consume() {
+		t.Fatalf("consumer stopped")
+	}
+	if got := trb.received(); len(got) != 1 {
+		t.Fatalf("got %q", got)
+	}
+	if trb.consumerPos() == trb.prod {
+		t.Fatalf("consumer went past a record not committed yet")
+	}
+}
+
+func TestEpollRingBufCorruptedHeader(t *testing.T) {
+	trb := newTestRingBuf(64)
+	var dropped uint64
+	trb.rb.recordSize = 4 // 16 bytes per record, with the header
+	trb.rb.dropped = func(records uint64) { dropped += records }
+	trb.submit([]byte("one"), 0)
+	// A length larger than the ring buffer.
+	trb.submitHdr(1000, []byte("garbage"))
+	trb.submit([]byte("lost"), 0)
+	if !trb.rb.consume() {
+		t.Fatalf("consumer stopped on a corrupted header")
+	}
+	if got := trb.received(); len(got) != 1 || got[0] != "one" {
+		t.Fatalf("got %q", got)
+	}
+	if trb.consumerPos() != trb.prod {
+		t.Fatalf("corrupted records not skipped: consumer at %d, producer at %d", trb.consumerPos(), trb.prod)
+	}
+	if len(trb.log.msgs) != 1 {
+		t.Fatalf("got %d log messages, want 1", len(trb.log.msgs))
+	}
+	// The corrupted record and the one behind it.
+	if dropped != 2 {
+		t.Fatalf("%d records reported as dropped, want 2", dropped)
+	}
+
+	// The consumer keeps going.
+	trb.submit([]byte("two"), 0)
+	trb.rb.consume()
+	if got := trb.received(); len(got) != 1 || got[0] != "two" {
+		t.Fatalf("got %q", got)
+	}
+}
+
+func TestEpollRingBufStop(t *testing.T) {
+	trb := newTestRingBuf(64)
+	trb.rb.ch = make(chan []byte) // never read
+	close(trb.rb.stop)
+	trb.submit([]byte("one"), 0)
+	if trb.rb.consume() {
+		t.Fatalf("consumer kept running after stop")
+	}
+}
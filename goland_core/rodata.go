@@ -77,6 +77,10 @@ type Rodata struct {
 }
 
 func (s *Sched) GetRoData() (Rodata, error) {
+	if err := s.acquire(); err != nil {
+		return Rodata{}, err
+	}
+	defer s.release()
 	if s.rodata == nil {
 		return Rodata{}, fmt.Errorf("BssMap is nil")
 	}
@@ -87,7 +91,7 @@ func (s *Sched) GetRoData() (Rodata, error) {
 	}
 	var ro Rodata
 	buff := bytes.NewBuffer(b)
-	err = binary.Read(buff, binary.LittleEndian, &ro)
+	err = binary.Read(buff, binary.NativeEndian, &ro)
 	if err != nil {
 		return Rodata{}, err
 	}
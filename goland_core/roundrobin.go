@@ -0,0 +1,113 @@
+package core
+
+import (
+	"fmt"
+	"unsafe"
+
+	bpf "github.com/aquasecurity/libbpfgo"
+	"golang.org/x/sys/unix"
+)
+
+// DispatchRoundRobinIdle dispatches the task pid to the next idle CPU after
+// the one chosen by the previous call, in round-robin order, with a time
+// slice of sliceNs (0 = default) and the vtime of a task of default weight
+// (see DispatchFair). It returns the chosen CPU.
+//
+// It is a simple policy that spreads the load on the idle CPUs, instead of
+// always picking the lowest one as SelectCPU tends to do. The idle CPUs are
+// the ones reported by IdleCpus, a best-effort snapshot. The affinity of the
+// task is not known here, so the BPF component moves the task to another CPU
+// if it can't run on the chosen one.
+//
+// If no CPU is idle, the task is dispatched to the first CPU available and
+// RL_CPU_ANY is returned. It is safe to call it from several goroutines.
+func (s *Sched) DispatchRoundRobinIdle(pid int32, sliceNs uint64) (int32, error) {
+	idle, err := s.IdleCpus()
+	if err != nil {
+		return RL_CPU_ANY, err
+	}
+	cpu := s.nextRoundRobin(&idle)
+	vtime, err := s.fairVtime(WeightDefault, sliceNs)
+	if err != nil {
+		return RL_CPU_ANY, err
+	}
+
+	t := s.NewDispatchedTask()
+	t.Pid = pid
+	t.Cpu = cpu
+	t.SliceNs = sliceNs
+	t.Vtime = vtime
+	if err := s.DispatchTask(t); err != nil {
+		s.putDispatchedTask(t)
+		return RL_CPU_ANY, err
+	}
+	return cpu, nil
+}
+
+// IdleCpus returns the idle CPUs, as tracked by the kernel, that are online
+// and available to the scheduler (see CPUAvailable). It is a snapshot: a CPU
+// may be busy by the time it is used.
+func (s *Sched) IdleCpus() (CpuMask, error) {
+	if err := s.acquire(); err != nil {
+		return CpuMask{}, err
+	}
+	defer s.release()
+	if s.idleMaskProg == nil {
+		return CpuMask{}, progNotFound("get_idle_cpumask")
+	}
+	if s.idleMask == nil {
+		return CpuMask{}, mapNotFound("idle_cpumask")
+	}
+	s.idleMaskMu.Lock()
+	defer s.idleMaskMu.Unlock()
+	var opt bpf.RunOpts
+	if err := s.idleMaskProg.Run(&opt); err != nil {
+		return CpuMask{}, fmt.Errorf("run get_idle_cpumask: %w", err)
+	}
+	if ret := int32(opt.RetVal); ret < 0 {
+		return CpuMask{}, fmt.Errorf("get_idle_cpumask: %w", unix.Errno(-ret))
+	}
+	key := uint32(0)
+	b, err := s.idleMask.GetValue(unsafe.Pointer(&key))
+	if err != nil {
+		return CpuMask{}, fmt.Errorf("read idle_cpumask: %w", err)
+	}
+	idle, err := decodeIdleCpumask(b)
+	if err != nil {
+		return CpuMask{}, err
+	}
+	s.filterUsableCpus(&idle)
+	return idle, nil
+}
+
+// filterUsableCpus clears from mask the CPUs that are offline or taken by a
+// higher priority sched_class.
+func (s *Sched) filterUsableCpus(mask *CpuMask) {
+	for _, cpu := range mask.Cpus() {
+		if !s.cpuOnline[cpu].Load() || !s.CPUAvailable(int32(cpu)) {
+			mask.Clear(cpu)
+		}
+	}
+}
+
+// nextRoundRobin returns the first CPU of idle from the round-robin cursor,
+// wrapping around, and moves the cursor past it. It returns RL_CPU_ANY, and
+// leaves the cursor unchanged, if idle is empty.
+func (s *Sched) nextRoundRobin(idle *CpuMask) int32 {
+	for {
+		from := s.rrCursor.Load()
+		cpu := int32(-1)
+		for i := int32(0); i < MAX_CPUS; i++ {
+			if c := (from + i) % MAX_CPUS; idle.Test(int(c)) {
+				cpu = c
+				break
+			}
+		}
+		if cpu < 0 {
+			return RL_CPU_ANY
+		}
+		if s.rrCursor.CompareAndSwap(from, (cpu+1)%MAX_CPUS) {
+			return cpu
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package core
+
+import (
+	"encoding/binary"
+	"fmt"
+	"testing"
+)
+
+func TestDecodeIdleCpumask(t *testing.T) {
+	data := make([]byte, idleCpumaskSize)
+	binary.NativeEndian.PutUint64(data[0:8], 1<<3|1<<5)
+	binary.NativeEndian.PutUint64(data[8:16], 1)
+	mask, err := decodeIdleCpumask(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := fmt.Sprint(mask.Cpus()); got != "[3 5 64]" {
+		t.Fatalf("got %s, want [3 5 64]", got)
+	}
+	if _, err := decodeIdleCpumask(data[:8]); err == nil {
+		t.Fatalf("short record accepted")
+	}
+}
+
+func TestFilterUsableCpus(t *testing.T) {
+	s := &Sched{}
+	for cpu := 0; cpu < 4; cpu++ {
+		s.updateCPUState(CPUEvent{Cpu: int32(cpu), Kind: CPUOnline})
+	}
+	s.updateCPUState(CPUEvent{Cpu: 1, Kind: CPUReleased})
+	s.updateCPUState(CPUEvent{Cpu: 2, Kind: CPUOffline})
+	s.updateCPUState(CPUEvent{Cpu: MAX_CPUS, Kind: CPUOnline}) // ignored
+
+	var idle CpuMask
+	for _, cpu := range []int{0, 1, 2, 3, 4} {
+		idle.Set(cpu)
+	}
+	s.filterUsableCpus(&idle)
+	if got := fmt.Sprint(idle.Cpus()); got != "[0 3]" {
+		t.Fatalf("got %s, want [0 3]", got)
+	}
+
+	// A released CPU going offline and back online is still released.
+	s.updateCPUState(CPUEvent{Cpu: 1, Kind: CPUOffline})
+	s.updateCPUState(CPUEvent{Cpu: 1, Kind: CPUOnline})
+	if s.CPUAvailable(1) {
+		t.Fatalf("cpu 1 available before it's acquired back")
+	}
+}
+
+func TestNextRoundRobin(t *testing.T) {
+	s := &Sched{}
+	var idle CpuMask
+	idle.Set(2)
+	idle.Set(5)
+	var got []int32
+	for i := 0; i < 3; i++ {
+		got = append(got, s.nextRoundRobin(&idle))
+	}
+	if fmt.Sprint(got) != "[2 5 2]" {
+		t.Fatalf("got %v, want [2 5 2]", got)
+	}
+	if cpu := s.nextRoundRobin(&CpuMask{}); cpu != RL_CPU_ANY {
+		t.Fatalf("got %d with no idle CPU, want RL_CPU_ANY", cpu)
+	}
+}
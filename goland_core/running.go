@@ -0,0 +1,47 @@
+package core
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// RunningTasks returns the pid of the task running on each CPU, by CPU. Idle
+// CPUs, and the CPUs running the user-space scheduler, are not reported.
+//
+// It is a best-effort snapshot: the map is updated by the BPF component
+// while it is read, so a task may be reported on a CPU it just left, or be
+// missing from a CPU it just started running on.
+func (s *Sched) RunningTasks() (map[int32]int32, error) {
+	if err := s.acquire(); err != nil {
+		return nil, err
+	}
+	defer s.release()
+	if s.runningTask == nil {
+		return nil, mapNotFound("running_task")
+	}
+	tasks := make(map[int32]int32)
+	iter := s.runningTask.Iterator()
+	for iter.Next() {
+		key := iter.Key()
+		if len(key) != 4 {
+			return nil, fmt.Errorf("running_task key size %d doesn't match 4", len(key))
+		}
+		cpu := int32(binary.NativeEndian.Uint32(key))
+		b, err := s.runningTask.GetValue(unsafe.Pointer(&key[0]))
+		if errors.Is(err, unix.ENOENT) {
+			// The task stopped in the meantime.
+			continue
+		} else if err != nil {
+			return nil, fmt.Errorf("read running_task: %w", err)
+		}
+		tasks[cpu] = int32(binary.NativeEndian.Uint32(b))
+	}
+	if err := iter.Err(); err != nil {
+		return nil, fmt.Errorf("iterate running_task: %w", err)
+	}
+	return tasks, nil
+}
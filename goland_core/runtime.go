@@ -0,0 +1,55 @@
+package core
+
+import "sync"
+
+// RuntimeTracker accumulates the per-pid CPU time reported by the BPF
+// component in QueuedTask.RuntimeNs.
+//
+// Entries must be released when the tasks exit (see HandleExit and
+// TaskExits), otherwise they leak.
+type RuntimeTracker struct {
+	mu     sync.Mutex
+	totals map[int32]uint64
+}
+
+// NewRuntimeTracker creates an empty RuntimeTracker.
+func NewRuntimeTracker() *RuntimeTracker {
+	return &RuntimeTracker{
+		totals: make(map[int32]uint64),
+	}
+}
+
+// Account adds the runtime delta of t to its pid and returns the total CPU
+// time used by the pid so far.
+func (rt *RuntimeTracker) Account(t *QueuedTask) uint64 {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	rt.totals[t.Pid] += t.RuntimeNs
+	return rt.totals[t.Pid]
+}
+
+// Total returns the total CPU time accounted to pid.
+func (rt *RuntimeTracker) Total(pid int32) uint64 {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	return rt.totals[pid]
+}
+
+// Forget drops the runtime accounted to pid.
+func (rt *RuntimeTracker) Forget(pid int32) {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	delete(rt.totals, pid)
+}
+
+// HandleExit drops the runtime of a task that exited.
+func (rt *RuntimeTracker) HandleExit(e TaskExit) {
+	rt.Forget(e.Pid)
+}
+
+// Len returns the number of tracked pids.
+func (rt *RuntimeTracker) Len() int {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	return len(rt.totals)
+}
@@ -0,0 +1,64 @@
+package core
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// schedExtPath is the sysfs directory of sched_ext. It is a variable so that
+// it can point to a fake tree.
+var schedExtPath = "/sys/kernel/sched_ext"
+
+// takeoverPollInterval is how often Attach checks whether the scheduler
+// attached by someone else exited, see LoadSchedOpts.TakeoverTimeout.
+const takeoverPollInterval = 100 * time.Millisecond
+
+// SchedulerActive reports whether a sched_ext scheduler is attached, and its
+// name if known (from /sys/kernel/sched_ext/root/ops). A kernel without
+// sched_ext reports no scheduler: Attach fails later with the error of the
+// kernel.
+func SchedulerActive() (active bool, name string, err error) {
+	state, err := os.ReadFile(filepath.Join(schedExtPath, "state"))
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, "", nil
+	} else if err != nil {
+		return false, "", err
+	}
+	if strings.TrimSpace(string(state)) == "disabled" {
+		return false, "", nil
+	}
+	// root/ops disappears while the scheduler is being disabled.
+	ops, err := os.ReadFile(filepath.Join(schedExtPath, "root", "ops"))
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return true, "", err
+	}
+	return true, strings.TrimSpace(string(ops)), nil
+}
+
+// waitSchedulerInactive waits up to timeout for the sched_ext scheduler
+// attached by someone else to exit. It fails with ErrSchedulerBusy if the
+// scheduler is still attached after the timeout.
+func (s *Sched) waitSchedulerInactive(timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		active, name, err := SchedulerActive()
+		if err != nil {
+			return err
+		}
+		if !active {
+			return nil
+		}
+		if !time.Now().Before(deadline) {
+			return &ErrSchedulerBusy{Current: name}
+		}
+		select {
+		case <-time.After(takeoverPollInterval):
+		case <-s.done:
+			return ErrClosed
+		}
+	}
+}
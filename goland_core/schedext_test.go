@@ -0,0 +1,92 @@
+package core
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// fakeSchedExt makes SchedulerActive read a temporary directory instead of
+// the sysfs of sched_ext, for the duration of the test. set writes the state
+// of sched_ext and the name of the attached scheduler ("" = no root/ops).
+func fakeSchedExt(t *testing.T) (set func(state, ops string)) {
+	t.Helper()
+	dir := t.TempDir()
+	orig := schedExtPath
+	schedExtPath = dir
+	t.Cleanup(func() { schedExtPath = orig })
+	return func(state, ops string) {
+		if err := os.WriteFile(filepath.Join(dir, "state"), []byte(state+"\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		root := filepath.Join(dir, "root")
+		if ops == "" {
+			if err := os.RemoveAll(root); err != nil {
+				t.Fatal(err)
+			}
+			return
+		}
+		if err := os.MkdirAll(root, 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(root, "ops"), []byte(ops+"\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestSchedulerActive(t *testing.T) {
+	set := fakeSchedExt(t)
+
+	// Kernel without sched_ext.
+	if active, _, err := SchedulerActive(); active || err != nil {
+		t.Fatalf("without sched_ext: %v, %v", active, err)
+	}
+
+	for _, tt := range []struct {
+		state, ops string
+		active     bool
+	}{
+		{"disabled", "", false},
+		{"enabled", "scx_lavd", true},
+		{"disabling", "", true}, // root/ops already removed
+	} {
+		set(tt.state, tt.ops)
+		active, name, err := SchedulerActive()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if active != tt.active || name != tt.ops {
+			t.Errorf("state %s: got %v, %q; want %v, %q", tt.state, active, name, tt.active, tt.ops)
+		}
+	}
+}
+
+func TestWaitSchedulerInactive(t *testing.T) {
+	set := fakeSchedExt(t)
+	s := &Sched{done: make(chan struct{})}
+
+	set("enabled", "scx_lavd")
+	err := s.waitSchedulerInactive(0)
+	var busy *ErrSchedulerBusy
+	if !errors.As(err, &busy) || busy.Current != "scx_lavd" || !errors.Is(err, unix.EBUSY) {
+		t.Fatalf("got %v, want ErrSchedulerBusy of scx_lavd", err)
+	}
+
+	// The other scheduler exits while waiting.
+	timer := time.AfterFunc(2*takeoverPollInterval, func() { set("disabled", "") })
+	defer timer.Stop()
+	if err := s.waitSchedulerInactive(time.Minute); err != nil {
+		t.Fatal(err)
+	}
+
+	set("enabled", "scx_lavd")
+	close(s.done)
+	if err := s.waitSchedulerInactive(time.Minute); !errors.Is(err, ErrClosed) {
+		t.Fatalf("got %v, want ErrClosed", err)
+	}
+}
@@ -1,3 +1,21 @@
 package core
 
+import "context"
+
 type CustomScheduler interface{}
+
+// SchedBackend is the set of operations a user-space scheduling policy needs
+// from the BPF component. Sched implements it on top of the loaded BPF object,
+// while MockBackend implements it in memory so that policies can be exercised
+// without a kernel.
+type SchedBackend interface {
+	BlockTilReadyForDequeue(ctx context.Context)
+	ReadyForDequeue() bool
+	DequeueTask(task *QueuedTask)
+	DispatchTask(t *DispatchedTask) error
+	SelectCPU(t *QueuedTask) (int32, error)
+	GetBssData() (BssData, error)
+}
+
+var _ SchedBackend = (*Sched)(nil)
+var _ SchedBackend = (*MockBackend)(nil)
@@ -0,0 +1,127 @@
+package core
+
+import (
+	"container/list"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// defaultSelectCPUCacheTTL is the max age of the CPUs cached by SelectCPU if
+// LoadSchedOpts.SelectCPUCacheTTL is not set.
+const defaultSelectCPUCacheTTL = 10 * time.Millisecond
+
+// SelectCPUCacheStats are the counters of the SelectCPU cache (see
+// LoadSchedOpts.SelectCPUCacheSize).
+type SelectCPUCacheStats struct {
+	Hits   uint64 // SelectCPU answered from the cache
+	Misses uint64 // pid not cached
+	// Stale counts the cached CPUs discarded because they were too old,
+	// the task can't run there anymore or the CPU has been taken by a
+	// higher priority sched_class.
+	Stale uint64
+}
+
+// selectCPUCache is an LRU cache of the CPUs returned by the rs_select_cpu
+// prog, by pid.
+type selectCPUCache struct {
+	mu      sync.Mutex
+	size    int
+	ttl     time.Duration
+	lru     *list.List // *selectCPUEntry, most recently used first
+	entries map[int32]*list.Element
+
+	hits, misses, stale atomic.Uint64
+}
+
+type selectCPUEntry struct {
+	pid int32
+	cpu int32
+	at  time.Time
+}
+
+func newSelectCPUCache(size int, ttl time.Duration) *selectCPUCache {
+	return &selectCPUCache{
+		size:    size,
+		ttl:     ttl,
+		lru:     list.New(),
+		entries: make(map[int32]*list.Element, size),
+	}
+}
+
+// get returns the CPU cached for t, if it is still valid: not older than the
+// ttl, allowed by the current affinity of t and not taken by a higher
+// priority sched_class.
+func (c *selectCPUCache) get(s *Sched, t *QueuedTask, now time.Time) (int32, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[t.Pid]
+	if !ok {
+		c.misses.Add(1)
+		return 0, false
+	}
+	ent := e.Value.(*selectCPUEntry)
+	if now.Sub(ent.at) > c.ttl || !t.CanRunOn(int(ent.cpu)) || !s.CPUAvailable(ent.cpu) {
+		c.lru.Remove(e)
+		delete(c.entries, t.Pid)
+		c.stale.Add(1)
+		return 0, false
+	}
+	c.lru.MoveToFront(e)
+	c.hits.Add(1)
+	return ent.cpu, true
+}
+
+// put caches cpu for pid, evicting the least recently used pid if the cache
+// is full.
+func (c *selectCPUCache) put(pid, cpu int32, now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.entries[pid]; ok {
+		ent := e.Value.(*selectCPUEntry)
+		ent.cpu, ent.at = cpu, now
+		c.lru.MoveToFront(e)
+		return
+	}
+	if c.lru.Len() >= c.size {
+		old := c.lru.Back()
+		c.lru.Remove(old)
+		delete(c.entries, old.Value.(*selectCPUEntry).pid)
+	}
+	c.entries[pid] = c.lru.PushFront(&selectCPUEntry{pid: pid, cpu: cpu, at: now})
+}
+
+// invalidate drops the CPU cached for pid, if any.
+func (c *selectCPUCache) invalidate(pid int32) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.entries[pid]; ok {
+		c.lru.Remove(e)
+		delete(c.entries, pid)
+	}
+}
+
+// InvalidateSelectCPU drops the CPU cached by SelectCPU for pid, e.g. when
+// the scheduler knows that the affinity of the task changed. The tasks that
+// exited or whose affinity changed are dropped automatically when the BPF
+// object provides the exit_rb ring buffer. It is a no-op if the cache is
+// disabled.
+func (s *Sched) InvalidateSelectCPU(pid int32) {
+	if s.selectCache != nil {
+		s.selectCache.invalidate(pid)
+	}
+}
+
+// SelectCPUCacheStats returns the counters of the SelectCPU cache (all zero
+// if the cache is disabled).
+func (s *Sched) SelectCPUCacheStats() SelectCPUCacheStats {
+	c := s.selectCache
+	if c == nil {
+		return SelectCPUCacheStats{}
+	}
+	return SelectCPUCacheStats{
+		Hits:   c.hits.Load(),
+		Misses: c.misses.Load(),
+		Stale:  c.stale.Load(),
+	}
+}
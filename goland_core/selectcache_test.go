@@ -0,0 +1,115 @@
+package core
+
+import (
+	"encoding/binary"
+	"testing"
+	"time"
+)
+
+// taskEventRecord encodes a record of exit_rb (see intf.h::task_exit_ctx).
+func taskEventRecord(pid int32, kind TaskEventKind) []byte {
+	data := make([]byte, taskExitSize)
+	binary.NativeEndian.PutUint32(data[0:4], uint32(pid))
+	binary.NativeEndian.PutUint32(data[4:8], uint32(pid))
+	binary.NativeEndian.PutUint32(data[8:12], uint32(kind))
+	return data
+}
+
+func TestSelectCPUCacheAffinityEvent(t *testing.T) {
+	s := &Sched{selectCache: newSelectCPUCache(4, time.Second)}
+	now := time.Now()
+	s.selectCache.put(10, 1, now)
+	s.selectCache.put(11, 2, now)
+
+	// The consumers that only deliver the exits drop the affinity
+	// changes, once the cache is invalidated.
+	if _, ok := s.receiveTaskExit(taskEventRecord(10, TaskCpumaskChanged)); ok {
+		t.Fatalf("affinity change delivered as an exit")
+	}
+	if _, ok := s.selectCache.entries[10]; ok {
+		t.Fatalf("CPU of pid 10 still cached after its affinity changed")
+	}
+	exit, ok := s.receiveTaskExit(taskEventRecord(11, TaskExited))
+	if !ok || exit.Pid != 11 {
+		t.Fatalf("got %+v, %v; want the exit of pid 11", exit, ok)
+	}
+	if len(s.selectCache.entries) != 0 {
+		t.Fatalf("%d pids still cached", len(s.selectCache.entries))
+	}
+}
+
+func TestSelectCPUCacheAffinityMidStream(t *testing.T) {
+	const ttl = time.Second
+	s := &Sched{selectCache: newSelectCPUCache(4, ttl)}
+	// The rs_select_cpu prog: the first idle CPU allowed by the affinity
+	// of the task.
+	idle := maskOf(1, 2, 3, 4)
+	var progCalls int
+	prog := func(t *QueuedTask) int32 {
+		progCalls++
+		if cpu := pickIdle(t, nil, &idle); cpu >= 0 {
+			return cpu
+		}
+		return RL_CPU_ANY
+	}
+	// As SelectCPU with the cache enabled.
+	selectCPU := func(t *QueuedTask, now time.Time) int32 {
+		if cpu, ok := s.selectCache.get(s, t, now); ok {
+			return cpu
+		}
+		cpu := prog(t)
+		if cpu == RL_CPU_ANY || !t.CanRunOn(int(cpu)) {
+			return RL_CPU_ANY
+		}
+		s.selectCache.put(t.Pid, cpu, now)
+		return cpu
+	}
+
+	now := time.Now()
+	task := &QueuedTask{Pid: 10, AllowedCpus: maskOf(1, 2)}
+	steps := []struct {
+		name      string
+		change    func()
+		want      int32
+		progCalls int
+	}{
+		{"first wakeup", nil, 1, 1},
+		{"cached", nil, 1, 1},
+		// The task is queued with its new affinity before the event is
+		// received: the cached CPU is not allowed anymore.
+		{"affinity without event", func() { task.AllowedCpus = maskOf(2, 3) }, 2, 2},
+		{"cached after the change", nil, 2, 2},
+		// The event drops the cached CPU, even if it's still allowed.
+		{"affinity event", func() {
+			task.AllowedCpus = maskOf(2, 4)
+			idle.Clear(2)
+			s.receiveTaskExit(taskEventRecord(10, TaskCpumaskChanged))
+		}, 4, 3},
+		{"CPU offline", func() {
+			s.cpuReleased[4].Store(true)
+			idle.Clear(4)
+		}, RL_CPU_ANY, 4},
+		{"CPU online", func() {
+			s.cpuReleased[4].Store(false)
+			idle.Set(4)
+		}, 4, 5},
+		{"expired", func() { now = now.Add(ttl + 1) }, 4, 6},
+	}
+	for _, step := range steps {
+		if step.change != nil {
+			step.change()
+		}
+		cpu := selectCPU(task, now)
+		if cpu != step.want || progCalls != step.progCalls {
+			t.Fatalf("%s: CPU %d after %d prog runs, want %d after %d",
+				step.name, cpu, progCalls, step.want, step.progCalls)
+		}
+		if cpu != RL_CPU_ANY && !task.CanRunOn(int(cpu)) {
+			t.Fatalf("%s: CPU %d not allowed by %v", step.name, cpu, task.AllowedCpus)
+		}
+	}
+	stats := s.SelectCPUCacheStats()
+	if stats.Hits != 2 || stats.Misses != 3 || stats.Stale != 3 {
+		t.Fatalf("got %+v", stats)
+	}
+}
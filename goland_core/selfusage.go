@@ -0,0 +1,165 @@
+package core
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// SchedPhase is a phase of the scheduling loop, whose cost is accounted by
+// SelfUsage.
+type SchedPhase int
+
+const (
+	PhaseDequeue  SchedPhase = iota // DequeueTask
+	PhaseSelect                     // SelectCPU
+	PhaseDispatch                   // DispatchTask and TryDispatchTask
+	NrSchedPhases
+)
+
+func (p SchedPhase) String() string {
+	switch p {
+	case PhaseDequeue:
+		return "dequeue"
+	case PhaseSelect:
+		return "select"
+	case PhaseDispatch:
+		return "dispatch"
+	}
+	return fmt.Sprintf("SchedPhase(%d)", int(p))
+}
+
+// phaseSampleRate is how often the duration of a phase is measured: one call
+// every phaseSampleRate, so that the scheduling loop doesn't read the clock
+// for every task.
+const phaseSampleRate = 64
+
+// phaseCounters accounts the calls of a phase and the duration of the sampled
+// ones.
+type phaseCounters struct {
+	calls     atomic.Uint64
+	sampled   atomic.Uint64
+	sampledNs atomic.Uint64
+}
+
+// phaseState accounts the phases of the scheduling loop.
+type phaseState [NrSchedPhases]phaseCounters
+
+// begin counts a call of phase p, and returns the time it started if the call
+// is sampled (zero otherwise), to be passed to end.
+func (ps *phaseState) begin(p SchedPhase) time.Time {
+	if ps[p].calls.Add(1)%phaseSampleRate != 0 {
+		return time.Time{}
+	}
+	return time.Now()
+}
+
+func (ps *phaseState) end(p SchedPhase, start time.Time) {
+	ps[p].sampledNs.Add(uint64(time.Since(start)))
+	ps[p].sampled.Add(1)
+}
+
+// PhaseUsage is the cost of a phase of the scheduling loop over the interval
+// of a SelfUsage.
+type PhaseUsage struct {
+	Calls uint64        // Calls of the phase
+	Time  time.Duration // Time spent in the phase, estimated from the sampled calls
+	CPU   float64       // Time as a fraction of one CPU
+}
+
+// SelfUsage is the CPU time used by the process of the user-space scheduler
+// over an interval, as a fraction of one CPU (1 = a CPU always busy).
+type SelfUsage struct {
+	Interval time.Duration
+	CPU      float64 // User + System
+	User     float64
+	System   float64
+	// Phases breaks down the time spent by the scheduling loop in the
+	// methods of Sched, by SchedPhase. The time of the goroutines of the
+	// package (e.g. the ring buffer consumers) is only part of CPU. The
+	// phase times are wall-clock times: they include the time
+	// DispatchTask waits for room in the dispatch buffer.
+	Phases [NrSchedPhases]PhaseUsage
+}
+
+// selfSample is a snapshot of the counters used to compute SelfUsage.
+type selfSample struct {
+	at        time.Time
+	user, sys time.Duration
+	phases    [NrSchedPhases]struct{ calls, sampled, sampledNs uint64 }
+}
+
+// selfUsageState is the sample taken by the last SelfUsage call.
+type selfUsageState struct {
+	mu   sync.Mutex
+	prev selfSample
+}
+
+// takeSelfSample reads the CPU times of the process (getrusage) and the
+// counters of the phases.
+func (s *Sched) takeSelfSample() (selfSample, error) {
+	var ru unix.Rusage
+	if err := unix.Getrusage(unix.RUSAGE_SELF, &ru); err != nil {
+		return selfSample{}, fmt.Errorf("getrusage: %w", err)
+	}
+	sample := selfSample{
+		at:   time.Now(),
+		user: time.Duration(ru.Utime.Nano()),
+		sys:  time.Duration(ru.Stime.Nano()),
+	}
+	for p := range s.phases {
+		c := &s.phases[p]
+		sample.phases[p].calls = c.calls.Load()
+		sample.phases[p].sampled = c.sampled.Load()
+		sample.phases[p].sampledNs = c.sampledNs.Load()
+	}
+	return sample, nil
+}
+
+// usageSince returns the usage between prev and cur.
+func usageSince(prev, cur selfSample) SelfUsage {
+	u := SelfUsage{Interval: cur.at.Sub(prev.at)}
+	if u.Interval <= 0 {
+		return u
+	}
+	interval := float64(u.Interval)
+	u.User = float64(cur.user-prev.user) / interval
+	u.System = float64(cur.sys-prev.sys) / interval
+	u.CPU = u.User + u.System
+	for p := range u.Phases {
+		c, pc := cur.phases[p], prev.phases[p]
+		pu := &u.Phases[p]
+		pu.Calls = c.calls - pc.calls
+		if sampled := c.sampled - pc.sampled; sampled > 0 {
+			avg := float64(c.sampledNs-pc.sampledNs) / float64(sampled)
+			pu.Time = time.Duration(avg * float64(pu.Calls))
+			pu.CPU = float64(pu.Time) / interval
+		}
+	}
+	return u
+}
+
+// SelfUsage returns the CPU time used by the user-space scheduler since the
+// previous call (or since the scheduler was loaded, for the first call).
+//
+// The accounting is always on and cheap: the process times are read only
+// here, and the scheduling loop measures the duration of one call every 64
+// of each phase, so the phase times are estimates.
+func (s *Sched) SelfUsage() (SelfUsage, error) {
+	cur, err := s.takeSelfSample()
+	if err != nil {
+		return SelfUsage{}, err
+	}
+	u := &s.selfUsage
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	prev := u.prev
+	if prev.at.IsZero() {
+		prev = cur
+	}
+	u.prev = cur
+	return usageSince(prev, cur), nil
+}
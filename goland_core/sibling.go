@@ -0,0 +1,99 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// SiblingPair is a sibling relationship between two CPUs in the scheduling
+// domain of a cache level, see EnableSiblingCpu.
+type SiblingPair struct {
+	Level   int32 // Cache level: 2 or 3
+	Cpu     int32
+	Sibling int32
+}
+
+func (p SiblingPair) String() string {
+	return fmt.Sprintf("L%d %d-%d", p.Level, p.Cpu, p.Sibling)
+}
+
+// nrCpuIds returns the number of possible CPU ids of the system, as the
+// kernel's nr_cpu_ids.
+var nrCpuIds = sync.OnceValues(func() (int, error) {
+	possible, err := readCpuList("possible")
+	if err != nil {
+		return 0, err
+	}
+	cpus := possible.Cpus()
+	if len(cpus) == 0 {
+		return 0, fmt.Errorf("no possible cpu")
+	}
+	return cpus[len(cpus)-1] + 1, nil
+})
+
+// checkSiblingPair validates p before it is passed to the
+// enable_sibling_cpu program.
+func checkSiblingPair(p SiblingPair) error {
+	if p.Level != 2 && p.Level != 3 {
+		return fmt.Errorf("invalid cache level %d (%v)", p.Level, p)
+	}
+	nr, err := nrCpuIds()
+	if err != nil {
+		return err
+	}
+	for _, cpu := range []int32{p.Cpu, p.Sibling} {
+		if err := checkCPU(cpu); err != nil {
+			return fmt.Errorf("%w (%v)", err, p)
+		}
+		if int(cpu) >= nr {
+			return fmt.Errorf("%w: %d >= nr_cpu_ids %d (%v)", ErrInvalidCPU, cpu, nr, p)
+		}
+	}
+	return nil
+}
+
+// ErrSiblingPairs is returned by EnableSiblingCpus with the error of each
+// pair that couldn't be applied.
+type ErrSiblingPairs struct {
+	Failed map[SiblingPair]error
+}
+
+func (e *ErrSiblingPairs) Error() string {
+	return fmt.Sprintf("%d sibling pairs failed", len(e.Failed))
+}
+
+func (e *ErrSiblingPairs) Unwrap() []error {
+	errs := make([]error, 0, len(e.Failed))
+	for _, err := range e.Failed {
+		errs = append(errs, err)
+	}
+	return errs
+}
+
+// EnableSiblingCpus applies all the sibling relationships in pairs, e.g. a
+// whole topology, skipping the duplicated pairs. It keeps going when a pair
+// fails and returns an *ErrSiblingPairs with the error of each failed pair.
+// Errors that affect every pair (e.g. ErrClosed) stop it immediately.
+func (s *Sched) EnableSiblingCpus(pairs []SiblingPair) error {
+	done := make(map[SiblingPair]bool, len(pairs))
+	failed := make(map[SiblingPair]error)
+	for _, p := range pairs {
+		if done[p] {
+			continue
+		}
+		done[p] = true
+		err := s.EnableSiblingCpu(p.Level, p.Cpu, p.Sibling)
+		if err == nil {
+			continue
+		}
+		if errors.Is(err, ErrClosed) || errors.Is(err, ErrProgNotFound) {
+			return err
+		}
+		failed[p] = err
+	}
+	if len(failed) > 0 {
+		return &ErrSiblingPairs{Failed: failed}
+	}
+	return nil
+}
@@ -0,0 +1,109 @@
+package core
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+// negErrno returns errno as returned by a BPF program in RunOpts.RetVal.
+func negErrno(errno unix.Errno) uint32 {
+	return uint32(-int32(errno))
+}
+
+func TestSiblingCpuResult(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		retVal uint32
+		errno  unix.Errno // 0 = success
+	}{
+		{"success", 0, 0},
+		{"benign positive", 1, 0},
+		{"large positive", 1 << 30, 0},
+		{"EINVAL", negErrno(unix.EINVAL), unix.EINVAL},
+		{"ENOENT", negErrno(unix.ENOENT), unix.ENOENT},
+	} {
+		err := siblingCpuResult(3, 1, 2, tt.retVal)
+		if tt.errno == 0 {
+			if err != nil {
+				t.Errorf("%s: %v", tt.name, err)
+			}
+			continue
+		}
+		var sibling *ErrSiblingCpu
+		if !errors.As(err, &sibling) || !errors.Is(err, tt.errno) {
+			t.Errorf("%s: got %v, want ErrSiblingCpu of %v", tt.name, err, tt.errno)
+			continue
+		}
+		if sibling.Level != 3 || sibling.Cpu != 1 || sibling.Sibling != 2 ||
+			!strings.Contains(err.Error(), tt.errno.Error()) {
+			t.Errorf("%s: got %+v: %v", tt.name, sibling, err)
+		}
+	}
+}
+
+func TestCheckSiblingPair(t *testing.T) {
+	nr, err := nrCpuIds()
+	if err != nil {
+		t.Skip(err)
+	}
+	for _, tt := range []struct {
+		pair    SiblingPair
+		ok      bool
+		invalid bool // ErrInvalidCPU
+	}{
+		{SiblingPair{Level: 2, Cpu: 0, Sibling: 0}, true, false},
+		{SiblingPair{Level: 3, Cpu: int32(nr - 1), Sibling: 0}, true, false},
+		{SiblingPair{Level: 1, Cpu: 0, Sibling: 0}, false, false},
+		{SiblingPair{Level: 4, Cpu: 0, Sibling: 0}, false, false},
+		{SiblingPair{Level: 2, Cpu: -1, Sibling: 0}, false, true},
+		{SiblingPair{Level: 2, Cpu: 0, Sibling: MAX_CPUS}, false, true},
+		{SiblingPair{Level: 2, Cpu: int32(nr), Sibling: 0}, false, true},
+	} {
+		err := checkSiblingPair(tt.pair)
+		if (err == nil) != tt.ok || errors.Is(err, ErrInvalidCPU) != tt.invalid {
+			t.Errorf("%v: %v", tt.pair, err)
+			continue
+		}
+		// The error tells which pair is invalid.
+		if err != nil && !strings.Contains(err.Error(), tt.pair.String()) {
+			t.Errorf("%v: error %q without the pair", tt.pair, err)
+		}
+	}
+}
+
+func TestEnableSiblingCpus(t *testing.T) {
+	s := &Sched{}
+	invalidLevel := SiblingPair{Level: 1, Cpu: 0, Sibling: 1}
+	invalidCpu := SiblingPair{Level: 2, Cpu: -1, Sibling: 1}
+	// The duplicated pairs are applied, and reported, once.
+	err := s.EnableSiblingCpus([]SiblingPair{invalidLevel, invalidCpu, invalidLevel, invalidCpu})
+	var pairs *ErrSiblingPairs
+	if !errors.As(err, &pairs) || len(pairs.Failed) != 2 {
+		t.Fatalf("got %v, want the errors of 2 pairs", err)
+	}
+	if !errors.Is(pairs.Failed[invalidCpu], ErrInvalidCPU) || pairs.Failed[invalidLevel] == nil {
+		t.Fatalf("got %v", pairs.Failed)
+	}
+	if !errors.Is(err, ErrInvalidCPU) {
+		t.Fatalf("%v doesn't match the errors of the pairs", err)
+	}
+
+	// An error that affects every pair (here, no enable_sibling_cpu
+	// program) stops it at the first valid pair.
+	valid := SiblingPair{Level: 2, Cpu: 0, Sibling: 0}
+	if err := s.EnableSiblingCpus([]SiblingPair{invalidLevel, valid, invalidCpu}); !errors.Is(err, ErrProgNotFound) {
+		t.Fatalf("got %v, want ErrProgNotFound", err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.EnableSiblingCpus([]SiblingPair{valid}); !errors.Is(err, ErrClosed) {
+		t.Fatalf("got %v after Close, want ErrClosed", err)
+	}
+	if err := s.EnableSiblingCpus(nil); err != nil {
+		t.Fatalf("no pairs: %v", err)
+	}
+}
@@ -0,0 +1,42 @@
+package core
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// DefaultShutdownSignals are the signals handled by RunUntilSignal when no
+// signal is specified.
+var DefaultShutdownSignals = []os.Signal{syscall.SIGINT, syscall.SIGTERM}
+
+// RunUntilSignal blocks until one of signals is received (DefaultShutdownSignals
+// if none is given) or the BPF component exits, then detaches the struct_ops,
+// so that the kernel falls back to the default scheduler promptly instead of
+// waiting for the sched_ext watchdog.
+//
+// It returns the received signal, or nil if the BPF component exited.
+func (s *Sched) RunUntilSignal(signals ...os.Signal) (os.Signal, error) {
+	if len(signals) == 0 {
+		signals = DefaultShutdownSignals
+	}
+	signalChan := make(chan os.Signal, 1)
+	signal.Notify(signalChan, signals...)
+	defer signal.Stop(signalChan)
+
+	timer := time.NewTicker(1 * time.Second)
+	defer timer.Stop()
+	for {
+		select {
+		case sig := <-signalChan:
+			s.opts.Logger.Printf("receive os signal: %v", sig)
+			return sig, s.Detach()
+		case <-timer.C:
+			if s.Stopped() {
+				s.opts.Logger.Printf("bpfModule stopped")
+				return nil, s.Detach()
+			}
+		}
+	}
+}
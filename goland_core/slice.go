@@ -0,0 +1,49 @@
+package core
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// RemainingSlice returns how much of its time slice the task running on cpu
+// has left, in nanoseconds: 0 if cpu is idle or the slice is exhausted, and
+// math.MaxUint64 for an infinite slice. A scheduler can use it to avoid
+// preempting a task that is about to release the CPU anyway.
+func (s *Sched) RemainingSlice(cpu int32) (uint64, error) {
+	if err := checkCPU(cpu); err != nil {
+		return 0, err
+	}
+	if err := s.acquire(); err != nil {
+		return 0, err
+	}
+	defer s.release()
+	if s.cpuSliceEnd == nil {
+		return 0, mapNotFound("cpu_slice_end")
+	}
+	key := uint32(cpu)
+	b, err := s.cpuSliceEnd.GetValue(unsafe.Pointer(&key))
+	if err != nil {
+		return 0, fmt.Errorf("read cpu_slice_end: %w", err)
+	}
+	end := binary.NativeEndian.Uint64(b)
+	if end == 0 {
+		return 0, nil
+	}
+	if end == math.MaxUint64 {
+		return end, nil
+	}
+	// The BPF component uses bpf_ktime_get_ns(), that is CLOCK_MONOTONIC.
+	var ts unix.Timespec
+	if err := unix.ClockGettime(unix.CLOCK_MONOTONIC, &ts); err != nil {
+		return 0, err
+	}
+	now := uint64(ts.Nano())
+	if end <= now {
+		return 0, nil
+	}
+	return end - now, nil
+}
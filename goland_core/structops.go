@@ -0,0 +1,54 @@
+package core
+
+/*
+#include "wrapper.h"
+*/
+import "C"
+
+import "fmt"
+
+// Maximum watchdog timeout accepted by sched_ext (SCX_WATCHDOG_MAX_TIMEOUT).
+const maxOpsTimeoutMs = 30 * 1000
+
+// checkNotLoaded fails if the struct_ops can't be changed anymore: the fields
+// of the struct_ops map are copied to the kernel when the BPF object is
+// loaded by Start, before Attach.
+func (s *Sched) checkNotLoaded() error {
+	if err := s.acquire(); err != nil {
+		return err
+	}
+	defer s.release()
+	if s.link != nil {
+		return ErrAlreadyAttached
+	}
+	if s.structOps != nil {
+		return ErrAlreadyLoaded
+	}
+	return nil
+}
+
+// SetOpsTimeout sets the timeout of the sched_ext watchdog (ops.timeout_ms):
+// the scheduler is aborted if a runnable task doesn't run for ms
+// milliseconds. 0 selects the default of the kernel. It must be called
+// before Start.
+func (s *Sched) SetOpsTimeout(ms uint32) error {
+	if ms > maxOpsTimeoutMs {
+		return fmt.Errorf("ops timeout %dms exceeds the maximum of %dms", ms, maxOpsTimeoutMs)
+	}
+	if err := s.checkNotLoaded(); err != nil {
+		return err
+	}
+	C.set_ops_timeout(C.u32(ms))
+	return nil
+}
+
+// SetOpsFlags sets the flags of the struct_ops (ops.flags, see enum
+// scx_ops_flags), e.g. SCX_OPS_SWITCH_PARTIAL. The flags not supported by the
+// running kernel make Attach fail. It must be called before Start.
+func (s *Sched) SetOpsFlags(flags uint64) error {
+	if err := s.checkNotLoaded(); err != nil {
+		return err
+	}
+	C.set_ops_flags(C.u64(flags))
+	return nil
+}
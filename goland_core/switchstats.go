@@ -0,0 +1,52 @@
+package core
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// taskSwitchStatsSize is sizeof(struct task_switch_stats) (see intf.h).
+const taskSwitchStatsSize = 16
+
+// TaskSwitchStats are the context switches of a task counted by the BPF
+// component since the scheduler started managing it (see
+// intf.h::task_switch_stats). The counters follow the task across CPUs and
+// they are dropped when it exits.
+type TaskSwitchStats struct {
+	Voluntary   uint64 // The task stopped running to sleep
+	Involuntary uint64 // The task stopped running while runnable: slice expired or preempted
+}
+
+// GetTaskSwitchStats returns the context switches of the task pid. The same
+// counters are reported with every QueuedTask (Nvcsw and Nivcsw), without a
+// map lookup: this is for the tasks that are not being queued.
+//
+// A task that never stopped running since the scheduler started, and a task
+// evicted from the map (beyond 65536 tasks), report zero counters.
+func (s *Sched) GetTaskSwitchStats(pid int32) (TaskSwitchStats, error) {
+	if err := s.acquire(); err != nil {
+		return TaskSwitchStats{}, err
+	}
+	defer s.release()
+	if s.switchStats == nil {
+		return TaskSwitchStats{}, mapNotFound("task_switch_stats")
+	}
+	key := uint32(pid)
+	b, err := s.switchStats.GetValue(unsafe.Pointer(&key))
+	if errors.Is(err, unix.ENOENT) {
+		return TaskSwitchStats{}, nil
+	} else if err != nil {
+		return TaskSwitchStats{}, fmt.Errorf("read task_switch_stats: %w", err)
+	}
+	if len(b) != taskSwitchStatsSize {
+		return TaskSwitchStats{}, fmt.Errorf("task_switch_stats value size %d doesn't match %d", len(b), taskSwitchStatsSize)
+	}
+	return TaskSwitchStats{
+		Voluntary:   binary.NativeEndian.Uint64(b[0:8]),
+		Involuntary: binary.NativeEndian.Uint64(b[8:16]),
+	}, nil
+}
@@ -0,0 +1,121 @@
+package core
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// TaskTag is a tag assigned to a PID with SetTaskTag. The bits in TagUserMask
+// are free for the scheduler (e.g. to remember how it classified the task),
+// the others select the rules applied by the BPF component when the task is
+// enqueued, without a round trip to user space (see intf.h::TASK_TAG_RULES).
+type TaskTag uint64
+
+const (
+	// TagUserMask are the bits of the tag free for the scheduler.
+	TagUserMask TaskTag = 1<<60 - 1
+	// TagBypass dispatches the task directly on the shared DSQ.
+	TagBypass TaskTag = 1 << 63
+	// TagSticky dispatches the task directly on the DSQ of its previous
+	// CPU, if it can still run there.
+	TagSticky TaskTag = 1 << 62
+	// TagInteractive dispatches the task directly on an idle CPU, if
+	// there is one.
+	TagInteractive TaskTag = 1 << 61
+)
+
+// TagStats is the number of enqueues short-circuited by each tag rule (see
+// intf.h::task_tag_rule).
+type TagStats struct {
+	Bypass      uint64
+	Sticky      uint64
+	Interactive uint64
+}
+
+// SetTaskTag assigns tag to pid. Tags are checked after the priorities set
+// with SetTaskPriority, if more rules are set only the first one among
+// TagBypass, TagSticky and TagInteractive that applies is used. The tag is
+// dropped when the task exits.
+func (s *Sched) SetTaskTag(pid int32, tag TaskTag) error {
+	if err := checkTaskTag(tag); err != nil {
+		return err
+	}
+	if err := s.acquire(); err != nil {
+		return err
+	}
+	defer s.release()
+	if s.taskTags == nil {
+		return mapNotFound("task_tags")
+	}
+	key, val := uint32(pid), uint64(tag)
+	return s.taskTags.Update(unsafe.Pointer(&key), unsafe.Pointer(&val))
+}
+
+func checkTaskTag(tag TaskTag) error {
+	if tag&^TagUserMask&^(TagBypass|TagSticky|TagInteractive) != 0 {
+		return fmt.Errorf("invalid task tag: %#x", uint64(tag))
+	}
+	return nil
+}
+
+// GetTaskTag returns the tag assigned to pid, and false if it has no tag.
+func (s *Sched) GetTaskTag(pid int32) (TaskTag, bool, error) {
+	if err := s.acquire(); err != nil {
+		return 0, false, err
+	}
+	defer s.release()
+	if s.taskTags == nil {
+		return 0, false, mapNotFound("task_tags")
+	}
+	key := uint32(pid)
+	b, err := s.taskTags.GetValue(unsafe.Pointer(&key))
+	if errors.Is(err, unix.ENOENT) {
+		return 0, false, nil
+	} else if err != nil {
+		return 0, false, err
+	}
+	return TaskTag(binary.NativeEndian.Uint64(b)), true, nil
+}
+
+// ClearTaskTag removes the tag assigned to pid, if any.
+func (s *Sched) ClearTaskTag(pid int32) error {
+	if err := s.acquire(); err != nil {
+		return err
+	}
+	defer s.release()
+	if s.taskTags == nil {
+		return mapNotFound("task_tags")
+	}
+	key := uint32(pid)
+	err := s.taskTags.DeleteKey(unsafe.Pointer(&key))
+	if errors.Is(err, unix.ENOENT) {
+		return nil
+	}
+	return err
+}
+
+// GetTagStats returns the number of enqueues short-circuited by each tag
+// rule.
+func (s *Sched) GetTagStats() (TagStats, error) {
+	if err := s.acquire(); err != nil {
+		return TagStats{}, err
+	}
+	defer s.release()
+	if s.tagStats == nil {
+		return TagStats{}, mapNotFound("tag_stats")
+	}
+	var nr [3]uint64
+	for i := range nr {
+		key := uint32(i)
+		b, err := s.tagStats.GetValue(unsafe.Pointer(&key))
+		if err != nil {
+			return TagStats{}, fmt.Errorf("read tag_stats: %w", err)
+		}
+		nr[i] = binary.NativeEndian.Uint64(b)
+	}
+	return TagStats{Bypass: nr[0], Sticky: nr[1], Interactive: nr[2]}, nil
+}
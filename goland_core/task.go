@@ -2,27 +2,134 @@ package core
 
 import (
 	"context"
-	"encoding/binary"
+	"errors"
 	"fmt"
-	"log"
 	"os"
 	"strconv"
 	"strings"
-	"unsafe"
 )
 
 // Task queued for scheduling from the BPF component (see bpf_intf::queued_task_ctx).
 type QueuedTask struct {
-	Pid            int32  // pid that uniquely identifies a task
-	Cpu            int32  // CPU where the task is running
-	NrCpusAllowed  uint64 // Number of CPUs that the task can use
-	Flags          uint64 // task enqueue flags
-	StartTs        uint64 // Timestamp since last time the task ran on a CPU
-	StopTs         uint64 // Timestamp since last time the task released a CPU
-	SumExecRuntime uint64 // Total cpu time
-	Weight         uint64 // Task static priority
-	Vtime          uint64 // Current vruntime
-	Tgid           int32  // Task group id
+	Pid            int32   // pid that uniquely identifies a task
+	Cpu            int32   // CPU assigned by the kernel (scx_bpf_task_cpu(): ops.select_cpu() pick on wakeup, see intf.h)
+	NrCpusAllowed  uint64  // Number of CPUs that the task can use
+	Flags          uint64  // task enqueue flags
+	StartTs        uint64  // Timestamp since last time the task ran on a CPU
+	StopTs         uint64  // Timestamp since last time the task released a CPU
+	SumExecRuntime uint64  // Total cpu time
+	Weight         uint64  // Task weight (p->scx.weight, 1..10000, 100 = nice 0)
+	Vtime          uint64  // Current vruntime
+	Tgid           int32   // Task group id
+	Nice           int32   // Task static nice value (-20..19)
+	RuntimeNs      uint64  // CPU time used since the task was last queued (a delta, not a total)
+	AllowedCpus    CpuMask // CPUs that the task can use (p->cpus_ptr)
+	PrevCpu        int32   // CPU where the task ran last, tracked in ops.running() (-1 = never ran)
+	Comm           string  // Task command name (p->comm)
+	WakerCpu       int32   // CPU of the task that woke it up (-1 = not a wakeup)
+	WakeFlags      uint64  // SCX_WAKE_* flags of the wakeup (see WakeSync)
+	// MigrationCount is how many times the task started running on a CPU
+	// different from the previous one. It counts from when the scheduler
+	// started managing the task and it is dropped when the task exits: a
+	// new task with the same pid starts from 0.
+	MigrationCount uint64
+	// RecentCpus are the CPUs where the task ran recently: up to the last
+	// 4 distinct CPUs, each one kept for 50ms after the task stopped
+	// running there (see intf.h::RECENT_CPUS_WINDOW_NS), when its data is
+	// likely still in the CPU caches. It is a soft preference, unlike
+	// AllowedCpus: the task may not be allowed to run on these CPUs
+	// anymore.
+	RecentCpus CpuMask
+	// CgroupId is the id of the cgroup v2 of the task (see CgroupID).
+	CgroupId uint64
+	// EnqueueTs is the time (bpf_ktime_get_ns(), CLOCK_MONOTONIC) when
+	// the task has been queued to the user-space scheduler.
+	EnqueueTs uint64
+	// Nvcsw and Nivcsw are the voluntary (the task went to sleep) and
+	// involuntary (its time slice expired or it has been preempted)
+	// context switches of the task since the scheduler started managing
+	// it (see Sched.GetTaskSwitchStats).
+	Nvcsw  uint64
+	Nivcsw uint64
+	// IsKthread is true for the kernel threads (PF_KTHREAD in p->flags,
+	// read by the BPF component when the task is queued), including the
+	// per-CPU kworkers, that can only run on one CPU (NrCpusAllowed 1).
+	// Kernel threads often do work other tasks wait for: schedulers
+	// usually dispatch them right away, on their CPU, and never throttle
+	// them.
+	IsKthread bool
+	// CpuSelected is true if the BPF component already picked an idle
+	// CPU for the task when it woke up (see Sched.SetFastSelect), in
+	// Cpu: SelectCPU returns it without running the rs_select_cpu prog.
+	// It is false for the tasks that went through the user-space path.
+	CpuSelected bool
+	// RunningTs is the time (bpf_ktime_get_ns(), CLOCK_MONOTONIC) when
+	// the task last started running, 0 if it never ran. Unlike StartTs,
+	// that comes from the rq clock, it can be compared with EnqueueTs.
+	RunningTs uint64
+	// Deadline and Runtime are the absolute deadline (ns) and the runtime
+	// budget (ns) requested for the task by an EDF policy. They are not
+	// reported by the BPF component: the scheduler sets them, e.g. from
+	// its per-task parameters, before ordering the tasks with EDFQueue.
+	Deadline uint64
+	Runtime  uint64
+}
+
+// CanRunOn returns true if the task is allowed to run on cpu. If AllowedCpus
+// is empty (not reported by the BPF component) any CPU is considered allowed.
+func (t *QueuedTask) CanRunOn(cpu int) bool {
+	if t.AllowedCpus.Empty() {
+		return cpu >= 0 && cpu < MAX_CPUS
+	}
+	return t.AllowedCpus.Test(cpu)
+}
+
+// EnqueueReason tells why a task has been queued to the user-space
+// scheduler.
+type EnqueueReason int
+
+const (
+	// ReasonRequeue: the task was running and it's still runnable, because
+	// its time slice expired, it has been preempted or it yielded.
+	ReasonRequeue EnqueueReason = iota
+	// ReasonWakeup: the task woke up after sleeping.
+	ReasonWakeup
+	// ReasonNew: first enqueue of the task since it has been created (it
+	// never ran). Note that exec() doesn't create a new task, so it can't
+	// be told apart from the enqueue flags.
+	ReasonNew
+	// ReasonReenqueue: the task has been re-enqueued by the kernel, e.g.
+	// because its CPU has been taken by a higher priority sched_class.
+	ReasonReenqueue
+)
+
+func (r EnqueueReason) String() string {
+	switch r {
+	case ReasonRequeue:
+		return "requeue"
+	case ReasonWakeup:
+		return "wakeup"
+	case ReasonNew:
+		return "new"
+	case ReasonReenqueue:
+		return "reenqueue"
+	}
+	return fmt.Sprintf("EnqueueReason(%d)", int(r))
+}
+
+// Reason decodes why the task has been queued from its enqueue flags and
+// from PrevCpu.
+func (t *QueuedTask) Reason() EnqueueReason {
+	flags := EnqFlags(t.Flags)
+	switch {
+	case flags&EnqReenq != 0:
+		return ReasonReenqueue
+	case t.PrevCpu < 0:
+		return ReasonNew
+	case flags&EnqWakeup != 0:
+		return ReasonWakeup
+	}
+	return ReasonRequeue
 }
 
 func (s *Sched) BlockTilReadyForDequeue(ctx context.Context) {
@@ -52,19 +159,31 @@ func (s *Sched) ReadyForDequeue() bool {
 }
 
 func (s *Sched) DequeueTask(task *QueuedTask) {
+	if start := s.phases.begin(PhaseDequeue); !start.IsZero() {
+		defer s.phases.end(PhaseDequeue, start)
+	}
 	select {
 	case t := <-s.queue:
-		err := fastDecode(t, task)
-		if err != nil {
+		s.noteQueueDepth(len(s.queue) + 1)
+		if err := fastDecode(t, task); err != nil {
 			task.Pid = -1
+			s.opts.Logger.Printf("dropping queued record: %v", err)
+			s.dropQueued(1)
 			return
 		}
-		err = s.SubNrQueued()
-		if err != nil {
+		if err := s.SubNrQueued(); err != nil {
 			task.Pid = -1
-			log.Printf("SubNrQueued err: %v", err)
+			if !errors.Is(err, ErrClosed) {
+				s.opts.Logger.Printf("SubNrQueued err: %v", err)
+			}
 			return
 		}
+		if s.opts.TraceHook != nil {
+			s.traceDequeued(task)
+		}
+		if s.audit != nil {
+			s.audit.dequeued(task)
+		}
 		return
 	default:
 		task.Pid = -1
@@ -80,56 +199,100 @@ type DispatchedTask struct {
 	SliceNs    uint64 // time slice assigned to the task (0 = default)
 	Vtime      uint64 // task deadline / vruntime
 	CpuMaskCnt uint64 // cpumask generation counter (private)
+	// Sticky keeps the task on the CPU it used last, for cache warmth.
+	// It only applies when Cpu is RL_CPU_ANY: an explicit target CPU
+	// always wins. If the task is not allowed to run on its last CPU
+	// anymore it is dispatched as if Sticky was false.
+	Sticky bool
+	// Dsq, if not 0, is the LLC queue where the task is dispatched (see
+	// SetupPerLLCQueues), instead of Cpu.
+	Dsq uint64
+	// Deadline, if not 0, is the absolute deadline (ns) assigned to the
+	// task, sent as Vtime: the DSQs are ordered by vtime, so tasks with
+	// earlier deadlines run first. It overrides Vtime.
+	Deadline uint64
+	// Runtime, if not 0, is the runtime budget assigned to the task, sent
+	// as the time slice if SliceNs is 0.
+	Runtime uint64
+
+	// pooled is the task itself if it was allocated by
+	// Sched.NewDispatchedTask: a copy of a pooled task is not pooled.
+	pooled *DispatchedTask
 }
 
-// NewDispatchedTask creates a DispatchedTask from a QueuedTask.
+// NewDispatchedTask creates a DispatchedTask from a QueuedTask. If the task
+// can't run on its current CPU anymore it is dispatched to RL_CPU_ANY.
 func NewDispatchedTask(task *QueuedTask) *DispatchedTask {
+	cpu := task.Cpu
+	if !task.CanRunOn(int(cpu)) {
+		cpu = RL_CPU_ANY
+	}
 	return &DispatchedTask{
-		Pid:     task.Pid,
-		Cpu:     task.Cpu,
-		Flags:   task.Flags,
-		SliceNs: 0, // use default time slice
-		Vtime:   0,
+		Pid:      task.Pid,
+		Cpu:      cpu,
+		Flags:    task.Flags,
+		SliceNs:  0, // use default time slice
+		Vtime:    0,
+		Deadline: task.Deadline,
+		Runtime:  task.Runtime,
 	}
 }
 
-func (s *Sched) DispatchTask(t *DispatchedTask) error {
-	if err := s.urb.Error(); err != nil {
-		return err
+// NewDispatchedTask returns a zeroed DispatchedTask from a pool owned by s,
+// to avoid an allocation per dispatch in the scheduling loop. The task goes
+// back to the pool once it has been sent by DispatchTask or TryDispatchTask,
+// so it must not be used after a successful dispatch. The tasks allocated in
+// any other way, e.g. by the package-level NewDispatchedTask, are never
+// recycled and stay owned by the caller.
+func (s *Sched) NewDispatchedTask() *DispatchedTask {
+	if t, ok := s.taskPool.Get().(*DispatchedTask); ok {
+		return t
 	}
-	s.dispatch <- fastEncode(t)
-	return nil
+	t := &DispatchedTask{}
+	t.pooled = t
+	return t
 }
 
-func fastDecode(data []byte, task *QueuedTask) error {
-	if len(data) < int(unsafe.Sizeof(QueuedTask{})) {
-		return fmt.Errorf("data length is less than QueuedTask size")
-	}
-	task.Pid = int32(binary.LittleEndian.Uint32(data[0:4]))
-	task.Cpu = int32(binary.LittleEndian.Uint32(data[4:8]))
-	task.NrCpusAllowed = binary.LittleEndian.Uint64(data[8:16])
-	task.Flags = binary.LittleEndian.Uint64(data[16:24])
-	task.StartTs = binary.LittleEndian.Uint64(data[24:32])
-	task.StopTs = binary.LittleEndian.Uint64(data[32:40])
-	task.SumExecRuntime = binary.LittleEndian.Uint64(data[40:48])
-	task.Weight = binary.LittleEndian.Uint64(data[48:56])
-	task.Vtime = binary.LittleEndian.Uint64(data[56:64])
-	task.Tgid = int32(binary.LittleEndian.Uint32(data[64:68]))
+// putDispatchedTask returns t to the pool if it was allocated by
+// Sched.NewDispatchedTask.
+func (s *Sched) putDispatchedTask(t *DispatchedTask) {
+	if t.pooled != t {
+		return
+	}
+	*t = DispatchedTask{pooled: t}
+	s.taskPool.Put(t)
+}
 
+// DispatchTask sends t to the BPF component, blocking while the dispatch
+// buffer is full. It is a shorthand for ReserveDispatch, followed by Commit.
+func (s *Sched) DispatchTask(t *DispatchedTask) error {
+	if start := s.phases.begin(PhaseDispatch); !start.IsZero() {
+		defer s.phases.end(PhaseDispatch, start)
+	}
+	slot, err := s.ReserveDispatch()
+	if err != nil {
+		return err
+	}
+	slot.set(t)
+	slot.Commit()
+	s.putDispatchedTask(t)
 	return nil
 }
 
-func fastEncode(t *DispatchedTask) []byte {
-	data := make([]byte, 8*8) // 64 bytes
-
-	binary.LittleEndian.PutUint32(data[0:4], uint32(t.Pid))
-	binary.LittleEndian.PutUint32(data[4:8], uint32(t.Cpu))
-	binary.LittleEndian.PutUint64(data[8:16], t.Flags)
-	binary.LittleEndian.PutUint64(data[16:24], t.SliceNs)
-	binary.LittleEndian.PutUint64(data[24:32], t.Vtime)
-	binary.LittleEndian.PutUint64(data[32:40], t.CpuMaskCnt)
-
-	return data
+// TryDispatchTask is like DispatchTask, but returns ErrDispatchBufferFull
+// instead of blocking if the dispatch buffer is full.
+func (s *Sched) TryDispatchTask(t *DispatchedTask) error {
+	if start := s.phases.begin(PhaseDispatch); !start.IsZero() {
+		defer s.phases.end(PhaseDispatch, start)
+	}
+	slot, err := s.tryReserveDispatch()
+	if err != nil {
+		return err
+	}
+	slot.set(t)
+	slot.Commit()
+	s.putDispatchedTask(t)
+	return nil
 }
 
 func IsSMTActive() (bool, error) {
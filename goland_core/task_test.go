@@ -0,0 +1,78 @@
+package core
+
+import "testing"
+
+func TestDispatchedTaskPool(t *testing.T) {
+	s := &Sched{}
+
+	// The tasks of the package-level NewDispatchedTask stay owned by the
+	// caller.
+	owned := NewDispatchedTask(&QueuedTask{Pid: 10, Cpu: 1})
+	s.putDispatchedTask(owned)
+	if owned.Pid != 10 {
+		t.Fatalf("task of NewDispatchedTask recycled: %+v", owned)
+	}
+
+	// So do the copies of a pooled task.
+	pooled := s.NewDispatchedTask()
+	pooled.Pid = 11
+	cp := *pooled
+	s.putDispatchedTask(&cp)
+	if cp.Pid != 11 {
+		t.Fatalf("copy of a pooled task recycled: %+v", cp)
+	}
+
+	s.putDispatchedTask(pooled)
+	if pooled.Pid != 0 || pooled.pooled != pooled {
+		t.Fatalf("pooled task not reset: %+v", pooled)
+	}
+}
+
+func BenchmarkNewDispatchedTask(b *testing.B) {
+	q := &QueuedTask{Pid: 10, Cpu: RL_CPU_ANY}
+	var sink *DispatchedTask
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		sink = NewDispatchedTask(q)
+	}
+	_ = sink
+}
+
+func BenchmarkSchedNewDispatchedTask(b *testing.B) {
+	s := &Sched{}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		t := s.NewDispatchedTask()
+		t.Pid = 10
+		t.Cpu = RL_CPU_ANY
+		// As DispatchTask does once the task is sent.
+		s.putDispatchedTask(t)
+	}
+}
+
+func TestDequeueTaskUndecodable(t *testing.T) {
+	var log recordLogger
+	s := &Sched{
+		done:  make(chan struct{}),
+		opts:  LoadSchedOpts{Logger: &log},
+		queue: make(chan []byte, 2),
+	}
+	s.queue <- make([]byte, queuedTaskSize-1)
+	s.queue <- GenerateQueuedTasks(1)[0]
+
+	var task QueuedTask
+	s.DequeueTask(&task)
+	if task.Pid != -1 {
+		t.Fatalf("truncated record dequeued as task %d", task.Pid)
+	}
+	if len(log.msgs) != 1 {
+		t.Fatalf("got %d log messages, want 1", len(log.msgs))
+	}
+	s.DequeueTask(&task)
+	if task.Pid == -1 {
+		t.Fatalf("valid record dropped")
+	}
+	if got := s.Health().DroppedQueued; got != 1 {
+		t.Fatalf("DroppedQueued = %d, want 1", got)
+	}
+}
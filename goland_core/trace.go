@@ -0,0 +1,137 @@
+package core
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"golang.org/x/sys/unix"
+)
+
+// TraceHook receives the stages of the scheduling of individual tasks, e.g.
+// to build a trace span per scheduling decision (see otlptrace.Tracer). It is
+// set with LoadSchedOpts.TraceHook.
+//
+// Each time a task is dequeued, Sample decides if it is traced until it runs
+// again: the other callbacks are only called for the sampled tasks, so the
+// decision must be cheap. The timestamps are CLOCK_MONOTONIC ns, the clock of
+// bpf_ktime_get_ns(): the kernel stages are timestamped by the BPF component
+// and carried through the records.
+//
+// The callbacks are called from the scheduling loop, they must not block.
+type TraceHook interface {
+	// Sample returns true if the scheduling of t must be traced.
+	Sample(t *QueuedTask) bool
+	// Queued: the BPF component queued the task to user space.
+	Queued(pid int32, ts uint64)
+	// Dequeued: the task has been read by DequeueTask.
+	Dequeued(pid int32, ts uint64)
+	// Selected: SelectCPU picked cpu (RL_CPU_ANY if none) for the task.
+	Selected(pid int32, cpu int32, ts uint64)
+	// Dispatched: the task has been sent to the BPF component to run on
+	// cpu (RL_CPU_ANY = the first CPU available).
+	Dispatched(pid int32, cpu int32, ts uint64)
+	// Running: the task started running on cpu. It is reported when the
+	// task is queued again (QueuedTask.RunningTs), and never if it exits
+	// first.
+	Running(pid int32, cpu int32, ts uint64)
+}
+
+// ktimeNow returns the current time of the clock used by the BPF component,
+// bpf_ktime_get_ns(), that is CLOCK_MONOTONIC.
+func ktimeNow() uint64 {
+	var ts unix.Timespec
+	if err := unix.ClockGettime(unix.CLOCK_MONOTONIC, &ts); err != nil {
+		return 0
+	}
+	return uint64(ts.Nano())
+}
+
+// pidSet is a set of pids with a lock-free emptiness check, so that the
+// scheduling loop doesn't take the lock while no task is traced.
+type pidSet struct {
+	n    atomic.Int32
+	mu   sync.Mutex
+	pids map[int32]struct{}
+}
+
+func (ps *pidSet) add(pid int32) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	if ps.pids == nil {
+		ps.pids = make(map[int32]struct{})
+	}
+	if _, ok := ps.pids[pid]; !ok {
+		ps.pids[pid] = struct{}{}
+		ps.n.Add(1)
+	}
+}
+
+// remove removes pid from the set, returning true if it was there.
+func (ps *pidSet) remove(pid int32) bool {
+	if ps.n.Load() == 0 {
+		return false
+	}
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	if _, ok := ps.pids[pid]; !ok {
+		return false
+	}
+	delete(ps.pids, pid)
+	ps.n.Add(-1)
+	return true
+}
+
+func (ps *pidSet) has(pid int32) bool {
+	if ps.n.Load() == 0 {
+		return false
+	}
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	_, ok := ps.pids[pid]
+	return ok
+}
+
+// traceState tracks the tasks sampled by the TraceHook.
+type traceState struct {
+	// active are the sampled tasks between DequeueTask and their
+	// dispatch, waiting are the dispatched ones that didn't run yet.
+	active  pidSet
+	waiting pidSet
+}
+
+// traceDequeued reports the stages of t up to its dequeue if it's sampled,
+// and the start of the previous run if it was traced.
+func (s *Sched) traceDequeued(t *QueuedTask) {
+	hook := s.opts.TraceHook
+	if s.trace.waiting.remove(t.Pid) {
+		hook.Running(t.Pid, t.PrevCpu, t.RunningTs)
+	}
+	if !hook.Sample(t) {
+		s.trace.active.remove(t.Pid)
+		return
+	}
+	s.trace.active.add(t.Pid)
+	hook.Queued(t.Pid, t.EnqueueTs)
+	hook.Dequeued(t.Pid, ktimeNow())
+}
+
+// traceSelected reports the CPU picked for pid if it's traced.
+func (s *Sched) traceSelected(pid, cpu int32) {
+	if s.trace.active.has(pid) {
+		s.opts.TraceHook.Selected(pid, cpu, ktimeNow())
+	}
+}
+
+// traceDispatched reports the dispatch of pid if it's traced.
+func (s *Sched) traceDispatched(pid, cpu int32) {
+	if s.trace.active.remove(pid) {
+		s.trace.waiting.add(pid)
+		s.opts.TraceHook.Dispatched(pid, cpu, ktimeNow())
+	}
+}
+
+// traceExited forgets pid, that exited.
+func (s *Sched) traceExited(pid int32) {
+	s.trace.active.remove(pid)
+	s.trace.waiting.remove(pid)
+}
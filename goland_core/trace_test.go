@@ -0,0 +1,78 @@
+package core
+
+import (
+	"fmt"
+	"testing"
+)
+
+// recordHook is a TraceHook that keeps the stages it receives.
+type recordHook struct {
+	sample bool
+	stages []string
+}
+
+func (h *recordHook) Sample(t *QueuedTask) bool { return h.sample }
+
+func (h *recordHook) Queued(pid int32, ts uint64) {
+	h.stages = append(h.stages, fmt.Sprintf("queued %d %d", pid, ts))
+}
+
+func (h *recordHook) Dequeued(pid int32, ts uint64) {
+	h.stages = append(h.stages, fmt.Sprintf("dequeued %d", pid))
+}
+
+func (h *recordHook) Selected(pid int32, cpu int32, ts uint64) {
+	h.stages = append(h.stages, fmt.Sprintf("selected %d %d", pid, cpu))
+}
+
+func (h *recordHook) Dispatched(pid int32, cpu int32, ts uint64) {
+	h.stages = append(h.stages, fmt.Sprintf("dispatched %d %d", pid, cpu))
+}
+
+func (h *recordHook) Running(pid int32, cpu int32, ts uint64) {
+	h.stages = append(h.stages, fmt.Sprintf("running %d %d %d", pid, cpu, ts))
+}
+
+func TestTraceStages(t *testing.T) {
+	hook := &recordHook{sample: true}
+	s := &Sched{opts: LoadSchedOpts{TraceHook: hook}}
+
+	task := &QueuedTask{Pid: 10, EnqueueTs: 100, PrevCpu: -1, StartTs: 7}
+	s.traceDequeued(task)
+	s.traceSelected(10, 2)
+	s.traceSelected(11, 3) // not traced
+	s.traceDispatched(10, 2)
+	// The next time the task is queued, it reports when it ran, with
+	// the clock of EnqueueTs, not the rq clock of StartTs.
+	hook.sample = false
+	task = &QueuedTask{Pid: 10, EnqueueTs: 300, PrevCpu: 2, StartTs: 9, RunningTs: 200}
+	s.traceDequeued(task)
+	s.traceDispatched(10, 2)
+
+	want := []string{
+		"queued 10 100",
+		"dequeued 10",
+		"selected 10 2",
+		"dispatched 10 2",
+		"running 10 2 200",
+	}
+	if fmt.Sprint(hook.stages) != fmt.Sprint(want) {
+		t.Fatalf("got %q, want %q", hook.stages, want)
+	}
+}
+
+func TestTraceExited(t *testing.T) {
+	hook := &recordHook{sample: true}
+	s := &Sched{opts: LoadSchedOpts{TraceHook: hook}}
+
+	s.traceDequeued(&QueuedTask{Pid: 10, EnqueueTs: 100})
+	s.traceDispatched(10, 1)
+	s.traceExited(10)
+	hook.sample = false
+	s.traceDequeued(&QueuedTask{Pid: 10, EnqueueTs: 300, RunningTs: 200})
+	for _, stage := range hook.stages {
+		if stage == "running 10 1 200" {
+			t.Fatalf("exited task reported running: %q", hook.stages)
+		}
+	}
+}
@@ -6,7 +6,6 @@ import (
 	"bytes"
 	"encoding/binary"
 	"fmt"
-	"log"
 	"unsafe"
 
 	bpf "github.com/aquasecurity/libbpfgo"
@@ -32,17 +31,21 @@ type UeiMap struct {
 func (s *Sched) Stopped() bool {
 	uei, err := s.GetUeiData()
 	if err != nil {
-		log.Printf("uei: %v", err)
+		s.opts.Logger.Printf("uei: %v", err)
 		return true
 	}
 	if uei.Kind != 0 || uei.ExitCode != 0 {
-		log.Printf("uei.kind %v, uei.ExitCode: %v", uei.Kind, uei.ExitCode)
+		s.opts.Logger.Printf("uei.kind %v, uei.ExitCode: %v", uei.Kind, uei.ExitCode)
 		return true
 	}
 	return false
 }
 
 func (s *Sched) GetUeiData() (UserExitInfo, error) {
+	if err := s.acquire(); err != nil {
+		return UserExitInfo{}, err
+	}
+	defer s.release()
 	if s.uei == nil {
 		return UserExitInfo{}, fmt.Errorf("UeiMap is nil")
 	}
@@ -53,7 +56,7 @@ func (s *Sched) GetUeiData() (UserExitInfo, error) {
 	}
 	var uei UserExitInfo
 	buff := bytes.NewBuffer(b)
-	err = binary.Read(buff, binary.LittleEndian, &uei)
+	err = binary.Read(buff, binary.NativeEndian, &uei)
 	if err != nil {
 		return UserExitInfo{}, err
 	}
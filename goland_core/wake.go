@@ -0,0 +1,244 @@
+package core
+
+import (
+	"fmt"
+	"math/bits"
+	"path/filepath"
+	"sort"
+)
+
+// Wakeup flags reported in QueuedTask.WakeFlags (see enum scx_wake_flags).
+const (
+	WakeFork = 1 << 2 // SCX_WAKE_FORK: wakeup after fork
+	WakeTtwu = 1 << 3 // SCX_WAKE_TTWU: wakeup of a sleeping task
+	// WakeSync: the waker is going to release its CPU right after the
+	// wakeup (e.g. a pipe or futex handoff).
+	WakeSync = 1 << 4 // SCX_WAKE_SYNC
+)
+
+// Topology describes the CPUs that share a core (SMT siblings) or a last
+// level cache, and the capacity of the CPUs, see ReadTopology.
+type Topology struct {
+	Smt map[int32]CpuMask // CPUs of the core of each CPU, including itself
+	Llc map[int32]CpuMask // CPUs sharing the last level cache of each CPU
+	// Capacity of each CPU, from 1 to CPUPerfMax for the most capable
+	// CPUs of the system.
+	Capacity map[int32]uint32
+	// Tiers groups the CPUs with similar capacity, from the most capable
+	// (tier 0, e.g. the P-cores of a hybrid CPU) to the least capable.
+	// Systems with uniform capacity have a single tier.
+	Tiers []CpuMask
+}
+
+// tierGap is how much smaller (in percent) than the most capable CPU of a
+// tier the capacity of a CPU must be to start a new tier: the cores of the
+// same kind may report slightly different frequencies.
+const tierGap = 10
+
+// ReadTopology reads the topology of the online CPUs from sysfs. CPUs without
+// SMT information are considered to be alone in their core.
+func ReadTopology() (*Topology, error) {
+	llcs, err := cpuLLCs()
+	if err != nil {
+		return nil, err
+	}
+	topo := &Topology{
+		Smt: make(map[int32]CpuMask),
+		Llc: make(map[int32]CpuMask),
+	}
+	byLlc := make(map[int]CpuMask)
+	for cpu, llc := range llcs {
+		if cpu >= MAX_CPUS {
+			continue
+		}
+		mask := byLlc[llc]
+		mask.Set(cpu)
+		byLlc[llc] = mask
+
+		smt, err := readCpuList(fmt.Sprintf("cpu%d/topology/thread_siblings_list", cpu))
+		if err != nil || !smt.Test(cpu) {
+			smt = CpuMask{}
+			smt.Set(cpu)
+		}
+		topo.Smt[int32(cpu)] = smt
+	}
+	for cpu, llc := range llcs {
+		if cpu < MAX_CPUS {
+			topo.Llc[int32(cpu)] = byLlc[llc]
+		}
+	}
+	topo.Capacity = readCapacities(topo.Smt)
+	topo.Tiers = capacityTiers(topo.Capacity)
+	return topo, nil
+}
+
+// readCapacities reads the capacity of each CPU from cpu_capacity (ARM and
+// hybrid x86), or from the maximum frequency of the CPUs. If neither is
+// available, all the CPUs get the same capacity.
+func readCapacities(cpus map[int32]CpuMask) map[int32]uint32 {
+	for _, file := range []string{"cpu_capacity", "cpufreq/cpuinfo_max_freq"} {
+		raw := make(map[int32]uint32, len(cpus))
+		var top uint32
+		for cpu := range cpus {
+			v, err := readInt(filepath.Join(sysCpuPath, fmt.Sprintf("cpu%d", cpu), file))
+			if err != nil || v <= 0 {
+				break
+			}
+			raw[cpu] = uint32(v)
+			top = max(top, uint32(v))
+		}
+		if len(raw) != len(cpus) {
+			continue
+		}
+		// Scale to CPUPerfMax for the most capable CPU.
+		for cpu, v := range raw {
+			raw[cpu] = max(uint32(uint64(v)*CPUPerfMax/uint64(top)), 1)
+		}
+		return raw
+	}
+	capacity := make(map[int32]uint32, len(cpus))
+	for cpu := range cpus {
+		capacity[cpu] = CPUPerfMax
+	}
+	return capacity
+}
+
+// capacityTiers groups the CPUs by capacity, see Topology.Tiers.
+func capacityTiers(capacity map[int32]uint32) []CpuMask {
+	cpus := make([]int32, 0, len(capacity))
+	for cpu := range capacity {
+		cpus = append(cpus, cpu)
+	}
+	sort.Slice(cpus, func(i, j int) bool {
+		if capacity[cpus[i]] != capacity[cpus[j]] {
+			return capacity[cpus[i]] > capacity[cpus[j]]
+		}
+		return cpus[i] < cpus[j]
+	})
+	var tiers []CpuMask
+	var top uint32
+	for _, cpu := range cpus {
+		c := capacity[cpu]
+		if len(tiers) == 0 || uint64(c)*100 < uint64(top)*(100-tierGap) {
+			tiers = append(tiers, CpuMask{})
+			top = c
+		}
+		tiers[len(tiers)-1].Set(int(cpu))
+	}
+	return tiers
+}
+
+// CPUsInTier returns the CPUs of the capacity tier (0 = most capable), or an
+// empty mask if there is no such tier.
+func (t *Topology) CPUsInTier(tier int) CpuMask {
+	if tier < 0 || tier >= len(t.Tiers) {
+		return CpuMask{}
+	}
+	return t.Tiers[tier]
+}
+
+// Tier returns the capacity tier of cpu, or -1 if it is unknown.
+func (t *Topology) Tier(cpu int32) int {
+	for i := range t.Tiers {
+		if t.Tiers[i].Test(int(cpu)) {
+			return i
+		}
+	}
+	return -1
+}
+
+// CapacityOf returns the capacity of cpu, from 1 to CPUPerfMax for the most
+// capable CPUs of the system (see Topology.Capacity). The CPUs without
+// capacity information get CPUPerfMax, as all the CPUs of a system with
+// uniform capacity.
+func (t *Topology) CapacityOf(cpu int) int {
+	if c, ok := t.Capacity[int32(cpu)]; ok {
+		return int(c)
+	}
+	return CPUPerfMax
+}
+
+// PreferTier returns a CPU of candidates (e.g. the idle CPUs) that task can
+// use, looking first in the capacity tier and then in the closest tiers,
+// preferring the more capable one on a tie. It returns -1 if none of the
+// candidates can be used.
+func (t *Topology) PreferTier(task *QueuedTask, candidates CpuMask, tier int) int32 {
+	tier = min(max(tier, 0), len(t.Tiers)-1)
+	for d := 0; d < len(t.Tiers); d++ {
+		for _, i := range []int{tier - d, tier + d} {
+			if i < 0 || i >= len(t.Tiers) {
+				continue
+			}
+			if cpu := pickIdle(task, &t.Tiers[i], &candidates); cpu >= 0 {
+				return cpu
+			}
+		}
+	}
+	return -1
+}
+
+// SuggestWakeCPU picks a CPU for a task that woke up, among the idle CPUs,
+// close to the CPU where it is likely to find its data in cache: the CPU of
+// the waker for a sync wakeup (t.WakeFlags has WakeSync), its previous CPU
+// otherwise. It tries that CPU, then its SMT siblings, then the other CPUs
+// where the task ran recently (t.RecentCpus), then the CPUs of its LLC, and
+// finally any idle CPU that the task can use.
+//
+// If no usable CPU is idle, it returns the waker's CPU for a sync wakeup
+// (that is going to be released) and RL_CPU_ANY otherwise. topo can be nil,
+// to skip the topology lookups.
+func SuggestWakeCPU(t *QueuedTask, topo *Topology, idle CpuMask) int32 {
+	sync := t.WakerCpu >= 0 && t.WakeFlags&WakeSync != 0
+	target := t.PrevCpu
+	if sync {
+		target = t.WakerCpu
+	}
+	if target < 0 {
+		target = t.Cpu
+	}
+
+	if idle.Test(int(target)) && t.CanRunOn(int(target)) {
+		return target
+	}
+	if topo != nil {
+		if mask, ok := topo.Smt[target]; ok {
+			if cpu := pickIdle(t, &mask, &idle); cpu >= 0 {
+				return cpu
+			}
+		}
+	}
+	if cpu := pickIdle(t, &t.RecentCpus, &idle); cpu >= 0 {
+		return cpu
+	}
+	if topo != nil {
+		if mask, ok := topo.Llc[target]; ok {
+			if cpu := pickIdle(t, &mask, &idle); cpu >= 0 {
+				return cpu
+			}
+		}
+	}
+	if cpu := pickIdle(t, nil, &idle); cpu >= 0 {
+		return cpu
+	}
+	if sync && t.CanRunOn(int(target)) {
+		return target
+	}
+	return RL_CPU_ANY
+}
+
+// pickIdle returns the first CPU of mask (nil = all) that is idle and usable
+// by t, or -1.
+func pickIdle(t *QueuedTask, mask, idle *CpuMask) int32 {
+	for i, w := range idle {
+		if mask != nil {
+			w &= mask[i]
+		}
+		for ; w != 0; w &= w - 1 {
+			cpu := i*64 + bits.TrailingZeros64(w)
+			if t.CanRunOn(cpu) {
+				return int32(cpu)
+			}
+		}
+	}
+	return -1
+}
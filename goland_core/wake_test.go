@@ -0,0 +1,148 @@
+package core
+
+import (
+	"fmt"
+	"testing"
+)
+
+// hybridTopology is a synthetic hybrid CPU: CPUs 0-3 are big cores (two of
+// them slightly slower), CPUs 4-7 little ones.
+func hybridTopology() *Topology {
+	capacity := map[int32]uint32{
+		0: 1024, 1: 1024, 2: 980, 3: 980,
+		4: 400, 5: 400, 6: 400, 7: 400,
+	}
+	return &Topology{Capacity: capacity, Tiers: capacityTiers(capacity)}
+}
+
+// smtTopology is a synthetic CPU with two LLCs of two SMT cores each: the
+// cores are 0-1, 2-3, 4-5 and 6-7, the LLCs 0-3 and 4-7.
+func smtTopology() *Topology {
+	topo := &Topology{Smt: make(map[int32]CpuMask), Llc: make(map[int32]CpuMask)}
+	for cpu := 0; cpu < 8; cpu++ {
+		core := cpu &^ 1
+		llc := cpu &^ 3
+		topo.Smt[int32(cpu)] = maskOf(core, core+1)
+		topo.Llc[int32(cpu)] = maskOf(llc, llc+1, llc+2, llc+3)
+	}
+	return topo
+}
+
+func maskOf(cpus ...int) CpuMask {
+	var m CpuMask
+	for _, cpu := range cpus {
+		m.Set(cpu)
+	}
+	return m
+}
+
+func TestCapacityTiers(t *testing.T) {
+	tests := []struct {
+		name     string
+		capacity map[int32]uint32
+		want     string
+	}{
+		{"uniform", map[int32]uint32{0: 1024, 1: 1024, 2: 1024}, "[0,1,2]"},
+		{"hybrid", hybridTopology().Capacity, "[0,1,2,3 4,5,6,7]"},
+		{"three tiers", map[int32]uint32{0: 1024, 1: 700, 2: 300, 3: 1024}, "[0,3 1 2]"},
+		{"empty", map[int32]uint32{}, "[]"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := fmt.Sprint(capacityTiers(tt.capacity)); got != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTier(t *testing.T) {
+	topo := hybridTopology()
+	for cpu, want := range map[int32]int{0: 0, 3: 0, 4: 1, 7: 1, 8: -1} {
+		if got := topo.Tier(cpu); got != want {
+			t.Errorf("Tier(%d) = %d, want %d", cpu, got, want)
+		}
+	}
+	if got := topo.CPUsInTier(1).String(); got != "4,5,6,7" {
+		t.Errorf("CPUsInTier(1) = %s, want 4,5,6,7", got)
+	}
+	if none, neg := topo.CPUsInTier(2), topo.CPUsInTier(-1); !none.Empty() || !neg.Empty() {
+		t.Errorf("CPUs in a tier that doesn't exist")
+	}
+}
+
+func TestPreferTier(t *testing.T) {
+	topo := hybridTopology()
+	mask := maskOf
+	task := &QueuedTask{}
+	pinned := &QueuedTask{AllowedCpus: mask(5, 6)}
+	tests := []struct {
+		name       string
+		task       *QueuedTask
+		candidates CpuMask
+		tier       int
+		want       int32
+	}{
+		{"big idle", task, mask(2, 5), 0, 2},
+		{"only little idle", task, mask(5, 6), 0, 5},
+		{"little preferred", task, mask(1, 6), 1, 6},
+		{"affinity", pinned, mask(0, 6), 0, 6},
+		{"tier clamped", task, mask(1, 4), 5, 4},
+		{"none usable", pinned, mask(0, 1), 0, -1},
+		{"none idle", task, CpuMask{}, 0, -1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := topo.PreferTier(tt.task, tt.candidates, tt.tier); got != tt.want {
+				t.Errorf("got %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSuggestWakeCPU(t *testing.T) {
+	topo := smtTopology()
+	// A task that last ran on CPU 2, woken up by a task on CPU 4.
+	wakee := func(sync bool) *QueuedTask {
+		task := &QueuedTask{Cpu: 2, PrevCpu: 2, WakerCpu: 4}
+		if sync {
+			task.WakeFlags = WakeSync
+		}
+		return task
+	}
+	recent := wakee(false)
+	recent.RecentCpus = maskOf(7)
+	pinned := wakee(false)
+	pinned.AllowedCpus = maskOf(1, 6)
+	notWoken := &QueuedTask{Cpu: 5, PrevCpu: -1, WakerCpu: -1}
+
+	tests := []struct {
+		name string
+		task *QueuedTask
+		topo *Topology
+		idle CpuMask
+		want int32
+	}{
+		{"previous CPU", wakee(false), topo, maskOf(0, 2, 4), 2},
+		{"waker CPU", wakee(true), topo, maskOf(2, 4), 4},
+		{"SMT sibling", wakee(false), topo, maskOf(0, 3, 6), 3},
+		{"waker SMT sibling", wakee(true), topo, maskOf(2, 5), 5},
+		{"recent CPU", recent, topo, maskOf(0, 7), 7},
+		{"same LLC", wakee(false), topo, maskOf(1, 4), 1},
+		{"waker LLC", wakee(true), topo, maskOf(0, 6), 6},
+		{"any idle", wakee(false), topo, maskOf(6), 6},
+		{"affinity", pinned, topo, maskOf(0, 3, 6), 6},
+		{"not a wakeup", notWoken, topo, maskOf(2, 4), 4},
+		{"no topology", wakee(false), nil, maskOf(1, 3), 1},
+		{"none idle", wakee(false), topo, CpuMask{}, RL_CPU_ANY},
+		{"none idle sync", wakee(true), topo, CpuMask{}, 4},
+		{"none usable", pinned, topo, maskOf(0, 2), RL_CPU_ANY},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := SuggestWakeCPU(tt.task, tt.topo, tt.idle); got != tt.want {
+				t.Errorf("got %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
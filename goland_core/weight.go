@@ -0,0 +1,60 @@
+package core
+
+const (
+	// WeightDefault is the weight of a nice 0 task (see p->scx.weight).
+	WeightDefault = 100
+	// WeightMin is the minimum weight of a task.
+	WeightMin = 1
+	// WeightMax is the maximum weight of a task.
+	WeightMax = 10000
+
+	// NiceMin is the minimum (highest priority) nice value.
+	NiceMin = -20
+	// NiceMax is the maximum (lowest priority) nice value.
+	NiceMax = 19
+
+	nice0Load = 1024
+)
+
+// SchedPrioToWeight is the kernel's nice to load weight table
+// (kernel/sched/core.c::sched_prio_to_weight), indexed by nice + 20. A nice 0
+// task has a load weight of 1024, each nice level is ~10% of CPU time.
+var SchedPrioToWeight = [40]uint64{
+	/* -20 */ 88761, 71755, 56483, 46273, 36291,
+	/* -15 */ 29154, 23254, 18705, 14949, 11916,
+	/* -10 */ 9548, 7620, 6100, 4904, 3906,
+	/*  -5 */ 3121, 2501, 1991, 1586, 1277,
+	/*   0 */ 1024, 820, 655, 526, 423,
+	/*   5 */ 335, 272, 215, 172, 137,
+	/*  10 */ 110, 87, 70, 56, 45,
+	/*  15 */ 36, 29, 23, 18, 15,
+}
+
+// NiceToWeight returns the sched_ext weight (the unit of QueuedTask.Weight)
+// of a task with the given nice value, rounded like the kernel does
+// (DIV_ROUND_CLOSEST(load_weight * 100, 1024)). Out of range nice values are
+// clamped.
+func NiceToWeight(nice int) uint64 {
+	nice = min(max(nice, NiceMin), NiceMax)
+	w := (SchedPrioToWeight[nice-NiceMin]*WeightDefault + nice0Load/2) / nice0Load
+	return min(max(w, WeightMin), WeightMax)
+}
+
+// ScaleByWeight returns the amount of virtual runtime to charge to a task
+// with the given weight that ran for runtime ns: runtime * 100 / weight,
+// truncated like the kernel's scale_by_task_weight_inverse(). A nice 0 task
+// is charged its actual runtime, higher weights are charged less. A zero
+// weight is treated as WeightDefault.
+func ScaleByWeight(runtime, weight uint64) uint64 {
+	if weight == 0 {
+		weight = WeightDefault
+	}
+	return runtime * WeightDefault / weight
+}
+
+// ScaleSliceByWeight scales a time slice proportionally to weight: slice *
+// weight / 100, truncated like the kernel's scale_by_task_weight(), so that
+// higher weight tasks get longer time slices.
+func ScaleSliceByWeight(slice, weight uint64) uint64 {
+	return slice * weight / WeightDefault
+}
@@ -0,0 +1,262 @@
+//go:build integration
+
+// integration loads the scheduler on the running kernel, attaches it and
+// runs a stress workload, then checks that the BPF component never exited,
+// that the dispatch counters advanced and that no task stalled. It also
+// checks that the affinity changes are delivered as task events. It is meant
+// to be run as root inside a virtual machine, see run.sh.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"time"
+
+	core "github.com/Gthulhu/scx_goland_core/goland_core"
+	"github.com/Gthulhu/scx_goland_core/util"
+	"golang.org/x/sys/unix"
+)
+
+const scxStatePath = "/sys/kernel/sched_ext/state"
+
+// eventTimeout is how long checkAffinityEvent waits for the event.
+const eventTimeout = 5 * time.Second
+
+var (
+	objPath  = flag.String("obj", "", "BPF object to load (default: the embedded skeleton)")
+	duration = flag.Duration("duration", 10*time.Second, "duration of the stress workload")
+)
+
+// schedule dispatches the queued tasks in FIFO order until ctx is done.
+func schedule(ctx context.Context, s *core.Sched) {
+	var t core.QueuedTask
+	var seq uint64
+	for ctx.Err() == nil {
+		s.DequeueTask(&t)
+		if t.Pid == -1 {
+			s.NotifyComplete(0)
+			s.BlockTilReadyForDequeue(ctx)
+			continue
+		}
+		task := core.NewDispatchedTask(&t)
+		cpu, err := s.SelectCPU(&t)
+		if err != nil {
+			cpu = core.RL_CPU_ANY
+		}
+		seq++
+		task.Cpu = cpu
+		task.SliceNs = 5000 * 1000
+		task.Vtime = seq
+		if err := s.DispatchTask(task); err != nil {
+			log.Printf("DispatchTask failed: %v", err)
+		}
+	}
+}
+
+// stress starts a CPU hog per CPU and as many tasks that sleep and wake up
+// continuously, it returns the started processes.
+func stress(ctx context.Context) ([]*exec.Cmd, error) {
+	var cmds []*exec.Cmd
+	for i := 0; i < runtime.NumCPU(); i++ {
+		for _, script := range []string{
+			"while :; do :; done",
+			"while :; do sleep 0.001; done",
+		} {
+			cmd := exec.CommandContext(ctx, "/bin/sh", "-c", script)
+			if err := cmd.Start(); err != nil {
+				return cmds, err
+			}
+			cmds = append(cmds, cmd)
+		}
+	}
+	return cmds, nil
+}
+
+// checkAffinityEvent pins a child process to the last CPU usable by this
+// process with sched_setaffinity, and waits for the TaskCpumaskChanged event
+// of the child carrying that mask. The last CPU is used so that masks wider
+// than 64 CPUs are checked on the systems that have them.
+func checkAffinityEvent(ctx context.Context, s *core.Sched) error {
+	var set unix.CPUSet
+	if err := unix.SchedGetaffinity(0, &set); err != nil {
+		return fmt.Errorf("sched_getaffinity: %w", err)
+	}
+	cpu := -1
+	for i := 0; i < core.MAX_CPUS; i++ {
+		if set.IsSet(i) {
+			cpu = i
+		}
+	}
+	if cpu < 0 {
+		return fmt.Errorf("no usable CPU")
+	}
+
+	cmd := exec.CommandContext(ctx, "/bin/sleep", "60")
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("start child: %w", err)
+	}
+	defer func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	}()
+	pid := int32(cmd.Process.Pid)
+
+	// Drop the events queued so far, e.g. the tasks enabled at attach.
+	for drained := false; !drained; {
+		select {
+		case <-s.TaskEvents():
+		default:
+			drained = true
+		}
+	}
+	set.Zero()
+	set.Set(cpu)
+	if err := unix.SchedSetaffinity(int(pid), &set); err != nil {
+		return fmt.Errorf("sched_setaffinity: %w", err)
+	}
+	var want core.CpuMask
+	want.Set(cpu)
+
+	timeout := time.After(eventTimeout)
+	for {
+		select {
+		case e := <-s.TaskEvents():
+			if e.Kind != core.TaskCpumaskChanged || e.Pid != pid {
+				continue
+			}
+			if e.AllowedCpus != want {
+				return fmt.Errorf("pid %d: cpumask %v, want %v", pid, e.AllowedCpus, want)
+			}
+			return nil
+		case <-timeout:
+			return fmt.Errorf("no %v event received for pid %d", core.TaskCpumaskChanged, pid)
+		}
+	}
+}
+
+func scxState() string {
+	b, err := os.ReadFile(scxStatePath)
+	if err != nil {
+		return "unknown"
+	}
+	return strings.TrimSpace(string(b))
+}
+
+func run() error {
+	opts := core.LoadSchedOpts{
+		Logger:     log.Default(),
+		TaskEvents: true,
+	}
+	var s *core.Sched
+	if *objPath != "" {
+		obj, err := os.ReadFile(*objPath)
+		if err != nil {
+			return err
+		}
+		s, err = core.LoadSchedFromBytesWithOpts(obj, opts)
+		if err != nil {
+			return fmt.Errorf("LoadSchedFromBytesWithOpts: %w", err)
+		}
+	} else {
+		var err error
+		s, err = core.LoadSchedWithOpts("", opts)
+		if err != nil {
+			return fmt.Errorf("LoadSchedWithOpts: %w", err)
+		}
+	}
+	defer s.Close()
+	if err := s.AssignUserSchedPid(os.Getpid()); err != nil {
+		return fmt.Errorf("AssignUserSchedPid: %w", err)
+	}
+	s.SetBuiltinIdle(true)
+	if _, err := s.Start(); err != nil {
+		return fmt.Errorf("Start: %w", err)
+	}
+	if err := util.InitCacheDomains(s); err != nil {
+		return fmt.Errorf("InitCacheDomains: %w", err)
+	}
+	if err := s.Attach(); err != nil {
+		return fmt.Errorf("Attach: %w", err)
+	}
+	defer s.Detach()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go schedule(ctx, s)
+
+	if state := scxState(); state != "enabled" {
+		return fmt.Errorf("sched_ext state is %q after attach", state)
+	}
+	if err := checkAffinityEvent(ctx, s); err != nil {
+		return fmt.Errorf("affinity event: %w", err)
+	}
+	go func() {
+		for {
+			select {
+			case <-s.TaskEvents():
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	before, err := s.GetBssData()
+	if err != nil {
+		return fmt.Errorf("GetBssData: %w", err)
+	}
+
+	cmds, err := stress(ctx)
+	defer func() {
+		cancel()
+		for _, cmd := range cmds {
+			cmd.Wait()
+		}
+	}()
+	if err != nil {
+		return fmt.Errorf("start workload: %w", err)
+	}
+
+	// A stalled task triggers the sched_ext watchdog, which unloads the
+	// scheduler: check that the BPF component is still running while the
+	// workload is active.
+	deadline := time.Now().Add(*duration)
+	for time.Now().Before(deadline) {
+		time.Sleep(1 * time.Second)
+		if s.Stopped() {
+			uei, _ := s.GetUeiData()
+			return fmt.Errorf("BPF component exited: kind %v, exit code %v", uei.Kind, uei.ExitCode)
+		}
+	}
+
+	after, err := s.GetBssData()
+	if err != nil {
+		return fmt.Errorf("GetBssData: %w", err)
+	}
+	log.Printf("stats: %v", after)
+	if after.Nr_user_dispatches <= before.Nr_user_dispatches {
+		return fmt.Errorf("nr_user_dispatches didn't advance: %d -> %d",
+			before.Nr_user_dispatches, after.Nr_user_dispatches)
+	}
+	if after.Nr_kernel_dispatches <= before.Nr_kernel_dispatches {
+		return fmt.Errorf("nr_kernel_dispatches didn't advance: %d -> %d",
+			before.Nr_kernel_dispatches, after.Nr_kernel_dispatches)
+	}
+	if state := scxState(); state != "enabled" {
+		return fmt.Errorf("sched_ext state is %q at the end of the workload", state)
+	}
+	return nil
+}
+
+func main() {
+	flag.Parse()
+	if err := run(); err != nil {
+		log.Printf("FAIL: %v", err)
+		os.Exit(1)
+	}
+	log.Println("PASS")
+}
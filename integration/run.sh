@@ -0,0 +1,31 @@
+#!/bin/bash
+# Run the integration check inside a virtme-ng virtual machine.
+#
+# Usage: ./integration/run.sh [kernel]
+#
+# kernel is passed to `vng -r`: a kernel release (e.g. v6.12.2), the path of
+# a bzImage or nothing to boot the running kernel. The check is skipped
+# (exit status 0) when virtme-ng or KVM are not available.
+
+set -u
+
+KERNEL=${1:-}
+DURATION=${DURATION:-10s}
+BIN=./integration.bin
+
+if ! command -v vng >/dev/null; then
+	echo "SKIP: vng not found in \$PATH"
+	exit 0
+fi
+if [ ! -w /dev/kvm ]; then
+	echo "SKIP: /dev/kvm not available"
+	exit 0
+fi
+if [ ! -x "$BIN" ]; then
+	echo "$BIN not found, run 'make integration'" >&2
+	exit 1
+fi
+
+# The guest must exit on its own even if the scheduler hangs the system.
+timeout=$(( ${DURATION%s} + 60 ))
+vng -r $KERNEL -- timeout "$timeout" "$BIN" -duration "$DURATION"
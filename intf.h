@@ -30,6 +30,8 @@ typedef signed int s32;
 typedef signed long s64;
 
 typedef int pid_t;
+
+#define TASK_COMM_LEN 16
 #endif /* __VMLINUX_H__ */
 
 /* Check a condition at build time */
@@ -39,12 +41,39 @@ typedef int pid_t;
 			__attribute__((unused)); \
 	} while(0)
 
+/*
+ * Version of the protocol spoken with the user-space scheduler: bump it every
+ * time the layout or the meaning of the records exchanged through the ring
+ * buffers changes (see goland_core/abi.go).
+ */
+#define GOLAND_ABI_VERSION 19
+
 /*
  * Maximum amount of CPUs supported by this scheduler (this defines the size of
  * cpu_map that is used to store the idle state and CPU ownership).
  */
 #define MAX_CPUS 1024
 
+/*
+ * Per-LLC DSQs are created on demand by the user-space scheduler: the DSQ of
+ * the LLC with id N is LLC_DSQ_BASE + N (with N < MAX_CPUS).
+ */
+#define LLC_DSQ_BASE (MAX_CPUS * 2)
+
+/*
+ * Number of CPUs remembered in the recently used CPUs of a task, and how long
+ * a CPU stays there after the task last ran on it: the data of a task that
+ * ran on a CPU more than RECENT_CPUS_WINDOW_NS ago is unlikely to be still in
+ * its caches.
+ */
+#define NR_RECENT_CPUS		4
+#define RECENT_CPUS_WINDOW_NS	(50ULL * 1000 * 1000)
+
+/*
+ * Maximum amount of ring buffers used to send tasks to user space.
+ */
+#define MAX_QUEUED_SHARDS 8
+
 /* Special dispatch flags */
 enum {
 	/*
@@ -56,6 +85,40 @@ enum {
 	RL_CPU_ANY = 1 << 20,
 };
 
+/*
+ * Priority levels that the user-space scheduler can assign to a PID via the
+ * task_prio map.
+ */
+enum task_priority {
+	/* Task is scheduled by the user-space scheduler */
+	TASK_PRIO_NORMAL = 0,
+	/* Task is dispatched immediately on a local DSQ, preempting */
+	TASK_PRIO_CRITICAL = 1,
+	/* Task is dispatched directly on the shared DSQ, bypassing user-space */
+	TASK_PRIO_BACKGROUND = 2,
+};
+
+/*
+ * Tags assigned to a PID by the user-space scheduler via the task_tags map:
+ * the bits below TASK_TAG_RULES are free for the user-space scheduler, the
+ * others select a rule applied by the BPF component at enqueue time.
+ */
+#define TASK_TAG_RULES		(1ULL << 60)
+/* Dispatch the task directly on the shared DSQ, bypassing user-space */
+#define TASK_TAG_BYPASS		(1ULL << 63)
+/* Dispatch the task directly on the DSQ of its previous CPU */
+#define TASK_TAG_STICKY		(1ULL << 62)
+/* Dispatch the task directly on an idle CPU, if there is one */
+#define TASK_TAG_INTERACTIVE	(1ULL << 61)
+
+/* Index of the tag rules in the tag_stats map */
+enum task_tag_rule {
+	TAG_RULE_BYPASS = 0,
+	TAG_RULE_STICKY = 1,
+	TAG_RULE_INTERACTIVE = 2,
+	NR_TAG_RULES,
+};
+
 /*
  * Specify a target CPU for a specific PID.
  */
@@ -78,6 +141,69 @@ struct preempt_cpu_arg {
 	s32 cpu_id;
 };
 
+/*
+ * Maximum amount of PIDs reported by dump_dsq().
+ */
+#define DSQ_DUMP_PIDS 16
+
+/*
+ * Input of dump_dsq(): the DSQ to inspect.
+ */
+struct dsq_dump_arg {
+	u64 dsq_id;
+};
+
+/*
+ * Content of a DSQ, written by dump_dsq() into the dsq_dump map.
+ */
+struct dsq_dump {
+	u64 dsq_id;
+	u64 nr_queued; /* Tasks in the DSQ */
+	u32 nr_pids; /* Valid entries of @pids */
+	s32 pids[DSQ_DUMP_PIDS]; /* First tasks of the DSQ, in dispatch order */
+};
+
+/*
+ * Idle CPUs, written by get_idle_cpumask() into the idle_cpumask map.
+ */
+struct idle_cpumask {
+	u64 cpumask[MAX_CPUS / 64];
+};
+
+/*
+ * Assign a CPU to the DSQ of its last level cache.
+ */
+struct llc_dsq_arg {
+	s32 cpu_id;
+	s32 llc_id;
+};
+
+/*
+ * Set the performance target of a CPU (see scx_bpf_cpuperf_set()).
+ */
+struct cpu_perf_arg {
+	s32 cpu_id;
+	u32 perf; /* 0..SCX_CPUPERF_ONE */
+};
+
+/*
+ * Attributes of a queued task (queued_task_ctx.task_flags).
+ */
+enum queued_task_flags {
+	/*
+	 * Kernel thread (PF_KTHREAD in p->flags), including the per-CPU
+	 * kworkers, that are also pinned to a single CPU (nr_cpus_allowed = 1)
+	 */
+	QUEUED_TASK_KTHREAD = 1 << 0,
+	/*
+	 * ops.select_cpu() already picked (and claimed) an idle CPU for the
+	 * task, reported in @cpu, but it couldn't dispatch the task there
+	 * directly: the user-space scheduler can dispatch it to @cpu without
+	 * selecting a CPU again (see fast_select_tasks)
+	 */
+	QUEUED_TASK_CPU_SELECTED = 1 << 1,
+};
+
 /*
  * Task sent to the user-space scheduler by the BPF dispatcher.
  *
@@ -85,7 +211,12 @@ struct preempt_cpu_arg {
  */
 struct queued_task_ctx {
 	s32 pid;
-	s32 cpu; /* CPU where the task is running */
+	/*
+	 * CPU assigned to the task by the kernel (scx_bpf_task_cpu()): on
+	 * wakeup this is the CPU picked by ops.select_cpu(), otherwise the CPU
+	 * where the task was running.
+	 */
+	s32 cpu;
 	u64 nr_cpus_allowed; /* Number of CPUs that the task can use */
 	u64 flags; /* Task enqueue flags */
 	u64 start_ts; /* Timestamp since last time the task ran on a CPU */
@@ -94,6 +225,124 @@ struct queued_task_ctx {
 	u64 weight; /* Task static priority */
 	u64 vtime; /* Current task's vruntime */
 	s32 tgid;
+	s32 nice; /* Task static nice value */
+	u64 runtime_ns; /* CPU time used since the task was last queued (delta) */
+	u64 cpumask[MAX_CPUS / 64]; /* CPUs that the task can use (p->cpus_ptr) */
+	s32 prev_cpu; /* CPU where the task ran last, from ops.running() (-1 = never ran) */
+	char comm[TASK_COMM_LEN]; /* Task command name (p->comm) */
+	s32 waker_cpu; /* CPU of the waker, from ops.select_cpu() (-1 = not a wakeup) */
+	u64 wake_flags; /* SCX_WAKE_* flags of the wakeup */
+	u64 nr_migrations; /* Times the task started running on a different CPU */
+	/*
+	 * CPUs where the task ran within the last RECENT_CPUS_WINDOW_NS, most
+	 * recent first (-1 = unused slot)
+	 */
+	s32 recent_cpus[NR_RECENT_CPUS];
+	u64 cgroup_id; /* Id of the cgroup v2 of the task */
+	u64 enq_ts; /* Time the task has been queued (bpf_ktime_get_ns()) */
+	u64 nvcsw; /* Voluntary context switches (see task_switch_stats) */
+	u64 nivcsw; /* Involuntary context switches (see task_switch_stats) */
+	u64 task_flags; /* Attributes of the task (enum queued_task_flags) */
+	u64 running_ts; /* Time the task last started running (bpf_ktime_get_ns()) */
+};
+
+/*
+ * Per-CPU statistics, stored in the cpu_stats map.
+ */
+struct cpu_stats {
+	u64 nr_dispatches; /* Tasks dispatched to the CPU by the user-space scheduler */
+	u64 nr_bounces; /* Tasks sent to the CPU that couldn't run there */
+	u64 nr_preemptions; /* Preemptions of the CPU requested by the scheduler */
+	u64 nr_queued; /* Tasks in the CPU's DSQ the last time it looked for work */
+};
+
+/*
+ * Context switches of a task, stored in the task_switch_stats map.
+ */
+struct task_switch_stats {
+	u64 nvcsw; /* The task stopped running to sleep */
+	u64 nivcsw; /* The task stopped running while still runnable (slice expired, preempted) */
+};
+
+/*
+ * Kind of task lifecycle events sent to the user-space scheduler.
+ */
+enum task_event_kind {
+	/* The task exited */
+	TASK_EVENT_EXIT = 0,
+	/* The task joined the scheduler (only when @task_events is set) */
+	TASK_EVENT_ENABLE = 1,
+	/* The task left the scheduler (only when @task_events is set) */
+	TASK_EVENT_DISABLE = 2,
+	/*
+	 * The affinity of the task changed (only when @task_events or
+	 * @cpumask_events is set)
+	 */
+	TASK_EVENT_SET_CPUMASK = 3,
+};
+
+/*
+ * Task lifecycle event sent to the user-space scheduler through the exit_rb
+ * ring buffer.
+ */
+struct task_exit_ctx {
+	s32 pid;
+	s32 tgid;
+	u32 kind; /* enum task_event_kind */
+	u32 pad;
+	u64 ts; /* Timestamp of the event (bpf_ktime_get_ns()) */
+	/* New CPUs that the task can use (TASK_EVENT_SET_CPUMASK only) */
+	u64 cpumask[MAX_CPUS / 64];
+};
+
+/*
+ * Kind of CPU events sent to the user-space scheduler.
+ */
+enum cpu_event_kind {
+	/* CPU taken away by a higher priority sched_class */
+	CPU_EVENT_RELEASE = 0,
+	/* CPU given back to the scheduler */
+	CPU_EVENT_ACQUIRE = 1,
+	/* CPU brought online */
+	CPU_EVENT_ONLINE = 2,
+	/* CPU taken offline */
+	CPU_EVENT_OFFLINE = 3,
+};
+
+/*
+ * CPU event sent to the user-space scheduler through the cpu_rb ring buffer.
+ */
+struct cpu_event_ctx {
+	s32 cpu;
+	u32 kind; /* enum cpu_event_kind */
+	u64 ts; /* Timestamp of the event */
+};
+
+/*
+ * Why a dispatch of the user-space scheduler couldn't be honored.
+ */
+enum bounce_reason {
+	/* The task exited before it could be dispatched: dropped */
+	BOUNCE_EXITED = 0,
+	/* The target CPU is not allowed: dispatched to the shared DSQ */
+	BOUNCE_NOT_ALLOWED = 1,
+	/* The affinity changed during the dispatch: cancelled and re-enqueued */
+	BOUNCE_CANCELLED = 2,
+	/* The target CPU is offline: dispatched to the shared DSQ */
+	BOUNCE_OFFLINE = 3,
+	/* The LLC DSQ doesn't exist or can't run the task: dispatched to the shared DSQ */
+	BOUNCE_NO_LLC_DSQ = 4,
+	NR_BOUNCE_REASONS,
+};
+
+/*
+ * Bounced dispatch sent to the user-space scheduler through the bounce_rb
+ * ring buffer (only when @bounce_events is set).
+ */
+struct bounce_event_ctx {
+	s32 pid;
+	s32 cpu; /* CPU requested by the user-space scheduler */
+	u32 reason; /* enum bounce_reason */
 };
 
 /*
@@ -108,6 +357,9 @@ struct dispatched_task_ctx {
 	u64 flags; /* task enqueue flags */
 	u64 slice_ns; /* time slice assigned to the task (0=default) */
 	u64 vtime; /* task deadline / vruntime */
+	u64 cpumask_cnt; /* cpumask generation counter (private) */
+	u32 sticky; /* keep the task on its previous CPU if cpu == RL_CPU_ANY */
+	u64 dsq_id; /* LLC DSQ where the task should be dispatched (0 = use cpu) */
 };
 
 #endif /* __INTF_H */
@@ -61,6 +61,21 @@ UEI_DEFINE(uei);
  */
 #define SCHED_DSQ (MAX_CPUS + 1)
 
+/*
+ * ABI of the BPF component, checked by the user-space scheduler before
+ * loading the object.
+ *
+ * These variables live in their own section, so that they don't change the
+ * layout of .rodata.
+ */
+const volatile u32 abi_version SEC(".rodata.abi") = GOLAND_ABI_VERSION;
+const volatile u32 abi_queued_task_size SEC(".rodata.abi") = sizeof(struct queued_task_ctx);
+const volatile u32 abi_dispatched_task_size SEC(".rodata.abi") = sizeof(struct dispatched_task_ctx);
+const volatile u32 abi_task_exit_size SEC(".rodata.abi") = sizeof(struct task_exit_ctx);
+const volatile u32 abi_cpu_event_size SEC(".rodata.abi") = sizeof(struct cpu_event_ctx);
+const volatile u32 abi_bounce_event_size SEC(".rodata.abi") = sizeof(struct bounce_event_ctx);
+const volatile u32 abi_max_cpus SEC(".rodata.abi") = MAX_CPUS;
+
 /*
  * Scheduler attributes and statistics.
  */
@@ -103,6 +118,134 @@ volatile u64 nr_user_dispatches, nr_kernel_dispatches,
 /* Failure statistics */
 volatile u64 nr_failed_dispatches, nr_sched_congested;
 
+/*
+ * Number of tasks dispatched directly by the BPF component according to the
+ * priority assigned in @task_prio.
+ */
+volatile u64 nr_prio_dispatches;
+
+/*
+ * Number of tasks dispatched directly by the BPF component, because too many
+ * tasks were still waiting to be consumed by the user-space scheduler.
+ */
+volatile u64 nr_sched_saturated;
+
+/*
+ * Number of tasks received from the @dispatched ring buffer, and how many of
+ * them were dropped, because the task exited before it could be dispatched.
+ *
+ * Together with @nr_cancel_dispatches, these allow the user-space scheduler
+ * to reconcile the tasks it submitted with the tasks actually dispatched.
+ */
+volatile u64 nr_dispatch_received, nr_dispatch_dropped;
+
+/*
+ * Number of tasks of the protected processes (@protected_tgids) dispatched
+ * directly on a local DSQ.
+ */
+volatile u64 nr_protected_dispatches;
+
+/*
+ * Highest vtime of the tasks that started running: the point the DSQs
+ * ordered by vtime have reached, used by the user-space scheduler as the
+ * global minimum vtime of the queued tasks (see Sched.DispatchFair).
+ */
+volatile u64 vtime_now;
+
+/*
+ * CPUs selected by the idle selection of the BPF component in
+ * ops.select_cpu() (fast path, see fast_select_tasks), and by the user-space
+ * scheduler through rs_select_cpu() (user-space path).
+ */
+volatile u64 nr_select_fast, nr_select_user;
+
+/*
+ * Task exits (and lifecycle events) lost because exit_rb was full.
+ */
+volatile u64 nr_exit_dropped;
+
+/*
+ * Number of tasks dispatched directly because of @bypass.
+ */
+volatile u64 nr_bypass_dispatches;
+
+/*
+ * Tunables of the scheduler, set by the user-space scheduler.
+ *
+ * These variables live in their own section, so that user space can update
+ * them all with a single map update (see BssMap.WriteStruct), without
+ * overwriting the counters of .bss. Keep them in sync with
+ * goland_core/bss.go::Tunables.
+ */
+
+/*
+ * Number of cgroups in @managed_cgroups (0 = manage all the tasks).
+ *
+ * This number is updated by the user-space scheduler together with the
+ * @managed_cgroups map.
+ */
+volatile u64 nr_managed_cgroups SEC(".data.tunables");
+
+/*
+ * Maximum amount of tasks that can wait to be consumed by the user-space
+ * scheduler, before the BPF component starts to dispatch new tasks directly
+ * on the shared DSQ (0 = MAX_QUEUED_DFL).
+ *
+ * This value can be changed by the user-space scheduler at runtime.
+ */
+volatile u64 max_queued SEC(".data.tunables");
+
+/*
+ * Number of @queued ring buffers used to send tasks to user space (0 = 1),
+ * see reserve_queued_task().
+ *
+ * This value is set by the user-space scheduler before loading the program.
+ */
+volatile u64 nr_queued_shards SEC(".data.tunables");
+
+/*
+ * Time slice assigned by the user-space scheduler at runtime, overriding
+ * @default_slice (0 = use @default_slice), see dfl_slice().
+ */
+volatile u64 auto_slice_ns SEC(".data.tunables");
+
+/*
+ * Send every bounced dispatch to the user-space scheduler through
+ * @bounce_rb, in addition to counting it in @bounce_stats.
+ */
+volatile u64 bounce_events SEC(".data.tunables");
+
+/*
+ * Bypass the user-space scheduler: the tasks are dispatched directly on the
+ * shared DSQ by the BPF component, instead of being queued to user space.
+ * The tasks already queued are still dispatched as decided by the user-space
+ * scheduler.
+ *
+ * This value can be changed by the user-space scheduler at runtime.
+ */
+volatile u64 bypass SEC(".data.tunables");
+
+/*
+ * Send the enable/disable and affinity change events of the tasks to the
+ * user-space scheduler through @exit_rb, in addition to the task exits.
+ */
+volatile u64 task_events SEC(".data.tunables");
+
+/*
+ * Send the affinity change events of the tasks to @exit_rb even if
+ * @task_events is not set, so that user space can drop the CPUs it cached for
+ * them.
+ */
+volatile u64 cpumask_events SEC(".data.tunables");
+
+/*
+ * Shard the tasks across the @queued ring buffers by the CPU that queues
+ * them, instead of by pid, see reserve_queued_task().
+ *
+ * This value is set by the user-space scheduler before loading the program.
+ */
+volatile u64 queued_shard_by_cpu SEC(".data.tunables");
+
  /* Report additional debugging information */
 const volatile bool debug;
 
@@ -153,27 +296,47 @@ static int calloc_cpumask(struct bpf_cpumask **p_cpumask)
  */
 #define MAX_ENQUEUED_TASKS 4096
 
+/*
+ * Default value of @max_queued.
+ */
+#define MAX_QUEUED_DFL (MAX_ENQUEUED_TASKS / 2)
+
 /*
  * Maximum amount of slots reserved to the tasks dispatched via shared queue.
  */
 #define MAX_DISPATCH_SLOT (MAX_ENQUEUED_TASKS / 8)
 
 /*
- * The map containing tasks that are queued to user space from the kernel.
+ * The maps containing tasks that are queued to user space from the kernel.
  *
- * This map is drained by the user space scheduler.
+ * Tasks are sharded across the first @nr_queued_shards ring buffers by pid, so
+ * that the events of each task are received in order, or by CPU if
+ * @queued_shard_by_cpu is set, so that each ring buffer is only written by a
+ * contiguous range of CPUs. Each ring buffer is drained by its own consumer in
+ * the user space scheduler. The ring buffers that are not used are shrunk by
+ * the user-space scheduler before loading the program.
  */
-struct {
+struct queued_ringbuf {
 	__uint(type, BPF_MAP_TYPE_RINGBUF);
 	__uint(max_entries, MAX_ENQUEUED_TASKS *
 				sizeof(struct queued_task_ctx));
-} queued SEC(".maps");
+};
+
+struct queued_ringbuf queued SEC(".maps");
+struct queued_ringbuf queued_1 SEC(".maps");
+struct queued_ringbuf queued_2 SEC(".maps");
+struct queued_ringbuf queued_3 SEC(".maps");
+struct queued_ringbuf queued_4 SEC(".maps");
+struct queued_ringbuf queued_5 SEC(".maps");
+struct queued_ringbuf queued_6 SEC(".maps");
+struct queued_ringbuf queued_7 SEC(".maps");
 
 /*
- * The user ring buffer containing pids that are dispatched from user space to
- * the kernel.
+ * The user ring buffer containing the tasks that are dispatched from user
+ * space to the kernel (struct dispatched_task_ctx).
  *
- * Drained by the kernel in .dispatch().
+ * Written only by the producer created in goland_core/dispatch.go and
+ * drained by the kernel in .dispatch().
  */
 struct {
         __uint(type, BPF_MAP_TYPE_USER_RINGBUF);
@@ -181,6 +344,48 @@ struct {
 				sizeof(struct dispatched_task_ctx));
 } dispatched SEC(".maps");
 
+/*
+ * The map containing the tasks that exited, used by the user-space scheduler
+ * to release its per-task state.
+ *
+ * This map carries only task exits, scheduler exits are reported through
+ * @uei.
+ */
+struct {
+	__uint(type, BPF_MAP_TYPE_RINGBUF);
+	__uint(max_entries, MAX_ENQUEUED_TASKS *
+				sizeof(struct task_exit_ctx));
+} exit_rb SEC(".maps");
+
+/*
+ * The map containing the CPUs released to / acquired from higher priority
+ * sched_classes.
+ */
+struct {
+	__uint(type, BPF_MAP_TYPE_RINGBUF);
+	__uint(max_entries, MAX_ENQUEUED_TASKS *
+				sizeof(struct cpu_event_ctx));
+} cpu_rb SEC(".maps");
+
+/*
+ * Number of bounced dispatches, by enum bounce_reason.
+ */
+struct {
+	__uint(type, BPF_MAP_TYPE_ARRAY);
+	__type(key, u32);
+	__type(value, u64);
+	__uint(max_entries, NR_BOUNCE_REASONS);
+} bounce_stats SEC(".maps");
+
+/*
+ * The map containing the bounced dispatches (see @bounce_events).
+ */
+struct {
+	__uint(type, BPF_MAP_TYPE_RINGBUF);
+	__uint(max_entries, MAX_ENQUEUED_TASKS *
+				sizeof(struct bounce_event_ctx));
+} bounce_rb SEC(".maps");
+
 /*
  * Map to track PIDs with vtime==0 (priority tasks).
  *
@@ -194,6 +399,115 @@ struct {
 	__uint(max_entries, MAX_ENQUEUED_TASKS);
 } priority_tasks SEC(".maps");
 
+/*
+ * Map of the priorities assigned to PIDs by the user-space scheduler (see
+ * enum task_priority).
+ *
+ * Entries are removed when the task exits.
+ */
+struct {
+	__uint(type, BPF_MAP_TYPE_HASH);
+	__type(key, u32);    /* PID */
+	__type(value, u32);   /* priority */
+	__uint(max_entries, MAX_ENQUEUED_TASKS);
+} task_prio SEC(".maps");
+
+/*
+ * Map of the tags assigned to PIDs by the user-space scheduler (see
+ * TASK_TAG_RULES).
+ *
+ * Entries are removed when the task exits.
+ */
+struct {
+	__uint(type, BPF_MAP_TYPE_HASH);
+	__type(key, u32);    /* PID */
+	__type(value, u64);   /* tag */
+	__uint(max_entries, MAX_ENQUEUED_TASKS);
+} task_tags SEC(".maps");
+
+/*
+ * PIDs whose CPU is selected in ops.select_cpu() even if @builtin_idle is
+ * disabled: the task is dispatched directly to an idle CPU if possible,
+ * otherwise it's queued to the user-space scheduler with the CPU selected
+ * (QUEUED_TASK_CPU_SELECTED), or without a CPU if none is idle.
+ *
+ * Entries are removed when the task exits.
+ */
+struct {
+	__uint(type, BPF_MAP_TYPE_HASH);
+	__type(key, u32);    /* PID */
+	__type(value, u8);
+	__uint(max_entries, MAX_ENQUEUED_TASKS);
+} fast_select_tasks SEC(".maps");
+
+/*
+ * Number of enqueues short-circuited by each tag rule (enum task_tag_rule).
+ */
+struct {
+	__uint(type, BPF_MAP_TYPE_ARRAY);
+	__type(key, u32);
+	__type(value, u64);
+	__uint(max_entries, NR_TAG_RULES);
+} tag_stats SEC(".maps");
+
+/*
+ * Maximum amount of tasks whose context switches are tracked: the least
+ * recently updated ones are evicted beyond it.
+ */
+#define MAX_SWITCH_STATS_TASKS 65536
+
+/*
+ * Context switches of each PID since the scheduler started managing it,
+ * counted in ops.stopping(). The map is not per-CPU, so the counters follow
+ * the task when it migrates.
+ *
+ * Entries are removed when the task exits.
+ */
+struct {
+	__uint(type, BPF_MAP_TYPE_LRU_HASH);
+	__type(key, u32);    /* PID */
+	__type(value, struct task_switch_stats);
+	__uint(max_entries, MAX_SWITCH_STATS_TASKS);
+} task_switch_stats SEC(".maps");
+
+/*
+ * Maximum amount of processes that can be protected by the user-space
+ * scheduler.
+ */
+#define MAX_PROTECTED_TGIDS 16
+
+/*
+ * Processes whose threads are always dispatched directly on a local DSQ,
+ * without going through the user-space scheduler: this is used to protect
+ * the user-space scheduler from being starved by its own policy.
+ */
+struct {
+	__uint(type, BPF_MAP_TYPE_HASH);
+	__type(key, u32);    /* TGID */
+	__type(value, u8);
+	__uint(max_entries, MAX_PROTECTED_TGIDS);
+} protected_tgids SEC(".maps");
+
+/*
+ * Maximum amount of cgroups that can be managed by the user-space scheduler.
+ */
+#define MAX_MANAGED_CGROUPS 1024
+
+/*
+ * Map of the cgroups managed by the user-space scheduler.
+ *
+ * If the map is empty all the tasks are sent to the user-space scheduler,
+ * otherwise only the tasks that belong to one of these cgroups are sent to
+ * user-space, while all the others are dispatched directly by the BPF
+ * component.
+ */
+struct {
+	__uint(type, BPF_MAP_TYPE_HASH);
+	__type(key, u64);    /* cgroup id */
+	__type(value, u8);
+	__uint(max_entries, MAX_MANAGED_CGROUPS);
+} managed_cgroups SEC(".maps");
+
 struct {
 	__uint(type, BPF_MAP_TYPE_HASH);
 	__type(key, s32);    /* CPU */
@@ -201,12 +515,63 @@ struct {
 	__uint(max_entries, MAX_CPUS);
 } running_task SEC(".maps");
 
+/*
+ * Output of get_idle_cpumask().
+ */
+struct {
+	__uint(type, BPF_MAP_TYPE_ARRAY);
+	__type(key, u32);
+	__type(value, struct idle_cpumask);
+	__uint(max_entries, 1);
+} idle_cpumask SEC(".maps");
+
+/*
+ * Output of dump_dsq().
+ */
+struct {
+	__uint(type, BPF_MAP_TYPE_ARRAY);
+	__type(key, u32);
+	__type(value, struct dsq_dump);
+	__uint(max_entries, 1);
+} dsq_dump SEC(".maps");
+
+/*
+ * When the time slice of the task running on each CPU expires, in
+ * bpf_ktime_get_ns() time (0 = the CPU is not running any task).
+ */
+struct {
+	__uint(type, BPF_MAP_TYPE_ARRAY);
+	__type(key, u32);    /* CPU */
+	__type(value, u64);
+	__uint(max_entries, MAX_CPUS);
+} cpu_slice_end SEC(".maps");
+
+/*
+ * Track the end of the time slice of the task starting or stopping on @cpu.
+ */
+static void update_slice_end(s32 cpu, const struct task_struct *p, bool running)
+{
+	u32 key = cpu;
+	u64 *end = bpf_map_lookup_elem(&cpu_slice_end, &key);
+
+	if (!end)
+		return;
+	if (!running)
+		*end = 0;
+	else if (p->scx.slice == SCX_SLICE_INF)
+		*end = -1ULL;
+	else
+		*end = bpf_ktime_get_ns() + p->scx.slice;
+}
+
 /*
  * Per-CPU context.
  */
 struct cpu_ctx {
 	struct bpf_cpumask __kptr *l2_cpumask;
 	struct bpf_cpumask __kptr *l3_cpumask;
+	u64 llc_dsq; /* DSQ of the CPU's LLC, 0 if not set up (see setup_llc_dsq()) */
+	bool offline; /* taken offline while the scheduler is loaded */
 };
 
 struct {
@@ -216,6 +581,52 @@ struct {
 	__uint(max_entries, 1);
 } cpu_ctx_stor SEC(".maps");
 
+/*
+ * Per-LLC context, indexed by LLC id (see setup_llc_dsq()).
+ */
+struct llc_ctx {
+	struct bpf_cpumask __kptr *cpumask; /* CPUs consuming the LLC DSQ */
+	bool created; /* the LLC DSQ exists */
+};
+
+struct {
+	__uint(type, BPF_MAP_TYPE_ARRAY);
+	__type(key, u32);
+	__type(value, struct llc_ctx);
+	__uint(max_entries, MAX_CPUS);
+} llc_ctx_stor SEC(".maps");
+
+/*
+ * Statistics of each CPU: a single entry, so that user space can read them
+ * all with a single lookup.
+ */
+struct cpu_stats_map_value {
+	struct cpu_stats cpus[MAX_CPUS];
+};
+
+struct {
+	__uint(type, BPF_MAP_TYPE_ARRAY);
+	__type(key, u32);
+	__type(value, struct cpu_stats_map_value);
+	__uint(max_entries, 1);
+} cpu_stats SEC(".maps");
+
+/*
+ * Return the statistics of @cpu, or NULL if @cpu is not valid.
+ */
+static struct cpu_stats *lookup_cpu_stats(s32 cpu)
+{
+	struct cpu_stats_map_value *v;
+	const u32 idx = 0;
+
+	if (cpu < 0 || cpu >= MAX_CPUS)
+		return NULL;
+	v = bpf_map_lookup_elem(&cpu_stats, &idx);
+	if (!v)
+		return NULL;
+	return &v->cpus[cpu];
+}
+
 /*
  * Return a CPU context.
  */
@@ -225,6 +636,16 @@ struct cpu_ctx *try_lookup_cpu_ctx(s32 cpu)
 	return bpf_map_lookup_percpu_elem(&cpu_ctx_stor, &idx, cpu);
 }
 
+/*
+ * Return true if @cpu has been taken offline, false otherwise.
+ */
+static bool is_cpu_offline(s32 cpu)
+{
+	struct cpu_ctx *cctx = try_lookup_cpu_ctx(cpu);
+
+	return cctx && cctx->offline;
+}
+
 /*
  * Per-task local storage.
  *
@@ -242,6 +663,12 @@ struct task_ctx {
 	 */
 	u64 start_ts;
 
+	/*
+	 * Time the task last started running, from bpf_ktime_get_ns() (unlike
+	 * start_ts, that uses the rq clock), reported to the tracers.
+	 */
+	u64 running_ts;
+
 	/*
 	 * Timestamp since last time the task released a CPU.
 	 */
@@ -251,6 +678,44 @@ struct task_ctx {
 	 * Execution time (in nanoseconds) since the last sleep event.
 	 */
 	u64 exec_runtime;
+
+	/*
+	 * Execution time (in nanoseconds) since the task was last sent to
+	 * the user-space scheduler.
+	 */
+	u64 queued_runtime;
+
+	/*
+	 * CPU where the task ran last (-1 if it never ran).
+	 */
+	s32 last_cpu;
+
+	/*
+	 * CPU and flags of the last wakeup, reported to the user-space
+	 * scheduler with the next enqueue (waker_cpu is -1 if the task
+	 * didn't wake up).
+	 */
+	s32 waker_cpu;
+	u64 wake_flags;
+
+	/*
+	 * Number of times the task started running on a CPU different from
+	 * last_cpu (the task context is freed when the task exits).
+	 */
+	u64 nr_migrations;
+
+	/*
+	 * Last NR_RECENT_CPUS CPUs used by the task, most recent first, with
+	 * the time the task stopped running on each of them.
+	 */
+	s32 recent_cpus[NR_RECENT_CPUS];
+	u64 recent_ts[NR_RECENT_CPUS];
+
+	/*
+	 * ops.select_cpu() picked an idle CPU for the task without
+	 * dispatching it (reported with QUEUED_TASK_CPU_SELECTED).
+	 */
+	bool cpu_selected;
 };
 
 /* Map that contains task-local storage. */
@@ -299,6 +764,15 @@ struct {
  */
 #define USERSCHED_TIMER_NS (NSEC_PER_SEC / 10)
 
+/*
+ * Return the time slice assigned to the tasks dispatched without an explicit
+ * time slice.
+ */
+static inline u64 dfl_slice(void)
+{
+	return auto_slice_ns ? : default_slice;
+}
+
 /*
  * Return true if the target task @p is the user-space scheduler.
  */
@@ -315,6 +789,16 @@ static inline bool is_belong_usersched_task(const struct task_struct *p)
 	return p->tgid == usersched_pid;
 }
 
+/*
+ * Return true if the target task @p belongs to a protected process.
+ */
+static bool is_protected_task(const struct task_struct *p)
+{
+	u32 tgid = p->tgid;
+
+	return bpf_map_lookup_elem(&protected_tgids, &tgid) != NULL;
+}
+
 /*
  * Return true if the target task @p is a kernel thread.
  */
@@ -347,6 +831,22 @@ static inline bool is_khugepaged(const struct task_struct *p)
 	return khugepaged_pid && p->pid == khugepaged_pid;
 }
 
+/*
+ * Return true if the target task @p must be scheduled by the user-space
+ * scheduler, false if it must be dispatched directly by the BPF component.
+ */
+static bool is_managed_task(const struct task_struct *p)
+{
+	u64 cgid;
+
+	if (!nr_managed_cgroups)
+		return true;
+
+	cgid = BPF_CORE_READ(p, cgroups, dfl_cgrp, kn, id);
+
+	return bpf_map_lookup_elem(&managed_cgroups, &cgid) != NULL;
+}
+
 /*
  * Return true if @p still wants to run, false otherwise.
  */
@@ -406,7 +906,87 @@ static bool usersched_has_pending_tasks(void)
 	if (nr_scheduled)
 		return true;
 
-	return bpf_ringbuf_query(&queued, BPF_RB_AVAIL_DATA) > 0;
+	switch (nr_queued_shards) {
+	default:
+	case 8:
+		if (bpf_ringbuf_query(&queued_7, BPF_RB_AVAIL_DATA) > 0)
+			return true;
+		/* fallthrough */
+	case 7:
+		if (bpf_ringbuf_query(&queued_6, BPF_RB_AVAIL_DATA) > 0)
+			return true;
+		/* fallthrough */
+	case 6:
+		if (bpf_ringbuf_query(&queued_5, BPF_RB_AVAIL_DATA) > 0)
+			return true;
+		/* fallthrough */
+	case 5:
+		if (bpf_ringbuf_query(&queued_4, BPF_RB_AVAIL_DATA) > 0)
+			return true;
+		/* fallthrough */
+	case 4:
+		if (bpf_ringbuf_query(&queued_3, BPF_RB_AVAIL_DATA) > 0)
+			return true;
+		/* fallthrough */
+	case 3:
+		if (bpf_ringbuf_query(&queued_2, BPF_RB_AVAIL_DATA) > 0)
+			return true;
+		/* fallthrough */
+	case 2:
+		if (bpf_ringbuf_query(&queued_1, BPF_RB_AVAIL_DATA) > 0)
+			return true;
+		/* fallthrough */
+	case 1:
+	case 0:
+		return bpf_ringbuf_query(&queued, BPF_RB_AVAIL_DATA) > 0;
+	}
+}
+
+/*
+ * Return the @queued ring buffer of @p, out of @nr.
+ *
+ * When sharding by CPU, the CPUs are split in @nr contiguous ranges, so that
+ * the user-space scheduler can run the consumer of each ring buffer on the
+ * CPUs that write into it (see queuedShardCpus() in the Go package).
+ */
+static u32 queued_shard(const struct task_struct *p, u64 nr)
+{
+	if (nr <= 1)
+		return 0;
+	if (queued_shard_by_cpu && nr_cpu_ids)
+		return (u64)bpf_get_smp_processor_id() * nr / nr_cpu_ids;
+	return (u32)p->pid % nr;
+}
+
+/*
+ * Reserve a slot for @p in its @queued ring buffer.
+ */
+static struct queued_task_ctx *reserve_queued_task(const struct task_struct *p)
+{
+	u32 sz = sizeof(struct queued_task_ctx);
+	u64 nr = nr_queued_shards;
+
+	if (nr > MAX_QUEUED_SHARDS)
+		nr = MAX_QUEUED_SHARDS;
+
+	switch (queued_shard(p, nr)) {
+	case 1: return bpf_ringbuf_reserve(&queued_1, sz, 0);
+	case 2: return bpf_ringbuf_reserve(&queued_2, sz, 0);
+	case 3: return bpf_ringbuf_reserve(&queued_3, sz, 0);
+	case 4: return bpf_ringbuf_reserve(&queued_4, sz, 0);
+	case 5: return bpf_ringbuf_reserve(&queued_5, sz, 0);
+	case 6: return bpf_ringbuf_reserve(&queued_6, sz, 0);
+	case 7: return bpf_ringbuf_reserve(&queued_7, sz, 0);
+	default: return bpf_ringbuf_reserve(&queued, sz, 0);
+	}
+}
+
+/*
+ * Return true if @dsq_id is a per-LLC DSQ.
+ */
+static bool is_llc_dsq(u64 dsq_id)
+{
+	return dsq_id >= LLC_DSQ_BASE && dsq_id < LLC_DSQ_BASE + MAX_CPUS;
 }
 
 /*
@@ -631,6 +1211,78 @@ static void kick_task_cpu(const struct task_struct *p, s32 cpu)
 	scx_bpf_kick_cpu(cpu, SCX_KICK_IDLE);
 }
 
+/*
+ * Account a dispatch of the user-space scheduler that couldn't be honored.
+ */
+static void record_bounce(const struct dispatched_task_ctx *task, u32 reason)
+{
+	struct bounce_event_ctx *event;
+	u64 *nr;
+
+	nr = bpf_map_lookup_elem(&bounce_stats, &reason);
+	if (nr)
+		__sync_fetch_and_add(nr, 1);
+
+	if (!bounce_events)
+		return;
+	event = bpf_ringbuf_reserve(&bounce_rb, sizeof(*event), 0);
+	if (!event)
+		return;
+	event->pid = task->pid;
+	event->cpu = task->cpu;
+	event->reason = reason;
+	bpf_ringbuf_submit(event, 0);
+}
+
+/*
+ * Dispatch @p to the LLC DSQ selected by the user-space scheduler and kick an
+ * idle CPU of that LLC that @p can use, to consume it.
+ *
+ * Return false, without dispatching @p, if the DSQ hasn't been created by
+ * setup_llc_dsq() or if @p can't run on any CPU of the LLC.
+ */
+static bool dispatch_llc_task(struct task_struct *p,
+			      const struct dispatched_task_ctx *task,
+			      u64 slice, s32 prev_cpu)
+{
+	struct bpf_cpumask *llc_mask, *mask;
+	struct task_ctx *tctx;
+	struct llc_ctx *lctx;
+	u32 llc_id = task->dsq_id - LLC_DSQ_BASE;
+	s32 cpu;
+
+	lctx = bpf_map_lookup_elem(&llc_ctx_stor, &llc_id);
+	if (!lctx || !lctx->created)
+		return false;
+	llc_mask = lctx->cpumask;
+	if (!llc_mask)
+		return false;
+
+	/*
+	 * Use the temporary cpumask of the task to compute the CPUs of the
+	 * LLC that it can use.
+	 */
+	tctx = try_lookup_task_ctx(p);
+	if (!tctx)
+		return false;
+	mask = tctx->l3_cpumask;
+	if (!mask || !bpf_cpumask_and(mask, p->cpus_ptr, cast_mask(llc_mask)))
+		return false;
+
+	scx_bpf_dsq_insert_vtime(p, task->dsq_id,
+				 slice, task->vtime, task->flags);
+
+	if (bpf_cpumask_test_cpu(prev_cpu, cast_mask(mask)) &&
+	    scx_bpf_test_and_clear_cpu_idle(prev_cpu))
+		cpu = prev_cpu;
+	else
+		cpu = scx_bpf_pick_idle_cpu(cast_mask(mask), 0);
+	if (cpu >= 0)
+		scx_bpf_kick_cpu(cpu, SCX_KICK_IDLE);
+
+	return true;
+}
+
 /*
  * Dispatch a task to a target per-CPU DSQ, waking up the corresponding CPU, if
  * needed.
@@ -638,21 +1290,72 @@ static void kick_task_cpu(const struct task_struct *p, s32 cpu)
 static void dispatch_task(const struct dispatched_task_ctx *task)
 {
 	struct task_struct *p;
+	struct cpu_stats *stats;
+	/*
+	 * A zero slice keeps the current slice of the task, unless the
+	 * auto-slice tuner is running.
+	 */
+	u64 slice = task->slice_ns ? : auto_slice_ns;
 	s32 prev_cpu;
 
 	/* Ignore entry if the task doesn't exist anymore */
 	p = bpf_task_from_pid(task->pid);
-	if (!p)
+	if (!p) {
+		__sync_fetch_and_add(&nr_dispatch_dropped, 1);
+		record_bounce(task, BOUNCE_EXITED);
 		return;
+	}
 	prev_cpu = scx_bpf_task_cpu(p);
 
+	/*
+	 * Dispatch the task to the LLC DSQ selected by the user-space
+	 * scheduler: it will be consumed by the first CPU of that LLC that
+	 * becomes available (and that the task can use).
+	 */
+	if (is_llc_dsq(task->dsq_id)) {
+		if (dispatch_llc_task(p, task, slice, prev_cpu)) {
+			__sync_fetch_and_add(&nr_user_dispatches, 1);
+			goto out_release;
+		}
+		/*
+		 * The LLC DSQ doesn't exist (e.g. the scheduler has been
+		 * reloaded since setup_llc_dsq()) or none of its CPUs can run
+		 * the task: use the shared DSQ.
+		 */
+		scx_bpf_dsq_insert_vtime(p, SHARED_DSQ,
+					 slice, task->vtime, task->flags);
+		__sync_fetch_and_add(&nr_bounce_dispatches, 1);
+		record_bounce(task, BOUNCE_NO_LLC_DSQ);
+		kick_task_cpu(p, prev_cpu);
+
+		goto out_release;
+	}
+
+	/*
+	 * Sticky tasks without a specific target CPU stay on the CPU they
+	 * used last, to keep their cache warm, as long as they're still
+	 * allowed to run there.
+	 */
+	if (task->cpu == RL_CPU_ANY && task->sticky &&
+	    bpf_cpumask_test_cpu(prev_cpu, p->cpus_ptr)) {
+		scx_bpf_dsq_insert_vtime(p, cpu_to_dsq(prev_cpu),
+					 slice, task->vtime, task->flags);
+		__sync_fetch_and_add(&nr_user_dispatches, 1);
+		stats = lookup_cpu_stats(prev_cpu);
+		if (stats)
+			__sync_fetch_and_add(&stats->nr_dispatches, 1);
+		scx_bpf_kick_cpu(prev_cpu, SCX_KICK_IDLE);
+
+		goto out_release;
+	}
+
 	/*
 	 * Dispatch task to the shared DSQ if the user-space scheduler
 	 * didn't select any specific target CPU.
 	 */
 	if (task->cpu == RL_CPU_ANY) {
 		scx_bpf_dsq_insert_vtime(p, SHARED_DSQ,
-					 task->slice_ns, task->vtime, task->flags);
+					 slice, task->vtime, task->flags);
 		kick_task_cpu(p, prev_cpu);
 
 		goto out_release;
@@ -665,8 +1368,29 @@ static void dispatch_task(const struct dispatched_task_ctx *task)
 	 */
 	if (!bpf_cpumask_test_cpu(task->cpu, p->cpus_ptr)) {
 		scx_bpf_dsq_insert_vtime(p, SHARED_DSQ,
-					 task->slice_ns, task->vtime, task->flags);
+					 slice, task->vtime, task->flags);
 		__sync_fetch_and_add(&nr_bounce_dispatches, 1);
+		record_bounce(task, BOUNCE_NOT_ALLOWED);
+		stats = lookup_cpu_stats(task->cpu);
+		if (stats)
+			__sync_fetch_and_add(&stats->nr_bounces, 1);
+		kick_task_cpu(p, prev_cpu);
+
+		goto out_release;
+	}
+
+	/*
+	 * The target CPU went offline after the user-space scheduler picked
+	 * it: its local DSQ wouldn't be consumed, use the shared DSQ.
+	 */
+	if (is_cpu_offline(task->cpu)) {
+		scx_bpf_dsq_insert_vtime(p, SHARED_DSQ,
+					 slice, task->vtime, task->flags);
+		__sync_fetch_and_add(&nr_bounce_dispatches, 1);
+		record_bounce(task, BOUNCE_OFFLINE);
+		stats = lookup_cpu_stats(task->cpu);
+		if (stats)
+			__sync_fetch_and_add(&stats->nr_bounces, 1);
 		kick_task_cpu(p, prev_cpu);
 
 		goto out_release;
@@ -678,7 +1402,7 @@ static void dispatch_task(const struct dispatched_task_ctx *task)
 	 */
 	if (task->vtime) {
 		scx_bpf_dsq_insert_vtime(p, cpu_to_dsq(task->cpu),
-				task->slice_ns, task->vtime, task->flags);
+				slice, task->vtime, task->flags);
 		__sync_fetch_and_add(&nr_user_dispatches, 1);
 	} else {
 		s32 cur_pid;
@@ -687,7 +1411,7 @@ static void dispatch_task(const struct dispatched_task_ctx *task)
 		elem = bpf_map_lookup_elem(&priority_tasks, &cur_pid);
 		if (!elem){
 			scx_bpf_dsq_insert_vtime(p, cpu_to_dsq(task->cpu),
-				task->slice_ns, task->vtime, task->flags);
+				slice, task->vtime, task->flags);
 			__sync_fetch_and_add(&nr_user_dispatches, 1);
 		}
 	}
@@ -705,10 +1429,14 @@ static void dispatch_task(const struct dispatched_task_ctx *task)
 	if (!bpf_cpumask_test_cpu(task->cpu, p->cpus_ptr)) {
 		scx_bpf_dispatch_cancel();
 		__sync_fetch_and_add(&nr_cancel_dispatches, 1);
+		record_bounce(task, BOUNCE_CANCELLED);
 
 		goto out_release;
 	}
 
+	stats = lookup_cpu_stats(task->cpu);
+	if (stats)
+		__sync_fetch_and_add(&stats->nr_dispatches, 1);
 	scx_bpf_kick_cpu(task->cpu, SCX_KICK_IDLE);
 
 out_release:
@@ -726,6 +1454,17 @@ static bool is_wake_sync(u64 wake_flags)
 	return (wake_flags & SCX_WAKE_SYNC) && !(current->flags & PF_EXITING);
 }
 
+/*
+ * Return true if the CPU of @p must be selected in BPF (see
+ * @fast_select_tasks).
+ */
+static bool is_fast_select_task(const struct task_struct *p)
+{
+	u32 pid = p->pid;
+
+	return bpf_map_lookup_elem(&fast_select_tasks, &pid) != NULL;
+}
+
 /*
  * Try to dispatch a task directly on an idle CPU.
  *
@@ -742,9 +1481,9 @@ static s32 try_direct_dispatch(struct task_struct *p,
 	/*
 	 * If built-in idle CPU policy is not enabled completely delegate
 	 * the idle selection policy to user-space and keep re-using the
-	 * same CPU here.
+	 * same CPU here (unless the task opted in the fast path).
 	 */
-	if (!builtin_idle)
+	if (!builtin_idle && !is_fast_select_task(p))
 		return -EBUSY;
 
 	/*
@@ -794,6 +1533,7 @@ static s32 try_direct_dispatch(struct task_struct *p,
 s32 BPF_STRUCT_OPS(goland_select_cpu, struct task_struct *p, s32 prev_cpu,
 		   u64 wake_flags)
 {
+	struct task_ctx *tctx;
 	bool dispatched = false;
 	s32 cpu;
 
@@ -805,8 +1545,24 @@ s32 BPF_STRUCT_OPS(goland_select_cpu, struct task_struct *p, s32 prev_cpu,
 		return prev_cpu;
 
 	cpu = try_direct_dispatch(p, prev_cpu, 0, &dispatched);
-	if (cpu >= 0)
+	tctx = try_lookup_task_ctx(p);
+	if (tctx)
+		tctx->cpu_selected = cpu >= 0 && !dispatched;
+	if (cpu >= 0) {
+		__sync_fetch_and_add(&nr_select_fast, 1);
 		return cpu;
+	}
+
+	/*
+	 * The task is going to be enqueued to the user-space scheduler: save
+	 * the waker's CPU, so it can place the task close to it. The sync
+	 * flag is dropped if the waker doesn't release its CPU.
+	 */
+	if (tctx) {
+		tctx->waker_cpu = bpf_get_smp_processor_id();
+		tctx->wake_flags = is_wake_sync(wake_flags) ?
+				   wake_flags : wake_flags & ~SCX_WAKE_SYNC;
+	}
 
 	/*
 	 * If we couldn't find an idle CPU, in case of a sync wakeup
@@ -818,8 +1574,13 @@ s32 BPF_STRUCT_OPS(goland_select_cpu, struct task_struct *p, s32 prev_cpu,
 
 SEC("syscall")
 int do_preempt(struct preempt_cpu_arg *input)
-{	
+{
+	struct cpu_stats *stats;
+
 	scx_bpf_kick_cpu(input->cpu_id, SCX_KICK_PREEMPT);
+	stats = lookup_cpu_stats(input->cpu_id);
+	if (stats)
+		__sync_fetch_and_add(&stats->nr_preemptions, 1);
 	return 0;
 }
 
@@ -840,10 +1601,37 @@ int rs_select_cpu(struct task_cpu_arg *input)
 	bpf_rcu_read_lock();
 	cpu = pick_idle_cpu(p, input->cpu);
 	bpf_rcu_read_unlock();
+	__sync_fetch_and_add(&nr_select_user, 1);
+
+	bpf_task_release(p);
+
+	return cpu;
+}
+
+/*
+ * Copy the kernel cpumask @src into @cpumask (MAX_CPUS bits).
+ */
+static void copy_cpumask(u64 *cpumask, const struct cpumask *src)
+{
+	u32 nr_words = (nr_cpu_ids + 63) / 64;
 
-	bpf_task_release(p);
+	__builtin_memset(cpumask, 0, MAX_CPUS / 8);
 
-	return cpu;
+	/*
+	 * Read only the words covered by nr_cpu_ids, the kernel cpumask can
+	 * be smaller than MAX_CPUS.
+	 */
+	if (nr_words < 1 || nr_words > MAX_CPUS / 64)
+		nr_words = MAX_CPUS / 64;
+	bpf_probe_read_kernel(cpumask, nr_words * sizeof(u64), src);
+}
+
+/*
+ * Copy the cpumask of the CPUs that @p can use into @cpumask.
+ */
+static void get_task_cpumask(u64 *cpumask, const struct task_struct *p)
+{
+	copy_cpumask(cpumask, p->cpus_ptr);
 }
 
 /*
@@ -854,17 +1642,72 @@ static void get_task_info(struct queued_task_ctx *task,
 			  const struct task_struct *p, u64 enq_flags)
 {
 	struct task_ctx *tctx = try_lookup_task_ctx(p);
+	struct task_switch_stats *sw;
+	u32 pid = p->pid;
+	u64 now;
+	int i;
 
 	task->pid = p->pid;
 	task->cpu = scx_bpf_task_cpu(p);
 	task->nr_cpus_allowed = p->nr_cpus_allowed;
 	task->flags = enq_flags;
 	task->start_ts = tctx ? tctx->start_ts : 0;
+	task->running_ts = tctx ? tctx->running_ts : 0;
 	task->stop_ts = tctx ? tctx->stop_ts : 0;
 	task->exec_runtime = tctx ? tctx->exec_runtime : 0;
 	task->weight = p->scx.weight;
 	task->vtime = p->scx.dsq_vtime;
 	task->tgid = p->tgid;
+	/* static_prio = NICE_TO_PRIO(nice) = nice + DEFAULT_PRIO (120) */
+	task->nice = p->static_prio - 120;
+
+	/*
+	 * Report the CPU time used since the task was last queued and start
+	 * a new accounting period.
+	 */
+	task->runtime_ns = 0;
+	if (tctx) {
+		task->runtime_ns = tctx->queued_runtime;
+		tctx->queued_runtime = 0;
+	}
+
+	get_task_cpumask(task->cpumask, p);
+	task->cgroup_id = BPF_CORE_READ(p, cgroups, dfl_cgrp, kn, id);
+	task->enq_ts = bpf_ktime_get_ns();
+	sw = bpf_map_lookup_elem(&task_switch_stats, &pid);
+	task->nvcsw = sw ? sw->nvcsw : 0;
+	task->nivcsw = sw ? sw->nivcsw : 0;
+	task->task_flags = 0;
+	if (is_kthread(p))
+		task->task_flags |= QUEUED_TASK_KTHREAD;
+	if (tctx && tctx->cpu_selected) {
+		/* Only valid for the enqueue that follows ops.select_cpu() */
+		if (enq_flags & SCX_ENQ_WAKEUP)
+			task->task_flags |= QUEUED_TASK_CPU_SELECTED;
+		tctx->cpu_selected = false;
+	}
+	task->prev_cpu = tctx ? tctx->last_cpu : -1;
+	bpf_probe_read_kernel_str(task->comm, sizeof(task->comm), p->comm);
+	task->nr_migrations = tctx ? tctx->nr_migrations : 0;
+
+	/* Report the CPUs used within the window, aging out the others */
+	now = scx_bpf_now();
+	for (i = 0; i < NR_RECENT_CPUS; i++) {
+		task->recent_cpus[i] = -1;
+		if (tctx && tctx->recent_cpus[i] >= 0 &&
+		    now - tctx->recent_ts[i] <= RECENT_CPUS_WINDOW_NS)
+			task->recent_cpus[i] = tctx->recent_cpus[i];
+	}
+
+	/* Report the last wakeup only once */
+	task->waker_cpu = -1;
+	task->wake_flags = 0;
+	if (tctx) {
+		task->waker_cpu = tctx->waker_cpu;
+		task->wake_flags = tctx->wake_flags;
+		tctx->waker_cpu = -1;
+		tctx->wake_flags = 0;
+	}
 }
 
 /*
@@ -876,6 +1719,111 @@ static void sched_congested(struct task_struct *p)
 	__sync_fetch_and_add(&nr_sched_congested, 1);
 }
 
+/*
+ * Dispatch a task directly from the BPF component according to the priority
+ * assigned by the user-space scheduler in @task_prio.
+ *
+ * Return true if the task has been dispatched, false if it must be processed
+ * by the user-space scheduler.
+ */
+static bool dispatch_prio_task(struct task_struct *p, u64 enq_flags)
+{
+	u32 pid = p->pid, *prio;
+	s32 cpu;
+
+	prio = bpf_map_lookup_elem(&task_prio, &pid);
+	if (!prio)
+		return false;
+
+	switch (*prio) {
+	case TASK_PRIO_CRITICAL:
+		/*
+		 * Run the task as soon as possible on an idle CPU, or preempt
+		 * the task running on its previously used CPU.
+		 */
+		cpu = scx_bpf_pick_idle_cpu(p->cpus_ptr, 0);
+		if (cpu < 0)
+			cpu = scx_bpf_task_cpu(p);
+		scx_bpf_dsq_insert(p, SCX_DSQ_LOCAL_ON | cpu,
+				   dfl_slice(), enq_flags | SCX_ENQ_PREEMPT);
+		scx_bpf_kick_cpu(cpu, SCX_KICK_IDLE);
+		break;
+	case TASK_PRIO_BACKGROUND:
+		/*
+		 * Keep the task away from the user-space scheduler, it will
+		 * run when the per-CPU DSQs are empty.
+		 */
+		cpu = scx_bpf_task_cpu(p);
+		scx_bpf_dsq_insert_vtime(p, SHARED_DSQ,
+					 dfl_slice(), p->scx.dsq_vtime, enq_flags);
+		kick_task_cpu(p, cpu);
+		break;
+	default:
+		return false;
+	}
+	__sync_fetch_and_add(&nr_prio_dispatches, 1);
+
+	return true;
+}
+
+/*
+ * Dispatch a task directly from the BPF component according to the rules
+ * selected by the tag assigned by the user-space scheduler in @task_tags.
+ *
+ * Return true if the task has been dispatched, false if it must be processed
+ * by the user-space scheduler.
+ */
+static bool dispatch_tagged_task(struct task_struct *p, u64 enq_flags)
+{
+	u32 pid = p->pid, rule;
+	s32 cpu = scx_bpf_task_cpu(p);
+	u64 *tag, *nr;
+
+	tag = bpf_map_lookup_elem(&task_tags, &pid);
+	if (!tag || *tag < TASK_TAG_RULES)
+		return false;
+
+	if (*tag & TASK_TAG_BYPASS) {
+		scx_bpf_dsq_insert_vtime(p, SHARED_DSQ,
+					 dfl_slice(), p->scx.dsq_vtime, enq_flags);
+		kick_task_cpu(p, cpu);
+		rule = TAG_RULE_BYPASS;
+	} else if ((*tag & TASK_TAG_STICKY) &&
+		   bpf_cpumask_test_cpu(cpu, p->cpus_ptr)) {
+		scx_bpf_dsq_insert_vtime(p, cpu_to_dsq(cpu),
+					 dfl_slice(), p->scx.dsq_vtime, enq_flags);
+		scx_bpf_kick_cpu(cpu, SCX_KICK_IDLE);
+		rule = TAG_RULE_STICKY;
+	} else if (*tag & TASK_TAG_INTERACTIVE) {
+		cpu = scx_bpf_pick_idle_cpu(p->cpus_ptr, 0);
+		if (cpu < 0)
+			return false;
+		scx_bpf_dsq_insert(p, SCX_DSQ_LOCAL_ON | cpu,
+				   dfl_slice(), enq_flags);
+		scx_bpf_kick_cpu(cpu, SCX_KICK_IDLE);
+		rule = TAG_RULE_INTERACTIVE;
+	} else {
+		return false;
+	}
+
+	nr = bpf_map_lookup_elem(&tag_stats, &rule);
+	if (nr)
+		__sync_fetch_and_add(nr, 1);
+
+	return true;
+}
+
+/*
+ * Return true if too many tasks are waiting to be consumed by the user-space
+ * scheduler, false otherwise.
+ */
+static bool is_usersched_saturated(void)
+{
+	u64 max = max_queued ? : MAX_QUEUED_DFL;
+
+	return nr_queued >= max;
+}
+
 /*
  * Return true if a task has been enqueued as a remote wakeup, false
  * otherwise.
@@ -902,6 +1850,19 @@ void BPF_STRUCT_OPS(goland_enqueue, struct task_struct *p, u64 enq_flags)
 	if (is_usersched_task(p))
 		return;
 
+	/*
+	 * Run the threads of the protected processes on the local DSQ of
+	 * their CPU, so that the user-space scheduler can't be starved by its
+	 * own scheduling policy.
+	 */
+	if (is_protected_task(p)) {
+		cpu = scx_bpf_task_cpu(p);
+		scx_bpf_dsq_insert(p, SCX_DSQ_LOCAL_ON | cpu, dfl_slice(), enq_flags);
+		__sync_fetch_and_add(&nr_protected_dispatches, 1);
+		scx_bpf_kick_cpu(cpu, SCX_KICK_IDLE);
+		return;
+	}
+
 	/*
 	 * WORKAROUND: Dispatch user-space scheduler to the shared DSQ to avoid
 	 * starvation on user space scheduler goroutine(s).
@@ -914,6 +1875,17 @@ void BPF_STRUCT_OPS(goland_enqueue, struct task_struct *p, u64 enq_flags)
 		return;
 	}
 
+	/*
+	 * Route every task through the kernel fallback path while the
+	 * user-space scheduler is bypassed.
+	 */
+	if (bypass) {
+		scx_bpf_dsq_insert_vtime(p, SHARED_DSQ, dfl_slice(), p->scx.dsq_vtime, enq_flags);
+		__sync_fetch_and_add(&nr_bypass_dispatches, 1);
+		__sync_fetch_and_add(&nr_kernel_dispatches, 1);
+		goto out_kick;
+	}
+
 	/*
 	 * Always dispatch per-CPU kthreads directly on their target CPU.
 	 *
@@ -924,14 +1896,14 @@ void BPF_STRUCT_OPS(goland_enqueue, struct task_struct *p, u64 enq_flags)
 	if (is_kthread(p) && p->nr_cpus_allowed == 1 && early_processing) {
 		cpu = scx_bpf_task_cpu(p);
                 scx_bpf_dsq_insert_vtime(p, cpu_to_dsq(cpu),
-					 default_slice, p->scx.dsq_vtime, enq_flags);
+					 dfl_slice(), p->scx.dsq_vtime, enq_flags);
 		__sync_fetch_and_add(&nr_kernel_dispatches, 1);
 		return;
 	}
 	if (is_kswapd(p) || is_khugepaged(p)) {
 		cpu = scx_bpf_task_cpu(p);
                 scx_bpf_dsq_insert_vtime(p, cpu_to_dsq(cpu),
-					 default_slice, p->scx.dsq_vtime, enq_flags);
+					 dfl_slice(), p->scx.dsq_vtime, enq_flags);
 		__sync_fetch_and_add(&nr_kernel_dispatches, 1);
 		return;
 	}
@@ -940,7 +1912,7 @@ void BPF_STRUCT_OPS(goland_enqueue, struct task_struct *p, u64 enq_flags)
 	 * Give the task a chance to be directly dispatched if
 	 * ops.select_cpu() was skipped.
 	 */
-	if (builtin_idle && is_queued_wakeup(p, enq_flags)) {
+	if (is_queued_wakeup(p, enq_flags)) {
 		bool dispatched = false;
 
 		cpu = try_direct_dispatch(p, scx_bpf_task_cpu(p), enq_flags, &dispatched);
@@ -950,6 +1922,30 @@ void BPF_STRUCT_OPS(goland_enqueue, struct task_struct *p, u64 enq_flags)
 		}
 	}
 
+	/*
+	 * Honor the priority assigned to the task by the user-space scheduler,
+	 * even if the user-space scheduler is congested.
+	 */
+	if (dispatch_prio_task(p, enq_flags))
+		return;
+
+	/*
+	 * Apply the rules selected by the tag of the task, for the tasks
+	 * already classified by the user-space scheduler.
+	 */
+	if (dispatch_tagged_task(p, enq_flags))
+		return;
+
+	/*
+	 * Tasks that don't belong to any of the managed cgroups never reach
+	 * the user-space scheduler: dispatch them directly on the shared DSQ.
+	 */
+	if (!is_managed_task(p)) {
+		scx_bpf_dsq_insert_vtime(p, SHARED_DSQ, dfl_slice(), p->scx.dsq_vtime, enq_flags);
+		__sync_fetch_and_add(&nr_kernel_dispatches, 1);
+		goto out_kick;
+	}
+
 	u64* elem;
 	u64 slice;
 	u32 pid = p->pid;
@@ -982,6 +1978,20 @@ void BPF_STRUCT_OPS(goland_enqueue, struct task_struct *p, u64 enq_flags)
 		}
 	}
 
+	/*
+	 * If the user-space scheduler is not keeping up (i.e., it is stalled
+	 * by a GC pause or a page fault storm), dispatch the task directly
+	 * from the kernel, to prevent the whole system from stalling behind
+	 * the @queued list.
+	 */
+	if (is_usersched_saturated()) {
+		dbg_msg("saturated: pid=%d (%s)", p->pid, p->comm);
+		__sync_fetch_and_add(&nr_sched_saturated, 1);
+		scx_bpf_dsq_insert_vtime(p, SHARED_DSQ, SCX_SLICE_DFL, p->scx.dsq_vtime, enq_flags);
+		__sync_fetch_and_add(&nr_kernel_dispatches, 1);
+		goto out_kick;
+	}
+
 	/*
 	 * Add tasks to the @queued list, they will be processed by the
 	 * user-space scheduler.
@@ -990,7 +2000,7 @@ void BPF_STRUCT_OPS(goland_enqueue, struct task_struct *p, u64 enq_flags)
 	 * will be dispatched directly from the kernel (using the first CPU
 	 * available in this case).
 	 */
-	task = bpf_ringbuf_reserve(&queued, sizeof(*task), 0);
+	task = reserve_queued_task(p);
 	if (!task) {
 		sched_congested(p);
 		scx_bpf_dsq_insert_vtime(p, SHARED_DSQ, SCX_SLICE_DFL, p->scx.dsq_vtime, enq_flags);
@@ -1046,6 +2056,7 @@ static long handle_dispatched_task(struct bpf_dynptr *dynptr, void *context)
 	if (!task)
 		return 0;
 
+	__sync_fetch_and_add(&nr_dispatch_received, 1);
 	dispatch_task(task);
 
 	return !!scx_bpf_dispatch_nr_slots();
@@ -1063,6 +2074,13 @@ static long handle_dispatched_task(struct bpf_dynptr *dynptr, void *context)
  */
 void BPF_STRUCT_OPS(goland_dispatch, s32 cpu, struct task_struct *prev)
 {
+	struct cpu_ctx *cctx;
+	struct cpu_stats *stats;
+
+	stats = lookup_cpu_stats(cpu);
+	if (stats)
+		stats->nr_queued = scx_bpf_dsq_nr_queued(cpu_to_dsq(cpu));
+
 	/*
 	 * Fire up the user-space scheduler: it will run only if no other
 	 * task needs to run.
@@ -1083,6 +2101,13 @@ void BPF_STRUCT_OPS(goland_dispatch, s32 cpu, struct task_struct *prev)
 	if (scx_bpf_dsq_move_to_local(cpu_to_dsq(cpu)))
 		return;
 
+	/*
+	 * Consume a task from the DSQ of the CPU's LLC.
+	 */
+	cctx = try_lookup_cpu_ctx(cpu);
+	if (cctx && cctx->llc_dsq && scx_bpf_dsq_move_to_local(cctx->llc_dsq))
+		return;
+
 	/*
 	 * Consume a task from the shared DSQ.
 	 */
@@ -1136,6 +2161,8 @@ void BPF_STRUCT_OPS(goland_running, struct task_struct *p)
 	s32 cpu = scx_bpf_task_cpu(p);
 	struct task_ctx *tctx;
 
+	update_slice_end(cpu, p, true);
+
 	if (is_usersched_task(p)) {
 		usersched_last_run_at = scx_bpf_now();
 		return;
@@ -1152,10 +2179,68 @@ void BPF_STRUCT_OPS(goland_running, struct task_struct *p)
 	 */
 	__sync_fetch_and_add(&nr_running, 1);
 
+	/*
+	 * Advance the global vtime (priority tasks, with vtime 0, never move
+	 * it back).
+	 */
+	if ((s64)(p->scx.dsq_vtime - vtime_now) > 0)
+		vtime_now = p->scx.dsq_vtime;
+
 	tctx = try_lookup_task_ctx(p);
 	if (!tctx)
 		return;
 	tctx->start_ts = scx_bpf_now();
+	tctx->running_ts = bpf_ktime_get_ns();
+	if (tctx->last_cpu >= 0 && tctx->last_cpu != cpu)
+		tctx->nr_migrations++;
+	tctx->last_cpu = cpu;
+}
+
+/*
+ * Record that the task stopped running on @cpu at @now in its recently used
+ * CPUs: @cpu moves to the front and, if it wasn't there, the oldest CPU is
+ * dropped.
+ */
+static void update_recent_cpus(struct task_ctx *tctx, s32 cpu, u64 now)
+{
+	int i, pos = NR_RECENT_CPUS - 1;
+
+	for (i = 0; i < NR_RECENT_CPUS; i++) {
+		if (tctx->recent_cpus[i] == cpu) {
+			pos = i;
+			break;
+		}
+	}
+	for (i = NR_RECENT_CPUS - 1; i > 0; i--) {
+		if (i > pos)
+			continue;
+		tctx->recent_cpus[i] = tctx->recent_cpus[i - 1];
+		tctx->recent_ts[i] = tctx->recent_ts[i - 1];
+	}
+	tctx->recent_cpus[0] = cpu;
+	tctx->recent_ts[0] = now;
+}
+
+/*
+ * Count a context switch of @p: voluntary if it's going to sleep, involuntary
+ * if it's still @runnable (its time slice expired or it has been preempted).
+ */
+static void update_switch_stats(const struct task_struct *p, bool runnable)
+{
+	struct task_switch_stats *sw, init = {};
+	u32 pid = p->pid;
+
+	sw = bpf_map_lookup_elem(&task_switch_stats, &pid);
+	if (!sw) {
+		bpf_map_update_elem(&task_switch_stats, &pid, &init, BPF_NOEXIST);
+		sw = bpf_map_lookup_elem(&task_switch_stats, &pid);
+		if (!sw)
+			return;
+	}
+	if (runnable)
+		__sync_fetch_and_add(&sw->nivcsw, 1);
+	else
+		__sync_fetch_and_add(&sw->nvcsw, 1);
 }
 
 /*
@@ -1167,22 +2252,51 @@ void BPF_STRUCT_OPS(goland_stopping, struct task_struct *p, bool runnable)
 	s32 cpu = scx_bpf_task_cpu(p);
 	struct task_ctx *tctx;
 
+	update_slice_end(cpu, p, false);
+
 	if (is_usersched_task(p))
 		return;
 
+	bpf_map_delete_elem(&running_task, &cpu);
+
 	dbg_msg("stop: pid=%d (%s) cpu=%ld", p->pid, p->comm, cpu);
 
 	__sync_fetch_and_sub(&nr_running, 1);
 
+	update_switch_stats(p, runnable);
+
 	tctx = try_lookup_task_ctx(p);
 	if (!tctx)
 		return;
 	tctx->stop_ts = now;
 
 	/*
-	 * Update the partial execution time since last sleep.
+	 * Update the partial execution time since last sleep and since the
+	 * task was last queued (this is called also when the task is
+	 * preempted by a higher priority sched_class, so the latter can span
+	 * multiple running periods).
 	 */
 	tctx->exec_runtime += now - tctx->start_ts;
+	tctx->queued_runtime += now - tctx->start_ts;
+
+	update_recent_cpus(tctx, cpu, now);
+}
+
+/*
+ * Notify the user-space scheduler that @cpu has been released or acquired,
+ * or that it went online or offline.
+ */
+static void send_cpu_event(s32 cpu, u32 kind)
+{
+	struct cpu_event_ctx *event;
+
+	event = bpf_ringbuf_reserve(&cpu_rb, sizeof(*event), 0);
+	if (!event)
+		return;
+	event->cpu = cpu;
+	event->kind = kind;
+	event->ts = scx_bpf_now();
+	bpf_ringbuf_submit(event, 0);
 }
 
 /*
@@ -1200,6 +2314,72 @@ void BPF_STRUCT_OPS(goland_cpu_release, s32 cpu,
 	dbg_msg("cpu preemption: pid=%d (%s)", p->pid, p->comm);
 	if (is_belong_usersched_task(p))
 		set_usersched_needed();
+
+	send_cpu_event(cpu, CPU_EVENT_RELEASE);
+}
+
+/*
+ * A CPU is given back to the scheduler by a higher priority sched_class.
+ */
+void BPF_STRUCT_OPS(goland_cpu_acquire, s32 cpu,
+				struct scx_cpu_acquire_args *args)
+{
+	send_cpu_event(cpu, CPU_EVENT_ACQUIRE);
+}
+
+/*
+ * A CPU is brought online.
+ *
+ * Implementing the hotplug callbacks keeps the scheduler attached when CPUs
+ * go online or offline: the per-CPU DSQs exist for all the possible CPUs.
+ */
+void BPF_STRUCT_OPS(goland_cpu_online, s32 cpu)
+{
+	struct cpu_ctx *cctx = try_lookup_cpu_ctx(cpu);
+
+	if (cctx)
+		cctx->offline = false;
+	__sync_fetch_and_add(&nr_online_cpus, 1);
+	send_cpu_event(cpu, CPU_EVENT_ONLINE);
+}
+
+/*
+ * A CPU is taken offline.
+ */
+void BPF_STRUCT_OPS(goland_cpu_offline, s32 cpu)
+{
+	struct cpu_ctx *cctx = try_lookup_cpu_ctx(cpu);
+
+	if (cctx)
+		cctx->offline = true;
+	__sync_fetch_and_sub(&nr_online_cpus, 1);
+	send_cpu_event(cpu, CPU_EVENT_OFFLINE);
+}
+
+/*
+ * Send a lifecycle event of task @p to user space through @exit_rb. @cpumask
+ * is the new affinity of @p for TASK_EVENT_SET_CPUMASK, NULL otherwise.
+ */
+static void send_task_event(const struct task_struct *p, u32 kind,
+			    const struct cpumask *cpumask)
+{
+	struct task_exit_ctx *event;
+
+	event = bpf_ringbuf_reserve(&exit_rb, sizeof(*event), 0);
+	if (!event) {
+		__sync_fetch_and_add(&nr_exit_dropped, 1);
+		return;
+	}
+	event->pid = p->pid;
+	event->tgid = p->tgid;
+	event->kind = kind;
+	event->pad = 0;
+	event->ts = bpf_ktime_get_ns();
+	if (cpumask)
+		copy_cpumask(event->cpumask, cpumask);
+	else
+		__builtin_memset(event->cpumask, 0, sizeof(event->cpumask));
+	bpf_ringbuf_submit(event, 0);
 }
 
 /*
@@ -1209,6 +2389,30 @@ void BPF_STRUCT_OPS(goland_enable, struct task_struct *p)
 {
 	p->scx.dsq_vtime = 0;
 	p->scx.slice = SCX_SLICE_DFL;
+
+	if (task_events)
+		send_task_event(p, TASK_EVENT_ENABLE, NULL);
+}
+
+/*
+ * A task leaves the sched_ext scheduler, e.g. because it switched to
+ * SCHED_FIFO, or before it exits.
+ */
+void BPF_STRUCT_OPS(goland_disable, struct task_struct *p)
+{
+	if (task_events)
+		send_task_event(p, TASK_EVENT_DISABLE, NULL);
+}
+
+/*
+ * The affinity of task @p changed: @cpumask is the new set of CPUs that it
+ * can use.
+ */
+void BPF_STRUCT_OPS(goland_set_cpumask, struct task_struct *p,
+		    const struct cpumask *cpumask)
+{
+	if (task_events || cpumask_events)
+		send_task_event(p, TASK_EVENT_SET_CPUMASK, cpumask);
 }
 
 /*
@@ -1227,6 +2431,10 @@ s32 BPF_STRUCT_OPS(goland_init_task, struct task_struct *p,
 				    BPF_LOCAL_STORAGE_GET_F_CREATE);
 	if (!tctx)
 		return -ENOMEM;
+	tctx->last_cpu = -1;
+	tctx->waker_cpu = -1;
+	for (int i = 0; i < NR_RECENT_CPUS; i++)
+		tctx->recent_cpus[i] = -1;
 
 	/*
 	 * Create task's L2 cache cpumask.
@@ -1403,6 +2611,136 @@ static int init_cpumask(struct bpf_cpumask **cpumask)
 	return err;
 }
 
+/*
+ * Create the DSQ of an LLC (if it doesn't exist yet) and make the CPU consume
+ * it.
+ */
+SEC("syscall")
+int setup_llc_dsq(struct llc_dsq_arg *input)
+{
+	struct bpf_cpumask *mask;
+	struct cpu_ctx *cctx;
+	struct llc_ctx *lctx;
+	u32 llc_id;
+	u64 dsq_id;
+	int err;
+
+	if (input->llc_id < 0 || input->llc_id >= MAX_CPUS)
+		return -EINVAL;
+	cctx = try_lookup_cpu_ctx(input->cpu_id);
+	if (!cctx)
+		return -ENOENT;
+
+	llc_id = input->llc_id;
+	lctx = bpf_map_lookup_elem(&llc_ctx_stor, &llc_id);
+	if (!lctx)
+		return -ENOENT;
+	err = init_cpumask(&lctx->cpumask);
+	if (err)
+		return err;
+
+	dsq_id = LLC_DSQ_BASE + llc_id;
+	err = scx_bpf_create_dsq(dsq_id, -1);
+	if (err && err != -EEXIST)
+		return err;
+	lctx->created = true;
+	cctx->llc_dsq = dsq_id;
+
+	/* Let dispatch_task() know the CPUs that consume the DSQ */
+	bpf_rcu_read_lock();
+	mask = lctx->cpumask;
+	if (mask)
+		bpf_cpumask_set_cpu(input->cpu_id, mask);
+	bpf_rcu_read_unlock();
+
+	return 0;
+}
+
+/*
+ * Set the performance target of a CPU, used by schedutil to pick the CPU
+ * frequency.
+ */
+SEC("syscall")
+int set_cpu_perf(struct cpu_perf_arg *input)
+{
+	if (input->cpu_id < 0 || input->cpu_id >= nr_cpu_ids)
+		return -EINVAL;
+	/* An invalid target would abort the scheduler */
+	if (input->perf > SCX_CPUPERF_ONE)
+		return -ERANGE;
+	scx_bpf_cpuperf_set(input->cpu_id, input->perf);
+
+	return 0;
+}
+
+/*
+ * Return the capacity of a CPU (0..SCX_CPUPERF_ONE), or a negative error.
+ */
+SEC("syscall")
+int get_cpu_perf_cap(struct cpu_perf_arg *input)
+{
+	if (input->cpu_id < 0 || input->cpu_id >= nr_cpu_ids)
+		return -EINVAL;
+
+	return scx_bpf_cpuperf_cap(input->cpu_id);
+}
+
+/*
+ * Report the amount of tasks in a DSQ and the PIDs of the first ones into
+ * @dsq_dump, for debugging. The DSQ is only iterated, the tasks are not
+ * consumed. Return -ENOENT if the DSQ doesn't exist.
+ */
+SEC("syscall")
+int dump_dsq(struct dsq_dump_arg *input)
+{
+	struct task_struct *p;
+	struct dsq_dump *dump;
+	u32 zero = 0, n = 0;
+	s32 nr;
+
+	dump = bpf_map_lookup_elem(&dsq_dump, &zero);
+	if (!dump)
+		return -ENOENT;
+	nr = scx_bpf_dsq_nr_queued(input->dsq_id);
+	if (nr < 0)
+		return nr;
+
+	dump->dsq_id = input->dsq_id;
+	dump->nr_queued = nr;
+	bpf_rcu_read_lock();
+	bpf_for_each(scx_dsq, p, input->dsq_id, 0) {
+		if (n >= DSQ_DUMP_PIDS)
+			break;
+		dump->pids[n & (DSQ_DUMP_PIDS - 1)] = p->pid;
+		n++;
+	}
+	bpf_rcu_read_unlock();
+	dump->nr_pids = n;
+
+	return 0;
+}
+
+/*
+ * Report the idle CPUs, as tracked by the built-in idle tracking, into
+ * @idle_cpumask.
+ */
+SEC("syscall")
+int get_idle_cpumask(void *input)
+{
+	const struct cpumask *idle;
+	struct idle_cpumask *out;
+	u32 zero = 0;
+
+	out = bpf_map_lookup_elem(&idle_cpumask, &zero);
+	if (!out)
+		return -ENOENT;
+	idle = scx_bpf_get_idle_cpumask();
+	copy_cpumask(out->cpumask, idle);
+	scx_bpf_put_idle_cpumask(idle);
+
+	return 0;
+}
+
 SEC("syscall")
 int enable_sibling_cpu(struct domain_arg *input)
 {
@@ -1448,6 +2786,57 @@ s32 BPF_STRUCT_OPS_SLEEPABLE(goland_init)
 	/* Compile-time checks */
 	BUILD_BUG_ON((MAX_CPUS % 2));
 
+	/*
+	 * Layout of the records exchanged with user space: keep in sync with
+	 * goland_core/codec.go.
+	 */
+	BUILD_BUG_ON(sizeof(struct queued_task_ctx) != 312);
+	BUILD_BUG_ON(__builtin_offsetof(struct queued_task_ctx, nr_cpus_allowed) != 8);
+	BUILD_BUG_ON(__builtin_offsetof(struct queued_task_ctx, flags) != 16);
+	BUILD_BUG_ON(__builtin_offsetof(struct queued_task_ctx, start_ts) != 24);
+	BUILD_BUG_ON(__builtin_offsetof(struct queued_task_ctx, stop_ts) != 32);
+	BUILD_BUG_ON(__builtin_offsetof(struct queued_task_ctx, exec_runtime) != 40);
+	BUILD_BUG_ON(__builtin_offsetof(struct queued_task_ctx, weight) != 48);
+	BUILD_BUG_ON(__builtin_offsetof(struct queued_task_ctx, vtime) != 56);
+	BUILD_BUG_ON(__builtin_offsetof(struct queued_task_ctx, tgid) != 64);
+	BUILD_BUG_ON(__builtin_offsetof(struct queued_task_ctx, nice) != 68);
+	BUILD_BUG_ON(__builtin_offsetof(struct queued_task_ctx, runtime_ns) != 72);
+	BUILD_BUG_ON(__builtin_offsetof(struct queued_task_ctx, cpumask) != 80);
+	BUILD_BUG_ON(__builtin_offsetof(struct queued_task_ctx, prev_cpu) != 208);
+	BUILD_BUG_ON(__builtin_offsetof(struct queued_task_ctx, comm) != 212);
+	BUILD_BUG_ON(__builtin_offsetof(struct queued_task_ctx, waker_cpu) != 228);
+	BUILD_BUG_ON(__builtin_offsetof(struct queued_task_ctx, wake_flags) != 232);
+	BUILD_BUG_ON(__builtin_offsetof(struct queued_task_ctx, nr_migrations) != 240);
+	BUILD_BUG_ON(__builtin_offsetof(struct queued_task_ctx, recent_cpus) != 248);
+	BUILD_BUG_ON(__builtin_offsetof(struct queued_task_ctx, cgroup_id) != 264);
+	BUILD_BUG_ON(__builtin_offsetof(struct queued_task_ctx, enq_ts) != 272);
+	BUILD_BUG_ON(__builtin_offsetof(struct queued_task_ctx, nvcsw) != 280);
+	BUILD_BUG_ON(__builtin_offsetof(struct queued_task_ctx, nivcsw) != 288);
+	BUILD_BUG_ON(__builtin_offsetof(struct queued_task_ctx, task_flags) != 296);
+	BUILD_BUG_ON(__builtin_offsetof(struct queued_task_ctx, running_ts) != 304);
+	BUILD_BUG_ON(sizeof(struct task_switch_stats) != 16);
+	BUILD_BUG_ON(sizeof(struct task_exit_ctx) != 152);
+	BUILD_BUG_ON(__builtin_offsetof(struct task_exit_ctx, ts) != 16);
+	BUILD_BUG_ON(__builtin_offsetof(struct task_exit_ctx, cpumask) != 24);
+	BUILD_BUG_ON(sizeof(struct cpu_event_ctx) != 16);
+	BUILD_BUG_ON(sizeof(struct bounce_event_ctx) != 12);
+	BUILD_BUG_ON(__builtin_offsetof(struct cpu_event_ctx, ts) != 8);
+	BUILD_BUG_ON(sizeof(struct dispatched_task_ctx) != 56);
+	BUILD_BUG_ON(sizeof(struct cpu_stats) != 32);
+	BUILD_BUG_ON(__builtin_offsetof(struct dispatched_task_ctx, flags) != 8);
+	BUILD_BUG_ON(__builtin_offsetof(struct dispatched_task_ctx, slice_ns) != 16);
+	BUILD_BUG_ON(__builtin_offsetof(struct dispatched_task_ctx, vtime) != 24);
+	BUILD_BUG_ON(__builtin_offsetof(struct dispatched_task_ctx, cpumask_cnt) != 32);
+	BUILD_BUG_ON(__builtin_offsetof(struct dispatched_task_ctx, sticky) != 40);
+	BUILD_BUG_ON(__builtin_offsetof(struct dispatched_task_ctx, dsq_id) != 48);
+	BUILD_BUG_ON(sizeof(struct task_cpu_arg) != 16);
+	BUILD_BUG_ON(sizeof(struct domain_arg) != 12);
+	BUILD_BUG_ON(sizeof(struct preempt_cpu_arg) != 4);
+	BUILD_BUG_ON(sizeof(struct llc_dsq_arg) != 8);
+	BUILD_BUG_ON(sizeof(struct dsq_dump_arg) != 8);
+	BUILD_BUG_ON(sizeof(struct dsq_dump) != 88);
+	BUILD_BUG_ON(sizeof(struct idle_cpumask) != MAX_CPUS / 8);
+
 	/* Initialize maximum possible CPU number */
 	nr_cpu_ids = scx_bpf_nr_cpu_ids();
 
@@ -1465,13 +2854,22 @@ s32 BPF_STRUCT_OPS_SLEEPABLE(goland_init)
 /*
  * A task is being destroyed.
  *
- * Clean up the task from priority tasks map.
+ * Clean up the task from priority tasks map and notify the user-space
+ * scheduler.
  */
 void BPF_STRUCT_OPS(goland_exit_task, struct task_struct *p,
 		    struct scx_exit_task_args *args)
 {
+	u32 pid = p->pid;
+
 	/* Remove task from priority tasks map */
-	update_priority_task_map(p->pid, 1, 0);
+	update_priority_task_map(pid, 1, 0);
+	bpf_map_delete_elem(&task_prio, &pid);
+	bpf_map_delete_elem(&task_tags, &pid);
+	bpf_map_delete_elem(&task_switch_stats, &pid);
+	bpf_map_delete_elem(&fast_select_tasks, &pid);
+
+	send_task_event(p, TASK_EVENT_EXIT, NULL);
 }
 
 /*
@@ -1492,8 +2890,13 @@ SCX_OPS_DEFINE(goland,
 	       .runnable		= (void *)goland_runnable,
 	       .running			= (void *)goland_running,
 	       .stopping		= (void *)goland_stopping,
+	       .cpu_acquire		= (void *)goland_cpu_acquire,
 	       .cpu_release		= (void *)goland_cpu_release,
+	       .cpu_online		= (void *)goland_cpu_online,
+	       .cpu_offline		= (void *)goland_cpu_offline,
 	       .enable			= (void *)goland_enable,
+	       .disable			= (void *)goland_disable,
+	       .set_cpumask		= (void *)goland_set_cpumask,
 	       .init_task		= (void *)goland_init_task,
 	       .exit_task		= (void *)goland_exit_task,
 	       .init			= (void *)goland_init,
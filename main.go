@@ -5,8 +5,6 @@ import (
 	"log"
 	"os"
 	"os/exec"
-	"os/signal"
-	"syscall"
 	"time"
 
 	core "github.com/Gthulhu/scx_goland_core/goland_core"
@@ -28,7 +26,7 @@ var taskPool = make([]Task, taskPoolSize)
 var taskPoolCount = 0
 var taskPoolHead, taskPoolTail int
 
-func DrainQueuedTask(s *core.Sched) int {
+func DrainQueuedTask(s core.SchedBackend) int {
 	var count int
 	for (taskPoolTail+1)%taskPoolSize != taskPoolHead {
 		var newQueuedTask core.QueuedTask
@@ -49,7 +47,7 @@ func DrainQueuedTask(s *core.Sched) int {
 
 var timeout = uint64(3 * NSEC_PER_SEC)
 
-func updatedEnqueueTask(s *core.Sched, t *core.QueuedTask) uint64 {
+func updatedEnqueueTask(s core.SchedBackend, t *core.QueuedTask) uint64 {
 	if minVruntime < t.Vtime {
 		minVruntime = t.Vtime
 	}
@@ -140,16 +138,21 @@ func InsertTaskToPool(
 }
 
 func main() {
-	bpfModule := core.LoadSched("main.bpf.o")
+	bpfModule, err := core.LoadSchedWithOpts("main.bpf.o", core.LoadSchedOpts{Logger: log.Default()})
+	if err != nil {
+		log.Panicf("LoadSchedWithOpts failed: %v", err)
+	}
 	defer bpfModule.Close()
 	pid := os.Getpid()
-	err := bpfModule.AssignUserSchedPid(pid)
+	err = bpfModule.AssignUserSchedPid(pid)
 	if err != nil {
 		log.Printf("AssignUserSchedPid failed: %v", err)
 	}
 	bpfModule.SetDebug(true)
 	bpfModule.SetBuiltinIdle(true)
-	bpfModule.Start()
+	if _, err := bpfModule.Start(); err != nil {
+		log.Panicf("Start failed: %v", err)
+	}
 
 	err = util.InitCacheDomains(bpfModule)
 	if err != nil {
@@ -178,7 +181,7 @@ func main() {
 				}
 			} else if t.Pid != -1 {
 				task = core.NewDispatchedTask(t)
-				err, cpu = bpfModule.SelectCPU(t)
+				cpu, err = bpfModule.SelectCPU(t)
 				if err != nil {
 					log.Printf("SelectCPU failed: %v", err)
 				}
@@ -195,7 +198,7 @@ func main() {
 					continue
 				}
 
-				err = core.NotifyComplete(uint64(taskPoolCount))
+				err = bpfModule.NotifyComplete(uint64(taskPoolCount))
 				if err != nil {
 					log.Printf("NotifyComplete failed: %v", err)
 				}
@@ -203,28 +206,17 @@ func main() {
 		}
 	}()
 
-	signalChan := make(chan os.Signal, 1)
-	signal.Notify(signalChan, syscall.SIGINT, syscall.SIGTERM)
-	cont := true
-	timer := time.NewTicker(1 * time.Second)
-	for cont {
-		select {
-		case <-signalChan:
-			log.Println("receive os signal")
-			cont = false
-		case <-timer.C:
-			if bpfModule.Stopped() {
-				log.Println("bpfModule stopped")
-				cmd := exec.Command("bpftool", []string{"map", "dump", "name", "main_bpf.data"}...)
-				cmd.Stdout = os.Stdout
-				cmd.Stderr = os.Stderr
-				if err := cmd.Run(); err != nil {
-					log.Printf("bpftool map dump failed: %v", err)
-				}
-				cont = false
-			}
+	sig, err := bpfModule.RunUntilSignal()
+	if err != nil {
+		log.Printf("detach failed: %v", err)
+	}
+	if sig == nil {
+		cmd := exec.Command("bpftool", []string{"map", "dump", "name", "main_bpf.data"}...)
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		if err := cmd.Run(); err != nil {
+			log.Printf("bpftool map dump failed: %v", err)
 		}
 	}
-	timer.Stop()
 	log.Println("scheduler exit")
 }
@@ -0,0 +1,167 @@
+package policy
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	core "github.com/Gthulhu/scx_goland_core/goland_core"
+)
+
+// Bandwidth limits the CPU time used by cgroups to a share of the machine,
+// enforced by the scheduling policy instead of the cfs bandwidth controller:
+// the runtime reported with each task (QueuedTask.RuntimeNs) is charged to
+// its cgroup, and a cgroup that used its quota in the current window is
+// throttled until the next one.
+//
+// The windows of each cgroup are fixed and start when it is first charged.
+// The quota is refilled at the start of every window, and the CPU time used
+// beyond the quota is carried over as a debt: a cgroup that keeps running
+// while throttled gets less in the following windows, so the limit holds on
+// average.
+//
+// It is safe for concurrent use, so that the limits can be changed at
+// runtime while the scheduling loop uses them.
+type Bandwidth struct {
+	// Clock returns the current time in ns (default: a monotonic clock).
+	Clock func() uint64
+
+	mu       sync.Mutex
+	window   uint64 // ns
+	capacity uint64 // CPU time of the machine in a window (ns)
+	groups   map[uint64]*groupBandwidth
+}
+
+// groupBandwidth is the state of a limited cgroup.
+type groupBandwidth struct {
+	pct         float64
+	quota       uint64 // CPU time allowed in a window (ns)
+	used        uint64 // CPU time used in the current window, including the debt (ns)
+	windowStart uint64 // 0 = never charged
+}
+
+// NewBandwidth returns a Bandwidth that enforces the limits over windows of
+// the given length, on a machine with nrCpus CPUs.
+func NewBandwidth(window time.Duration, nrCpus int) *Bandwidth {
+	start := time.Now()
+	return &Bandwidth{
+		Clock:    func() uint64 { return uint64(time.Since(start)) },
+		window:   uint64(window),
+		capacity: uint64(window) * uint64(max(nrCpus, 1)),
+		groups:   make(map[uint64]*groupBandwidth),
+	}
+}
+
+// SetLimit limits the cgroup with id cgid (see core.CgroupID) to pct percent
+// of the CPU time of the machine, 0 < pct <= 100. Changing the limit of a
+// cgroup keeps the CPU time it used in the current window.
+func (b *Bandwidth) SetLimit(cgid uint64, pct float64) error {
+	if !(pct > 0 && pct <= 100) {
+		return fmt.Errorf("invalid bandwidth limit for cgroup %d: %v%%", cgid, pct)
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	g, ok := b.groups[cgid]
+	if !ok {
+		g = &groupBandwidth{}
+		b.groups[cgid] = g
+	}
+	g.pct = pct
+	g.quota = uint64(float64(b.capacity) * pct / 100)
+	return nil
+}
+
+// ClearLimit removes the limit of the cgroup cgid.
+func (b *Bandwidth) ClearLimit(cgid uint64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.groups, cgid)
+}
+
+// Limits returns the limits of the cgroups, in percent of the machine, by
+// cgroup id.
+func (b *Bandwidth) Limits() map[uint64]float64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	limits := make(map[uint64]float64, len(b.groups))
+	for cgid, g := range b.groups {
+		limits[cgid] = g.pct
+	}
+	return limits
+}
+
+// refill starts the window of g that contains now, refilling the quota of
+// every window elapsed since the current one started.
+func (b *Bandwidth) refill(g *groupBandwidth, now uint64) {
+	if g.windowStart == 0 {
+		g.windowStart = max(now, 1)
+		return
+	}
+	if now < g.windowStart+b.window {
+		return
+	}
+	n := (now - g.windowStart) / b.window
+	g.used -= min(g.used, n*g.quota)
+	g.windowStart += n * b.window
+}
+
+// Account charges the CPU time used by t since it was last queued to its
+// cgroup. Tasks of cgroups without a limit are ignored.
+func (b *Bandwidth) Account(t *core.QueuedTask) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	g, ok := b.groups[t.CgroupId]
+	if !ok {
+		return
+	}
+	b.refill(g, b.Clock())
+	g.used += t.RuntimeNs
+}
+
+// ShouldThrottle returns true if the cgroup cgid used its quota in the
+// current window.
+func (b *Bandwidth) ShouldThrottle(cgid uint64) bool {
+	return b.ThrottleDelay(cgid) > 0
+}
+
+// ThrottleDelay returns how long the cgroup cgid stays throttled: until the
+// window in which its debt is paid back. It returns 0 if the cgroup is not
+// throttled.
+func (b *Bandwidth) ThrottleDelay(cgid uint64) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	g, ok := b.groups[cgid]
+	if !ok {
+		return 0
+	}
+	now := b.Clock()
+	b.refill(g, now)
+	if g.used < g.quota || g.quota == 0 {
+		return 0
+	}
+	// Each window pays back one quota: the group can run again in the
+	// window where the used time drops below it.
+	windows := g.used / g.quota
+	end := g.windowStart + windows*b.window
+	return time.Duration(end - min(end, now))
+}
+
+// Throttle down-prioritizes d, the dispatch of t, if the cgroup of t is
+// throttled: its Deadline (or its Vtime, if it has no deadline) is pushed
+// back by the time the cgroup stays throttled, so the tasks of the other
+// cgroups run first. It returns that delay, 0 if the cgroup is not throttled.
+//
+// A scheduler that prefers to hold the tasks of throttled cgroups can keep t
+// queued for the returned delay instead of dispatching d.
+func (b *Bandwidth) Throttle(t *core.QueuedTask, d *core.DispatchedTask) time.Duration {
+	delay := b.ThrottleDelay(t.CgroupId)
+	if delay == 0 {
+		return 0
+	}
+	if d.Deadline != 0 {
+		d.Deadline += uint64(delay)
+	} else {
+		d.Vtime += uint64(delay)
+	}
+	return delay
+}
@@ -0,0 +1,149 @@
+package policy
+
+import (
+	"testing"
+	"time"
+
+	core "github.com/Gthulhu/scx_goland_core/goland_core"
+)
+
+const ms = uint64(time.Millisecond)
+
+// newTestBandwidth returns a Bandwidth with windows of 100ms on 2 CPUs, with
+// a fake clock set by the returned function.
+func newTestBandwidth() (*Bandwidth, func(now uint64)) {
+	b := NewBandwidth(100*time.Millisecond, 2)
+	var now uint64
+	b.Clock = func() uint64 { return now }
+	return b, func(t uint64) { now = t }
+}
+
+func TestBandwidthWindows(t *testing.T) {
+	const cgid = 42
+	const t0 = 10 * ms
+	type step struct {
+		at     uint64        // time of the step
+		limit  float64       // new limit (0 = unchanged)
+		charge uint64        // runtime charged to the cgroup
+		want   time.Duration // ThrottleDelay after the charge
+	}
+	// The limit is 25% of the machine: a quota of 50ms per window.
+	tests := []struct {
+		name  string
+		steps []step
+	}{
+		{"under quota", []step{
+			{at: t0, charge: 40 * ms, want: 0},
+			{at: t0 + 50*ms, charge: 9 * ms, want: 0},
+		}},
+		{"quota used", []step{
+			{at: t0, charge: 50 * ms, want: 100 * time.Millisecond},
+			{at: t0 + 30*ms, want: 70 * time.Millisecond},
+			{at: t0 + 100*ms, want: 0},
+		}},
+		{"debt carried over", []step{
+			{at: t0, charge: 120 * ms, want: 200 * time.Millisecond},
+			{at: t0 + 100*ms, want: 100 * time.Millisecond},
+			{at: t0 + 200*ms, want: 0},
+			{at: t0 + 210*ms, charge: 30 * ms, want: 90 * time.Millisecond},
+		}},
+		{"idle windows", []step{
+			{at: t0, charge: 50 * ms, want: 100 * time.Millisecond},
+			{at: t0 + 350*ms, want: 0},
+			{at: t0 + 350*ms, charge: 50 * ms, want: 50 * time.Millisecond},
+		}},
+		{"limit raised", []step{
+			{at: t0, charge: 50 * ms, want: 100 * time.Millisecond},
+			{at: t0 + 10*ms, limit: 50, want: 0},
+			{at: t0 + 20*ms, charge: 50 * ms, want: 80 * time.Millisecond},
+		}},
+		{"limit lowered", []step{
+			{at: t0, charge: 30 * ms, want: 0},
+			{at: t0 + 10*ms, limit: 10, want: 90 * time.Millisecond},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, setClock := newTestBandwidth()
+			if err := b.SetLimit(cgid, 25); err != nil {
+				t.Fatal(err)
+			}
+			for i, s := range tt.steps {
+				setClock(s.at)
+				if s.limit != 0 {
+					if err := b.SetLimit(cgid, s.limit); err != nil {
+						t.Fatal(err)
+					}
+				}
+				if s.charge != 0 {
+					b.Account(&core.QueuedTask{CgroupId: cgid, RuntimeNs: s.charge})
+				}
+				if got := b.ThrottleDelay(cgid); got != s.want {
+					t.Fatalf("step %d: delay %v, want %v", i, got, s.want)
+				}
+				if got := b.ShouldThrottle(cgid); got != (s.want > 0) {
+					t.Fatalf("step %d: ShouldThrottle %v", i, got)
+				}
+			}
+		})
+	}
+}
+
+func TestBandwidthSetLimit(t *testing.T) {
+	b, _ := newTestBandwidth()
+	for _, tt := range []struct {
+		pct float64
+		ok  bool
+	}{
+		{0, false},
+		{-1, false},
+		{100.5, false},
+		{0.5, true},
+		{100, true},
+	} {
+		if err := b.SetLimit(1, tt.pct); (err == nil) != tt.ok {
+			t.Errorf("SetLimit(%v): %v", tt.pct, err)
+		}
+	}
+	if limits := b.Limits(); len(limits) != 1 || limits[1] != 100 {
+		t.Fatalf("limits %v", limits)
+	}
+	b.ClearLimit(1)
+	if limits := b.Limits(); len(limits) != 0 {
+		t.Fatalf("limits %v after ClearLimit", limits)
+	}
+}
+
+func TestBandwidthThrottle(t *testing.T) {
+	b, setClock := newTestBandwidth()
+	if err := b.SetLimit(1, 25); err != nil {
+		t.Fatal(err)
+	}
+	setClock(10 * ms)
+	limited := &core.QueuedTask{CgroupId: 1, RuntimeNs: 50 * ms}
+	free := &core.QueuedTask{CgroupId: 2, RuntimeNs: 500 * ms}
+	b.Account(limited)
+	b.Account(free)
+
+	for _, tt := range []struct {
+		name         string
+		task         *core.QueuedTask
+		d            core.DispatchedTask
+		wantDelay    time.Duration
+		wantVtime    uint64
+		wantDeadline uint64
+	}{
+		{"vtime", limited, core.DispatchedTask{Vtime: 1000}, 100 * time.Millisecond, 1000 + 100*ms, 0},
+		{"deadline", limited, core.DispatchedTask{Vtime: 1000, Deadline: 2000}, 100 * time.Millisecond, 1000, 2000 + 100*ms},
+		{"no limit", free, core.DispatchedTask{Vtime: 1000}, 0, 1000, 0},
+	} {
+		d := tt.d
+		if delay := b.Throttle(tt.task, &d); delay != tt.wantDelay {
+			t.Errorf("%s: delay %v, want %v", tt.name, delay, tt.wantDelay)
+		}
+		if d.Vtime != tt.wantVtime || d.Deadline != tt.wantDeadline {
+			t.Errorf("%s: vtime %d, deadline %d; want %d, %d",
+				tt.name, d.Vtime, d.Deadline, tt.wantVtime, tt.wantDeadline)
+		}
+	}
+}
@@ -0,0 +1,127 @@
+// Package policy provides the building blocks of latency-aware scheduling
+// policies: tracking of the runtime and of the wakeup rate of each task, the
+// computation of a virtual deadline to use as DispatchedTask.Vtime, and the
+// enforcement of CPU bandwidth limits on cgroups (see Bandwidth).
+//
+// The deadline of a task is its weighted vruntime plus its average runtime,
+// scaled down by a latency weight that grows with the rate of its voluntary
+// context switches (wakeups): tasks that run for short bursts and sleep
+// often, like interactive ones, get earlier deadlines than CPU-bound tasks
+// with the same vruntime.
+package policy
+
+import (
+	"math/bits"
+
+	core "github.com/Gthulhu/scx_goland_core/goland_core"
+)
+
+const nsecPerSec = 1000000000
+
+// MaxLatencyWeight is the maximum latency weight of a task, see
+// LatencyWeight.
+const MaxLatencyWeight = 32
+
+// EWMA returns the exponentially weighted moving average of prev with the
+// new sample cur, weighting the history 3/4 and the sample 1/4.
+func EWMA(prev, cur uint64) uint64 {
+	return (prev*3 + cur) / 4
+}
+
+// LatencyWeight returns the latency weight of a task that wakes up freq times
+// per second: 1 + log2(freq + 1), up to MaxLatencyWeight. Doubling the
+// wakeup rate adds one to the weight.
+func LatencyWeight(freq uint64) uint64 {
+	return min(uint64(1+bits.Len64(freq)), MaxLatencyWeight)
+}
+
+// Deadline returns the virtual deadline of a task: vruntime plus the average
+// runtime divided by the latency weight. The result is never 0, as Vtime 0
+// is reserved to the priority tasks by the BPF component.
+func Deadline(vruntime, avgRuntime, latWeight uint64) uint64 {
+	return max(vruntime+avgRuntime/max(latWeight, 1), 1)
+}
+
+// TaskStats is the state of a task tracked by a Tracker.
+type TaskStats struct {
+	Vruntime   uint64 // Weighted virtual runtime (see core.ScaleByWeight)
+	AvgRuntime uint64 // EWMA of the CPU time used between two enqueues (ns)
+	WakeupFreq uint64 // EWMA of the wakeups per second
+	LastWakeup uint64 // Time of the last wakeup (ns, 0 = never woke up)
+	// VcswFreq is the EWMA of the voluntary context switches per second,
+	// measured by the BPF component (core.QueuedTask.Nvcsw) between two
+	// enqueues: unlike WakeupFreq it also counts the sleeps that ended
+	// while the task was not queued to the user-space scheduler.
+	VcswFreq    uint64
+	Nvcsw       uint64 // Voluntary context switches at the last enqueue
+	LastEnqueue uint64 // Time of the last enqueue (ns)
+}
+
+// Tracker tracks the tasks queued to a scheduler and computes their
+// deadlines. It is not safe for concurrent use.
+type Tracker struct {
+	// MaxCredit is how much vruntime a task that slept can lag behind the
+	// tasks that are running, in ns: it bounds the advantage of a task
+	// after a long sleep. 0 means no credit.
+	MaxCredit uint64
+
+	tasks       map[int32]*TaskStats
+	minVruntime uint64
+}
+
+// NewTracker returns a Tracker that gives at most maxCredit ns of vruntime
+// credit to the tasks that slept (usually the maximum time slice).
+func NewTracker(maxCredit uint64) *Tracker {
+	return &Tracker{
+		MaxCredit: maxCredit,
+		tasks:     make(map[int32]*TaskStats),
+	}
+}
+
+// Task returns the state of the task with the given pid, or nil if it is not
+// tracked.
+func (tr *Tracker) Task(pid int32) *TaskStats {
+	return tr.tasks[pid]
+}
+
+// Enqueue updates the state of t, received from the BPF component at time now
+// (ns), and returns its deadline.
+func (tr *Tracker) Enqueue(t *core.QueuedTask, now uint64) uint64 {
+	st, ok := tr.tasks[t.Pid]
+	if !ok {
+		st = &TaskStats{Vruntime: tr.minVruntime}
+		tr.tasks[t.Pid] = st
+	}
+
+	if t.Reason() == core.ReasonWakeup {
+		if st.LastWakeup != 0 && now > st.LastWakeup {
+			st.WakeupFreq = EWMA(st.WakeupFreq, nsecPerSec/(now-st.LastWakeup))
+		}
+		st.LastWakeup = now
+	}
+	st.AvgRuntime = EWMA(st.AvgRuntime, t.RuntimeNs)
+
+	// The counter restarts from 0 if the BPF component is reloaded.
+	if st.LastEnqueue != 0 && now > st.LastEnqueue && t.Nvcsw >= st.Nvcsw {
+		st.VcswFreq = EWMA(st.VcswFreq, (t.Nvcsw-st.Nvcsw)*nsecPerSec/(now-st.LastEnqueue))
+	}
+	st.Nvcsw, st.LastEnqueue = t.Nvcsw, now
+
+	st.Vruntime += core.ScaleByWeight(t.RuntimeNs, t.Weight)
+	if floor := tr.minVruntime - min(tr.minVruntime, tr.MaxCredit); st.Vruntime < floor {
+		st.Vruntime = floor
+	}
+
+	return Deadline(st.Vruntime, st.AvgRuntime, LatencyWeight(st.VcswFreq))
+}
+
+// Dispatched advances the minimum vruntime of the tracker when a task with the
+// given deadline is dispatched: tasks that are tracked later start from it.
+func (tr *Tracker) Dispatched(deadline uint64) {
+	tr.minVruntime = max(tr.minVruntime, deadline)
+}
+
+// Exit drops the state of the task with the given pid (see Sched.TaskExits).
+func (tr *Tracker) Exit(pid int32) {
+	delete(tr.tasks, pid)
+}
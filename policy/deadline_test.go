@@ -0,0 +1,121 @@
+package policy
+
+import (
+	"testing"
+
+	core "github.com/Gthulhu/scx_goland_core/goland_core"
+)
+
+func TestTrackerVcswLatencyWeight(t *testing.T) {
+	// Two tasks with the same runtime, never queued on a wakeup: only
+	// the BPF component sees one of them going to sleep, 1000 times per
+	// second.
+	tr := NewTracker(0)
+	const step = 10000000 // 10ms
+	var sleeper, spinner uint64
+	for i := uint64(1); i <= 20; i++ {
+		now := i * step
+		sleeper = tr.Enqueue(&core.QueuedTask{Pid: 1, Weight: 100, RuntimeNs: 1000000, Nvcsw: i * 10, PrevCpu: 0}, now)
+		spinner = tr.Enqueue(&core.QueuedTask{Pid: 2, Weight: 100, RuntimeNs: 1000000, PrevCpu: 0}, now)
+	}
+	st := tr.Task(1)
+	if st.WakeupFreq != 0 || st.VcswFreq == 0 {
+		t.Fatalf("WakeupFreq %d, VcswFreq %d", st.WakeupFreq, st.VcswFreq)
+	}
+	if sleeper >= spinner {
+		t.Fatalf("deadline of the task switching voluntarily %d, not earlier than %d", sleeper, spinner)
+	}
+}
+
+func TestLatencyWeight(t *testing.T) {
+	for _, tt := range []struct{ freq, want uint64 }{
+		{0, 1},
+		{1, 2},
+		{2, 3},
+		{3, 3},
+		{1000, 11},
+		{1 << 40, MaxLatencyWeight},
+	} {
+		if got := LatencyWeight(tt.freq); got != tt.want {
+			t.Errorf("LatencyWeight(%d) = %d, want %d", tt.freq, got, tt.want)
+		}
+	}
+}
+
+func TestDeadline(t *testing.T) {
+	for _, tt := range []struct{ vruntime, avgRuntime, latWeight, want uint64 }{
+		{1000, 800, 1, 1800},
+		{1000, 800, 4, 1200},
+		{1000, 800, 0, 1800}, // no latency weight
+		{0, 0, 1, 1},         // Vtime 0 is reserved
+	} {
+		if got := Deadline(tt.vruntime, tt.avgRuntime, tt.latWeight); got != tt.want {
+			t.Errorf("Deadline(%d, %d, %d) = %d, want %d",
+				tt.vruntime, tt.avgRuntime, tt.latWeight, got, tt.want)
+		}
+	}
+}
+
+func TestTrackerInteractiveDeadline(t *testing.T) {
+	// Every 5ms, an interactive task wakes up and runs for 200us, while
+	// a CPU-bound task is requeued after running for its whole 5ms slice.
+	tr := NewTracker(0)
+	const step = 5000000
+	var nvcsw uint64
+	for i := uint64(1); i <= 40; i++ {
+		now := i * step
+		nvcsw++
+		interactive := tr.Enqueue(&core.QueuedTask{
+			Pid: 1, Weight: 100, RuntimeNs: 200000, Flags: uint64(core.EnqWakeup), Nvcsw: nvcsw,
+		}, now)
+		cpuBound := tr.Enqueue(&core.QueuedTask{Pid: 2, Weight: 100, RuntimeNs: step}, now)
+		if interactive >= cpuBound {
+			t.Fatalf("step %d: interactive deadline %d, not earlier than %d", i, interactive, cpuBound)
+		}
+	}
+
+	st := tr.Task(1)
+	if st.WakeupFreq < 190 || st.WakeupFreq > 200 {
+		t.Errorf("wakeup frequency %d, want about 200", st.WakeupFreq)
+	}
+	if st.AvgRuntime > 200000 {
+		t.Errorf("average runtime %d, above the runtime of each burst", st.AvgRuntime)
+	}
+	if w, cpuW := LatencyWeight(st.VcswFreq), LatencyWeight(tr.Task(2).VcswFreq); w <= cpuW {
+		t.Errorf("latency weight %d, not above the CPU-bound one %d", w, cpuW)
+	}
+}
+
+func TestTrackerMaxCredit(t *testing.T) {
+	// A task that slept for a long time doesn't get more than MaxCredit
+	// of vruntime advantage over the tasks that kept running.
+	const credit = 5000000
+	tr := NewTracker(credit)
+	tr.Enqueue(&core.QueuedTask{Pid: 1, Weight: 100, RuntimeNs: 1000}, 1)
+	tr.Dispatched(100000000)
+	tr.Enqueue(&core.QueuedTask{Pid: 1, Weight: 100, Flags: uint64(core.EnqWakeup)}, 2)
+	if got, want := tr.Task(1).Vruntime, uint64(100000000-credit); got != want {
+		t.Fatalf("vruntime after the sleep %d, want %d", got, want)
+	}
+
+	// The tasks tracked later start from the minimum vruntime.
+	tr.Enqueue(&core.QueuedTask{Pid: 2, Weight: 100}, 3)
+	if got := tr.Task(2).Vruntime; got != 100000000 {
+		t.Fatalf("vruntime of a new task %d, want 100000000", got)
+	}
+	tr.Exit(2)
+	if tr.Task(2) != nil {
+		t.Fatalf("exited task still tracked")
+	}
+}
+
+func TestTrackerNvcswReset(t *testing.T) {
+	// The counter of the BPF component restarts from 0 when it's reloaded:
+	// the decrease is not taken as a burst of context switches.
+	tr := NewTracker(0)
+	tr.Enqueue(&core.QueuedTask{Pid: 1, Nvcsw: 1000}, 1000000)
+	tr.Enqueue(&core.QueuedTask{Pid: 1, Nvcsw: 10}, 2000000)
+	if st := tr.Task(1); st.VcswFreq != 0 || st.Nvcsw != 10 {
+		t.Fatalf("VcswFreq %d, Nvcsw %d after the reset", st.VcswFreq, st.Nvcsw)
+	}
+}
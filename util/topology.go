@@ -95,36 +95,44 @@ func GetTopology() (map[string]map[string][]int, error) {
 	return cacheMap, nil
 }
 
-func initCacheDomains(bpfModule *core.Sched, level int32) error {
-	topo, err := GetTopology()
-	if err != nil {
-		return err
-	}
+// cacheDomainPairs returns the sibling relationships of the scheduling
+// domains of a cache level: every pair of CPUs sharing a cache of topo.
+func cacheDomainPairs(topo map[string]map[string][]int, level int32) []core.SiblingPair {
 	l := "L2"
 	if level == 3 {
 		l = "L3"
 	}
+	var pairs []core.SiblingPair
 	for _, cpuIdList := range topo[l] {
 		for _, cpuId := range cpuIdList {
 			for _, sibCpuId := range cpuIdList {
-				err = bpfModule.EnableSiblingCpu(level, int32(cpuId), int32(sibCpuId))
-				if err != nil {
-					return fmt.Errorf("EnableSiblingCpu failed: lvl %v cpuId %v sibCpuId %v", level, cpuId, sibCpuId)
-				}
+				pairs = append(pairs, core.SiblingPair{Level: level, Cpu: int32(cpuId), Sibling: int32(sibCpuId)})
 			}
 		}
 	}
-	return nil
+	return pairs
 }
 
-func InitCacheDomains(bpfModule *core.Sched) error {
-	err := initCacheDomains(bpfModule, 2)
-	if err != nil {
-		return err
-	}
-	err = initCacheDomains(bpfModule, 3)
+// ConfigureCacheDomains sets up the L2 and L3 scheduling domains of the BPF
+// component from the cache topology of the system, with
+// Sched.EnableSiblingCpus, and returns the sibling relationships of the
+// topology. The relationships rejected by the BPF component (e.g. on unusual
+// topologies) don't stop the configuration: they are reported by a
+// *core.ErrSiblingPairs, and the caller decides whether the partial
+// configuration is acceptable. Any other error affects all the pairs (e.g.
+// the scheduler has been closed).
+func ConfigureCacheDomains(bpfModule *core.Sched) ([]core.SiblingPair, error) {
+	topo, err := GetTopology()
 	if err != nil {
-		return err
+		return nil, err
 	}
-	return nil
+	pairs := append(cacheDomainPairs(topo, 2), cacheDomainPairs(topo, 3)...)
+	return pairs, bpfModule.EnableSiblingCpus(pairs)
+}
+
+// InitCacheDomains is like ConfigureCacheDomains, but fails if any sibling
+// relationship is rejected.
+func InitCacheDomains(bpfModule *core.Sched) error {
+	_, err := ConfigureCacheDomains(bpfModule)
+	return err
 }
@@ -10,6 +10,97 @@ void *open_skel() {
     return obj->obj;
 }
 
+/*
+ * Open the skeleton from the BPF object in @data, instead of the object
+ * embedded in the skeleton: @data must stay valid until the object is
+ * closed.
+ */
+void *open_skel_from(const void *data, size_t size) {
+    struct main_bpf *obj;
+
+    obj = calloc(1, sizeof(*obj));
+    if (!obj)
+        return NULL;
+    if (main_bpf__create_skeleton(obj))
+        goto err;
+    obj->skeleton->data = data;
+    obj->skeleton->data_sz = size;
+    if (bpf_object__open_skeleton(obj->skeleton, NULL))
+        goto err;
+    global_obj = obj;
+    return obj->obj;
+err:
+    main_bpf__destroy(obj);
+    return NULL;
+}
+
+/*
+ * Open a new skeleton from the BPF object in @data, like open_skel_from(),
+ * with the settings of the current skeleton: the rodata and the tunables are
+ * copied, so the two objects must define the same global variables.
+ * The previous skeleton is returned in @prev, to be released with
+ * release_skel() or made current again with restore_skel().
+ */
+void *reopen_skel_from(const void *data, size_t size, void **prev) {
+    struct main_bpf *old = global_obj;
+    void *obj;
+
+    obj = open_skel_from(data, size);
+    if (!obj)
+        return NULL;
+    *global_obj->rodata = *old->rodata;
+    *global_obj->data_tunables = *old->data_tunables;
+    *prev = old;
+    return obj;
+}
+
+/*
+ * Release the skeleton @skel. Its BPF object is closed too, unless
+ * @obj_closed is set (i.e., it has been closed by the libbpfgo module that
+ * owns it).
+ */
+void release_skel(void *skel, bool obj_closed) {
+    struct main_bpf *obj = skel;
+
+    if (obj_closed)
+        obj->obj = NULL;
+    main_bpf__destroy(obj);
+}
+
+/*
+ * Make @prev the current skeleton again, releasing the one opened by
+ * reopen_skel_from().
+ */
+void restore_skel(void *prev, bool obj_closed) {
+    struct main_bpf *cur = global_obj;
+
+    global_obj = prev;
+    release_skel(cur, obj_closed);
+}
+
+/*
+ * Release the current skeleton, e.g. after a failed load: see
+ * release_skel() for @obj_closed.
+ */
+void close_skel(bool obj_closed) {
+    struct main_bpf *cur = global_obj;
+
+    global_obj = NULL;
+    if (cur)
+        release_skel(cur, obj_closed);
+}
+
+/*
+ * Set the log level of the verifier for all the programs of the current
+ * skeleton, before they are loaded.
+ */
+void set_prog_log_level(u32 level) {
+    struct bpf_program *prog;
+
+    bpf_object__for_each_program(prog, global_obj->obj)
+        bpf_program__set_log_level(prog, level);
+}
+
 u32 get_usersched_pid() {
     return global_obj->rodata->usersched_pid;
 }
@@ -38,22 +129,180 @@ void set_builtin_idle(bool enabled) {
     global_obj->rodata->builtin_idle = enabled;
 }
 
+/*
+ * The struct_ops fields are copied to the kernel when the object is loaded,
+ * so they must be set before that.
+ */
+void set_ops_timeout(u32 ms) {
+    global_obj->struct_ops.goland->timeout_ms = ms;
+}
+
+void set_ops_flags(u64 flags) {
+    global_obj->struct_ops.goland->flags = flags;
+}
+
+u64 get_default_slice() {
+    return global_obj->rodata->default_slice;
+}
+
+u32 get_ops_timeout() {
+    return global_obj->struct_ops.goland->timeout_ms;
+}
+
+u64 get_ops_flags() {
+    return global_obj->struct_ops.goland->flags;
+}
+
+/*
+ * The counters below can be used through the package-level functions of
+ * goland_core, that don't hold a scheduler: they are no-ops (or return 0)
+ * once the skeleton has been released.
+ */
 u64 get_nr_scheduled() {
-    return global_obj->bss->nr_scheduled;
+    return global_obj ? global_obj->bss->nr_scheduled : 0;
 }
 
 u64 get_nr_queued() {
-    return global_obj->bss->nr_queued;
+    return global_obj ? global_obj->bss->nr_queued : 0;
 }
 
 void notify_complete(u64 nr_pending) {
-    global_obj->bss->nr_scheduled = nr_pending;
+    if (global_obj)
+        global_obj->bss->nr_scheduled = nr_pending;
+}
+
+/*
+ * nr_queued is incremented concurrently by the BPF component (with an atomic
+ * add): subtract @n with a compare-and-swap, that never goes below 0.
+ */
+void sub_nr_queued(u64 n) {
+    volatile u64 *nr;
+    u64 cur;
+
+    if (!global_obj)
+        return;
+    nr = &global_obj->bss->nr_queued;
+    cur = __atomic_load_n(nr, __ATOMIC_RELAXED);
+    while (cur && !__atomic_compare_exchange_n(nr, &cur, cur > n ? cur - n : 0, false,
+                                               __ATOMIC_RELAXED, __ATOMIC_RELAXED))
+        ;
+}
+
+u64 get_nr_managed_cgroups() {
+    return global_obj->data_tunables->nr_managed_cgroups;
+}
+
+void set_nr_managed_cgroups(u64 nr) {
+    global_obj->data_tunables->nr_managed_cgroups = nr;
+}
+
+u64 get_max_queued() {
+    return global_obj->data_tunables->max_queued;
+}
+
+void *get_bss() {
+    return global_obj ? global_obj->bss : NULL;
+}
+
+void set_max_queued(u64 nr) {
+    if (global_obj)
+        global_obj->data_tunables->max_queued = nr;
+}
+
+u64 get_auto_slice_ns() {
+    return global_obj->data_tunables->auto_slice_ns;
+}
+
+void set_auto_slice_ns(u64 t) {
+    global_obj->data_tunables->auto_slice_ns = t;
+}
+
+u64 get_nr_select_fast() {
+    return global_obj->bss->nr_select_fast;
+}
+
+u64 get_nr_select_user() {
+    return global_obj->bss->nr_select_user;
+}
+
+u64 get_nr_exit_dropped() {
+    return global_obj->bss->nr_exit_dropped;
+}
+
+void set_task_events(u64 enabled) {
+    global_obj->data_tunables->task_events = enabled;
+}
+
+void set_cpumask_events(u64 enabled) {
+    global_obj->data_tunables->cpumask_events = enabled;
+}
+
+void set_bypass(u64 enabled) {
+    global_obj->data_tunables->bypass = enabled;
+}
+
+u64 get_vtime_now() {
+    return global_obj->bss->vtime_now;
+}
+
+void set_bounce_events(u64 enabled) {
+    global_obj->data_tunables->bounce_events = enabled;
+}
+
+void set_nr_queued_shards(u64 nr) {
+    global_obj->data_tunables->nr_queued_shards = nr;
+}
+
+void set_queued_shard_by_cpu(u64 enabled) {
+    global_obj->data_tunables->queued_shard_by_cpu = enabled;
+}
+
+u32 get_abi_version() {
+    return global_obj->rodata_abi->abi_version;
+}
+
+u32 get_abi_queued_task_size() {
+    return global_obj->rodata_abi->abi_queued_task_size;
+}
+
+u32 get_abi_dispatched_task_size() {
+    return global_obj->rodata_abi->abi_dispatched_task_size;
+}
+
+u32 get_abi_task_exit_size() {
+    return global_obj->rodata_abi->abi_task_exit_size;
+}
+
+u32 get_abi_cpu_event_size() {
+    return global_obj->rodata_abi->abi_cpu_event_size;
+}
+
+u32 get_abi_bounce_event_size() {
+    return global_obj->rodata_abi->abi_bounce_event_size;
+}
+
+u32 get_abi_max_cpus() {
+    return global_obj->rodata_abi->abi_max_cpus;
+}
+
+void *new_dispatch_rb(int map_fd) {
+    return user_ring_buffer__new(map_fd, NULL);
+}
+
+void *reserve_dispatch(void *rb, u32 size) {
+    return user_ring_buffer__reserve(rb, size);
+}
+
+void submit_dispatch(void *rb, void *sample) {
+    user_ring_buffer__submit(rb, sample);
+}
+
+void discard_dispatch(void *rb, void *sample) {
+    user_ring_buffer__discard(rb, sample);
 }
 
-void sub_nr_queued() {
-    if (global_obj->bss->nr_queued){
-        global_obj->bss->nr_queued--;
-    }
+void free_dispatch_rb(void *rb) {
+    user_ring_buffer__free(rb);
 }
 
 void destroy_skel(void*skel) {
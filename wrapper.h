@@ -44,6 +44,18 @@ struct user_exit_info {
 
 void *open_skel();
 
+void *open_skel_from(const void *data, size_t size);
+
+void *reopen_skel_from(const void *data, size_t size, void **prev);
+
+void release_skel(void *skel, bool obj_closed);
+
+void restore_skel(void *prev, bool obj_closed);
+
+void close_skel(bool obj_closed);
+
+void set_prog_log_level(u32 level);
+
 u32 get_usersched_pid();
 
 void set_usersched_pid(u32 id);
@@ -58,13 +70,80 @@ void set_early_processing(bool enabled);
 
 void set_default_slice(u64 t);
 
+void set_ops_timeout(u32 ms);
+
+void set_ops_flags(u64 flags);
+
+u64 get_default_slice();
+
+u32 get_ops_timeout();
+
+u64 get_ops_flags();
+
 u64 get_nr_scheduled();
 
+void *get_bss();
+
 u64 get_nr_queued();
 
 void notify_complete(u64 nr_pending);
 
-void sub_nr_queued();
+void sub_nr_queued(u64 n);
+
+u64 get_nr_managed_cgroups();
+
+void set_nr_managed_cgroups(u64 nr);
+
+u64 get_max_queued();
+
+void set_max_queued(u64 nr);
+
+void set_nr_queued_shards(u64 nr);
+
+void set_queued_shard_by_cpu(u64 enabled);
+
+u64 get_auto_slice_ns();
+
+void set_auto_slice_ns(u64 t);
+
+u64 get_vtime_now();
+
+u64 get_nr_select_fast();
+
+u64 get_nr_select_user();
+
+u64 get_nr_exit_dropped();
+
+void set_bypass(u64 enabled);
+
+void set_task_events(u64 enabled);
+void set_cpumask_events(u64 enabled);
+
+void set_bounce_events(u64 enabled);
+
+u32 get_abi_version();
+
+u32 get_abi_queued_task_size();
+
+u32 get_abi_dispatched_task_size();
+
+u32 get_abi_task_exit_size();
+
+u32 get_abi_cpu_event_size();
+
+u32 get_abi_bounce_event_size();
+
+u32 get_abi_max_cpus();
+
+void *new_dispatch_rb(int map_fd);
+
+void *reserve_dispatch(void *rb, u32 size);
+
+void submit_dispatch(void *rb, void *sample);
+
+void discard_dispatch(void *rb, void *sample);
+
+void free_dispatch_rb(void *rb);
 
 void destroy_skel(void *);
 